@@ -0,0 +1,408 @@
+package bert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The header of a term archive file, a magic number and a format version,
+// and its trailer, which locates the index and repeats the magic number.
+const (
+	archiveMagic   = "BARC"
+	archiveVersion = 1
+	archiveHeader  = len(archiveMagic) + 1
+	archiveTrailer = 8 + 4 + 4 + len(archiveMagic)
+)
+
+var (
+	// ErrArchiveClosed is returned when using a TermArchive after Close.
+	ErrArchiveClosed = errors.New("term archive closed")
+
+	// ErrNoEntry is returned when reading an entry a TermArchive does not
+	// hold.
+	ErrNoEntry = errors.New("no such entry")
+
+	errNotArchive = errors.New("not a term archive")
+)
+
+// An ArchiveEntry describes a term stored in a TermArchive.
+type ArchiveEntry struct {
+	Name     string
+	Offset   int64  // of the encoded term in the file
+	Size     int64  // of the encoded term
+	Checksum uint32 // CRC-32 of the encoded term
+}
+
+// A TermArchive is a file of named BERT terms, for snapshots and fixtures.
+// It starts with a header, followed by the encoded terms, an index of
+// their names, offsets, sizes and CRC-32 checksums, itself a term, and a
+// trailer locating the index. Terms are read individually, without reading
+// the rest of the file, and verified against their checksum.
+//
+// Appending a term writes it after the trailer, followed by a new index
+// and trailer, and syncs the file before the term is listed, so that the
+// previous trailer remains valid until the new one is complete. An Append
+// interrupted by a crash thus leaves the archive as it was, the archive
+// being read from the last complete trailer, and the indexes of earlier
+// appends remain in the file as unused space. As each Append writes the
+// whole index again, appending n terms one by one takes O(n²) space and
+// each Append takes O(n) I/O; Compact rewrites the archive without the
+// indexes of earlier appends. A TermArchive is safe for concurrent use.
+type TermArchive struct {
+	mu      sync.Mutex
+	r       io.ReaderAt
+	f       *os.File // of archives open for appending
+	path    string   // of f
+	entries []ArchiveEntry
+	names   map[string]int
+	size    int64 // up to the end of the current trailer
+	closed  bool
+}
+
+// OpenTermArchive opens the archive at path for reading and appending,
+// creating it if it does not exist.
+func OpenTermArchive(path string) (*TermArchive, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	a := &TermArchive{r: f, f: f, path: path, names: map[string]int{}}
+	if info.Size() == 0 {
+		header := append([]byte(archiveMagic), archiveVersion)
+		if err := a.write(header, nil, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return a, nil
+	}
+	if err := a.readIndex(info.Size()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if a.size < info.Size() {
+		// the remains of an interrupted Append
+		if err := f.Truncate(a.size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// ReadTermArchive reads the index of the archive held in the first size
+// bytes of r, returning a read-only archive.
+func ReadTermArchive(r io.ReaderAt, size int64) (*TermArchive, error) {
+	a := &TermArchive{r: r, names: map[string]int{}}
+	if err := a.readIndex(size); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// readIndex reads the header of the archive of the given size, and the
+// index located by its last complete trailer.
+func (a *TermArchive) readIndex(size int64) error {
+	if size < int64(archiveHeader+archiveTrailer) {
+		return errNotArchive
+	}
+	header := make([]byte, archiveHeader)
+	if _, err := a.r.ReadAt(header, 0); err != nil {
+		return noEOF(err)
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return errNotArchive
+	}
+	if header[len(archiveMagic)] != archiveVersion {
+		return fmt.Errorf("unsupported term archive version %d", header[len(archiveMagic)])
+	}
+
+	err := a.loadIndex(size)
+	if err == nil {
+		return nil
+	}
+	// an Append was interrupted, leaving the file past an earlier
+	// trailer, found back from the end
+	buf := make([]byte, atBufferSize)
+	for end := size; end > int64(archiveHeader); {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, rerr := a.r.ReadAt(chunk, start); rerr != nil {
+			return noEOF(rerr)
+		}
+		i := bytes.LastIndex(chunk, []byte(archiveMagic))
+		if i < 0 {
+			// the magic may straddle chunks
+			end = start + int64(len(archiveMagic)) - 1
+			if start == 0 {
+				break
+			}
+			continue
+		}
+		pos := start + int64(i+len(archiveMagic))
+		if a.loadIndex(pos) == nil {
+			return nil
+		}
+		end = pos - 1
+	}
+	return err
+}
+
+// loadIndex reads the index located by the trailer ending at end.
+func (a *TermArchive) loadIndex(end int64) error {
+	if end < int64(archiveHeader+archiveTrailer) {
+		return errors.New("term archive index missing")
+	}
+	trailer := make([]byte, archiveTrailer)
+	if _, err := a.r.ReadAt(trailer, end-int64(archiveTrailer)); err != nil {
+		return noEOF(err)
+	}
+	if string(trailer[16:]) != archiveMagic {
+		return errors.New("term archive index missing")
+	}
+	off := int64(binary.BigEndian.Uint64(trailer))
+	n := int64(binary.BigEndian.Uint32(trailer[8:]))
+	if off < int64(archiveHeader) || off+n != end-int64(archiveTrailer) {
+		return errors.New("term archive index out of range")
+	}
+	index := make([]byte, n)
+	if _, err := a.r.ReadAt(index, off); err != nil {
+		return noEOF(err)
+	}
+	if crc32.ChecksumIEEE(index) != binary.BigEndian.Uint32(trailer[12:]) {
+		return errors.New("term archive index corrupt")
+	}
+
+	var entries []ArchiveEntry
+	if err := Unmarshal(index, &entries); err != nil {
+		return fmt.Errorf("term archive index: %v", err)
+	}
+	names := make(map[string]int, len(entries))
+	for i, e := range entries {
+		if e.Offset < int64(archiveHeader) || e.Size < 0 || e.Offset+e.Size > off {
+			return fmt.Errorf("entry %q out of range", e.Name)
+		}
+		if _, dup := names[e.Name]; dup {
+			return fmt.Errorf("duplicate entry %q", e.Name)
+		}
+		names[e.Name] = i
+	}
+	a.entries, a.names, a.size = entries, names, end
+	return nil
+}
+
+// write writes data at off followed by the index of entries and its
+// trailer, ending the file, and syncs the file, before making entries
+// those of the archive.
+func (a *TermArchive) write(data []byte, entries []ArchiveEntry, off int64) error {
+	end, err := writeArchiveIndex(a.f, data, entries, off)
+	if err != nil {
+		return err
+	}
+	a.setEntries(entries, end)
+	return nil
+}
+
+// writeArchiveIndex writes data at off of f followed by the index of
+// entries and its trailer, ending the file, and syncs it, returning the
+// end of the trailer.
+func writeArchiveIndex(f *os.File, data []byte, entries []ArchiveEntry, off int64) (int64, error) {
+	index, err := Encode(entries)
+	if err != nil {
+		return 0, err
+	}
+	at := off + int64(len(data))
+	trailer := make([]byte, archiveTrailer)
+	binary.BigEndian.PutUint64(trailer, uint64(at))
+	binary.BigEndian.PutUint32(trailer[8:], uint32(len(index)))
+	binary.BigEndian.PutUint32(trailer[12:], crc32.ChecksumIEEE(index))
+	copy(trailer[16:], archiveMagic)
+
+	buf := append(append(data[:len(data):len(data)], index...), trailer...)
+	if _, err := f.WriteAt(buf, off); err != nil {
+		return 0, err
+	}
+	end := off + int64(len(buf))
+	// the remains of a failed Append may lie beyond
+	if err := f.Truncate(end); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+// setEntries makes entries those of the archive, whose current trailer
+// ends at size.
+func (a *TermArchive) setEntries(entries []ArchiveEntry, size int64) {
+	names := make(map[string]int, len(entries))
+	for i, e := range entries {
+		names[e.Name] = i
+	}
+	a.entries, a.names, a.size = entries, names, size
+}
+
+// Entries returns the entries of the archive, in the order they were
+// appended.
+func (a *TermArchive) Entries() []ArchiveEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ArchiveEntry(nil), a.entries...)
+}
+
+// Raw returns the encoding of the term named name, after verifying its
+// checksum.
+func (a *TermArchive) Raw(name string) (RawTerm, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil, ErrArchiveClosed
+	}
+	i, ok := a.names[name]
+	if !ok {
+		return nil, ErrNoEntry
+	}
+	e := a.entries[i]
+	data := make([]byte, e.Size)
+	if _, err := a.r.ReadAt(data, e.Offset); err != nil {
+		return nil, noEOF(err)
+	}
+	if crc32.ChecksumIEEE(data) != e.Checksum {
+		return nil, fmt.Errorf("entry %q corrupt", name)
+	}
+	return data, nil
+}
+
+// Read decodes the term named name.
+func (a *TermArchive) Read(name string) (Term, error) {
+	data, err := a.Raw(name)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Unmarshal stores the term named name in val, as Unmarshal does.
+func (a *TermArchive) Unmarshal(name string, val interface{}) error {
+	data, err := a.Raw(name)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(data, val)
+}
+
+// Append encodes val and stores it under name, which must not be taken.
+func (a *TermArchive) Append(name string, val interface{}) error {
+	data, err := Encode(val)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrArchiveClosed
+	}
+	if a.f == nil {
+		return errors.New("term archive is read-only")
+	}
+	if _, dup := a.names[name]; dup {
+		return fmt.Errorf("entry %q exists", name)
+	}
+	entries := append(a.entries[:len(a.entries):len(a.entries)], ArchiveEntry{
+		Name:     name,
+		Offset:   a.size,
+		Size:     int64(len(data)),
+		Checksum: crc32.ChecksumIEEE(data),
+	})
+	return a.write(data, entries, a.size)
+}
+
+// Compact rewrites the archive, opened by OpenTermArchive, with its terms
+// and a single index, dropping the indexes left by earlier appends. The
+// archive is written to a temporary file next to it, which then replaces
+// it, so that the archive is left as it was if Compact fails.
+func (a *TermArchive) Compact() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrArchiveClosed
+	}
+	if a.f == nil {
+		return errors.New("term archive is read-only")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if info, err := a.f.Stat(); err == nil {
+		tmp.Chmod(info.Mode())
+	}
+	if _, err := tmp.Write(append([]byte(archiveMagic), archiveVersion)); err != nil {
+		return err
+	}
+	off := int64(archiveHeader)
+	entries := make([]ArchiveEntry, len(a.entries))
+	for i, e := range a.entries {
+		if _, err := io.Copy(tmp, io.NewSectionReader(a.r, e.Offset, e.Size)); err != nil {
+			return err
+		}
+		e.Offset = off
+		entries[i] = e
+		off += e.Size
+	}
+	end, err := writeArchiveIndex(tmp, nil, entries, off)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return err
+	}
+
+	a.f.Close()
+	a.f, a.r, tmp = tmp, tmp, nil
+	a.setEntries(entries, end)
+	return nil
+}
+
+// Close syncs and closes the file of the archive, if it was opened by
+// OpenTermArchive.
+func (a *TermArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrArchiveClosed
+	}
+	a.closed = true
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Sync()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
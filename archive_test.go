@@ -0,0 +1,201 @@
+package bert
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTermArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.barc")
+	a, err := OpenTermArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 0, len(a.Entries()))
+	if err := a.Append("user", []Term{Atom("user"), Binary("ann"), 42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Append("config", map[string]int{"retries": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Append("user", 1); err == nil {
+		t.Error("duplicate name accepted")
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Appends continue after reopening.
+	a, err = OpenTermArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Append("empty", []Term{}); err != nil {
+		t.Fatal(err)
+	}
+	entries := a.Entries()
+	assertEqual(t, 3, len(entries))
+	assertEqual(t, "user", entries[0].Name)
+	assertEqual(t, "empty", entries[2].Name)
+
+	term, err := a.Read("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("user"), []byte("ann"), int64(42)}, term)
+	var config map[string]int
+	if err := a.Unmarshal("config", &config); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 3, config["retries"])
+	if _, err := a.Read("missing"); err != ErrNoEntry {
+		t.Errorf("expected ErrNoEntry, got %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Read("user"); err != ErrArchiveClosed {
+		t.Errorf("expected ErrArchiveClosed, got %v", err)
+	}
+
+	// A corrupt entry fails its checksum, without affecting the others.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[entries[0].Offset+entries[0].Size-1]++
+	r, err := ReadTermArchive(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read("user"); err == nil {
+		t.Error("corrupt entry accepted")
+	}
+	if _, err := r.Read("empty"); err != nil {
+		t.Error(err)
+	}
+	if err := r.Append("more", 1); err == nil {
+		t.Error("append to read-only archive accepted")
+	}
+
+	// An archive cut short, as by a crash during Append, reads as it was
+	// before the Append.
+	r, err = ReadTermArchive(bytes.NewReader(data[:len(data)-1]), int64(len(data)-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, entries[:2], r.Entries())
+	if _, err := ReadTermArchive(bytes.NewReader(data[:archiveHeader+archiveTrailer]), int64(archiveHeader+archiveTrailer)); err == nil {
+		t.Error("truncated archive accepted")
+	}
+}
+
+func TestTermArchiveInterruptedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.barc")
+	a, err := OpenTermArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Append("first", 1); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An Append interrupted after writing part of its term and index.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{131, 97, 2, 131, 108, 0, 0})
+	f.Close()
+
+	a, err = OpenTermArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(a.Entries()))
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, before, after)
+
+	if err := a.Append("second", 2); err != nil {
+		t.Fatal(err)
+	}
+	var second int
+	if err := a.Unmarshal("second", &second); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, second)
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a, err = OpenTermArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	assertEqual(t, 2, len(a.Entries()))
+}
+
+func TestTermArchiveCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.barc")
+	a, err := OpenTermArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := a.Append(fmt.Sprint("term", i), []Term{Atom("term"), i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size()/2 {
+		t.Errorf("compacted from %d to %d bytes", before.Size(), after.Size())
+	}
+
+	// The archive remains usable, before and after reopening.
+	if err := a.Append("last", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a, err = OpenTermArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	assertEqual(t, 21, len(a.Entries()))
+	term, err := a.Read("term7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("term"), int64(7)}, term)
+	term, err = a.Read("last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(20), term)
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
@@ -0,0 +1,123 @@
+package bert
+
+import (
+	"io"
+	"unsafe"
+)
+
+// Sizes of the chunks an Arena allocates from. Larger requests are
+// allocated on their own.
+const (
+	arenaByteChunk = 32 << 10
+	arenaTermChunk = 1 << 10
+)
+
+// An Arena provides the memory of the terms decoded by a Decoder whose
+// Arena is set: the bytes of binaries, bitstrings, strings and atoms, and
+// the elements of tuples and lists are carved out of large chunks that
+// are reused once the terms are released, instead of being allocated one
+// by one. This relieves the garbage collector in services decoding many
+// short-lived terms.
+//
+// Terms decoded into an arena, and any strings, atoms and slices taken
+// from them, including by Unmarshal, must not be used after Release,
+// since their memory is then reused. Values that must outlive the arena
+// have to be copied first. Maps and numbers are allocated as usual.
+//
+// The zero value is an empty arena. An Arena must not be used by several
+// goroutines at once.
+type Arena struct {
+	bytes [][]byte
+	terms [][]Term
+
+	byteChunk, byteOff int
+	termChunk, termOff int
+}
+
+// allocBytes returns a slice of n bytes.
+func (a *Arena) allocBytes(n int) []byte {
+	if n > arenaByteChunk/4 {
+		return make([]byte, n)
+	}
+	if a.byteChunk < len(a.bytes) && a.byteOff+n > arenaByteChunk {
+		a.byteChunk++
+		a.byteOff = 0
+	}
+	if a.byteChunk == len(a.bytes) {
+		a.bytes = append(a.bytes, make([]byte, arenaByteChunk))
+	}
+	b := a.bytes[a.byteChunk][a.byteOff : a.byteOff+n : a.byteOff+n]
+	a.byteOff += n
+	return b
+}
+
+// allocTerms returns a slice of n nil terms.
+func (a *Arena) allocTerms(n int) []Term {
+	if n > arenaTermChunk/4 {
+		return make([]Term, n)
+	}
+	if a.termChunk < len(a.terms) && a.termOff+n > arenaTermChunk {
+		a.termChunk++
+		a.termOff = 0
+	}
+	if a.termChunk == len(a.terms) {
+		a.terms = append(a.terms, make([]Term, arenaTermChunk))
+	}
+	t := a.terms[a.termChunk][a.termOff : a.termOff+n : a.termOff+n]
+	a.termOff += n
+	return t
+}
+
+// Release frees the memory of all terms decoded into a, to be reused by
+// the next terms decoded into it.
+func (a *Arena) Release() {
+	for i := 0; i <= a.termChunk && i < len(a.terms); i++ {
+		chunk := a.terms[i]
+		for j := range chunk {
+			chunk[j] = nil
+		}
+	}
+	a.byteChunk, a.byteOff = 0, 0
+	a.termChunk, a.termOff = 0, 0
+}
+
+// arenaString returns a string sharing the bytes of b, which must not
+// change while the string is in use.
+func arenaString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// readBytes reads the n bytes of a term body, into the arena if the
+// decoder has one.
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	if d.Arena == nil || n > arenaByteChunk/4 {
+		return readN(d.r, n)
+	}
+	b := d.Arena.allocBytes(n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// makeTerms returns an empty slice to append the n elements of a tuple or
+// list to, from the arena if the decoder has one. Since n comes from the
+// input, at most maxPrealloc elements are allocated up front, and larger
+// slices grow as their elements are actually read.
+func (d *Decoder) makeTerms(n int) []Term {
+	if d.Arena != nil && n <= arenaTermChunk/4 {
+		return d.Arena.allocTerms(n)[:0]
+	}
+	if n > maxPrealloc {
+		n = maxPrealloc
+	}
+	return make([]Term, 0, n)
+}
+
+// newString returns b, as read by readBytes, as a string.
+func (d *Decoder) newString(b []byte) string {
+	if d.Arena != nil {
+		return arenaString(b)
+	}
+	return string(b)
+}
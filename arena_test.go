@@ -0,0 +1,46 @@
+package bert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	term := []Term{
+		Atom("block"), "héllo", []byte{1, 2, 3},
+		List{Items: []Term{1, 2}}, Bitstring{[]byte{0xf0}, 4},
+		[]Term{strings.Repeat("x", arenaByteChunk), []Term{Atom("été")}},
+	}
+	data, err := Encode(term)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var arena Arena
+	for i := 0; i < 3; i++ {
+		d := NewDecoder(bytes.NewReader(bytes.Repeat(data, 20)))
+		d.Arena = &arena
+		for j := 0; j < 20; j++ {
+			actual, err := d.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, expected, actual)
+		}
+		arena.Release()
+	}
+	if len(arena.bytes) > 2 {
+		t.Errorf("expected the arena to reuse its chunks, got %d", len(arena.bytes))
+	}
+
+	// Slices from the arena cannot grow into their neighbours.
+	a, b := arena.allocBytes(2), arena.allocBytes(2)
+	b[0] = 7
+	a = append(a, 9)
+	assertEqual(t, byte(7), b[0])
+}
@@ -0,0 +1,155 @@
+package bert
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// Info packet commands used for authentication.
+const (
+	AuthAtom      = Atom("auth")
+	ChallengeAtom = Atom("challenge")
+)
+
+// An Authenticator verifies a new connection before any request on it is
+// served, possibly exchanging packets with the peer over conn. It returns
+// a non-nil error to reject the connection, which is then answered with
+// protocol error 6 and closed.
+type Authenticator func(ctx context.Context, conn *TermConn) error
+
+// errUnauthorized is returned by the authenticators in this package when
+// the peer's credentials are missing or wrong.
+var errUnauthorized = errors.New("unauthorized")
+
+// TokenAuth returns an Authenticator that expects the connection to start
+// with an {info, auth, [Token]} packet, as sent by Client.Authenticate, and
+// accepts it if verify returns nil for the token.
+func TokenAuth(verify func(token Term) error) Authenticator {
+	return func(ctx context.Context, conn *TermConn) error {
+		token, err := readAuth(ctx, conn)
+		if err != nil {
+			return err
+		}
+		return verify(token)
+	}
+}
+
+// SecretAuth returns an Authenticator implementing a shared-secret
+// challenge. It sends an {info, challenge, [Nonce]} packet and expects an
+// {info, auth, [MAC]} reply carrying the HMAC-SHA256 of the nonce keyed
+// with secret, as sent by Client.AuthenticateSecret.
+func SecretAuth(secret []byte) Authenticator {
+	return func(ctx context.Context, conn *TermConn) error {
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		if err := conn.WriteTerm(ctx, infoTerm(ChallengeAtom, nonce)); err != nil {
+			return err
+		}
+		token, err := readAuth(ctx, conn)
+		if err != nil {
+			return err
+		}
+		mac, ok := token.([]byte)
+		if !ok || !hmac.Equal(mac, hmacSHA256(secret, nonce)) {
+			return errUnauthorized
+		}
+		return nil
+	}
+}
+
+// TLSAuth returns an Authenticator for TLS connections that completes the
+// handshake and passes the resulting connection state to verify. With a
+// nil verify, any connection presenting a client certificate is accepted;
+// the certificate itself is verified according to the tls.Config.
+func TLSAuth(verify func(tls.ConnectionState) error) Authenticator {
+	return func(ctx context.Context, conn *TermConn) error {
+		tc, ok := conn.Conn().(*tls.Conn)
+		if !ok {
+			return errors.New("not a TLS connection")
+		}
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		state := tc.ConnectionState()
+		if verify == nil {
+			if len(state.PeerCertificates) == 0 {
+				return errUnauthorized
+			}
+			return nil
+		}
+		return verify(state)
+	}
+}
+
+// readAuth reads an {info, auth, [Token]} packet and returns the token.
+func readAuth(ctx context.Context, conn *TermConn) (Term, error) {
+	term, err := conn.ReadTerm(ctx)
+	if err != nil {
+		return nil, err
+	}
+	command, options, ok := parseInfo(term)
+	if !ok || command != AuthAtom || len(options) != 1 {
+		return nil, errUnauthorized
+	}
+	return options[0], nil
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data keyed with key.
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// Authenticate sends token in an {info, auth, [Token]} packet, for servers
+// using TokenAuth. It must be called before any call is made. If the
+// server rejects the token, the next call fails with protocol error 6.
+func (c *Client) Authenticate(ctx context.Context, token Term) error {
+	c.serial.Lock()
+	defer c.serial.Unlock()
+	c.setup()
+
+	if err := c.failed(); err != nil {
+		return err
+	}
+	if err := c.conn.WriteTerm(ctx, infoTerm(AuthAtom, token)); err != nil {
+		return c.fail(err)
+	}
+	return nil
+}
+
+// AuthenticateSecret answers the challenge of a server using SecretAuth
+// with the same secret. It must be called before any call is made. If the
+// server rejects the answer, the next call fails with protocol error 6.
+func (c *Client) AuthenticateSecret(ctx context.Context, secret []byte) error {
+	c.serial.Lock()
+	defer c.serial.Unlock()
+	c.setup()
+
+	if err := c.failed(); err != nil {
+		return err
+	}
+	term, err := c.conn.ReadTerm(ctx)
+	if err != nil {
+		return c.fail(err)
+	}
+	command, options, ok := parseInfo(term)
+	if !ok || command != ChallengeAtom || len(options) != 1 {
+		return c.fail(fmt.Errorf("unexpected packet %v", term))
+	}
+	nonce, ok := options[0].([]byte)
+	if !ok {
+		return c.fail(fmt.Errorf("unexpected packet %v", term))
+	}
+	if err := c.conn.WriteTerm(ctx, infoTerm(AuthAtom, hmacSHA256(secret, nonce))); err != nil {
+		return c.fail(err)
+	}
+	return nil
+}
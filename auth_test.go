@@ -0,0 +1,115 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// dial returns a client connected to s over TCP. Unlike pipe, writes do
+// not wait for the peer to read, which rejected connections rely on.
+func dial(t *testing.T, s *Server) *Client {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	c, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTokenAuth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := newTestServer()
+	s.Authenticate = TokenAuth(func(token Term) error {
+		if token != "open sesame" {
+			return errors.New("bad token")
+		}
+		return nil
+	})
+
+	c := dial(t, s)
+	if err := c.Authenticate(ctx, "open sesame"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Call(ctx, "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+	c.Close()
+
+	for _, multiplex := range []bool{false, true} {
+		c = dial(t, s)
+		c.Multiplex = multiplex
+		c.Authenticate(ctx, "guess")
+		_, err = c.Call(ctx, "math", "add", 1, 2)
+		assertEqual(t, &RPCError{Type: ProtocolError, Code: 6, Detail: "bad token"}, err)
+		c.Close()
+	}
+
+	c = dial(t, s)
+	defer c.Close()
+	_, err = c.Call(ctx, "math", "add", 1, 2)
+	assertEqual(t, &RPCError{Type: ProtocolError, Code: 6, Detail: "unauthorized"}, err)
+}
+
+func TestSecretAuth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := newTestServer()
+	s.Authenticate = SecretAuth([]byte("secret"))
+
+	c := dial(t, s)
+	if err := c.AuthenticateSecret(ctx, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Call(ctx, "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+	c.Close()
+
+	c = dial(t, s)
+	defer c.Close()
+	if err := c.AuthenticateSecret(ctx, []byte("guess")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Call(ctx, "math", "add", 1, 2)
+	assertProtocolError(t, 6, err)
+}
+
+func TestTLSAuthRequiresTLS(t *testing.T) {
+	s := newTestServer()
+	s.Authenticate = TLSAuth(nil)
+	c := dial(t, s)
+	defer c.Close()
+	_, err := c.Call(context.Background(), "math", "add", 1)
+	assertEqual(t, &RPCError{Type: ProtocolError, Code: 6, Detail: "not a TLS connection"}, err)
+}
+
+func TestServerIgnoresInfo(t *testing.T) {
+	c := pipe(newTestServer())
+	defer c.Close()
+	ctx := context.Background()
+	if err := c.Authenticate(ctx, "unused"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Call(ctx, "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+}
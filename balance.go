@@ -0,0 +1,380 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A BalanceStrategy selects the endpoint of a BalancedClient each call is
+// sent to.
+type BalanceStrategy int
+
+const (
+	// RoundRobin sends calls to each endpoint in turn.
+	RoundRobin BalanceStrategy = iota
+	// LeastPending sends calls to the endpoint with the fewest calls in
+	// flight, which favors faster servers.
+	LeastPending
+	// Random sends calls to an endpoint picked at random.
+	Random
+)
+
+// DefaultRetryAfter is the time a BalancedClient waits by default before
+// sending calls to an endpoint again after its connection failed.
+const DefaultRetryAfter = 5 * time.Second
+
+// ErrNoEndpoints is returned for calls on a BalancedClient without
+// endpoints.
+var ErrNoEndpoints = errors.New("no endpoints")
+
+// A BalancedClient makes BERT-RPC calls over connections to several
+// servers offering the same functions, spreading calls between them as
+// selected by Strategy.
+//
+// Connections are dialed on first use. An endpoint whose connection cannot
+// be established or fails is considered unhealthy and receives no calls
+// for RetryAfter, after which it is dialed again; calls only go to
+// unhealthy endpoints if all of them are. The call that found an endpoint
+// failing returns its error, unless Retry allows it to be retried.
+//
+// A BalancedClient with a single endpoint is a client that reconnects.
+type BalancedClient struct {
+	// Strategy selects the endpoint of each call.
+	Strategy BalanceStrategy
+
+	// Retry configures how failed calls and casts are retried. The zero
+	// value retries none.
+	Retry RetryPolicy
+
+	// Breaker configures the circuit breaker of each endpoint. The zero
+	// value disables them.
+	Breaker CircuitBreaker
+
+	// Dial connects to an endpoint. Nil means DialAddrContext, or Dial
+	// if set, which ignores ctx. It may set options of the returned
+	// client, such as Multiplex.
+	DialContext func(ctx context.Context, addr string) (*Client, error)
+
+	// Dial connects to an endpoint, as DialContext does, for dialers that
+	// take no context.
+	Dial func(addr string) (*Client, error)
+
+	// RetryAfter is the time an unhealthy endpoint is avoided. Zero means
+	// DefaultRetryAfter.
+	RetryAfter time.Duration
+
+	// Logger, if set, is told about endpoints becoming unhealthy and
+	// failures to refresh them.
+	Logger Logger
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int // of RoundRobin
+	closed    bool
+	stop      chan struct{} // closed by Close to stop refreshing
+}
+
+// An endpoint is a server of a BalancedClient.
+type endpoint struct {
+	addr string
+
+	// dial is held while dialing the endpoint, so that concurrent calls
+	// share the connection.
+	dial sync.Mutex
+
+	// guarded by the client's mu
+	client    *Client
+	pending   int
+	downUntil time.Time
+	lastErr   error
+	removed   bool // by Refresh, closing client once no call is pending
+	breaker   breaker
+}
+
+// An EndpointStatus describes an endpoint of a BalancedClient.
+type EndpointStatus struct {
+	Addr    string
+	Healthy bool
+	Open    bool  // whether its circuit breaker is open
+	Pending int   // calls in flight
+	Err     error // that made the endpoint unhealthy, if it is
+}
+
+// NewBalancedClient returns a client spreading calls between the servers
+// at addrs, given as for DialAddr.
+func NewBalancedClient(addrs ...string) *BalancedClient {
+	c := &BalancedClient{}
+	for _, addr := range addrs {
+		c.endpoints = append(c.endpoints, &endpoint{addr: addr})
+	}
+	return c
+}
+
+// Call calls module:function with args on one of the endpoints and
+// returns the result of the reply, as Client.Call does.
+func (c *BalancedClient) Call(ctx context.Context, module, function Atom, args ...Term) (Term, error) {
+	var result Term
+	err := c.do(ctx, CallAtom, module, function, func(client *Client) (err error) {
+		result, err = client.Call(ctx, module, function, args...)
+		return err
+	})
+	return result, err
+}
+
+// Cast sends a cast of module:function with args to one of the endpoints,
+// as Client.Cast does.
+func (c *BalancedClient) Cast(ctx context.Context, module, function Atom, args ...Term) error {
+	return c.do(ctx, CastAtom, module, function, func(client *Client) error {
+		return client.Cast(ctx, module, function, args...)
+	})
+}
+
+// Endpoints returns the status of each endpoint, in the order they were
+// given.
+func (c *BalancedClient) Endpoints() []EndpointStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	status := make([]EndpointStatus, len(c.endpoints))
+	for i, ep := range c.endpoints {
+		status[i] = EndpointStatus{Addr: ep.addr, Healthy: ep.healthy(now), Open: ep.breaker.open, Pending: ep.pending}
+		if !status[i].Healthy {
+			status[i].Err = ep.lastErr
+		}
+	}
+	return status
+}
+
+// Close closes the connections to all endpoints. Calls in flight fail with
+// ErrShutdown, as do later calls.
+func (c *BalancedClient) Close() error {
+	c.mu.Lock()
+	if !c.closed && c.stop != nil {
+		close(c.stop)
+	}
+	c.closed = true
+	var clients []*Client
+	for _, ep := range c.endpoints {
+		if ep.client != nil {
+			clients = append(clients, ep.client)
+			ep.client = nil
+		}
+	}
+	c.mu.Unlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+	return nil
+}
+
+// do makes a request of kind CallAtom or CastAtom for module:function with
+// fn on the client of an endpoint, retrying it as c.Retry allows.
+func (c *BalancedClient) do(ctx context.Context, kind, module, function Atom, fn func(*Client) error) error {
+	for attempt := 1; ; attempt++ {
+		ep, err := c.pick()
+		if err != nil {
+			return err
+		}
+		f, err := c.attempt(ctx, ep, fn)
+		c.release(ep, breakerFailure(err, f))
+		if err == nil || ctx.Err() != nil || !c.Retry.retry(attempt, err, f, kind, module, function) {
+			return err
+		}
+		logf(c.Logger, "bert: retrying %s %s:%s after attempt %d failed: %v", kind, module, function, attempt, err)
+		if sleep(ctx, c.Retry.backoff(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// attempt makes a request with fn on the client of ep, returning how it
+// failed and its error.
+func (c *BalancedClient) attempt(ctx context.Context, ep *endpoint, fn func(*Client) error) (failure, error) {
+	client, err := c.connect(ctx, ep)
+	if err != nil {
+		if err == ErrShutdown {
+			return failPermanent, err
+		}
+		return failUnsent, err
+	}
+	err = fn(client)
+	if err == nil {
+		return failPermanent, nil
+	}
+	if client.failed() != nil {
+		if callerGaveUp(ctx) {
+			// the caller gave up, which says nothing of the endpoint
+			c.disconnect(ep, client)
+		} else {
+			c.markDown(ep, client, err)
+		}
+	}
+	return classify(client, err), err
+}
+
+// callerGaveUp reports whether ctx is done or past its deadline, which
+// the connection deadline may reach slightly before ctx reports it.
+func callerGaveUp(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// pick selects the endpoint of a call and counts the call as pending.
+func (c *BalancedClient) pick() (*endpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrShutdown
+	}
+	if len(c.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	now := time.Now()
+	candidates := make([]*endpoint, 0, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		if ep.healthy(now) && ep.breaker.ready(now) {
+			candidates = append(candidates, ep)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, ep := range c.endpoints {
+			if ep.breaker.ready(now) {
+				candidates = append(candidates, ep)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrCircuitOpen
+	}
+
+	var ep *endpoint
+	switch c.Strategy {
+	case LeastPending:
+		for _, e := range candidates {
+			if ep == nil || e.pending < ep.pending {
+				ep = e
+			}
+		}
+	case Random:
+		ep = candidates[rand.Intn(len(candidates))]
+	default:
+		ep = candidates[c.next%len(candidates)]
+		c.next++
+	}
+	ep.pending++
+	ep.breaker.acquire()
+	return ep, nil
+}
+
+// release counts a call to ep as no longer pending, recording whether it
+// failed in the breaker of ep.
+func (c *BalancedClient) release(ep *endpoint, failed bool) {
+	c.mu.Lock()
+	ep.pending--
+	opened := c.Breaker.record(&ep.breaker, failed, time.Now())
+	var client *Client
+	if ep.removed && ep.pending == 0 {
+		client, ep.client = ep.client, nil
+	}
+	c.mu.Unlock()
+
+	if opened {
+		logf(c.Logger, "bert: circuit breaker of endpoint %s open", ep.addr)
+	}
+	if client != nil {
+		client.Close()
+	}
+}
+
+// connect returns the client of ep, dialing it if need be.
+func (c *BalancedClient) connect(ctx context.Context, ep *endpoint) (*Client, error) {
+	ep.dial.Lock()
+	defer ep.dial.Unlock()
+
+	c.mu.Lock()
+	client, closed := ep.client, c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, ErrShutdown
+	}
+	if client != nil {
+		return client, nil
+	}
+
+	var err error
+	switch {
+	case c.DialContext != nil:
+		client, err = c.DialContext(ctx, ep.addr)
+	case c.Dial != nil:
+		client, err = c.Dial(ep.addr)
+	default:
+		client, err = DialAddrContext(ctx, ep.addr)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			c.markDown(ep, nil, err)
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		client.Close()
+		return nil, ErrShutdown
+	}
+	// a removed endpoint keeps its connection for the calls still pending
+	ep.client = client
+	return client, nil
+}
+
+// markDown records that ep failed with err, closing client, its
+// connection, if that is still in use.
+func (c *BalancedClient) markDown(ep *endpoint, client *Client, err error) {
+	retryAfter := c.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = DefaultRetryAfter
+	}
+
+	c.mu.Lock()
+	if client != nil && ep.client != client {
+		// another call already replaced the connection
+		c.mu.Unlock()
+		return
+	}
+	ep.client = nil
+	ep.downUntil = time.Now().Add(retryAfter)
+	ep.lastErr = err
+	c.mu.Unlock()
+
+	logf(c.Logger, "bert: endpoint %s unhealthy for %v: %v", ep.addr, retryAfter, err)
+	if client != nil {
+		client.Close()
+	}
+}
+
+// disconnect closes client, the failed connection of ep, so that the next
+// call dials ep again, without making ep unhealthy.
+func (c *BalancedClient) disconnect(ep *endpoint, client *Client) {
+	c.mu.Lock()
+	if ep.client == client {
+		ep.client = nil
+	}
+	c.mu.Unlock()
+	client.Close()
+}
+
+// healthy reports whether ep may receive calls at now.
+func (ep *endpoint) healthy(now time.Time) bool {
+	return !now.Before(ep.downUntil)
+}
@@ -0,0 +1,162 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBalancedClient(t *testing.T) {
+	servers := map[string]*Server{}
+	for _, name := range []string{"a", "b"} {
+		name := Atom(name)
+		s := NewServer()
+		s.Register("who", "am_i", func(ctx context.Context, args []Term) (Term, error) {
+			return name, nil
+		})
+		servers[string(name)] = s
+	}
+	dial := func(addr string) (*Client, error) {
+		s, ok := servers[addr]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		return pipe(s), nil
+	}
+	ctx := context.Background()
+
+	c := NewBalancedClient("a", "b", "down")
+	c.Dial = dial
+	defer c.Close()
+
+	var got []Term
+	for i := 0; i < 2; i++ {
+		result, err := c.Call(ctx, "who", "am_i")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, result)
+	}
+	assertEqual(t, []Term{Atom("a"), Atom("b")}, got)
+
+	// The third endpoint fails once, then receives no more calls.
+	if _, err := c.Call(ctx, "who", "am_i"); err == nil || err.Error() != "connection refused" {
+		t.Errorf("expected the dial error, got %v", err)
+	}
+	status := c.Endpoints()
+	assertEqual(t, []bool{true, true, false}, []bool{status[0].Healthy, status[1].Healthy, status[2].Healthy})
+	for i := 0; i < 4; i++ {
+		if _, err := c.Call(ctx, "who", "am_i"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Close()
+	if _, err := c.Call(ctx, "who", "am_i"); err != ErrShutdown {
+		t.Errorf("expected ErrShutdown, got %v", err)
+	}
+}
+
+func TestBalancedClientCallerTimeout(t *testing.T) {
+	s := newTestServer()
+	s.Register("sync", "sleep", func(ctx context.Context, args []Term) (Term, error) {
+		time.Sleep(100 * time.Millisecond)
+		return Atom("ok"), nil
+	})
+	dials := 0
+	c := NewBalancedClient("a")
+	c.DialContext = func(ctx context.Context, addr string) (*Client, error) {
+		dials++
+		return pipe(s), nil
+	}
+	defer c.Close()
+
+	// The caller giving up closes the connection but leaves the endpoint
+	// healthy.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, "sync", "sleep"); err != context.DeadlineExceeded {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	assertEqual(t, true, c.Endpoints()[0].Healthy)
+	result, err := c.Call(context.Background(), "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+	assertEqual(t, 2, dials)
+
+	// Dialing stops with the context of the call.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DialAddrContext(cancelled, "127.0.0.1:1"); err == nil {
+		t.Error("expected an error dialing with a cancelled context")
+	}
+}
+
+func TestBalancedClientCallerTimeoutRepeated(t *testing.T) {
+	s := newTestServer()
+	s.Register("sync", "sleep", func(ctx context.Context, args []Term) (Term, error) {
+		time.Sleep(20 * time.Millisecond)
+		return Atom("ok"), nil
+	})
+	c := NewBalancedClient("a")
+	c.Dial = func(addr string) (*Client, error) { return pipe(s), nil }
+	defer c.Close()
+
+	// The connection deadline may fire before the context reports it.
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, err := c.Call(ctx, "sync", "sleep")
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("attempt %d: expected a timeout, got %v", i, err)
+		}
+		if !c.Endpoints()[0].Healthy {
+			t.Fatalf("attempt %d: endpoint marked down", i)
+		}
+	}
+}
+
+func TestBalancedClientLeastPending(t *testing.T) {
+	release := make(chan struct{})
+	slow, fast := NewServer(), NewServer()
+	slow.Register("who", "am_i", func(ctx context.Context, args []Term) (Term, error) {
+		<-release
+		return Atom("slow"), nil
+	})
+	fast.Register("who", "am_i", func(ctx context.Context, args []Term) (Term, error) {
+		return Atom("fast"), nil
+	})
+
+	c := NewBalancedClient("slow", "fast")
+	c.Strategy = LeastPending
+	c.Dial = func(addr string) (*Client, error) {
+		if addr == "slow" {
+			return pipe(slow), nil
+		}
+		return pipe(fast), nil
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	done := make(chan Term)
+	go func() {
+		result, _ := c.Call(ctx, "who", "am_i")
+		done <- result
+	}()
+	for c.Endpoints()[0].Pending == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		result, err := c.Call(ctx, "who", "am_i")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, Atom("fast"), result)
+	}
+	close(release)
+	assertEqual(t, Atom("slow"), <-done)
+}
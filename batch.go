@@ -0,0 +1,132 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// BatchAtom tags a {batch, [Packet...]} packet carrying several requests
+// or their responses, in the same order, in a single frame.
+const BatchAtom = Atom("batch")
+
+// A BatchCall is one call of a batch made with Client.CallBatch.
+type BatchCall struct {
+	Module   Atom
+	Function Atom
+	Args     []Term
+
+	// Result and Err are set once the batch has completed, to the result
+	// of the reply or the error of the response.
+	Result Term
+	Err    error
+}
+
+// CallBatch sends all calls in a single round trip and stores the outcome
+// of each in its Result and Err fields. The returned error reports a
+// failure of the batch as a whole. The server handles the calls of a batch
+// concurrently, each counting against its limits, and refuses nested
+// batches. This requires a peer that understands batches, such as
+// Server.
+func (c *Client) CallBatch(ctx context.Context, calls []BatchCall) error {
+	reqs := make([]Term, len(calls))
+	for i, call := range calls {
+		reqs[i] = requestTerm(CallAtom, call.Module, call.Function, call.Args)
+	}
+
+	resp, err := c.roundTrip(ctx, &outgoing{packet: []Term{BatchAtom, List{Items: reqs}}})
+	if err != nil {
+		return err
+	}
+	resps, ok := parseBatch(resp)
+	if !ok {
+		// a batch that cannot be handled is answered with a single error
+		if _, err := parseResponse(resp); err != nil {
+			return err
+		}
+		return fmt.Errorf("malformed batch response %v", resp)
+	}
+	if len(resps) != len(calls) {
+		return fmt.Errorf("batch of %d calls answered with %d responses", len(calls), len(resps))
+	}
+	for i, resp := range resps {
+		calls[i].Result, calls[i].Err = parseResponse(resp)
+	}
+	return nil
+}
+
+// parseBatch returns the packets of a batch.
+func parseBatch(term Term) ([]Term, bool) {
+	tuple, ok := term.([]Term)
+	if !ok || len(tuple) != 2 || tuple[0] != BatchAtom {
+		return nil, false
+	}
+	return listOf(tuple[1])
+}
+
+// batchConcurrency bounds the number of requests of a batch handled at
+// once.
+const batchConcurrency = 16
+
+// handleBatch answers the requests of a batch. They are handled
+// concurrently, in the slot of the batch itself and as many more as limits
+// leave free, up to batchConcurrency. Each request beyond the first counts
+// against the rate limit, and those it refuses are answered with protocol
+// error 5. Batches may not be nested.
+func (s *Server) handleBatch(ctx context.Context, reqs []Term, limits requestLimits) Term {
+	resps := make([]Term, len(reqs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	work := func() {
+		defer wg.Done()
+		for i := range next {
+			resps[i] = s.handleBatched(ctx, reqs[i], limits)
+		}
+	}
+
+	wg.Add(1)
+	go work()
+	workers := 1
+	for i := range reqs {
+		if i > 0 && !limits.allow() {
+			resps[i] = (&RPCError{Type: ProtocolError, Code: 5, Detail: "rate limit exceeded"}).term()
+			continue
+		}
+		select {
+		case next <- i:
+			continue
+		default:
+		}
+		// every worker is busy
+		if workers < batchConcurrency && limits.acquire() {
+			wg.Add(1)
+			go func() {
+				defer limits.release()
+				work()
+			}()
+			workers++
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return []Term{BatchAtom, List{Items: resps}}
+}
+
+// handleBatched answers a request within a batch.
+func (s *Server) handleBatched(ctx context.Context, req Term, limits requestLimits) Term {
+	if _, ok := parseBatch(req); ok {
+		return (&RPCError{Type: ProtocolError, Code: 0, Detail: "nested batch"}).term()
+	}
+	resp := s.handleRequest(ctx, req, limits)
+	if fn, ok := resp.(streamResponse); ok {
+		// streams cannot be interleaved within a batch
+		var buf bytes.Buffer
+		if err := fn(&buf); err != nil {
+			return userError(err).term()
+		}
+		return []Term{ReplyAtom, buf.Bytes()}
+	}
+	return resp
+}
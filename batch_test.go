@@ -0,0 +1,112 @@
+package bert
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallBatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, multiplex := range []bool{false, true} {
+		c := pipe(newTestServer())
+		c.Multiplex = multiplex
+		calls := []BatchCall{
+			{Module: "math", Function: "add", Args: []Term{1, 2}},
+			{Module: "math", Function: "fail"},
+			{Module: "math", Function: "add", Args: []Term{3, 4}},
+			{Module: "nope", Function: "add"},
+		}
+		if err := c.CallBatch(ctx, calls); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, int64(3), calls[0].Result)
+		assertEqual(t, nil, calls[0].Err)
+		assertEqual(t, &RPCError{Type: UserError, Detail: "boom"}, calls[1].Err)
+		assertEqual(t, int64(7), calls[2].Result)
+		if e, ok := calls[3].Err.(*RPCError); !ok || e.Type != ServerError || e.Code != 1 {
+			t.Errorf("expected server error 1, got %v", calls[3].Err)
+		}
+
+		if err := c.CallBatch(ctx, nil); err != nil {
+			t.Errorf("empty batch failed: %v", err)
+		}
+		c.Close()
+	}
+}
+
+func TestCallBatchLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Calls within a batch take the slots left by other requests.
+	s := newTestServer()
+	s.MaxConcurrentRequests = 2
+	var mu sync.Mutex
+	running, peak := 0, 0
+	s.Register("sync", "step", func(ctx context.Context, args []Term) (Term, error) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return Atom("ok"), nil
+	})
+	c := pipe(s)
+	calls := make([]BatchCall, 10)
+	for i := range calls {
+		calls[i] = BatchCall{Module: "sync", Function: "step"}
+	}
+	if err := c.CallBatch(ctx, calls); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range calls {
+		assertEqual(t, Atom("ok"), call.Result)
+	}
+	if peak > 2 {
+		t.Errorf("%d calls ran at once, expected at most 2", peak)
+	}
+	c.Close()
+
+	// Each call counts against the rate limit.
+	s = newTestServer()
+	s.RateLimit = 0.001
+	s.RateBurst = 2
+	c = pipe(s)
+	defer c.Close()
+	calls = []BatchCall{
+		{Module: "math", Function: "add", Args: []Term{1}},
+		{Module: "math", Function: "add", Args: []Term{2}},
+		{Module: "math", Function: "add", Args: []Term{3}},
+	}
+	if err := c.CallBatch(ctx, calls); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(1), calls[0].Result)
+	assertEqual(t, int64(2), calls[1].Result)
+	assertProtocolError(t, 5, calls[2].Err)
+}
+
+func TestCallBatchNested(t *testing.T) {
+	c := pipe(newTestServer())
+	defer c.Close()
+	nested := []Term{BatchAtom, List{Items: []Term{requestTerm(CallAtom, "math", "add", []Term{1})}}}
+	resp, err := c.roundTrip(context.Background(), &outgoing{packet: []Term{BatchAtom, List{Items: []Term{nested}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resps, ok := parseBatch(resp)
+	if !ok || len(resps) != 1 {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	_, err = parseResponse(resps[0])
+	assertProtocolError(t, 0, err)
+}
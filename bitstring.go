@@ -0,0 +1,141 @@
+package bert
+
+import "fmt"
+
+// NewBitstring returns the bitstring of the first n bits of data, which
+// must hold at least n bits. The bits of each byte are taken from the
+// most significant down, as in Erlang.
+func NewBitstring(data []byte, n int) Bitstring {
+	if n < 0 || n > 8*len(data) {
+		panic(fmt.Sprintf("bert: NewBitstring of %d bits from %d bytes", n, len(data)))
+	}
+	b := make([]byte, (n+7)/8)
+	copy(b, data)
+	return makeBitstring(b, n)
+}
+
+// makeBitstring returns the bitstring of the n bits of b, which holds
+// exactly (n+7)/8 bytes and is not shared, clearing its unused bits.
+func makeBitstring(b []byte, n int) Bitstring {
+	if r := n % 8; r != 0 {
+		b[len(b)-1] &^= 0xff >> r
+	}
+	bits := n
+	if n > 255 {
+		bits = trailingBits(n)
+	}
+	return Bitstring{Bytes: b, Bits: uint8(bits)}
+}
+
+// trailingBits returns the number of bits in the last byte of a non-empty
+// bitstring of n bits.
+func trailingBits(n int) int {
+	if r := n % 8; r != 0 {
+		return r
+	}
+	return 8
+}
+
+// data returns the bytes holding b, left-padded with zero bytes if Bytes
+// is shorter than Bits requires.
+func (b Bitstring) data() []byte {
+	n := (int(b.Bits) + 7) / 8
+	if n <= len(b.Bytes) {
+		return b.Bytes
+	}
+	padded := make([]byte, n)
+	copy(padded[n-len(b.Bytes):], b.Bytes)
+	return padded
+}
+
+// Len returns the number of bits in b: those of all bytes of Bytes but
+// the last, which holds Bits%8 bits, or 8 if that is zero. For bitstrings
+// of up to 255 bits, this is Bits, and Bytes may then be shorter than
+// Bits requires, in which case it is padded with leading zero bytes.
+func (b Bitstring) Len() int {
+	n := len(b.data())
+	if n == 0 {
+		return 0
+	}
+	return 8*(n-1) + trailingBits(int(b.Bits))
+}
+
+// BitAt returns the bit at index i of b, 0 or 1. It panics if i is out of
+// range.
+func (b Bitstring) BitAt(i int) uint8 {
+	if i < 0 || i >= b.Len() {
+		panic(fmt.Sprintf("bert: bit index %d out of range for bitstring of %d bits", i, b.Len()))
+	}
+	return b.data()[i/8] >> (7 - i%8) & 1
+}
+
+// Uint returns the n bits of b starting at index i as an unsigned
+// integer, most significant bit first. n may be at most 64. It panics if
+// the bits are out of range.
+func (b Bitstring) Uint(i, n int) uint64 {
+	if n > 64 || n < 0 || i < 0 || i+n > b.Len() {
+		panic(fmt.Sprintf("bert: bits [%d:%d] out of range for bitstring of %d bits", i, i+n, b.Len()))
+	}
+	data := b.data()
+	var v uint64
+	for j := i; j < i+n; j++ {
+		v = v<<1 | uint64(data[j/8]>>(7-j%8)&1)
+	}
+	return v
+}
+
+// Slice returns the bits of b from index i up to, but not including,
+// index j. It panics if the indexes are out of range.
+func (b Bitstring) Slice(i, j int) Bitstring {
+	if i < 0 || j < i || j > b.Len() {
+		panic(fmt.Sprintf("bert: slice [%d:%d] out of range for bitstring of %d bits", i, j, b.Len()))
+	}
+	dst := make([]byte, (j-i+7)/8)
+	copyBits(dst, 0, b.data(), i, j-i)
+	return makeBitstring(dst, j-i)
+}
+
+// Append returns b followed by the n low bits of v, most significant
+// first. n may be at most 64.
+func (b Bitstring) Append(v uint64, n int) Bitstring {
+	if n < 0 || n > 64 {
+		panic(fmt.Sprintf("bert: Append of %d bits", n))
+	}
+	if n == 0 {
+		return b
+	}
+	var src [8]byte
+	for k := 0; k < 8; k++ {
+		src[k] = byte(v << (64 - n) >> (56 - 8*k))
+	}
+	return b.Concat(Bitstring{Bytes: src[:(n+7)/8], Bits: uint8(trailingBits(n))})
+}
+
+// Concat returns the bits of b followed by those of o.
+func (b Bitstring) Concat(o Bitstring) Bitstring {
+	n, m := b.Len(), o.Len()
+	dst := make([]byte, (n+m+7)/8)
+	copyBits(dst, 0, b.data(), 0, n)
+	copyBits(dst, n, o.data(), 0, m)
+	return makeBitstring(dst, n+m)
+}
+
+// Binary returns the bytes of b, and whether it holds a whole number of
+// bytes, that is whether it is a binary.
+func (b Bitstring) Binary() ([]byte, bool) {
+	n := b.Len()
+	return b.data()[:(n+7)/8], n%8 == 0
+}
+
+// copyBits copies n bits of src, starting at bit index j, to dst starting
+// at bit index i.
+func copyBits(dst []byte, i int, src []byte, j, n int) {
+	if i%8 == 0 && j%8 == 0 {
+		copy(dst[i/8:], src[j/8:(j+n)/8])
+		i, j, n = i+n&^7, j+n&^7, n%8
+	}
+	for k := 0; k < n; k++ {
+		bit := src[(j+k)/8] >> (7 - (j+k)%8) & 1
+		dst[(i+k)/8] |= bit << (7 - (i+k)%8)
+	}
+}
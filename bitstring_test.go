@@ -0,0 +1,78 @@
+package bert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBitstring(t *testing.T) {
+	// <<5:3, 1:1>>
+	b := Bitstring{}.Append(5, 3).Append(1, 1)
+	assertEqual(t, Bitstring{[]byte{0xb0}, 4}, b)
+	assertEqual(t, 4, b.Len())
+	assertEqual(t, []uint8{1, 0, 1, 1}, []uint8{b.BitAt(0), b.BitAt(1), b.BitAt(2), b.BitAt(3)})
+	assertEqual(t, uint64(5), b.Uint(0, 3))
+	assertEqual(t, Bitstring{[]byte{0x60}, 3}, b.Slice(1, 4))
+
+	// Appending across byte boundaries.
+	c := b.Append(0xabc, 12)
+	assertEqual(t, 16, c.Len())
+	assertEqual(t, uint64(0xabc), c.Uint(4, 12))
+	data, ok := c.Binary()
+	assertEqual(t, true, ok)
+	assertEqual(t, []byte{0xba, 0xbc}, data)
+
+	whole := NewBitstring([]byte{1, 2, 3}, 24)
+	assertEqual(t, []byte{1, 2, 3}, whole.Concat(Bitstring{}).Bytes)
+	assertEqual(t, Bitstring{[]byte{2, 3, 0xb0}, 20}, whole.Slice(8, 24).Concat(b))
+
+	if _, ok := b.Binary(); ok {
+		t.Errorf("expected a 4-bit bitstring not to be a binary")
+	}
+	if !Equal(b, Bitstring{[]byte{0xbf}, 4}) {
+		t.Errorf("expected unused bits to be ignored by Equal")
+	}
+}
+
+func TestBitstringLong(t *testing.T) {
+	// Bitstrings of more than 255 bits keep only their trailing bit count.
+	long := NewBitstring(bytes.Repeat([]byte{0xff}, 40), 317)
+	assertEqual(t, 40, len(long.Bytes))
+	assertEqual(t, uint8(5), long.Bits)
+	assertEqual(t, 317, long.Len())
+	assertEqual(t, uint8(1), long.BitAt(316))
+
+	data, err := Encode(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 77, 0, 0, 0, 40, 5}, data[:7])
+	term, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 317, term.(Bitstring).Len())
+	assertEqual(t, true, Equal(long, term))
+
+	// Short Bytes are padded with leading zero bytes.
+	assertEqual(t, 10, Bitstring{[]byte{3}, 10}.Len())
+}
+
+func TestBitstringPanics(t *testing.T) {
+	for name, fn := range map[string]func(){
+		"BitAt":  func() { Bitstring{[]byte{0}, 3}.BitAt(3) },
+		"Slice":  func() { Bitstring{[]byte{0}, 3}.Slice(2, 4) },
+		"Uint":   func() { NewBitstring(make([]byte, 9), 72).Uint(0, 65) },
+		"Append": func() { Bitstring{}.Append(0, 65) },
+		"New":    func() { NewBitstring([]byte{0}, 9) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %s to panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
@@ -0,0 +1,118 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Defaults of CircuitBreaker.
+const (
+	DefaultBreakerWindow   = 20
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned for requests on a BalancedClient whose
+// endpoints all have their circuit breaker open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// A CircuitBreaker configures the breaker a BalancedClient keeps for each
+// endpoint, so that a dead or overloaded server is not sent requests that
+// would only wait for it to fail.
+//
+// Requests fail, for a breaker, if their endpoint could not be dialed, its
+// connection failed, the server refused them as too busy, or their
+// context's deadline passed. Once FailureRate of the last Window requests
+// to an endpoint have failed, its breaker opens and the endpoint receives
+// no requests for Cooldown. Then a single request is let through: the
+// breaker closes if it succeeds and opens again if it fails. Requests are
+// failed with ErrCircuitOpen when the breakers of all endpoints are open.
+type CircuitBreaker struct {
+	// FailureRate, from 0 to 1, is the fraction of failed requests that
+	// opens a breaker. Zero disables breakers.
+	FailureRate float64
+
+	// Window is the number of recent requests considered, and the least
+	// that must have been made before a breaker opens. Zero means
+	// DefaultBreakerWindow.
+	Window int
+
+	// Cooldown is the time a breaker stays open. Zero means
+	// DefaultBreakerCooldown.
+	Cooldown time.Duration
+}
+
+// A breaker is the circuit breaker of an endpoint.
+type breaker struct {
+	outcomes  []bool // of the last requests, true for failures, as a ring
+	next      int
+	failures  int
+	open      bool
+	openUntil time.Time
+	probing   bool // a request is let through an open breaker
+}
+
+// ready reports whether a request may go through b at now.
+func (b *breaker) ready(now time.Time) bool {
+	return !b.open || (!b.probing && !now.Before(b.openUntil))
+}
+
+// acquire lets a request through b, which must be ready.
+func (b *breaker) acquire() {
+	if b.open {
+		b.probing = true
+	}
+}
+
+// record records the outcome of a request let through b at now.
+// It reports whether that opened b.
+func (cb *CircuitBreaker) record(b *breaker, failed bool, now time.Time) bool {
+	if cb.FailureRate <= 0 {
+		return false
+	}
+	cooldown := cb.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	if b.open {
+		if !b.probing {
+			// a request let through before the breaker opened
+			return false
+		}
+		b.probing = false
+		if failed {
+			b.openUntil = now.Add(cooldown)
+			return true
+		}
+		*b = breaker{}
+		return false
+	}
+
+	window := cb.Window
+	if window <= 0 {
+		window = DefaultBreakerWindow
+	}
+	if len(b.outcomes) < window {
+		b.outcomes = append(b.outcomes, failed)
+	} else {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+		b.outcomes[b.next] = failed
+		b.next = (b.next + 1) % window
+	}
+	if failed {
+		b.failures++
+	}
+	if len(b.outcomes) == window && float64(b.failures) >= cb.FailureRate*float64(window) {
+		b.open, b.openUntil = true, now.Add(cooldown)
+		return true
+	}
+	return false
+}
+
+// breakerFailure reports whether a request that failed with err,
+// classified as f, counts as a failure for circuit breakers.
+func breakerFailure(err error, f failure) bool {
+	return err != nil && (f != failPermanent || err == context.DeadlineExceeded)
+}
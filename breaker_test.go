@@ -0,0 +1,72 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := CircuitBreaker{FailureRate: 0.5, Window: 4, Cooldown: time.Minute}
+	var b breaker
+	now := time.Now()
+	for _, failed := range []bool{false, true, false} {
+		if cb.record(&b, failed, now) {
+			t.Fatalf("expected the breaker to stay closed before the window fills")
+		}
+	}
+	if !cb.record(&b, true, now) {
+		t.Fatalf("expected 2 failures out of 4 to open the breaker")
+	}
+	assertEqual(t, false, b.ready(now))
+
+	// After the cooldown, a single request is let through.
+	later := now.Add(time.Minute)
+	assertEqual(t, true, b.ready(later))
+	b.acquire()
+	assertEqual(t, false, b.ready(later))
+	assertEqual(t, true, cb.record(&b, true, later))
+	assertEqual(t, false, b.ready(later.Add(time.Second)))
+
+	b.acquire()
+	even := later.Add(time.Minute)
+	assertEqual(t, false, cb.record(&b, false, even))
+	assertEqual(t, breaker{}, b)
+}
+
+func TestBalancedClientBreaker(t *testing.T) {
+	s := NewServer()
+	s.Register("who", "am_i", func(ctx context.Context, args []Term) (Term, error) {
+		return Atom("ok"), nil
+	})
+	dials := 0
+	c := NewBalancedClient("down", "ok")
+	c.Dial = func(addr string) (*Client, error) {
+		if addr == "down" {
+			dials++
+			return nil, errors.New("connection refused")
+		}
+		return pipe(s), nil
+	}
+	// endpoints are retried at once, so only the breaker keeps them away
+	c.RetryAfter = time.Nanosecond
+	c.Breaker = CircuitBreaker{FailureRate: 1, Window: 2, Cooldown: time.Minute}
+	defer c.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		c.Call(ctx, "who", "am_i")
+	}
+	assertEqual(t, 2, dials)
+	status := c.Endpoints()
+	assertEqual(t, []bool{true, false}, []bool{status[0].Open, status[1].Open})
+
+	c = NewBalancedClient("down")
+	c.Dial = func(addr string) (*Client, error) { return nil, errors.New("connection refused") }
+	c.Breaker = CircuitBreaker{FailureRate: 1, Window: 1}
+	c.Call(ctx, "who", "am_i")
+	if _, err := c.Call(ctx, "who", "am_i"); err != ErrCircuitOpen {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package bert
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer is the capacity beyond which buffers are not kept for
+// reuse, so that a single large value does not pin its memory.
+const maxPooledBuffer = 64 << 10
+
+var encodeBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// EncodeBatch encodes each of vals, as Encode does, spreading them over
+// as many goroutines as GOMAXPROCS allows, and returns their encodings in
+// the same order. The values must not be modified until it returns. If any
+// cannot be encoded, the error of the first one, in order, is returned
+// with its index prefixed to the path of the error, and no encodings.
+func EncodeBatch(vals []interface{}) ([][]byte, error) {
+	out := make([][]byte, len(vals))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(vals) {
+		workers = len(vals)
+	}
+
+	var (
+		next   int64 = -1
+		failed int32
+		mu     sync.Mutex
+		first  = len(vals) // index of the first failure
+		err    error
+		wg     sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := encodeBuffers.Get().(*bytes.Buffer)
+			e := NewEncoder(buf)
+			// values are taken in order, so that once one fails those
+			// before it have all been taken and the first failure is found
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(vals) {
+					break
+				}
+				buf.Reset()
+				if encErr := e.Encode(vals[i]); encErr != nil {
+					atomic.StoreInt32(&failed, 1)
+					mu.Lock()
+					if i < first {
+						first, err = i, encErr
+					}
+					mu.Unlock()
+					break
+				}
+				out[i] = append([]byte(nil), buf.Bytes()...)
+			}
+			if buf.Cap() <= maxPooledBuffer {
+				encodeBuffers.Put(buf)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err != nil {
+		return nil, withPath(err, fmt.Sprintf("[%d]", first))
+	}
+	return out, nil
+}
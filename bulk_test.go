@@ -0,0 +1,36 @@
+package bert
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEncodeBatch(t *testing.T) {
+	vals := make([]interface{}, 1000)
+	for i := range vals {
+		vals[i] = []Term{Atom("msg"), i, fmt.Sprint(i)}
+	}
+	out, err := EncodeBatch(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(vals), len(out))
+	for i, val := range vals {
+		want, err := Encode(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, want, out[i])
+	}
+
+	out, err = EncodeBatch(nil)
+	if err != nil || len(out) != 0 {
+		t.Errorf("empty batch: got %v, %v", out, err)
+	}
+
+	vals[700] = []Term{make(chan int)}
+	vals[900] = true
+	if _, err := EncodeBatch(vals); err == nil || err.Error() != "cannot encode chan int at [700][0]: unknown type" {
+		t.Errorf("expected error of value 700, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package bert
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Atoms of the bus protocol. Peers send {subscribe, Topic},
+// {unsubscribe, Topic} and {publish, Topic, Term}, and receive
+// {event, Topic, Term} for the topics they subscribed to.
+const (
+	SubscribeAtom   = Atom("subscribe")
+	UnsubscribeAtom = Atom("unsubscribe")
+	PublishAtom     = Atom("publish")
+	EventAtom       = Atom("event")
+)
+
+// An Event is a term published on a topic of a Bus.
+type Event struct {
+	Topic Atom
+	Term  Term
+}
+
+// OverflowPolicy selects what a Bus does when a subscriber's queue is
+// full.
+type OverflowPolicy int
+
+const (
+	// DropNewest drops the event that does not fit. This is the default.
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the oldest queued event to make room.
+	DropOldest
+	// Disconnect ends the subscription, closing its channel or the
+	// connection of a remote subscriber.
+	Disconnect
+)
+
+// DefaultBusBuffer is the queue size of subscribers when none is
+// configured.
+const DefaultBusBuffer = 64
+
+// A Bus fans out the events published on a topic to its subscribers,
+// which may be local, through Subscribe, or remote peers served over a
+// TermConn by ServeConn. Publishing never blocks: each subscriber has a
+// queue, and Overflow decides what happens when it is full.
+//
+// The zero value is ready to use. Buffer and Overflow must be set before
+// the bus is used.
+type Bus struct {
+	// Buffer is the number of events queued for each subscriber. Zero
+	// means DefaultBusBuffer.
+	Buffer int
+
+	// Overflow selects what happens to events for a subscriber whose
+	// queue is full.
+	Overflow OverflowPolicy
+
+	// Logger, if set, is told about malformed messages from peers and
+	// subscribers disconnected or dropping events.
+	Logger Logger
+
+	mu     sync.RWMutex
+	topics map[Atom]map[*subscriber]struct{}
+}
+
+// A subscriber is the queue of events for one local or remote subscriber.
+type subscriber struct {
+	ch     chan Event
+	topics map[Atom]struct{} // guarded by Bus.mu
+	closed bool              // guarded by Bus.mu
+}
+
+func (b *Bus) newSubscriber() *subscriber {
+	n := b.Buffer
+	if n <= 0 {
+		n = DefaultBusBuffer
+	}
+	return &subscriber{ch: make(chan Event, n), topics: make(map[Atom]struct{})}
+}
+
+// subscribe adds s to the subscribers of topic.
+func (b *Bus) subscribe(s *subscriber, topic Atom) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	if b.topics == nil {
+		b.topics = make(map[Atom]map[*subscriber]struct{})
+	}
+	subs := b.topics[topic]
+	if subs == nil {
+		subs = make(map[*subscriber]struct{})
+		b.topics[topic] = subs
+	}
+	subs[s] = struct{}{}
+	s.topics[topic] = struct{}{}
+}
+
+// unsubscribe removes s from the subscribers of topic.
+func (b *Bus) unsubscribe(s *subscriber, topic Atom) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(s, topic)
+}
+
+func (b *Bus) remove(s *subscriber, topic Atom) {
+	delete(s.topics, topic)
+	if subs := b.topics[topic]; subs != nil {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+}
+
+// close removes s from all its topics and closes its queue.
+func (b *Bus) close(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	for topic := range s.topics {
+		b.remove(s, topic)
+	}
+	s.closed = true
+	close(s.ch)
+}
+
+// Publish sends term to the subscribers of topic.
+func (b *Bus) Publish(topic Atom, term Term) {
+	ev := Event{Topic: topic, Term: term}
+	var overflowed []*subscriber
+
+	b.mu.RLock()
+	for s := range b.topics[topic] {
+		if !b.deliver(s, ev) {
+			overflowed = append(overflowed, s)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range overflowed {
+		logf(b.Logger, "bert: disconnecting subscriber to %s whose queue is full", topic)
+		b.close(s)
+	}
+}
+
+// deliver queues ev for s, reporting false if s must be disconnected.
+func (b *Bus) deliver(s *subscriber, ev Event) bool {
+	select {
+	case s.ch <- ev:
+		return true
+	default:
+	}
+
+	switch b.Overflow {
+	case DropOldest:
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	case Disconnect:
+		return false
+	default:
+		logf(b.Logger, "bert: dropping event on %s for a subscriber whose queue is full", ev.Topic)
+	}
+	return true
+}
+
+// A Subscription receives the events of the topics it is subscribed to on
+// C, until it is closed.
+type Subscription struct {
+	C <-chan Event
+
+	bus *Bus
+	sub *subscriber
+}
+
+// Subscribe returns a subscription to the events published on topics.
+func (b *Bus) Subscribe(topics ...Atom) *Subscription {
+	s := b.newSubscriber()
+	for _, topic := range topics {
+		b.subscribe(s, topic)
+	}
+	return &Subscription{C: s.ch, bus: b, sub: s}
+}
+
+// Add subscribes s to topic as well.
+func (s *Subscription) Add(topic Atom) { s.bus.subscribe(s.sub, topic) }
+
+// Remove unsubscribes s from topic.
+func (s *Subscription) Remove(topic Atom) { s.bus.unsubscribe(s.sub, topic) }
+
+// Close ends the subscription and closes C.
+func (s *Subscription) Close() { s.bus.close(s.sub) }
+
+// ServeConn serves the bus to the peer at the other end of c until the
+// peer disconnects, ctx is done or the peer is disconnected by the
+// Disconnect policy, and then closes c. Events published by the peer are
+// fanned out like those passed to Publish.
+func (b *Bus) ServeConn(ctx context.Context, c *TermConn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := b.newSubscriber()
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		defer c.Close()
+		for ev := range s.ch {
+			if err := c.WriteTerm(ctx, []Term{EventAtom, ev.Topic, ev.Term}); err != nil {
+				return
+			}
+		}
+	}()
+	defer func() {
+		b.close(s)
+		<-written
+	}()
+
+	for {
+		term, err := c.ReadTerm(ctx)
+		if err != nil {
+			return err
+		}
+		if err := b.handle(s, term); err != nil {
+			logf(b.Logger, "bert: bus message from %s: %v", c.Conn().RemoteAddr(), err)
+		}
+	}
+}
+
+// handle carries out a message of the bus protocol from a peer.
+func (b *Bus) handle(s *subscriber, term Term) error {
+	msg, ok := term.([]Term)
+	if !ok || len(msg) < 2 {
+		return fmt.Errorf("malformed message %v", term)
+	}
+	topic, ok := msg[1].(Atom)
+	if !ok {
+		return fmt.Errorf("malformed message %v", term)
+	}
+	switch {
+	case msg[0] == SubscribeAtom && len(msg) == 2:
+		b.subscribe(s, topic)
+	case msg[0] == UnsubscribeAtom && len(msg) == 2:
+		b.unsubscribe(s, topic)
+	case msg[0] == PublishAtom && len(msg) == 3:
+		b.Publish(topic, msg[2])
+	default:
+		return fmt.Errorf("malformed message %v", term)
+	}
+	return nil
+}
+
+// A BusClient uses a bus served by Bus.ServeConn at the other end of a
+// TermConn.
+type BusClient struct {
+	c *TermConn
+}
+
+// NewBusClient returns a client of the bus served over c.
+func NewBusClient(c *TermConn) *BusClient {
+	return &BusClient{c: c}
+}
+
+// Subscribe subscribes to the events published on topic.
+func (b *BusClient) Subscribe(ctx context.Context, topic Atom) error {
+	return b.c.WriteTerm(ctx, []Term{SubscribeAtom, topic})
+}
+
+// Unsubscribe unsubscribes from the events published on topic.
+func (b *BusClient) Unsubscribe(ctx context.Context, topic Atom) error {
+	return b.c.WriteTerm(ctx, []Term{UnsubscribeAtom, topic})
+}
+
+// Publish publishes val on topic.
+func (b *BusClient) Publish(ctx context.Context, topic Atom, val interface{}) error {
+	return b.c.WriteTerm(ctx, []Term{PublishAtom, topic, val})
+}
+
+// Next returns the next event received.
+func (b *BusClient) Next(ctx context.Context) (Event, error) {
+	term, err := b.c.ReadTerm(ctx)
+	if err != nil {
+		return Event{}, err
+	}
+	msg, ok := term.([]Term)
+	if !ok || len(msg) != 3 || msg[0] != EventAtom {
+		return Event{}, fmt.Errorf("malformed event %v", term)
+	}
+	topic, ok := msg[1].(Atom)
+	if !ok {
+		return Event{}, fmt.Errorf("malformed event %v", term)
+	}
+	return Event{Topic: topic, Term: msg[2]}, nil
+}
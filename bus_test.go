@@ -0,0 +1,73 @@
+package bert
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBus(t *testing.T) {
+	var b Bus
+	sub := b.Subscribe("blocks")
+	defer sub.Close()
+
+	client, server := net.Pipe()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- b.ServeConn(ctx, NewTermConn(server)) }()
+
+	bc := NewBusClient(NewTermConn(client))
+	if err := bc.Subscribe(ctx, "tickets"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Publish(ctx, "blocks", 7); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Event{Topic: "blocks", Term: int64(7)}, <-sub.C)
+
+	b.Publish("tickets", []Term{Atom("ticket"), 1})
+	ev, err := bc.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Event{Topic: "tickets", Term: []Term{Atom("ticket"), int64(1)}}, ev)
+
+	client.Close()
+	if err := <-served; err == nil {
+		t.Errorf("expected ServeConn to end with the connection")
+	}
+}
+
+func TestBusOverflow(t *testing.T) {
+	b := Bus{Buffer: 2, Overflow: DropOldest}
+	sub := b.Subscribe("n")
+	for i := 0; i < 4; i++ {
+		b.Publish("n", i)
+	}
+	assertEqual(t, 2, (<-sub.C).Term)
+	assertEqual(t, 3, (<-sub.C).Term)
+	sub.Close()
+
+	b.Overflow = DropNewest
+	sub = b.Subscribe("n")
+	for i := 0; i < 4; i++ {
+		b.Publish("n", i)
+	}
+	assertEqual(t, 0, (<-sub.C).Term)
+	assertEqual(t, 1, (<-sub.C).Term)
+	sub.Close()
+
+	b.Overflow = Disconnect
+	sub = b.Subscribe("n")
+	for i := 0; i < 4; i++ {
+		b.Publish("n", i)
+	}
+	var got []Term
+	for ev := range sub.C {
+		got = append(got, ev.Term)
+	}
+	assertEqual(t, []Term{0, 1}, got)
+	sub.Close()
+}
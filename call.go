@@ -0,0 +1,37 @@
+package bert
+
+import "context"
+
+// A Call is an asynchronous call made with Client.Go.
+type Call struct {
+	Module   Atom
+	Function Atom
+	Args     []Term
+
+	// Result and Error are set once the call has completed, after which
+	// the Call is sent on Done.
+	Result Term
+	Error  error
+	Done   chan *Call
+}
+
+// Go calls module:function with args asynchronously and returns the Call
+// describing it. The Call is sent on done once it completes. If done is
+// nil, a new channel is allocated; otherwise it must be buffered, as Go
+// would block sending to it. Calls only proceed concurrently with
+// Multiplex set; otherwise they are made one at a time in an unspecified
+// order.
+func (c *Client) Go(ctx context.Context, module, function Atom, args []Term, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		panic("bert: done channel is unbuffered")
+	}
+
+	call := &Call{Module: module, Function: function, Args: args, Done: done}
+	go func() {
+		call.Result, call.Error = c.Call(ctx, module, function, args...)
+		call.Done <- call
+	}()
+	return call
+}
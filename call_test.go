@@ -0,0 +1,41 @@
+package bert
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientGo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := pipe(newTestServer())
+	c.Multiplex = true
+	defer c.Close()
+
+	done := make(chan *Call, 20)
+	for i := 0; i < 20; i++ {
+		c.Go(ctx, "math", "add", []Term{i, 1}, done)
+	}
+	seen := make(map[int64]bool)
+	for i := 0; i < 20; i++ {
+		call := <-done
+		if call.Error != nil {
+			t.Fatal(call.Error)
+		}
+		assertEqual(t, int64(call.Args[0].(int)+1), call.Result)
+		seen[call.Result.(int64)] = true
+	}
+	assertEqual(t, 20, len(seen))
+
+	call := <-c.Go(ctx, "math", "fail", nil, nil).Done
+	assertEqual(t, &RPCError{Type: UserError, Detail: "boom"}, call.Error)
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for unbuffered done channel")
+		}
+	}()
+	c.Go(ctx, "math", "add", nil, make(chan *Call))
+}
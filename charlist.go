@@ -0,0 +1,110 @@
+package bert
+
+import (
+	"fmt"
+	"reflect"
+	"unicode/utf8"
+)
+
+// A Charlist is an Erlang string, that is a list of integer code points,
+// held as Go UTF-8 text. Charlists whose code points all fit in a byte
+// encode as STRING_EXT and others as a list of integers, as
+// term_to_binary does. Unmarshal fills them from such lists, from
+// STRING_EXT terms, whose bytes are Latin-1 code points, and from UTF-8
+// binaries, as Elixir strings are.
+type Charlist string
+
+var charlistType = reflect.TypeOf(Charlist(""))
+
+// A latin1String is the Latin-1 text of a Charlist, which always encodes as
+// STRING_EXT whatever the Encoder's StringEncoding.
+type latin1String string
+
+var latin1StringType = reflect.TypeOf(latin1String(""))
+
+func init() {
+	RegisterEncoder(charlistType, func(v interface{}) (Term, error) {
+		return v.(Charlist).term()
+	})
+	registerConverter(charlistType, func(term Term) (interface{}, bool, error) {
+		switch x := term.(type) {
+		case string:
+			return latin1Charlist(x), true, nil
+		case []byte:
+			if !utf8.Valid(x) {
+				return nil, false, fmt.Errorf("invalid UTF-8 in binary %q", x)
+			}
+			return Charlist(x), true, nil
+		case []Term, List:
+			items, _ := listOf(x)
+			return codePointCharlist(items)
+		}
+		return nil, false, nil
+	})
+}
+
+// term returns the STRING_EXT or integer list form of c.
+func (c Charlist) term() (Term, error) {
+	if !utf8.ValidString(string(c)) {
+		return nil, fmt.Errorf("invalid UTF-8 in charlist %q", string(c))
+	}
+	runes := []rune(string(c))
+	if len(runes) == 0 {
+		return List{}, nil
+	}
+
+	latin1 := len(runes) <= 65535
+	for _, r := range runes {
+		if r > 255 {
+			latin1 = false
+			break
+		}
+	}
+	if latin1 {
+		b := make([]byte, len(runes))
+		for i, r := range runes {
+			b[i] = byte(r)
+		}
+		return latin1String(b), nil
+	}
+
+	items := make([]Term, len(runes))
+	for i, r := range runes {
+		items[i] = int(r)
+	}
+	return List{Items: items}, nil
+}
+
+// items returns the code points of c as a list.
+func (c Charlist) items() []Term {
+	var items []Term
+	for _, r := range string(c) {
+		items = append(items, int64(r))
+	}
+	return items
+}
+
+// latin1Charlist returns the charlist of the code points in the bytes of s.
+func latin1Charlist(s string) Charlist {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return Charlist(runes)
+}
+
+// codePointCharlist returns the charlist of a list of integer code points.
+func codePointCharlist(items []Term) (interface{}, bool, error) {
+	runes := make([]rune, len(items))
+	for i, item := range items {
+		n, ok := item.(int64)
+		if !ok {
+			return nil, false, nil
+		}
+		if n < 0 || n > utf8.MaxRune || !utf8.ValidRune(rune(n)) {
+			return nil, false, fmt.Errorf("invalid code point %d at [%d]", n, i)
+		}
+		runes[i] = rune(n)
+	}
+	return Charlist(runes), true, nil
+}
@@ -0,0 +1,37 @@
+package bert
+
+import "testing"
+
+func TestCharlist(t *testing.T) {
+	assertEncode(t, Charlist("héllo"), []byte{131, 107, 0, 5, 104, 233, 108, 108, 111})
+	assertEncode(t, Charlist("€1"), []byte{131, 108, 0, 0, 0, 2, 98, 0, 0, 32, 172, 97, 49, 106})
+	assertEncode(t, Charlist(""), []byte{131, 106})
+	assertNotEncode(t, Charlist("\xff"), `cannot encode bert.Charlist: invalid UTF-8 in charlist "\xff"`)
+
+	for _, data := range [][]byte{
+		{131, 107, 0, 5, 104, 233, 108, 108, 111},
+		{131, 108, 0, 0, 0, 5, 97, 104, 97, 233, 97, 108, 97, 108, 97, 111, 106},
+		{131, 109, 0, 0, 0, 6, 104, 195, 169, 108, 108, 111},
+	} {
+		var c Charlist
+		if err := Unmarshal(data, &c); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, Charlist("héllo"), c)
+	}
+
+	var c Charlist
+	if err := Unmarshal([]byte{131, 108, 0, 0, 0, 2, 98, 0, 0, 32, 172, 97, 49, 106}, &c); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Charlist("€1"), c)
+	if err := Unmarshal([]byte{131, 106}, &c); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Charlist(""), c)
+	if err := UnmarshalTerm([]Term{int64(-1)}, &c); err == nil {
+		t.Errorf("expected error for an invalid code point")
+	}
+
+	assertEqual(t, true, Equal(Charlist("€1"), List{Items: []Term{int64(8364), int64(49)}}))
+}
@@ -0,0 +1,341 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync"
+)
+
+// StreamAtom is the info command announcing a streamed response.
+const StreamAtom = Atom("stream")
+
+// maxChunkSize bounds the size of each chunk of a streamed response.
+const maxChunkSize = 64 << 10
+
+// DefaultMaxStreamBuffer is the default for Client.MaxStreamBuffer.
+const DefaultMaxStreamBuffer = 16 << 20
+
+// A StreamFunc produces a streamed binary response by writing it to w,
+// as an alternative to returning an io.Reader from a handler. Each write
+// is sent as soon as it is made. If it returns an error after writing,
+// the error is sent to the client of a multiplexed connection in place of
+// the end of the stream; a plain connection is closed instead.
+type StreamFunc func(w io.Writer) error
+
+// streamResponse is the response of a handler that streams its result.
+type streamResponse StreamFunc
+
+// streamReader returns the streamed response copying r.
+func streamReader(r io.Reader) streamResponse {
+	return func(w io.Writer) error {
+		if c, ok := r.(io.Closer); ok {
+			defer c.Close()
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+}
+
+// isStreamStart reports whether term is the {info, stream, []} packet
+// announcing a streamed response.
+func isStreamStart(term Term) bool {
+	command, _, ok := parseInfo(term)
+	return ok && command == StreamAtom
+}
+
+// chunkOverhead bounds the size of the envelope of a chunk.
+const chunkOverhead = 32
+
+// A chunkWriter sends each write as one or more chunks of at most size
+// bytes.
+type chunkWriter struct {
+	size int
+	send func(chunk []byte) error
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.size {
+			chunk = chunk[:w.size]
+		}
+		if err := w.send(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// stream sends a streamed response. As BERT-RPC specifies, a plain
+// connection carries the {info, stream, []} packet followed by the chunks
+// as raw frames, ending with an empty frame. A multiplexed connection
+// carries each of these enveloped, with the chunks as binaries.
+func (sc *serverConn) stream(req *inflightRequest, fn streamResponse) {
+	ctx := sc.ctx
+	if !req.enveloped {
+		if err := sc.conn.WriteTerm(ctx, infoTerm(StreamAtom)); err != nil {
+			return
+		}
+		if err := fn(chunkWriter{sc.chunkSize(), func(chunk []byte) error { return sc.conn.WriteFrame(ctx, chunk) }}); err != nil {
+			sc.conn.Close()
+			return
+		}
+		sc.conn.WriteFrame(ctx, nil)
+		return
+	}
+
+	if err := sc.conn.WriteTerm(ctx, []Term{req.seq, infoTerm(StreamAtom)}); err != nil {
+		return
+	}
+	err := fn(chunkWriter{sc.chunkSize(), func(chunk []byte) error { return sc.conn.WriteTerm(ctx, []Term{req.seq, chunk}) }})
+	if err != nil {
+		sc.conn.WriteTerm(ctx, []Term{req.seq, userError(err).term()})
+		return
+	}
+	sc.conn.WriteTerm(ctx, []Term{req.seq, []byte{}})
+}
+
+// chunkSize returns the size of the chunks of streamed responses, which
+// must fit, with their envelope, in the frames the client accepts.
+func (sc *serverConn) chunkSize() int {
+	sc.conn.wmu.Lock()
+	size := sc.conn.writeLimit() - chunkOverhead
+	sc.conn.wmu.Unlock()
+	if size > maxChunkSize {
+		size = maxChunkSize
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// CallStream calls module:function with args and returns a reader for its
+// streamed binary response, which must be closed. A response that is not
+// streamed is returned as a reader of its binary result. Without
+// Multiplex, no other call can be made until the stream has been read to
+// the end or closed.
+func (c *Client) CallStream(ctx context.Context, module, function Atom, args ...Term) (io.ReadCloser, error) {
+	resp, err := c.roundTrip(ctx, &outgoing{packet: requestTerm(CallAtom, module, function, args)})
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := resp.(*responseStream); ok {
+		return s, nil
+	}
+
+	result, err := parseResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := result.([]byte)
+	if !ok {
+		return nil, &UnmarshalTypeError{Term: result, Type: reflect.TypeOf([]byte(nil))}
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+var (
+	errStreamClosed   = errors.New("read from closed stream")
+	errStreamOverflow = errors.New("stream exceeds its buffer unread")
+)
+
+// A responseStream reads a streamed response chunk by chunk.
+type responseStream struct {
+	next  func() ([]byte, error)
+	close func()
+	queue *chunkQueue // for multiplexed streams
+	buf   []byte
+	err   error
+}
+
+func (s *responseStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.buf, s.err = s.next()
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Close discards the rest of the stream.
+func (s *responseStream) Close() error {
+	if s.err == nil {
+		s.close()
+		s.err = errStreamClosed
+	}
+	s.buf = nil
+	return nil
+}
+
+// serialStream returns the stream read from the connection while c.serial
+// is held, releasing it once the stream ends.
+func (c *Client) serialStream(ctx context.Context) *responseStream {
+	var release sync.Once
+	s := &responseStream{}
+	s.next = func() ([]byte, error) {
+		frame, err := c.conn.ReadFrame(ctx)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			release.Do(c.serial.Unlock)
+			return nil, c.fail(err)
+		}
+		c.touch()
+		if len(frame) == 0 {
+			release.Do(c.serial.Unlock)
+			return nil, io.EOF
+		}
+		return frame, nil
+	}
+	s.close = func() {
+		for {
+			if _, err := s.next(); err != nil {
+				return
+			}
+		}
+	}
+	return s
+}
+
+// openStream starts collecting the chunks of the multiplexed stream with
+// seq.
+func (c *Client) openStream(seq int64) *responseStream {
+	q := newChunkQueue(c.maxStreamBuffer())
+	c.mu.Lock()
+	if _, ok := c.pending[seq]; !ok {
+		// the call has been abandoned, so its chunks are discarded
+		q.closed = true
+	}
+	if c.streams == nil {
+		c.streams = make(map[int64]*chunkQueue)
+	}
+	c.streams[seq] = q
+	c.mu.Unlock()
+	return &responseStream{next: q.next, close: q.close, queue: q}
+}
+
+func (c *Client) maxStreamBuffer() int {
+	if c.MaxStreamBuffer <= 0 {
+		return DefaultMaxStreamBuffer
+	}
+	return c.MaxStreamBuffer
+}
+
+// streamChunk passes payload to the open stream with seq, if any.
+func (c *Client) streamChunk(seq int64, payload Term) bool {
+	c.mu.Lock()
+	q := c.streams[seq]
+	c.mu.Unlock()
+	if q == nil {
+		return false
+	}
+
+	chunk, ok := payload.([]byte)
+	if ok && len(chunk) > 0 {
+		q.push(chunk)
+		return true
+	}
+	err := error(io.EOF)
+	if !ok {
+		if _, err = parseResponse(payload); err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	c.mu.Lock()
+	delete(c.streams, seq)
+	c.mu.Unlock()
+	q.finish(err)
+	return true
+}
+
+// A chunkQueue buffers the chunks of a multiplexed stream, so that a slow
+// reader does not hold up other calls. Once more than max bytes are
+// buffered, beyond a single chunk of any size, the stream fails and its
+// remaining chunks are discarded.
+type chunkQueue struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	chunks [][]byte
+	size   int // of the chunks
+	max    int
+	err    error // set once the stream has ended
+	closed bool
+	done   chan struct{}
+}
+
+func newChunkQueue(max int) *chunkQueue {
+	q := &chunkQueue{max: max, done: make(chan struct{})}
+	q.cond.L = &q.mu
+	return q
+}
+
+func (q *chunkQueue) push(chunk []byte) {
+	q.mu.Lock()
+	defer q.cond.Broadcast()
+	defer q.mu.Unlock()
+	if q.closed || q.err != nil {
+		return
+	}
+	if len(q.chunks) > 0 && q.size+len(chunk) > q.max {
+		q.closed = true
+		q.chunks, q.size = nil, 0
+		q.err = errStreamOverflow
+		close(q.done)
+		return
+	}
+	q.chunks = append(q.chunks, chunk)
+	q.size += len(chunk)
+}
+
+func (q *chunkQueue) finish(err error) {
+	q.mu.Lock()
+	if q.err == nil {
+		q.err = err
+		close(q.done)
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *chunkQueue) next() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.chunks) == 0 && q.err == nil {
+		q.cond.Wait()
+	}
+	if len(q.chunks) > 0 {
+		chunk := q.chunks[0]
+		q.chunks = q.chunks[1:]
+		q.size -= len(chunk)
+		return chunk, nil
+	}
+	return nil, q.err
+}
+
+func (q *chunkQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.chunks, q.size = nil, 0
+	q.mu.Unlock()
+}
+
+// cancelOn ends the stream with ctx's error if ctx is done first.
+func (q *chunkQueue) cancelOn(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		q.finish(ctx.Err())
+	case <-q.done:
+	}
+}
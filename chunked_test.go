@@ -0,0 +1,166 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStreamServer() *Server {
+	s := newTestServer()
+	s.Register("stream", "read", func(ctx context.Context, args []Term) (Term, error) {
+		return strings.NewReader(strings.Repeat("x", int(args[0].(int64)))), nil
+	})
+	s.Register("stream", "push", func(ctx context.Context, args []Term) (Term, error) {
+		return StreamFunc(func(w io.Writer) error {
+			for _, arg := range args {
+				io.WriteString(w, arg.(string))
+			}
+			return nil
+		}), nil
+	})
+	s.Register("stream", "fail", func(ctx context.Context, args []Term) (Term, error) {
+		return StreamFunc(func(w io.Writer) error {
+			io.WriteString(w, "partial")
+			return errors.New("broken")
+		}), nil
+	})
+	s.Register("stream", "binary", func(ctx context.Context, args []Term) (Term, error) {
+		return []byte("whole"), nil
+	})
+	return s
+}
+
+func TestCallStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, multiplex := range []bool{false, true} {
+		c := pipe(newStreamServer())
+		c.Multiplex = multiplex
+
+		r, err := c.CallStream(ctx, "stream", "read", 200000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		assertEqual(t, nil, err)
+		assertEqual(t, 200000, len(data))
+
+		r, err = c.CallStream(ctx, "stream", "push", "a", "", "bc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ = ioutil.ReadAll(r)
+		assertEqual(t, "abc", string(data))
+
+		// closing early leaves the connection usable
+		r, _ = c.CallStream(ctx, "stream", "read", 200000)
+		r.Read(make([]byte, 10))
+		r.Close()
+		result, err := c.Call(ctx, "math", "add", 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, int64(3), result)
+
+		// Call collects the stream, and CallStream accepts a binary reply
+		result, err = c.Call(ctx, "stream", "push", "a", "b")
+		assertEqual(t, nil, err)
+		assertEqual(t, []byte("ab"), result)
+		r, err = c.CallStream(ctx, "stream", "binary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ = ioutil.ReadAll(r)
+		assertEqual(t, "whole", string(data))
+		c.Close()
+	}
+}
+
+func TestCallStreamError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := pipe(newStreamServer())
+	c.Multiplex = true
+	defer c.Close()
+	r, err := c.CallStream(ctx, "stream", "fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, r)
+	assertEqual(t, "partial", buf.String())
+	assertEqual(t, &RPCError{Type: UserError, Detail: "broken"}, err)
+
+	c = pipe(newStreamServer())
+	defer c.Close()
+	r, _ = c.CallStream(ctx, "stream", "fail")
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("expected error reading a failed stream")
+	}
+}
+
+func TestCallStreamOverflow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := newStreamServer()
+	sent := make(chan struct{})
+	s.Register("stream", "burst", func(ctx context.Context, args []Term) (Term, error) {
+		return StreamFunc(func(w io.Writer) error {
+			defer close(sent)
+			for i := 0; i < 4; i++ {
+				io.WriteString(w, "xxxx")
+			}
+			return nil
+		}), nil
+	})
+	c := pipe(s)
+	c.Multiplex = true
+	c.MaxStreamBuffer = 10
+	defer c.Close()
+
+	// The stream fails once more is buffered than allowed, without holding
+	// up other calls.
+	r, err := c.CallStream(ctx, "stream", "burst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sent
+	if _, err := c.Call(ctx, "math", "add", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != errStreamOverflow {
+		t.Errorf("expected errStreamOverflow, got %v", err)
+	}
+	r.Close()
+
+	// A chunk larger than the buffer is accepted on its own.
+	r, err = c.CallStream(ctx, "stream", "read", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 100, len(data))
+}
+
+func TestBatchStream(t *testing.T) {
+	c := pipe(newStreamServer())
+	defer c.Close()
+	calls := []BatchCall{{Module: "stream", Function: "push", Args: []Term{"a", "b"}}}
+	if err := c.CallBatch(context.Background(), calls); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte("ab"), calls[0].Result)
+}
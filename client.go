@@ -0,0 +1,397 @@
+package bert
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Caller makes BERT-RPC calls. It is implemented by Client, HTTPClient
+// and BalancedClient.
+type Caller interface {
+	Call(ctx context.Context, module, function Atom, args ...Term) (Term, error)
+}
+
+// A Client makes BERT-RPC calls over a single connection.
+//
+// By default calls are made one at a time, as plain BERT-RPC requires.
+// With Multiplex set, each request is wrapped in a {SeqID, Request}
+// envelope and many calls may be in flight at once, with responses matched
+// to calls by their sequence IDs. This requires a peer that understands
+// the envelope, such as Server.
+type Client struct {
+	// lastUsed is the UnixNano time the last packet was received. It is
+	// accessed atomically and kept first for alignment.
+	lastUsed int64
+
+	// Multiplex enables concurrent calls. It must be set before the first
+	// call.
+	Multiplex bool
+
+	// HeartbeatInterval, if positive, makes the client ping an idle server
+	// at that interval once the first call has been made, and fail the
+	// connection if no pong arrives within HeartbeatTimeout, which
+	// defaults to the interval. Without Multiplex, pings are only sent
+	// while no call is in progress. Both must be set before the first
+	// call.
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+
+	// Logger, if set, is told about connection failures, malformed
+	// responses and calls taking longer than SlowCall. Both must be set
+	// before the first call.
+	Logger   Logger
+	SlowCall time.Duration
+
+	// Tracer, if set, traces each call and cast, passing the trace context
+	// on to the server in an info packet sent ahead of the request.
+	Tracer Tracer
+
+	// SendDeadlines sends the time left before the deadline of the context
+	// of each request in an info packet ahead of it, as described for
+	// DeadlineAtom. Peers that do not understand it ignore it, as BERT-RPC
+	// requires of unknown info packets.
+	SendDeadlines bool
+
+	// CorrelationIDs sends a correlation ID ahead of each request, as
+	// described for CorrelationAtom: that of the request's context, set
+	// with WithCorrelationID, or else a random one. Error responses
+	// returned for the request record it in their CorrelationID.
+	CorrelationIDs bool
+
+	// Checksum selects the checksum trailing each packet, which the server
+	// must use too, as described for FrameChecksum. It must be set before
+	// the client is used.
+	Checksum FrameChecksum
+
+	// MaxStreamBuffer limits the bytes of a multiplexed streamed response
+	// received ahead of its reader, though a single chunk is buffered
+	// whatever its size. Rather than holding up the other calls on the
+	// connection, a stream exceeding it fails, and the rest of it is
+	// discarded. Zero means DefaultMaxStreamBuffer.
+	MaxStreamBuffer int
+
+	conn *TermConn
+
+	// serial is held for the duration of each call without Multiplex.
+	serial sync.Mutex
+
+	mu       sync.Mutex
+	seq      int64
+	pending  map[int64]chan result
+	streams  map[int64]*chunkQueue
+	err      error // set once the connection has failed
+	started  bool
+	shutdown bool
+
+	start     sync.Once
+	configure sync.Once
+}
+
+// result is the outcome of a multiplexed call.
+type result struct {
+	term Term
+	err  error
+	size int // of the response packet
+}
+
+// NewClient returns a client making calls over c.
+func NewClient(c net.Conn) *Client {
+	return &Client{
+		conn:    NewTermConn(c),
+		pending: make(map[int64]chan result),
+	}
+}
+
+// Dial connects to a BERT-RPC server at address on the named network.
+func Dial(network, address string) (*Client, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// Close closes the connection. Calls in flight fail with ErrShutdown.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.shutdown {
+		c.mu.Unlock()
+		return ErrShutdown
+	}
+	c.shutdown = true
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+// Call calls module:function with args and returns the result of the
+// reply. An error response is returned as an *RPCError.
+func (c *Client) Call(ctx context.Context, module, function Atom, args ...Term) (Term, error) {
+	start := time.Now()
+	out := &outgoing{packet: requestTerm(CallAtom, module, function, args)}
+	ctx, end := c.startSpan(ctx, module, function, out)
+	result, err := c.do(ctx, out)
+	end(err)
+	if d := time.Since(start); c.SlowCall > 0 && d >= c.SlowCall {
+		logf(c.Logger, "bert: slow call %s:%s took %v", module, function, d)
+	}
+	return result, err
+}
+
+// Cast sends a cast of module:function with args, returning once the
+// server has acknowledged it.
+func (c *Client) Cast(ctx context.Context, module, function Atom, args ...Term) error {
+	out := &outgoing{packet: requestTerm(CastAtom, module, function, args)}
+	ctx, end := c.startSpan(ctx, module, function, out)
+	_, err := c.do(ctx, out)
+	end(err)
+	return err
+}
+
+// An outgoing is a request packet on its way to the server.
+type outgoing struct {
+	packet      Term
+	info        Term   // an info packet to send just before, if any
+	correlation string // the correlation ID sent with it, if any
+
+	// sent and received are the sizes of the request and response
+	sent, received int
+}
+
+func (c *Client) do(ctx context.Context, out *outgoing) (Term, error) {
+	resp, err := c.roundTrip(ctx, out)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := resp.(*responseStream); ok {
+		defer s.Close()
+		return ioutil.ReadAll(s)
+	}
+	result, err := parseResponse(resp)
+	if rpcErr, ok := err.(*RPCError); ok && out.correlation != "" {
+		rpcErr.CorrelationID = out.correlation
+	}
+	return result, err
+}
+
+// roundTrip sends a request and returns the response packet.
+func (c *Client) roundTrip(ctx context.Context, out *outgoing) (Term, error) {
+	c.start.Do(c.init)
+	if c.Multiplex {
+		return c.doMultiplexed(ctx, out)
+	}
+
+	c.serial.Lock()
+	resp, err := c.roundTripSerial(ctx, out)
+	if s, ok := resp.(*responseStream); ok {
+		// the stream releases the connection once it has been read
+		return s, nil
+	}
+	c.serial.Unlock()
+	return resp, err
+}
+
+func (c *Client) roundTripSerial(ctx context.Context, out *outgoing) (Term, error) {
+	if err := c.failed(); err != nil {
+		return nil, err
+	}
+	if err := c.send(ctx, out, out.packet); err != nil {
+		return nil, c.sendFailed(err)
+	}
+	resp, size, err := c.conn.readTerm(ctx)
+	if err != nil {
+		// the response may still arrive, so the connection is unusable
+		return nil, c.fail(err)
+	}
+	out.received = size
+	c.touch()
+	if isStreamStart(resp) {
+		return c.serialStream(ctx), nil
+	}
+	return resp, nil
+}
+
+// send writes packet, preceded by the info packet of out if any and, for
+// requests, by their correlation ID with CorrelationIDs and the deadline
+// of ctx with SendDeadlines.
+func (c *Client) send(ctx context.Context, out *outgoing, packet Term) (err error) {
+	terms := make([]interface{}, 0, 4)
+	if out.info != nil {
+		terms = append(terms, out.info)
+	}
+	if c.CorrelationIDs && out.packet != nil {
+		if out.correlation = CorrelationID(ctx); out.correlation == "" {
+			out.correlation = newCorrelationID()
+		}
+		terms = append(terms, infoTerm(CorrelationAtom, []byte(out.correlation)))
+	}
+	if c.SendDeadlines && out.packet != nil {
+		if info, ok := deadlineInfo(ctx); ok {
+			terms = append(terms, info)
+		}
+	}
+	out.sent, err = c.conn.writeTerms(ctx, append(terms, packet)...)
+	return err
+}
+
+// sendFailed returns err, returned by send, failing the connection unless
+// nothing was written: a partial frame would leave the stream misaligned
+// for every call after it.
+func (c *Client) sendFailed(err error) error {
+	if _, ok := err.(*EncodeError); ok || err == ErrFrameTooLarge {
+		return err
+	}
+	return c.fail(err)
+}
+
+// init applies the options set before the first call.
+func (c *Client) init() {
+	c.setup()
+	c.startHeartbeat()
+}
+
+// setup applies the options of the connection, before it is first used.
+func (c *Client) setup() {
+	c.configure.Do(func() {
+		c.conn.Logger = c.Logger
+		c.conn.Checksum = c.Checksum
+	})
+}
+
+func (c *Client) doMultiplexed(ctx context.Context, out *outgoing) (Term, error) {
+	resp, err := c.exchange(ctx, out, func(seq int64) Term { return []Term{seq, out.packet} })
+	if s, ok := resp.(*responseStream); ok {
+		go s.queue.cancelOn(ctx)
+	}
+	return resp, err
+}
+
+// exchange sends the packet returned by packet for a new sequence number
+// and waits for the response delivered for that number.
+func (c *Client) exchange(ctx context.Context, out *outgoing, packet func(seq int64) Term) (Term, error) {
+	ch := make(chan result, 1)
+	c.setup()
+
+	c.mu.Lock()
+	if c.err != nil || c.shutdown {
+		c.mu.Unlock()
+		return nil, c.failed()
+	}
+	if !c.started {
+		c.started = true
+		go c.readLoop()
+	}
+	c.seq++
+	seq := c.seq
+	c.pending[seq] = ch
+	c.mu.Unlock()
+
+	if err := c.send(ctx, out, packet(seq)); err != nil {
+		c.forget(seq)
+		return nil, c.sendFailed(err)
+	}
+
+	select {
+	case r := <-ch:
+		out.received = r.size
+		return r.term, r.err
+	case <-ctx.Done():
+		c.forget(seq)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) forget(seq int64) {
+	c.mu.Lock()
+	delete(c.pending, seq)
+	c.mu.Unlock()
+}
+
+// readLoop delivers multiplexed responses until the connection fails.
+func (c *Client) readLoop() {
+	for {
+		term, size, err := c.conn.readTerm(context.Background())
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.touch()
+		if command, options, ok := parseInfo(term); ok && command == PongAtom && len(options) == 1 {
+			if seq, ok := options[0].(int64); ok {
+				c.deliver(seq, result{})
+			}
+			continue
+		}
+
+		seq, payload, ok := envelope(term)
+		if !ok {
+			// an error outside any envelope concerns the connection
+			if _, err := parseResponse(term); err != nil {
+				if _, ok := err.(*RPCError); ok {
+					c.fail(err)
+					return
+				}
+			}
+			continue
+		}
+		if c.streamChunk(seq, payload) {
+			continue
+		}
+		if isStreamStart(payload) {
+			payload = c.openStream(seq)
+		}
+		c.deliver(seq, result{term: payload, size: size})
+	}
+}
+
+// deliver completes the pending call with seq, if any.
+func (c *Client) deliver(seq int64, r result) {
+	c.mu.Lock()
+	ch := c.pending[seq]
+	delete(c.pending, seq)
+	c.mu.Unlock()
+	if ch != nil {
+		ch <- r
+	}
+}
+
+// fail records that the connection has failed with err, fails all pending
+// calls and closes the connection. It returns the error calls should see.
+func (c *Client) fail(err error) error {
+	c.mu.Lock()
+	if c.shutdown {
+		err = ErrShutdown
+	}
+	if c.err == nil {
+		c.err = err
+		if !c.shutdown {
+			logf(c.Logger, "bert: connection to %s failed: %v", c.conn.Conn().RemoteAddr(), err)
+		}
+	}
+	pending := c.pending
+	c.pending = make(map[int64]chan result)
+	streams := c.streams
+	c.streams = nil
+	c.mu.Unlock()
+
+	for _, ch := range pending {
+		ch <- result{err: err}
+	}
+	for _, q := range streams {
+		q.finish(err)
+	}
+	c.conn.Close()
+	return err
+}
+
+// failed returns the error calls should fail with, if any.
+func (c *Client) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shutdown {
+		return ErrShutdown
+	}
+	return c.err
+}
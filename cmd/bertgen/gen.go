@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+	"text/template"
+)
+
+// A Spec describes the modules of a BERT-RPC service.
+type Spec struct {
+	Package string   `json:"package"`
+	Modules []Module `json:"modules"`
+}
+
+// A Module is a named set of functions.
+type Module struct {
+	Name      string     `json:"name"`
+	Functions []Function `json:"functions"`
+}
+
+// A Function is a remote function. Returns is the Go type its result is
+// unmarshaled into; a function without one only reports errors.
+type Function struct {
+	Name    string `json:"name"`
+	Args    []Arg  `json:"args"`
+	Returns string `json:"returns"`
+}
+
+// An Arg is a function argument and the Go type it is passed as.
+type Arg struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// reserved holds the names generated methods use themselves.
+var reserved = map[string]bool{"c": true, "ctx": true, "term": true, "result": true, "err": true, "bert": true, "context": true}
+
+func parseSpec(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	if !token.IsIdentifier(spec.Package) {
+		return nil, fmt.Errorf("invalid package name %q", spec.Package)
+	}
+	for _, m := range spec.Modules {
+		if !token.IsIdentifier(exportedName(m.Name)) {
+			return nil, fmt.Errorf("invalid module name %q", m.Name)
+		}
+		for _, f := range m.Functions {
+			if !token.IsIdentifier(exportedName(f.Name)) {
+				return nil, fmt.Errorf("invalid function name %q in module %s", f.Name, m.Name)
+			}
+			for _, a := range f.Args {
+				if !token.IsIdentifier(a.Name) || reserved[a.Name] || a.Type == "" {
+					return nil, fmt.Errorf("invalid argument %q of %s:%s", a.Name, m.Name, f.Name)
+				}
+			}
+		}
+	}
+	return &spec, nil
+}
+
+// exportedName turns an Erlang style name such as get_user into GetUser.
+func exportedName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+var funcs = template.FuncMap{
+	"exported": exportedName,
+	"quote":    func(s string) string { return fmt.Sprintf("%q", s) },
+}
+
+var clientTemplate = template.Must(template.New("client").Funcs(funcs).Parse(`// Code generated by bertgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	bert "github.com/diodechain/gobert"
+)
+{{range $m := .Modules}}{{$client := printf "%sClient" (exported $m.Name)}}
+// {{$client}} calls the functions of the {{$m.Name}} module.
+type {{$client}} struct {
+	Caller bert.Caller
+}
+
+// New{{$client}} returns a client making calls with c.
+func New{{$client}}(c bert.Caller) *{{$client}} {
+	return &{{$client}}{Caller: c}
+}
+{{range $f := $m.Functions}}
+// {{exported $f.Name}} calls {{$m.Name}}:{{$f.Name}}.
+func (c *{{$client}}) {{exported $f.Name}}(ctx context.Context{{range $f.Args}}, {{.Name}} {{.Type}}{{end}}) {{if $f.Returns}}(result {{$f.Returns}}, err error){{else}}error{{end}} {
+	{{if $f.Returns}}term{{else}}_{{end}}, err := c.Caller.Call(ctx, {{quote $m.Name}}, {{quote $f.Name}}{{range $f.Args}}, {{.Name}}{{end}})
+	{{- if $f.Returns}}
+	if err != nil {
+		return result, err
+	}
+	err = bert.UnmarshalTerm(term, &result)
+	return result, err
+	{{- else}}
+	return err
+	{{- end}}
+}
+{{end}}{{end}}`))
+
+// generate returns the formatted client code for spec.
+func generate(spec *Spec) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := clientTemplate.Execute(&buf, spec); err != nil {
+		return nil, err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %v", err)
+	}
+	return code, nil
+}
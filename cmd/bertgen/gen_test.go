@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/calc.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := parseSpec(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := generate(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("testdata/calc.go.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, want) {
+		t.Errorf("generated code differs from testdata/calc.go.golden:\n%s", code)
+	}
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	tests := []string{
+		`{"package": "not valid"}`,
+		`{"package": "p", "modules": [{"functions": []}]}`,
+		`{"package": "p", "modules": [{"name": "m", "functions": [{"args": []}]}]}`,
+		`{"package": "p", "modules": [{"name": "m", "functions": [{"name": "f", "args": [{"name": "ctx", "type": "int"}]}]}]}`,
+		`{"package": "p", "modules": [{"name": "m", "functions": [{"name": "f", "args": [{"name": "a"}]}]}]}`,
+	}
+	for _, test := range tests {
+		if _, err := parseSpec([]byte(test)); err == nil {
+			t.Errorf("parseSpec(%s) succeeded", test)
+		}
+	}
+}
+
+func TestExportedName(t *testing.T) {
+	tests := map[string]string{
+		"add":         "Add",
+		"split_words": "SplitWords",
+		"get-user":    "GetUser",
+	}
+	for name, want := range tests {
+		if got := exportedName(name); got != want {
+			t.Errorf("exportedName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Command bertgen generates typed Go clients for BERT-RPC services.
+//
+// It reads a JSON spec describing the modules and functions of a service
+// and writes Go code with one client type per module, whose methods
+// marshal their arguments and unmarshal the result:
+//
+//	{
+//	  "package": "calc",
+//	  "modules": [{
+//	    "name": "math",
+//	    "functions": [
+//	      {"name": "add", "args": [{"name": "a", "type": "int64"}, {"name": "b", "type": "int64"}], "returns": "int64"},
+//	      {"name": "reset"}
+//	    ]
+//	  }]
+//	}
+//
+// generates a MathClient with the methods
+//
+//	func (c *MathClient) Add(ctx context.Context, a int64, b int64) (int64, error)
+//	func (c *MathClient) Reset(ctx context.Context) error
+//
+// Usage:
+//
+//	bertgen [-o output.go] spec.json
+//
+// The output is written to standard output unless -o is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	output := flag.String("o", "", "write the generated code to `file`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: bertgen [-o output.go] spec.json\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	spec, err := parseSpec(data)
+	if err != nil {
+		fatal(err)
+	}
+	code, err := generate(spec)
+	if err != nil {
+		fatal(err)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := ioutil.WriteFile(*output, code, 0666); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "bertgen:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,61 @@
+// Command bertjson converts streams of BERT terms to JSON lines.
+//
+// It reads 4-byte length-prefixed BERT frames, as exchanged by BERT-RPC,
+// from the named files or standard input and writes each term to standard
+// output as a JSON value on its own line, converted as bert.JSONValue
+// describes, so that captures of BERT traffic can be fed to jq and the
+// like:
+//
+//	bertjson capture.bin | jq .
+//
+// Usage:
+//
+//	bertjson [-unframed] [-max-frame bytes] [file ...]
+//
+// With -unframed, the input is a sequence of terms each starting with the
+// version tag, as written by bert.EncodeTo.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	bert "github.com/diodechain/gobert"
+)
+
+func main() {
+	var opts bert.JSONLinesOptions
+	flag.BoolVar(&opts.Unframed, "unframed", false, "read terms that are not length-prefixed")
+	flag.IntVar(&opts.MaxFrameSize, "max-frame", bert.DefaultMaxFrameSize, "reject frames larger than `bytes`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: bertjson [-unframed] [-max-frame bytes] [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		convert(os.Stdin, "stdin", opts)
+		return
+	}
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal(err)
+		}
+		convert(f, name, opts)
+		f.Close()
+	}
+}
+
+func convert(r io.Reader, name string, opts bert.JSONLinesOptions) {
+	if err := bert.WriteJSONLines(os.Stdout, r, opts); err != nil {
+		fatal(fmt.Errorf("%s: %v", name, err))
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "bertjson:", err)
+	os.Exit(1)
+}
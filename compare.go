@@ -0,0 +1,284 @@
+package bert
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// Classes of terms in the order Erlang sorts them.
+const (
+	numberOrder = iota
+	atomOrder
+	tupleOrder
+	mapOrder
+	listOrder
+	bitstringOrder
+	otherOrder
+)
+
+// Compare compares two terms in Erlang term order, returning -1, 0 or +1:
+//
+//	number < atom < tuple < map < list < binary
+//
+// Numbers compare by value, with an integer sorting before a float of the
+// same value. Tuples compare by size and then element by element, maps by
+// size, then keys and then values, and lists and binaries element by
+// element. Since Decode returns both as []Term, decoded lists compare as
+// tuples; strings, List and arrays compare as lists. Booleans compare as
+// the atoms true and false and nil as the empty list.
+func Compare(a, b Term) int {
+	va, vb := termValue(a), termValue(b)
+	oa, ob := orderOf(va), orderOf(vb)
+	if oa != ob {
+		return compareInts(oa, ob)
+	}
+
+	switch oa {
+	case numberOrder:
+		return compareNumbers(va, vb)
+	case atomOrder:
+		return strings.Compare(atomName(va), atomName(vb))
+	case tupleOrder:
+		ea, eb := elements(va), elements(vb)
+		if len(ea) != len(eb) {
+			return compareInts(len(ea), len(eb))
+		}
+		return compareItems(ea, eb)
+	case mapOrder:
+		return compareMaps(termMapOf(va), termMapOf(vb))
+	case listOrder:
+		return compareItems(elements(va), elements(vb))
+	case bitstringOrder:
+		ba, bitsA := bitsOf(va)
+		bb, bitsB := bitsOf(vb)
+		if c := bytes.Compare(ba, bb); c != 0 {
+			return c
+		}
+		return compareInts(bitsA, bitsB)
+	}
+	return strings.Compare(fmt.Sprintf("%#v", a), fmt.Sprintf("%#v", b))
+}
+
+// Equal reports whether a and b are the same term, that is whether they
+// compare equal. Unlike Erlang's ==, an integer never equals a float.
+func Equal(a, b Term) bool { return Compare(a, b) == 0 }
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// termValue returns the value of term, through any pointers, interfaces
+// Options and Verbatims. An empty Option is the atom undefined.
+func termValue(term Term) reflect.Value {
+	if x, ok := term.(Verbatim); ok {
+		return termValue(x.Term)
+	}
+	if o, ok := term.(optional); ok {
+		if x, ok := o.optionTerm(); ok {
+			return termValue(x)
+		}
+		return reflect.ValueOf(UndefinedAtom)
+	}
+	v := reflect.ValueOf(term)
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+func orderOf(v reflect.Value) int {
+	switch kindOf(v) {
+	case IntegerKind, FloatKind:
+		return numberOrder
+	case AtomKind, BooleanKind:
+		return atomOrder
+	case TupleKind:
+		return tupleOrder
+	case MapKind:
+		return mapOrder
+	case ListKind, StringKind, NilKind:
+		return listOrder
+	case BinaryKind, BitstringKind:
+		return bitstringOrder
+	}
+	return otherOrder
+}
+
+// numberOf returns the value of the number v as either a big.Int or a
+// float64.
+func numberOf(v reflect.Value) (*big.Int, float64) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int()), 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint()), 0
+	case reflect.Float32, reflect.Float64:
+		return nil, v.Float()
+	}
+	n := v.Interface().(big.Int)
+	return &n, 0
+}
+
+func compareNumbers(a, b reflect.Value) int {
+	ia, fa := numberOf(a)
+	ib, fb := numberOf(b)
+	switch {
+	case ia != nil && ib != nil:
+		return ia.Cmp(ib)
+	case ia == nil && ib == nil:
+		return compareFloats(fa, fb)
+	case ia != nil:
+		if c := compareIntFloat(ia, fb); c != 0 {
+			return c
+		}
+		return -1
+	default:
+		if c := compareIntFloat(ib, fa); c != 0 {
+			return -c
+		}
+		return 1
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	}
+	// NaN sorts after every other float
+	return compareInts(boolInt(math.IsNaN(a)), boolInt(math.IsNaN(b)))
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compareIntFloat compares the integer i with the float f by value.
+func compareIntFloat(i *big.Int, f float64) int {
+	switch {
+	case math.IsNaN(f), math.IsInf(f, 1):
+		return -1
+	case math.IsInf(f, -1):
+		return 1
+	}
+	return new(big.Float).SetInt(i).Cmp(big.NewFloat(f))
+}
+
+func atomName(v reflect.Value) string {
+	if a, ok := atomFor(v); ok {
+		return string(a)
+	}
+	if v.Kind() == reflect.Bool {
+		if v.Bool() {
+			return string(TrueAtom)
+		}
+		return string(FalseAtom)
+	}
+	return v.String()
+}
+
+// elements returns the elements of a tuple or list.
+func elements(v reflect.Value) []Term {
+	switch x := valueInterface(v).(type) {
+	case []Term:
+		return x
+	case List:
+		return x.Items
+	case IOList:
+		return x.Items
+	case string:
+		items, _ := listOf(x)
+		return items
+	case Charlist:
+		return x.items()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]Term, v.Len())
+		for i := range items {
+			items[i] = v.Index(i).Interface()
+		}
+		return items
+	case reflect.Struct:
+		var items []Term
+		if tag, ok := recordTag(v.Type()); ok {
+			items = append(items, tag)
+		}
+		for _, f := range structFields(v.Type()) {
+			var item Term
+			if fv := fieldByIndex(v, f.index); fv.IsValid() {
+				item = fv.Interface()
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+	return nil
+}
+
+// valueInterface returns the value held by v, or nil if there is none.
+func valueInterface(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func compareItems(a, b []Term) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+func compareMaps(a, b TermMap) int {
+	if a.Len() != b.Len() {
+		return compareInts(a.Len(), b.Len())
+	}
+	for i := range a.entries {
+		if c := Compare(a.entries[i].Key, b.entries[i].Key); c != 0 {
+			return c
+		}
+	}
+	for i := range a.entries {
+		if c := Compare(a.entries[i].Value, b.entries[i].Value); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// bitsOf returns the bytes of a binary or bitstring and its size in bits.
+func bitsOf(v reflect.Value) ([]byte, int) {
+	switch x := v.Interface().(type) {
+	case Bitstring:
+		n := x.Len()
+		return x.Slice(0, n).Bytes, n
+	case IOList:
+		b, _ := x.Bytes()
+		return b, 8 * len(b)
+	case Binary:
+		return []byte(x), 8 * len(x)
+	}
+	return v.Bytes(), 8 * v.Len()
+}
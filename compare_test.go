@@ -0,0 +1,70 @@
+package bert
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	big1 := new(big.Int).Lsh(big.NewInt(1), 70)
+
+	// each term sorts before the next
+	ordered := []Term{
+		math.Inf(-1),
+		int64(-5),
+		1,
+		1.0,
+		1.5,
+		uint64(2),
+		*big1,
+		Atom("a"),
+		false,
+		Atom("ok"),
+		true,
+		[]Term{},
+		[]Term{int64(9)},
+		[]Term{int64(1), int64(1)},
+		[]Term{int64(1), Atom("a")},
+		map[Atom]Term{"b": 1},
+		map[Atom]Term{"a": 1, "b": 1},
+		map[Atom]Term{"a": 1, "b": 2},
+		nil,
+		"\x01",
+		List{Items: []Term{int64(1), int64(2)}},
+		[2]Term{2, 1},
+		[]byte{},
+		Bitstring{[]byte{0}, 1},
+		[]byte{0},
+		[]byte{1, 2},
+	}
+	for i := range ordered {
+		for j := range ordered {
+			want := compareInts(i, j)
+			if got := Compare(ordered[i], ordered[j]); got != want {
+				t.Errorf("Compare(%#v, %#v) = %d, expected %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	equal := [][2]Term{
+		{1, int64(1)},
+		{uint8(7), *big.NewInt(7)},
+		{"ab", List{Items: []Term{int64(97), int64(98)}}},
+		{[]byte("ab"), Bitstring{[]byte("ab"), 16}},
+		{true, TrueAtom},
+		{nil, List{}},
+		{map[Atom]Term{"a": 1}, NewTermMap(KV{Atom("a"), int64(1)})},
+		{struct{ X, Y int }{1, 2}, []Term{int64(1), int64(2)}},
+	}
+	for _, pair := range equal {
+		if !Equal(pair[0], pair[1]) {
+			t.Errorf("expected %#v to equal %#v", pair[0], pair[1])
+		}
+	}
+	if Equal(1, 1.0) {
+		t.Errorf("expected 1 not to equal 1.0")
+	}
+}
@@ -0,0 +1,61 @@
+package bert
+
+import "reflect"
+
+// ComplexAtom tags the {complex, Re, Im} tuples complex numbers are
+// represented as.
+const ComplexAtom = Atom("complex")
+
+// Complex numbers, which Erlang has no type for, are represented as
+// {complex, Re, Im} tuples of floats. complex64 and complex128 values
+// encode in this form, and Unmarshal fills them from it, accepting
+// integers for either part. Both mappings are registered through
+// RegisterEncoder and so can be replaced.
+func init() {
+	RegisterEncoder(reflect.TypeOf(complex64(0)), func(v interface{}) (Term, error) {
+		c := v.(complex64)
+		return []Term{ComplexAtom, float64(real(c)), float64(imag(c))}, nil
+	})
+	RegisterEncoder(reflect.TypeOf(complex128(0)), func(v interface{}) (Term, error) {
+		c := v.(complex128)
+		return []Term{ComplexAtom, real(c), imag(c)}, nil
+	})
+
+	registerConverter(reflect.TypeOf(complex64(0)), func(term Term) (interface{}, bool, error) {
+		c, ok := tupleComplex(term)
+		return complex64(c), ok, nil
+	})
+	registerConverter(reflect.TypeOf(complex128(0)), func(term Term) (interface{}, bool, error) {
+		c, ok := tupleComplex(term)
+		return c, ok, nil
+	})
+}
+
+// tupleComplex parses a {complex, Re, Im} tuple.
+func tupleComplex(term Term) (complex128, bool) {
+	tuple, ok := term.([]Term)
+	if !ok || len(tuple) != 3 || tuple[0] != ComplexAtom {
+		return 0, false
+	}
+	re, ok := complexPart(tuple[1])
+	if !ok {
+		return 0, false
+	}
+	im, ok := complexPart(tuple[2])
+	if !ok {
+		return 0, false
+	}
+	return complex(re, im), true
+}
+
+// complexPart returns the value of a float or integer part of a complex
+// number.
+func complexPart(term Term) (float64, bool) {
+	switch x := term.(type) {
+	case float64:
+		return x, true
+	case int64:
+		return float64(x), true
+	}
+	return 0, false
+}
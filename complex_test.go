@@ -0,0 +1,61 @@
+package bert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplex(t *testing.T) {
+	data, err := Encode([]Term{complex(1.5, -2), complex64(3i)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	term, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{
+		[]Term{ComplexAtom, 1.5, -2.0},
+		[]Term{ComplexAtom, 0.0, 3.0},
+	}, term)
+
+	var v struct {
+		Z  complex128
+		Z2 complex64
+	}
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, complex(1.5, -2), v.Z)
+	assertEqual(t, complex64(3i), v.Z2)
+
+	// Integer parts are accepted.
+	var z complex128
+	if err := UnmarshalTerm([]Term{ComplexAtom, int64(1), 0.5}, &z); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, complex(1, 0.5), z)
+	if err := UnmarshalTerm([]Term{Atom("polar"), 1.0, 0.5}, &z); err == nil {
+		t.Errorf("expected an error unmarshaling a tuple of another tag")
+	}
+}
+
+func TestComplexOverride(t *testing.T) {
+	typ := reflect.TypeOf(complex128(0))
+	saved := encoderFor(typ)
+	defer RegisterEncoder(typ, saved)
+
+	RegisterEncoder(typ, func(v interface{}) (Term, error) {
+		c := v.(complex128)
+		return []Term{real(c), imag(c)}, nil
+	})
+	data, err := Encode(complex(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	term, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{1.0, 2.0}, term)
+}
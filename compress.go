@@ -0,0 +1,222 @@
+package bert
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// DefaultCompressThreshold is the size from which RPC packets are
+// compressed once compression has been negotiated, unless configured
+// otherwise.
+const DefaultCompressThreshold = 1024
+
+// Info packet command and option used to negotiate compression.
+const (
+	CompressAtom = Atom("compress")
+	ZlibAtom     = Atom("zlib")
+)
+
+var (
+	errCompressedSize    = errors.New("compressed term does not match its size")
+	errCompressedRefused = errors.New("compressed term not accepted")
+)
+
+// encodeCompressed encodes val, compressing it if it is large enough.
+func (e *Encoder) encodeCompressed(val interface{}) error {
+	data, err := e.encodeValue(reflect.ValueOf(val))
+	if err != nil {
+		return err
+	}
+	return writeCompressed(e.w, data, e.CompressThreshold, !e.NoVersion)
+}
+
+// writeCompressed writes the term encoded in data, compressed if it is at
+// least threshold bytes and compression makes it smaller, preceded by the
+// version tag if version is set.
+func writeCompressed(w io.Writer, data []byte, threshold int, version bool) error {
+	if len(data) >= threshold {
+		var buf bytes.Buffer
+		if version {
+			write1(&buf, VersionTag)
+		}
+		write1(&buf, CompressedTag)
+		write4(&buf, uint32(len(data)))
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		if buf.Len() < len(data)+boolInt(version) {
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+	}
+
+	if version {
+		write1(w, VersionTag)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// byteReader reads single bytes from an io.Reader, so that decompressing
+// does not consume input beyond the compressed data.
+type byteReader struct {
+	io.Reader
+	b [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.Reader, r.b[:])
+	return r.b[0], err
+}
+
+// readCompressed reads a zlib compressed term.
+func (d *Decoder) readCompressed() (Term, error) {
+	if d.RejectCompressed {
+		return nil, errCompressedRefused
+	}
+	size, err := read4(d.r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUncompressedSize(size, d.MaxUncompressedSize); err != nil {
+		return nil, err
+	}
+
+	r := d.r
+	if _, ok := r.(io.ByteReader); !ok {
+		r = &byteReader{Reader: r}
+	}
+	data, err := inflate(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// with Fidelity the whole compressed term is kept verbatim
+	saved, rec := d.r, d.rec
+	d.r, d.rec = bytes.NewReader(data), nil
+	defer func() { d.r, d.rec = saved, rec }()
+	return d.readTag()
+}
+
+// checkUncompressedSize checks the size a compressed term declares for its
+// uncompressed form against max, or DefaultMaxFrameSize if max is zero.
+func checkUncompressedSize(size, max int) error {
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+	if size > max {
+		return fmt.Errorf("compressed term of %d bytes exceeds %d bytes", size, max)
+	}
+	return nil
+}
+
+// inflate reads zlib compressed data from r, which must not be read past
+// its end, expected to hold size bytes.
+func inflate(r io.Reader, size int) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(zr, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	// reading to the end verifies the checksum
+	if n, err := zr.Read(make([]byte, 1)); len(data) != size || n != 0 || err != io.EOF {
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, errCompressedSize
+	}
+	return data, nil
+}
+
+// compressedSize returns the size of the compressed term starting at b,
+// which begins with CompressedTag, if it holds no more than max bytes
+// uncompressed, as checked by checkUncompressedSize. ok is false if b is
+// too short to tell.
+func compressedSize(b []byte, max int) (size int, ok bool, err error) {
+	if len(b) < 5 {
+		return 0, false, nil
+	}
+	declared := int(binary.BigEndian.Uint32(b[1:]))
+	if err := checkUncompressedSize(declared, max); err != nil {
+		return 0, false, err
+	}
+	r := bytes.NewReader(b[5:])
+	zr, err := zlib.NewReader(r)
+	if err == nil {
+		// inflating past the declared size shows the term is malformed
+		var n int64
+		n, err = io.Copy(ioutil.Discard, io.LimitReader(zr, int64(declared)+1))
+		if err == nil && n > int64(declared) {
+			return 0, false, errCompressedSize
+		}
+	}
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return len(b) - r.Len(), true, nil
+}
+
+// NegotiateCompression asks the server to compress the packets it sends
+// with zlib, and compresses the packets sent to it from threshold bytes,
+// or DefaultCompressThreshold if threshold is not positive. It reports
+// whether the server agreed. It must be called before any call is made,
+// and only with servers that answer the {info, compress, [zlib]} request,
+// such as Server.
+func (c *Client) NegotiateCompression(ctx context.Context, threshold int) (bool, error) {
+	c.serial.Lock()
+	defer c.serial.Unlock()
+	c.setup()
+
+	if err := c.failed(); err != nil {
+		return false, err
+	}
+	if err := c.conn.WriteTerm(ctx, infoTerm(CompressAtom, ZlibAtom)); err != nil {
+		return false, c.fail(err)
+	}
+	term, err := c.conn.ReadTerm(ctx)
+	if err != nil {
+		return false, c.fail(err)
+	}
+	command, options, ok := parseInfo(term)
+	if !ok || command != CompressAtom {
+		return false, c.fail(fmt.Errorf("unexpected packet %v", term))
+	}
+	if len(options) != 1 || options[0] != ZlibAtom {
+		return false, nil
+	}
+
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	c.conn.SetCompressThreshold(threshold)
+	return true, nil
+}
+
+// negotiateCompression answers a client's request for compression.
+func (sc *serverConn) negotiateCompression(options []Term) {
+	threshold := sc.server.CompressThreshold
+	if threshold == 0 {
+		threshold = DefaultCompressThreshold
+	}
+	for _, option := range options {
+		if option == ZlibAtom && threshold > 0 {
+			sc.conn.SetCompressThreshold(threshold)
+			sc.conn.WriteTerm(sc.ctx, infoTerm(CompressAtom, ZlibAtom))
+			return
+		}
+	}
+	sc.conn.WriteTerm(sc.ctx, infoTerm(CompressAtom))
+}
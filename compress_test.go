@@ -0,0 +1,165 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeCompressed(t *testing.T) {
+	data := []byte{131, 80, 0, 0, 0, 103, 120, 156, 203, 102, 72, 73, 164, 3, 0, 0, 204, 203, 38, 180}
+	assertDecode(t, data, strings.Repeat("a", 100))
+
+	// a wrong size or checksum is an error
+	bad := append([]byte{}, data...)
+	bad[5] = 102
+	if _, err := Decode(bad); err == nil {
+		t.Errorf("expected error for wrong size")
+	}
+	bad = append([]byte{}, data...)
+	bad[len(bad)-1]++
+	if _, err := Decode(bad); err == nil {
+		t.Errorf("expected error for wrong checksum")
+	}
+
+	// decoding stops at the end of the compressed data
+	r := bytes.NewReader(append(data, 131, 97, 1))
+	dec := NewDecoder(r)
+	term, err := dec.Decode()
+	assertEqual(t, nil, err)
+	assertEqual(t, strings.Repeat("a", 100), term)
+	term, err = dec.Decode()
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(1), term)
+}
+
+func TestEncoderCompressThreshold(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.CompressThreshold = 64
+
+	enc.Encode(long)
+	assertEqual(t, []byte{131, 80, 0, 0, 0, 103}, buf.Bytes()[:6])
+	if buf.Len() >= 104 {
+		t.Errorf("compressed term is %d bytes", buf.Len())
+	}
+	assertDecode(t, buf.Bytes(), long)
+
+	// small or incompressible terms are written as is
+	buf.Reset()
+	enc.Encode("short")
+	assertEqual(t, []byte{131, 107, 0, 5, 115, 104, 111, 114, 116}, buf.Bytes())
+	noise := make([]byte, 100)
+	for i := range noise {
+		noise[i] = byte(i * 131)
+	}
+	buf.Reset()
+	enc.Encode(noise)
+	assertEqual(t, byte(BinTag), buf.Bytes()[1])
+}
+
+func TestPushDecoderCompressed(t *testing.T) {
+	data := []byte{131, 80, 0, 0, 0, 103, 120, 156, 203, 102, 72, 73, 164, 3, 0, 0, 204, 203, 38, 180, 131, 97, 1}
+	p := NewPushDecoder()
+	var terms []Term
+	for _, b := range data {
+		p.Feed([]byte{b})
+		term, err := p.Next()
+		if err == ErrNeedMore {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		terms = append(terms, term)
+	}
+	assertEqual(t, []Term{strings.Repeat("a", 100), int64(1)}, terms)
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := newTestServer()
+	s.Register("text", "repeat", func(ctx context.Context, args []Term) (Term, error) {
+		return strings.Repeat(args[0].(string), int(args[1].(int64))), nil
+	})
+	c := pipe(s)
+	defer c.Close()
+	ok, err := c.NegotiateCompression(ctx, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, ok)
+	result, err := c.Call(ctx, "text", "repeat", strings.Repeat("ab", 50), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Repeat("ab", 5000), result)
+
+	s.CompressThreshold = -1
+	c = pipe(s)
+	defer c.Close()
+	ok, err = c.NegotiateCompression(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, false, ok)
+}
+
+func TestCompressedLimits(t *testing.T) {
+	data := []byte{131, 80, 0, 0, 0, 103, 120, 156, 203, 102, 72, 73, 164, 3, 0, 0, 204, 203, 38, 180}
+
+	// The declared size is checked before inflating.
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.MaxUncompressedSize = 64
+	if _, err := dec.Decode(); err == nil {
+		t.Error("expected a term over MaxUncompressedSize to be rejected")
+	}
+	huge := []byte{131, 80, 255, 255, 255, 255, 120, 156}
+	if _, err := Decode(huge); err == nil {
+		t.Error("expected a term over DefaultMaxFrameSize to be rejected")
+	}
+	p := NewPushDecoder()
+	p.Feed(huge)
+	if _, err := p.Next(); err == nil || err == ErrNeedMore {
+		t.Errorf("expected the push decoder to reject the term, got %v", err)
+	}
+	if _, err := ValidateAt(bytes.NewReader(huge), int64(len(huge))); err == nil {
+		t.Error("expected ValidateAt to reject the term")
+	}
+
+	dec = NewDecoder(bytes.NewReader(data))
+	dec.RejectCompressed = true
+	if _, err := dec.Decode(); err != errCompressedRefused {
+		t.Errorf("expected errCompressedRefused, got %v", err)
+	}
+}
+
+func TestTermConnCompressedUnnegotiated(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	w, r := NewTermConn(a), NewTermConn(b)
+	w.SetCompressThreshold(16)
+
+	long := strings.Repeat("a", 100)
+	go w.WriteTerm(ctx, long)
+	if _, err := r.ReadTerm(ctx); err != errCompressedRefused {
+		t.Errorf("expected errCompressedRefused, got %v", err)
+	}
+
+	r.SetCompressThreshold(16)
+	go w.WriteTerm(ctx, long)
+	term, err := r.ReadTerm(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, long, term)
+}
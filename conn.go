@@ -0,0 +1,317 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A TermConn exchanges length-prefixed BERT terms over a network
+// connection, taking care of framing, deadlines, size limits and buffer
+// reuse. Reads and writes may proceed concurrently with each other, and
+// concurrent writes are serialized.
+type TermConn struct {
+	// MaxFrameSize limits the size of frames read and written. Zero means
+	// DefaultMaxFrameSize.
+	MaxFrameSize int
+
+	// Checksum selects the checksum trailing each frame, which must match
+	// that of the peer. It must be set before the connection is used.
+	Checksum FrameChecksum
+
+	// Logger, if set, is told about frames that are oversized or cannot be
+	// decoded or encoded. It must be set before the connection is used.
+	Logger Logger
+
+	// ObserveRead and ObserveWrite, if set, are called with the statistics
+	// of each term read and written, as Decoder.Observe and
+	// Encoder.Observe are. They must be set before the connection is used.
+	ObserveRead  func(CodecStats)
+	ObserveWrite func(CodecStats)
+
+	conn net.Conn
+
+	rmu        sync.Mutex
+	rbuf       []byte
+	dec        Decoder
+	compressed int32 // whether compressed terms are accepted, atomically
+
+	wmu      sync.Mutex
+	wbuf     bytes.Buffer
+	enc      Encoder
+	maxWrite int // the peer's limit, if negotiated
+}
+
+// NewTermConn returns a TermConn exchanging terms over c.
+func NewTermConn(c net.Conn) *TermConn {
+	tc := &TermConn{conn: c}
+	tc.enc.w = &tc.wbuf
+	return tc
+}
+
+// Conn returns the underlying connection.
+func (c *TermConn) Conn() net.Conn { return c.conn }
+
+// Close closes the underlying connection.
+func (c *TermConn) Close() error { return c.conn.Close() }
+
+// SetCompressThreshold makes the connection compress terms written from n
+// bytes on, as Encoder.CompressThreshold does; zero disables compression.
+// Compressed terms are only accepted when reading once compression has
+// been enabled, as it is by negotiation with the peer, and only up to the
+// size limit of frames read once uncompressed.
+func (c *TermConn) SetCompressThreshold(n int) {
+	c.wmu.Lock()
+	c.enc.CompressThreshold = n
+	c.wmu.Unlock()
+	var compressed int32
+	if n > 0 {
+		compressed = 1
+	}
+	atomic.StoreInt32(&c.compressed, compressed)
+}
+
+func (c *TermConn) maxFrameSize() int {
+	if c.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return c.MaxFrameSize
+}
+
+// limitWrites limits the size of frames written to n, the limit of the
+// peer, besides MaxFrameSize.
+func (c *TermConn) limitWrites(n int) {
+	c.wmu.Lock()
+	c.maxWrite = n
+	c.wmu.Unlock()
+}
+
+// writeLimit returns the size of the largest frame that may be written.
+// c.wmu must be held.
+func (c *TermConn) writeLimit() int {
+	max := c.maxFrameSize()
+	if c.maxWrite > 0 && c.maxWrite < max {
+		max = c.maxWrite
+	}
+	return max
+}
+
+// ReadFrame reads the payload of the next frame. The returned slice is only
+// valid until the next call to ReadFrame or ReadTerm. ctx bounds the time
+// spent waiting; its deadline and cancellation are applied to the
+// connection's read deadline.
+func (c *TermConn) ReadFrame(ctx context.Context) ([]byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.readFrame(ctx)
+}
+
+func (c *TermConn) readFrame(ctx context.Context) ([]byte, error) {
+	stop, err := applyDeadline(ctx, c.conn.SetReadDeadline)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	frame, err := readFrame(c.conn, c.rbuf, c.maxFrameSize()+c.Checksum.size())
+	if frame != nil {
+		c.rbuf = frame
+		frame, err = c.verify(frame)
+	}
+	if err == ErrFrameTooLarge {
+		logf(c.Logger, "bert: frame from %s exceeds %d bytes", c.conn.RemoteAddr(), c.maxFrameSize())
+	}
+	return frame, ctxError(ctx, err)
+}
+
+// verify checks the checksum of frame and returns its payload.
+func (c *TermConn) verify(frame []byte) ([]byte, error) {
+	payload, err := c.Checksum.verify(frame)
+	if err != nil {
+		logf(c.Logger, "bert: frame of %d bytes from %s fails its checksum", len(frame), c.conn.RemoteAddr())
+	}
+	return payload, err
+}
+
+// readRequest is ReadTerm for servers, also returning the size of the
+// frame. Frames larger than max are skipped rather than left half read,
+// and the first bytes of their payload are returned as head with
+// ErrFrameTooLarge so that the request can still be answered.
+func (c *TermConn) readRequest(ctx context.Context, max int) (term Term, size int, head []byte, err error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	stop, err := applyDeadline(ctx, c.conn.SetReadDeadline)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer stop()
+
+	size, err = readFrameSize(c.conn)
+	if err != nil {
+		return nil, 0, nil, ctxError(ctx, err)
+	}
+	if size > max+c.Checksum.size() {
+		logf(c.Logger, "bert: request of %d bytes from %s exceeds %d bytes", size, c.conn.RemoteAddr(), max)
+		head, err := skipFrame(c.conn, make([]byte, 16), size)
+		if err != nil {
+			return nil, 0, nil, ctxError(ctx, err)
+		}
+		return nil, size, head, ErrFrameTooLarge
+	}
+	frame, err := readFramePayload(c.conn, c.rbuf, size)
+	if err != nil {
+		return nil, 0, nil, ctxError(ctx, err)
+	}
+	c.rbuf = frame
+	if frame, err = c.verify(frame); err != nil {
+		return nil, 0, nil, err
+	}
+	term, err = c.decode(frame, max)
+	return term, len(frame), nil, err
+}
+
+// decode decodes frame, whose term may hold up to max bytes uncompressed.
+func (c *TermConn) decode(frame []byte, max int) (Term, error) {
+	c.dec.r = bytes.NewReader(frame)
+	c.dec.Observe = c.ObserveRead
+	c.dec.MaxUncompressedSize = max
+	c.dec.RejectCompressed = atomic.LoadInt32(&c.compressed) == 0
+	term, err := c.dec.Decode()
+	if err != nil {
+		logf(c.Logger, "bert: decoding frame of %d bytes from %s: %v", len(frame), c.conn.RemoteAddr(), err)
+	}
+	return term, err
+}
+
+// ReadTerm reads and decodes the next frame.
+func (c *TermConn) ReadTerm(ctx context.Context) (Term, error) {
+	term, _, err := c.readTerm(ctx)
+	return term, err
+}
+
+// readTerm is ReadTerm, also returning the size of the frame read.
+func (c *TermConn) readTerm(ctx context.Context) (Term, int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	frame, err := c.readFrame(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	term, err := c.decode(frame, c.maxFrameSize())
+	return term, len(frame), err
+}
+
+// WriteFrame writes payload as a single frame.
+func (c *TermConn) WriteFrame(ctx context.Context, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if len(payload) > c.writeLimit() {
+		logf(c.Logger, "bert: frame of %d bytes to %s exceeds %d bytes", len(payload), c.conn.RemoteAddr(), c.writeLimit())
+		return ErrFrameTooLarge
+	}
+	c.wbuf.Reset()
+	write4(&c.wbuf, uint32(len(payload)+c.Checksum.size()))
+	c.wbuf.Write(payload)
+	c.wbuf.Write(c.Checksum.trailer(payload))
+	return c.flush(ctx)
+}
+
+// WriteTerm encodes val and writes it as a single frame. Nothing is written
+// if val cannot be encoded.
+func (c *TermConn) WriteTerm(ctx context.Context, val interface{}) error {
+	_, err := c.writeTerms(ctx, val)
+	return err
+}
+
+// writeTerms writes each of vals as a frame, with no other frames written
+// in between, and returns the total size of their payloads. Nothing is
+// written if any cannot be encoded.
+func (c *TermConn) writeTerms(ctx context.Context, vals ...interface{}) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.enc.Observe = c.ObserveWrite
+	c.wbuf.Reset()
+	for _, val := range vals {
+		start := c.wbuf.Len()
+		write4(&c.wbuf, 0)
+		if err := c.enc.Encode(val); err != nil {
+			logf(c.Logger, "bert: encoding term for %s: %v", c.conn.RemoteAddr(), err)
+			return 0, err
+		}
+		size := c.wbuf.Len() - start - 4
+		if size > c.writeLimit() {
+			logf(c.Logger, "bert: frame of %d bytes to %s exceeds %d bytes", size, c.conn.RemoteAddr(), c.writeLimit())
+			return 0, ErrFrameTooLarge
+		}
+		c.wbuf.Write(c.Checksum.trailer(c.wbuf.Bytes()[start+4:]))
+		binary.BigEndian.PutUint32(c.wbuf.Bytes()[start:], uint32(size+c.Checksum.size()))
+	}
+	return c.wbuf.Len() - (4+c.Checksum.size())*len(vals), c.flush(ctx)
+}
+
+func (c *TermConn) flush(ctx context.Context) error {
+	stop, err := applyDeadline(ctx, c.conn.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	_, err = c.conn.Write(c.wbuf.Bytes())
+	return ctxError(ctx, err)
+}
+
+// applyDeadline sets the connection deadline from ctx and arranges for
+// cancellation of ctx to interrupt blocked I/O. The returned function must
+// be called once the I/O has finished.
+func applyDeadline(ctx context.Context, set func(time.Time) error) (stop func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := set(deadline); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return func() {}, nil
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			set(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}, nil
+}
+
+// ctxError prefers the context's error over the timeout it caused. The
+// connection deadline may pass slightly before ctx reports it has.
+func ctxError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
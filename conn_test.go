@@ -0,0 +1,96 @@
+package bert
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTermConn(t *testing.T) {
+	a, b := net.Pipe()
+	ca, cb := NewTermConn(a), NewTermConn(b)
+	defer ca.Close()
+	defer cb.Close()
+
+	ctx := context.Background()
+	go func() {
+		ca.WriteTerm(ctx, []Term{Atom("reply"), 42})
+		ca.WriteTerm(ctx, "foo")
+	}()
+
+	term, err := cb.ReadTerm(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("reply"), int64(42)}, term)
+	term, err = cb.ReadTerm(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "foo", term)
+
+	// nothing is written for values that cannot be encoded
+	if err := ca.WriteTerm(ctx, make(chan int)); err == nil {
+		t.Errorf("expected encode error")
+	}
+}
+
+func TestTermConnLimits(t *testing.T) {
+	a, b := net.Pipe()
+	ca, cb := NewTermConn(a), NewTermConn(b)
+	defer ca.Close()
+	defer cb.Close()
+
+	ca.MaxFrameSize = 8
+	if err := ca.WriteTerm(context.Background(), "too long to fit"); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge writing, got %v", err)
+	}
+
+	cb.MaxFrameSize = 4
+	go ca.WriteFrame(context.Background(), []byte{131, 107, 0, 3, 102, 111, 111})
+	if _, err := cb.ReadTerm(context.Background()); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge reading, got %v", err)
+	}
+}
+
+func TestTermConnDeadline(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := NewTermConn(a)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.ReadTerm(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := c.ReadTerm(ctx); err != context.Canceled {
+		t.Errorf("expected cancellation error, got %v", err)
+	}
+}
+
+func TestTermConnChecksum(t *testing.T) {
+	a, b := net.Pipe()
+	ca, cb := NewTermConn(a), NewTermConn(b)
+	defer ca.Close()
+	defer cb.Close()
+	ca.Checksum, cb.Checksum = CRC32CChecksum, CRC32CChecksum
+
+	ctx := context.Background()
+	go ca.WriteTerm(ctx, []Term{Atom("reply"), 42})
+	term, err := cb.ReadTerm(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("reply"), int64(42)}, term)
+
+	// a flipped bit is caught before decoding
+	go a.Write([]byte{0, 0, 0, 7, 131, 97, 43, 0, 0, 0, 0})
+	if _, err := cb.ReadTerm(ctx); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+}
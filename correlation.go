@@ -0,0 +1,59 @@
+package bert
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// CorrelationAtom is the info command carrying the correlation ID of the
+// request that follows it, as {info, correlation, [ID]} with ID a binary.
+// Clients with CorrelationIDs send it for each request, and Server makes
+// it available to the handler of the request through CorrelationID and
+// includes it in what it logs about the request, so that the logs of the
+// services a request went through can be joined.
+const CorrelationAtom = Atom("correlation")
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation ID id,
+// which clients with CorrelationIDs send along with requests made with it.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by ctx, or "" if it
+// carries none. The contexts passed to handlers carry the ID sent by the
+// client, if any.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// newCorrelationID returns a random correlation ID.
+func newCorrelationID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// correlationNote returns the note identifying the correlation ID id in
+// logs and errors, or "" if id is empty.
+func correlationNote(id string) string {
+	if id == "" {
+		return ""
+	}
+	return " [correlation " + id + "]"
+}
+
+// parseCorrelation returns the ID sent in a correlation info packet.
+func parseCorrelation(options []Term) (string, bool) {
+	if len(options) != 1 {
+		return "", false
+	}
+	switch id := options[0].(type) {
+	case []byte, string:
+		return textOf(id), true
+	}
+	return "", false
+}
@@ -0,0 +1,51 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCorrelationIDs(t *testing.T) {
+	s := NewServer()
+	s.Register("log", "id", func(ctx context.Context, args []Term) (Term, error) {
+		return CorrelationID(ctx), nil
+	})
+	s.Register("log", "fail", func(ctx context.Context, args []Term) (Term, error) {
+		return nil, errors.New("boom")
+	})
+
+	for _, multiplex := range []bool{false, true} {
+		c := pipe(s)
+		c.Multiplex = multiplex
+		c.CorrelationIDs = true
+		ctx := WithCorrelationID(context.Background(), "req-42")
+
+		result, err := c.Call(ctx, "log", "id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "req-42", result)
+
+		// Requests without one get a random ID.
+		result, err = c.Call(context.Background(), "log", "id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, _ := result.(string); len(id) != 16 {
+			t.Errorf("expected a generated correlation ID, got %q", result)
+		}
+
+		_, err = c.Call(ctx, "log", "fail")
+		assertEqual(t, "user error 0: boom [correlation req-42]", err.Error())
+		c.Close()
+	}
+
+	c := pipe(s)
+	defer c.Close()
+	result, err := c.Call(context.Background(), "log", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "", result)
+}
@@ -0,0 +1,52 @@
+package bert
+
+import (
+	"context"
+	"time"
+)
+
+// DeadlineAtom is the info command carrying the time left before the
+// deadline of the request that follows it, as {info, deadline, [Millis]}.
+// Clients with SendDeadlines send it for requests whose context has a
+// deadline, and Server gives the handler of the request a context with
+// that deadline, so that it can abandon work the client has already given
+// up on. The time left is sent rather than the deadline, as the clocks of
+// client and server may differ.
+const DeadlineAtom = Atom("deadline")
+
+// deadlineInfo returns the info packet carrying the deadline of ctx, if it
+// has one.
+func deadlineInfo(ctx context.Context) (Term, bool) {
+	left, ok := deadlineLeft(ctx)
+	if !ok {
+		return nil, false
+	}
+	return infoTerm(DeadlineAtom, left), true
+}
+
+// deadlineLeft returns the milliseconds left before the deadline of ctx,
+// if it has one.
+func deadlineLeft(ctx context.Context) (int64, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	left := time.Until(deadline).Milliseconds()
+	if left < 0 {
+		left = 0
+	}
+	return left, true
+}
+
+// parseDeadline returns the deadline sent in a deadline info packet,
+// received at now.
+func parseDeadline(options []Term, now time.Time) (time.Time, bool) {
+	if len(options) != 1 {
+		return time.Time{}, false
+	}
+	left, ok := options[0].(int64)
+	if !ok || left < 0 {
+		return time.Time{}, false
+	}
+	return now.Add(time.Duration(left) * time.Millisecond), true
+}
@@ -0,0 +1,42 @@
+package bert
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	s := NewServer()
+	s.Register("time", "left", func(ctx context.Context, args []Term) (Term, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return Atom("none"), nil
+		}
+		return int64(time.Until(deadline) / time.Second), nil
+	})
+
+	for _, multiplex := range []bool{false, true} {
+		c := pipe(s)
+		c.Multiplex = multiplex
+		c.SendDeadlines = true
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		result, err := c.Call(ctx, "time", "left")
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left, ok := result.(int64); !ok || left < 8 || left > 10 {
+			t.Errorf("expected the handler's deadline about 10s away with multiplex %v, got %v", multiplex, result)
+		}
+
+		// The deadline applies to a single request.
+		result, err = c.Call(context.Background(), "time", "left")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, Atom("none"), result)
+		c.Close()
+	}
+}
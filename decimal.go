@@ -0,0 +1,142 @@
+package bert
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DecimalAtom tags the tuples {decimal, Coefficient, Exponent} standing
+// for the exact decimal number Coefficient × 10^Exponent.
+const DecimalAtom = Atom("decimal")
+
+// BigFloatEncoding selects how an Encoder represents big.Float values and
+// json.Number values that are not integers.
+type BigFloatEncoding int
+
+const (
+	// BigFloatAsFloat encodes big.Float values as NEW_FLOAT, rounded to
+	// the nearest float64, and json.Number values as floats. This is the
+	// default.
+	BigFloatAsFloat BigFloatEncoding = iota
+	// BigFloatAsDecimal encodes them exactly as {decimal, Coefficient,
+	// Exponent} tuples: big.Float values with the shortest decimal that
+	// converts back to the same value at their precision, and json.Number
+	// values with the digits of their text.
+	BigFloatAsDecimal
+)
+
+var (
+	bigFloatType   = reflect.TypeOf(big.Float{})
+	jsonNumberType = reflect.TypeOf(json.Number(""))
+)
+
+// parseDecimal returns the coefficient and exponent of the decimal
+// number in s, in JSON syntax or as formatted by big.Float.Text with the
+// 'e' format.
+func parseDecimal(s string) (*big.Int, int, error) {
+	text := s
+	exp := 0
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		e, err := strconv.Atoi(strings.TrimPrefix(text[i+1:], "+"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid number %q", s)
+		}
+		text, exp = text[:i], e
+	}
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		exp -= len(text) - i - 1
+		text = text[:i] + text[i+1:]
+	}
+	coef, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid number %q", s)
+	}
+	return coef, exp, nil
+}
+
+// writeDecimal writes the number in s as a {decimal, Coefficient,
+// Exponent} tuple.
+func (e *Encoder) writeDecimal(s string) error {
+	coef, exp, err := parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	write1(e.w, SmallTupleTag)
+	write1(e.w, 3)
+	if err := e.writeAtom(DecimalAtom); err != nil {
+		return err
+	}
+	writeNumber(e.w, *coef)
+	writeNumber(e.w, *big.NewInt(int64(exp)))
+	return nil
+}
+
+// writeBigFloat writes f as selected by e.BigFloats.
+func (e *Encoder) writeBigFloat(f *big.Float) error {
+	if e.BigFloats == BigFloatAsDecimal && !f.IsInf() {
+		return e.writeDecimal(f.Text('e', -1))
+	}
+	x, _ := f.Float64()
+	return e.writeFloat(x, true)
+}
+
+// writeJSONNumber writes n as an integer if it is one, and otherwise as
+// selected by e.BigFloats.
+func (e *Encoder) writeJSONNumber(n json.Number) error {
+	if i, ok := new(big.Int).SetString(string(n), 10); ok {
+		writeNumber(e.w, *i)
+		return nil
+	}
+	if e.BigFloats == BigFloatAsDecimal {
+		return e.writeDecimal(string(n))
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("invalid number %q", string(n))
+	}
+	return e.writeFloat(f, false)
+}
+
+// decimalText returns the number held by term, an integer, float or
+// decimal tuple, as text that big.ParseFloat and json.Number accept.
+func decimalText(term Term) (string, bool) {
+	if f, ok := term.(float64); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+	if n, ok := termBigInt(term); ok {
+		return n.String(), true
+	}
+	tuple, ok := term.([]Term)
+	if !ok || len(tuple) != 3 || tuple[0] != DecimalAtom {
+		return "", false
+	}
+	coef, ok := termBigInt(tuple[1])
+	if !ok {
+		return "", false
+	}
+	exp, ok := tuple[2].(int64)
+	if !ok {
+		return "", false
+	}
+	if exp == 0 {
+		return coef.String(), true
+	}
+	return fmt.Sprintf("%se%d", coef, exp), true
+}
+
+// unmarshalBigFloat stores the number held by term in the big.Float v, at
+// the precision v already has, or else 64 bits.
+func unmarshalBigFloat(term Term, v reflect.Value) bool {
+	s, ok := decimalText(term)
+	if !ok || !v.CanAddr() {
+		return false
+	}
+	f := v.Addr().Interface().(*big.Float)
+	_, ok = f.SetString(s)
+	return ok
+}
@@ -4,29 +4,149 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"math"
 	"math/big"
-	"reflect"
 	"strconv"
+	"unicode/utf8"
 )
 
 var ErrBadMagic error = errors.New("bad magic")
 var ErrUnknownType error = errors.New("unknown type")
+
+// ErrUintType is no longer returned by this package.
+//
+// Deprecated: unsigned integers are supported by both Encode and Unmarshal.
 var ErrUintType error = errors.New("Unsupported value type uint.")
 
+// maxInternLength is the longest string InternStrings will share, and
+// maxInternEntries bounds the size of each intern table.
+const (
+	maxInternLength  = 64
+	maxInternEntries = 4096
+)
+
+// maxPrealloc is the most elements allocated for a tuple, list or map
+// before they are read, whatever count the input claims.
+const maxPrealloc = 1024
+
+// A Decoder reads and decodes BERT terms from an input stream.
+//
+// Lists decode as []Term holding their elements. The tail of an improper
+// list, such as [1|2], is dropped, the list decoding as its elements
+// alone, unless Fidelity is set.
+type Decoder struct {
+	// InternAtoms makes repeated atoms share a single backing string
+	// instead of allocating a new one for every occurrence.
+	InternAtoms bool
+
+	// InternStrings does the same for strings of up to 64 bytes.
+	InternStrings bool
+
+	// MapPairs decodes maps as []KV holding their entries in the order
+	// they were received, instead of as TermMap.
+	MapPairs bool
+
+	// LenientArity decodes tuples tagged for a type registered with
+	// RegisterExtension even if they have fewer elements than the type has
+	// fields, leaving the remaining fields zero, or more, ignoring the
+	// extra ones. Otherwise such tuples decode as plain tuples.
+	LenientArity bool
+
+	// Fidelity decodes terms so that encoding them with an Encoder in its
+	// default configuration reproduces the bytes they were decoded from.
+	// Lists decode as List, keeping the tail of improper lists, maps as
+	// []KV in their original order, and tuples are not converted by
+	// RegisterDecoder or as BERT complex types. Terms whose encoding
+	// differs from what an Encoder would write for them, such as old-style
+	// floats, integers in a longer form than needed, UTF-8 atoms or
+	// compressed terms, decode as Verbatim.
+	Fidelity bool
+
+	// SpecialFloats, if SpecialFloatsAsAtoms, decodes the atoms nan,
+	// infinity and neg_infinity as NaN, +Inf and -Inf floats. Note that
+	// Erlang also uses infinity for timeouts.
+	SpecialFloats SpecialFloatPolicy
+
+	// MaxUncompressedSize limits the size compressed terms declare for
+	// their uncompressed form; larger ones are rejected before being
+	// inflated. Zero means DefaultMaxFrameSize.
+	MaxUncompressedSize int
+
+	// RejectCompressed rejects compressed terms, as from peers that have
+	// not negotiated compression.
+	RejectCompressed bool
+
+	// NoVersion decodes terms that are not preceded by the version tag,
+	// as within distribution messages and some container formats.
+	NoVersion bool
+
+	// Arena, if set, provides the memory of decoded binaries, strings,
+	// atoms, tuples and lists, which must not be used after the arena is
+	// released.
+	Arena *Arena
+
+	// Observe, if set, is called with the statistics of each term
+	// successfully decoded.
+	Observe func(CodecStats)
+
+	r       io.Reader
+	rec     *recordingReader // of the term being decoded, with Fidelity
+	atoms   map[string]Atom
+	strings map[string]string
+
+	stats *CodecStats // of the term being decoded, when observed
+	depth int
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Reset makes d read from r, keeping its options and the atoms and
+// strings it has interned, so that decoders can be pooled and reused.
+func (d *Decoder) Reset(r io.Reader) {
+	d.r = r
+	d.rec = nil
+	d.stats = nil
+	d.depth = 0
+}
+
+// readN reads exactly n bytes from r, never reading past them, so that
+// the bytes following a term remain unread. Large bodies are read in
+// chunks rather than allocated up front from an untrusted length.
+func readN(r io.Reader, n int) ([]byte, error) {
+	if n <= 4096 {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func read1(r io.Reader) (int, error) {
-	bits, err := ioutil.ReadAll(io.LimitReader(r, 1))
+	bits, err := readN(r, 1)
 	if err != nil {
 		return 0, err
 	}
 
-	ui8 := uint8(bits[0])
-	return int(ui8), nil
+	return int(bits[0]), nil
 }
 
 func read2(r io.Reader) (int, error) {
-	bits, err := ioutil.ReadAll(io.LimitReader(r, 2))
+	bits, err := readN(r, 2)
 	if err != nil {
 		return 0, err
 	}
@@ -35,36 +155,55 @@ func read2(r io.Reader) (int, error) {
 	return int(ui16), nil
 }
 
+// read4 reads an unsigned 32-bit length or count.
 func read4(r io.Reader) (int, error) {
-	bits, err := ioutil.ReadAll(io.LimitReader(r, 4))
+	bits, err := readN(r, 4)
 	if err != nil {
 		return 0, err
 	}
 
 	ui32 := binary.BigEndian.Uint32(bits)
-	return int(int32(ui32)), nil
+	return int(ui32), nil
 }
 
-func readSmallInt(r io.Reader) (int, error) {
-	return read1(r)
+func (d *Decoder) readSmallInt() (int64, error) {
+	n, err := read1(d.r)
+	return int64(n), err
 }
 
-func readInt(r io.Reader) (int, error) { return read4(r) }
+func (d *Decoder) readInt() (int64, error) {
+	bits, err := readN(d.r, 4)
+	if err != nil {
+		return 0, err
+	}
+
+	// INTEGER_EXT is a signed 32-bit big endian integer
+	return int64(int32(binary.BigEndian.Uint32(bits))), nil
+}
 
-func readBigInt(r io.Reader) (big.Int, error) {
-	length, err := read1(r)
+// readBigInt reads a SMALL_BIG_EXT or LARGE_BIG_EXT whose digit count is
+// stored in lengthSize bytes. Values that fit in an int64 are returned as
+// int64 and all others as big.Int.
+func (d *Decoder) readBigInt(lengthSize int) (Term, error) {
+	var length int
+	var err error
+	if lengthSize == 1 {
+		length, err = read1(d.r)
+	} else {
+		length, err = read4(d.r)
+	}
 	if err != nil {
-		return *big.NewInt(0), err
+		return nil, err
 	}
 
-	sign, err := read1(r)
+	sign, err := read1(d.r)
 	if err != nil {
-		return *big.NewInt(0), err
+		return nil, err
 	}
 
-	bytes, err := ioutil.ReadAll(io.LimitReader(r, int64(length)))
+	bytes, err := readN(d.r, length)
 	if err != nil {
-		return *big.NewInt(0), err
+		return nil, err
 	}
 
 	// converting big endian to small endian
@@ -78,11 +217,14 @@ func readBigInt(r io.Reader) (big.Int, error) {
 		n.Neg(n)
 	}
 
+	if n.IsInt64() {
+		return n.Int64(), nil
+	}
 	return *n, nil
 }
 
-func readFloat(r io.Reader) (float32, error) {
-	bits, err := ioutil.ReadAll(io.LimitReader(r, 31))
+func (d *Decoder) readFloat() (float64, error) {
+	bits, err := readN(d.r, 31)
 	if err != nil {
 		return 0, err
 	}
@@ -95,94 +237,204 @@ func readFloat(r io.Reader) (float32, error) {
 		}
 	}
 
-	f, err := strconv.ParseFloat(string(bits[0:i]), 32)
+	return strconv.ParseFloat(string(bits[0:i]), 64)
+}
+
+func (d *Decoder) readNewFloat() (float64, error) {
+	bits, err := readN(d.r, 8)
 	if err != nil {
 		return 0, err
 	}
-	return float32(f), nil
+
+	return math.Float64frombits(binary.BigEndian.Uint64(bits)), nil
+}
+
+// readAtom reads an atom with the given tag, converting the text of
+// ATOM_EXT and SMALL_ATOM_EXT from Latin-1 to UTF-8.
+func (d *Decoder) readAtom(tag int) (Atom, error) {
+	var size int
+	var err error
+	if tag == SmallAtomTag || tag == SmallAtomUTF8Tag {
+		size, err = read1(d.r)
+	} else {
+		size, err = read2(d.r)
+	}
+	if err != nil {
+		return "", err
+	}
+	b, err := d.readBytes(size)
+	if err != nil {
+		return "", err
+	}
+	if tag == AtomTag || tag == SmallAtomTag {
+		b = latin1ToUTF8(b)
+	}
+
+	if !d.InternAtoms {
+		return Atom(d.newString(b)), nil
+	}
+	if a, ok := d.atoms[string(b)]; ok {
+		return a, nil
+	}
+	a := Atom(b)
+	if d.atoms == nil {
+		d.atoms = make(map[string]Atom)
+	}
+	if len(d.atoms) < maxInternEntries {
+		d.atoms[string(a)] = a
+	}
+	return a, nil
 }
 
-func readAtom(r io.Reader) (Atom, error) {
-	str, err := readString(r)
-	return Atom(str), err
+// specialFloat returns the float a stands for with SpecialFloatsAsAtoms.
+func specialFloat(a Atom) (float64, bool) {
+	switch a {
+	case NaNAtom:
+		return math.NaN(), true
+	case InfinityAtom:
+		return math.Inf(1), true
+	case NegInfinityAtom:
+		return math.Inf(-1), true
+	}
+	return 0, false
 }
 
-func readSmallTuple(r io.Reader) (Term, error) {
-	size, err := read1(r)
+// latin1ToUTF8 returns the Latin-1 text b in UTF-8.
+func latin1ToUTF8(b []byte) []byte {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return []byte(latin1Charlist(string(b)))
+		}
+	}
+	return b
+}
+
+func (d *Decoder) readSmallTuple() (Term, error) {
+	size, err := read1(d.r)
+	if err != nil {
+		return nil, err
+	}
+	return d.readTuple(size)
+}
+
+func (d *Decoder) readLargeTuple() (Term, error) {
+	size, err := read4(d.r)
 	if err != nil {
 		return nil, err
 	}
+	return d.readTuple(size)
+}
 
-	tuple := make([]Term, size)
+// readTuple reads the size elements of a tuple.
+func (d *Decoder) readTuple(size int) (Term, error) {
+	tuple := d.makeTerms(size)
 
 	for i := 0; i < size; i++ {
-		term, err := readTag(r)
+		term, err := d.readTag()
 		if err != nil {
 			return nil, err
 		}
-		switch a := term.(type) {
-		case Atom:
-			if a == BertAtom {
-				return readComplex(r)
-			}
-		}
-		tuple[i] = term
+		tuple = append(tuple, term)
 	}
 
+	if d.Fidelity {
+		return tuple, nil
+	}
+	if size > 1 && tuple[0] == BertAtom {
+		return readComplex(tuple)
+	}
+	if t, ok := extensionFor(tuple); ok {
+		if v, ok := decodeExtension(t, tuple, d.LenientArity); ok {
+			return v, nil
+		}
+		return tuple, nil
+	}
+	if fn := decoderFor(tuple); fn != nil {
+		return fn(tuple)
+	}
 	return tuple, nil
 }
 
-func readNil(r io.Reader) ([]Term, error) {
-	_, err := ioutil.ReadAll(io.LimitReader(r, 1))
-	if err != nil {
-		return nil, err
+func (d *Decoder) readNil() (Term, error) {
+	if d.Fidelity {
+		return List{}, nil
 	}
-	list := make([]Term, 0)
-	return list, nil
+	return make([]Term, 0), nil
 }
 
-func readString(r io.Reader) (string, error) {
-	size, err := read2(r)
+func (d *Decoder) readStringBytes() ([]byte, error) {
+	size, err := read2(d.r)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	str, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	return d.readBytes(size)
+}
+
+func (d *Decoder) readString() (string, error) {
+	b, err := d.readStringBytes()
 	if err != nil {
 		return "", err
 	}
 
-	return string(str), nil
+	if !d.InternStrings || len(b) > maxInternLength {
+		return d.newString(b), nil
+	}
+	if s, ok := d.strings[string(b)]; ok {
+		return s, nil
+	}
+	s := string(b)
+	if d.strings == nil {
+		d.strings = make(map[string]string)
+	}
+	if len(d.strings) < maxInternEntries {
+		d.strings[s] = s
+	}
+	return s, nil
 }
 
-func readList(r io.Reader) ([]Term, error) {
-	size, err := read4(r)
+// readList reads a LIST_EXT. The tail of an improper list is only kept
+// with Fidelity.
+func (d *Decoder) readList() (Term, error) {
+	size, err := read4(d.r)
 	if err != nil {
 		return nil, err
 	}
 
-	list := make([]Term, size)
+	list := d.makeTerms(size)
 
 	for i := 0; i < size; i++ {
-		term, err := readTag(r)
+		term, err := d.readTag()
 		if err != nil {
 			return nil, err
 		}
-		list[i] = term
+		list = append(list, term)
 	}
 
-	read1(r)
+	tag, err := read1(d.r)
+	if err != nil {
+		return nil, err
+	}
+	var tail Term
+	if tag != NilTag {
+		if tail, err = d.readTagged(tag); err != nil {
+			return nil, err
+		}
+	}
 
+	if d.Fidelity {
+		return List{Items: list, Tail: tail}, nil
+	}
 	return list, nil
 }
 
-func readBin(r io.Reader) ([]uint8, error) {
-	size, err := read4(r)
+func (d *Decoder) readBin() ([]uint8, error) {
+	size, err := read4(d.r)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	bytes, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	bytes, err := d.readBytes(size)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -190,18 +442,18 @@ func readBin(r io.Reader) ([]uint8, error) {
 	return bytes, nil
 }
 
-func readBit(r io.Reader) (Bitstring, error) {
-	size, err := read4(r)
+func (d *Decoder) readBit() (Bitstring, error) {
+	size, err := read4(d.r)
 	if err != nil {
 		return Bitstring{}, err
 	}
 
-	bits, err := read1(r)
+	bits, err := read1(d.r)
 	if err != nil {
 		return Bitstring{}, err
 	}
 
-	bytes, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	bytes, err := d.readBytes(size)
 	if err != nil {
 		return Bitstring{}, err
 	}
@@ -209,120 +461,202 @@ func readBit(r io.Reader) (Bitstring, error) {
 	return Bitstring{bytes, uint8(bits)}, nil
 }
 
-func readComplex(r io.Reader) (Term, error) {
-	term, err := readTag(r)
-
-	if err != nil {
-		return term, err
-	}
-
-	switch kind := term.(type) {
-	case Atom:
-		switch kind {
-		case NilAtom:
-			return nil, nil
-		case TrueAtom:
-			return true, nil
-		case FalseAtom:
-			return false, nil
-		}
+// readComplex converts a {bert, Kind, ...} tuple into the Go value it
+// represents.
+func readComplex(tuple []Term) (Term, error) {
+	switch tuple[1] {
+	case NilAtom:
+		return nil, nil
+	case TrueAtom:
+		return true, nil
+	case FalseAtom:
+		return false, nil
+	case TimeAtom:
+		return readTime(tuple)
 	}
 
-	return term, nil
+	if len(tuple) == 2 {
+		return tuple[1], nil
+	}
+	return tuple, nil
 }
 
-func readTag(r io.Reader) (Term, error) {
-	tag, err := read1(r)
+func (d *Decoder) readTag() (Term, error) {
+	tag, err := read1(d.r)
 	if err != nil {
 		return nil, err
 	}
+	return d.readTagged(tag)
+}
+
+// readTagged reads the term following tag.
+func (d *Decoder) readTagged(tag int) (Term, error) {
+	if d.rec != nil {
+		return d.readVerbatim(tag)
+	}
+	return d.readBody(tag)
+}
+
+// readBody reads the term following tag, observing it if need be.
+func (d *Decoder) readBody(tag int) (Term, error) {
+	if d.stats != nil && tag != CompressedTag {
+		return d.readObserved(tag)
+	}
+	return d.readValue(tag)
+}
 
+// readValue reads the term following tag.
+func (d *Decoder) readValue(tag int) (Term, error) {
 	switch tag {
 	case SmallIntTag:
-		return readSmallInt(r)
+		return d.readSmallInt()
 	case IntTag:
-		return readInt(r)
+		return d.readInt()
 	case SmallBignumTag:
-		return readBigInt(r)
+		return d.readBigInt(1)
 	case LargeBignumTag:
-		return nil, ErrUnknownType
+		return d.readBigInt(4)
 	case FloatTag:
-		return readFloat(r)
-	case AtomTag:
-		return readAtom(r)
+		return d.readFloat()
+	case NewFloatTag:
+		return d.readNewFloat()
+	case AtomTag, SmallAtomTag, AtomUTF8Tag, SmallAtomUTF8Tag:
+		a, err := d.readAtom(tag)
+		if err == nil && d.SpecialFloats == SpecialFloatsAsAtoms {
+			if f, ok := specialFloat(a); ok {
+				return f, nil
+			}
+		}
+		return a, err
 	case SmallTupleTag:
-		return readSmallTuple(r)
+		return d.readSmallTuple()
 	case LargeTupleTag:
-		return nil, ErrUnknownType
+		return d.readLargeTuple()
 	case NilTag:
-		return readNil(r)
+		return d.readNil()
 	case StringTag:
-		return readString(r)
+		return d.readString()
 	case ListTag:
-		return readList(r)
+		return d.readList()
 	case BinTag:
-		return readBin(r)
+		return d.readBin()
 	case BitTag:
-		return readBit(r)
+		return d.readBit()
+	case MapTag:
+		return d.readMap()
+	case CompressedTag:
+		return d.readCompressed()
 	}
 
 	return nil, ErrUnknownType
 }
 
-// DecodeFrom decodes a Term from r and returns it or an error.
-func DecodeFrom(r io.Reader) (Term, error) {
-	version, err := read1(r)
-
+// Decode reads the next term from the decoder's input and returns it or an
+// error.
+func (d *Decoder) Decode() (Term, error) {
+	if d.Fidelity && d.rec == nil {
+		d.rec = &recordingReader{Reader: d.r}
+		saved := d.r
+		d.r = d.rec
+		defer func() { d.r, d.rec = saved, nil }()
+	}
+	if d.Observe != nil {
+		return d.decodeObserved()
+	}
+	tag, err := d.readStart()
 	if err != nil {
 		return nil, err
 	}
+	term, err := d.readTagged(tag)
+	return term, noEOF(err)
+}
+
+// noEOF turns the io.EOF of a term that ended early into
+// io.ErrUnexpectedEOF. Only an input ending before a term starts is at
+// its end.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readStart reads the version tag, unless NoVersion is set, and returns
+// the tag of the term that follows it. The error is io.EOF only if the
+// input ends before the term starts.
+func (d *Decoder) readStart() (int, error) {
+	if d.NoVersion {
+		return read1(d.r)
+	}
+
+	version, err := read1(d.r)
+
+	if err != nil {
+		return 0, err
+	}
 
 	// check protocol version
 	if version != VersionTag {
-		return nil, ErrBadMagic
+		return 0, ErrBadMagic
 	}
-
-	return readTag(r)
+	tag, err := read1(d.r)
+	return tag, noEOF(err)
 }
 
+// DecodeFrom decodes a Term from r and returns it or an error.
+func DecodeFrom(r io.Reader) (Term, error) { return NewDecoder(r).Decode() }
+
 // Decode decodes a Term from data and returns it or an error.
 func Decode(data []byte) (Term, error) { return DecodeFrom(bytes.NewBuffer(data)) }
 
+// DecodeNoVersion decodes a Term from data, which does not start with the
+// version tag, and returns it or an error.
+func DecodeNoVersion(data []byte) (Term, error) {
+	d := NewDecoder(bytes.NewReader(data))
+	d.NoVersion = true
+	return d.Decode()
+}
+
 // UnmarshalFrom decodes a value from r, stores it in val, and returns any
 // error encountered.
 func UnmarshalFrom(r io.Reader, val interface{}) (err error) {
-	result, _ := DecodeFrom(r)
-
-	value := reflect.ValueOf(val).Elem()
-
-	switch v := value; v.Kind() {
-	case reflect.Struct:
-		slice := reflect.ValueOf(result)
-		for i := 0; i < slice.Len(); i++ {
-			e := slice.Index(i).Elem()
-			v.Field(i).Set(e)
-		}
-	}
-
-	return nil
+	return UnmarshalOptions{}.unmarshalFrom(r, val)
 }
 
 // Unmarshal decodes a value from data, stores it in val, and returns any error
-// encountered.
+// encountered. It is UnmarshalOptions{}.Unmarshal: tuples need not have as
+// many elements as the struct or registered extension receiving them
+// has fields.
 func Unmarshal(data []byte, val interface{}) (err error) {
-	return UnmarshalFrom(bytes.NewBuffer(data), val)
+	return UnmarshalOptions{}.Unmarshal(data, val)
 }
 
-// UnmarshalRequest decodes a BURP from r and returns it as a Request.
+// UnmarshalRequest decodes a BURP, a 4-byte length followed by a
+// {call|cast, Module, Function, Arguments} request of that many bytes,
+// from r and returns it as a Request. The request must fill the BURP
+// exactly.
 func UnmarshalRequest(r io.Reader) (Request, error) {
-	var req Request
-
 	size, err := read4(r)
 	if err != nil {
-		return req, err
+		return Request{}, err
 	}
 
-	err = UnmarshalFrom(io.LimitReader(r, int64(size)), &req)
+	// the size comes from the input, so the buffer grows as data arrives
+	data, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return Request{}, err
+	}
+	if len(data) < size {
+		return Request{}, io.ErrUnexpectedEOF
+	}
 
-	return req, err
+	buf := bytes.NewReader(data)
+	term, err := DecodeFrom(buf)
+	if err != nil {
+		return Request{}, noEOF(err)
+	}
+	if buf.Len() > 0 {
+		return Request{}, fmt.Errorf("%d bytes left after request in BURP of %d bytes", buf.Len(), size)
+	}
+	return parseRequest(term)
 }
@@ -1,11 +1,17 @@
 package bert
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"math/big"
 	"reflect"
+	"runtime"
 	"testing"
+	"unsafe"
 )
 
 func ExampleDecode() {
@@ -32,21 +38,21 @@ func ExampleDecode() {
 
 func TestDecode(t *testing.T) {
 	// Small Integer
-	assertDecode(t, []byte{131, 97, 1}, 1)
-	assertDecode(t, []byte{131, 97, 2}, 2)
-	assertDecode(t, []byte{131, 97, 3}, 3)
-	assertDecode(t, []byte{131, 97, 4}, 4)
-	assertDecode(t, []byte{131, 97, 42}, 42)
+	assertDecode(t, []byte{131, 97, 1}, int64(1))
+	assertDecode(t, []byte{131, 97, 2}, int64(2))
+	assertDecode(t, []byte{131, 97, 3}, int64(3))
+	assertDecode(t, []byte{131, 97, 4}, int64(4))
+	assertDecode(t, []byte{131, 97, 42}, int64(42))
 
 	// Integer
-	assertDecode(t, []byte{131, 98, 0, 0, 1, 1}, 257)
-	assertDecode(t, []byte{131, 98, 0, 0, 4, 1}, 1025)
-	assertDecode(t, []byte{131, 98, 255, 255, 255, 255}, -1)
-	assertDecode(t, []byte{131, 98, 255, 255, 255, 248}, -8)
-	assertDecode(t, []byte{131, 98, 0, 0, 19, 136}, 5000)
-	assertDecode(t, []byte{131, 98, 255, 255, 236, 120}, -5000)
-	assertDecode(t, []byte{131, 98, 58, 222, 104, 177}, 987654321)
-	assertDecode(t, []byte{131, 98, 197, 33, 151, 79}, -987654321)
+	assertDecode(t, []byte{131, 98, 0, 0, 1, 1}, int64(257))
+	assertDecode(t, []byte{131, 98, 0, 0, 4, 1}, int64(1025))
+	assertDecode(t, []byte{131, 98, 255, 255, 255, 255}, int64(-1))
+	assertDecode(t, []byte{131, 98, 255, 255, 255, 248}, int64(-8))
+	assertDecode(t, []byte{131, 98, 0, 0, 19, 136}, int64(5000))
+	assertDecode(t, []byte{131, 98, 255, 255, 236, 120}, int64(-5000))
+	assertDecode(t, []byte{131, 98, 58, 222, 104, 177}, int64(987654321))
+	assertDecode(t, []byte{131, 98, 197, 33, 151, 79}, int64(-987654321))
 
 	// Small Bignum
 	n := *big.NewInt(0)
@@ -57,32 +63,45 @@ func TestDecode(t *testing.T) {
 	n.SetString("18446744073709551615", 10)
 	assertDecode(t, []byte{131, 110, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255}, n)
 
+	// Small Bignum within int64 range
+	assertDecode(t, []byte{131, 110, 5, 0, 0, 232, 118, 72, 23}, int64(100000000000))
+	assertDecode(t, []byte{131, 110, 5, 1, 0, 232, 118, 72, 23}, int64(-100000000000))
+	assertDecode(t, []byte{131, 110, 8, 1, 0, 0, 0, 0, 0, 0, 0, 128}, int64(-9223372036854775808))
+
+	// Large Bignum
+	n.SetString("18446744073709551616", 10)
+	assertDecode(t, []byte{131, 111, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, n)
+
 	// Float
 	assertDecode(t, []byte{131, 99, 53, 46, 48, 48, 48, 48, 48, 48, 48,
 		48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 101, 45,
 		48, 49, 0, 0, 0, 0, 0,
 	},
-		float32(0.5))
+		0.5)
 	assertDecode(t, []byte{131, 99, 51, 46, 49, 52, 49, 53, 56,
 		57, 57, 57, 57, 57, 57, 57, 57, 57, 57, 56, 56, 50, 54, 50,
 		101, 43, 48, 48, 0, 0, 0, 0, 0,
 	},
-		float32(3.14159))
+		3.14159)
 	assertDecode(t, []byte{131, 99, 45, 51, 46, 49, 52, 49, 53,
 		56, 57, 57, 57, 57, 57, 57, 57, 57, 57, 57, 56, 56, 50, 54,
 		50, 101, 43, 48, 48, 0, 0, 0, 0,
 	},
-		float32(-3.14159))
+		-3.14159)
 	assertDecode(t, []byte{131, 99, 51, 46, 49, 52, 49, 53, 57,
 		48, 49, 49, 56, 52, 48, 56, 50, 48, 51, 49, 50, 53, 48, 48,
 		101, 43, 48, 48, 0, 0, 0, 0, 0,
 	},
-		float32(3.14159))
+		float64(float32(3.14159)))
 	assertDecode(t, []byte{131, 99, 45, 51, 46, 49, 52, 49, 53,
 		57, 48, 49, 49, 56, 52, 48, 56, 50, 48, 51, 49, 50, 53, 48,
 		48, 101, 43, 48, 48, 0, 0, 0, 0,
 	},
-		float32(-3.14159))
+		float64(float32(-3.14159)))
+
+	// New Float
+	assertDecode(t, []byte{131, 70, 64, 9, 33, 249, 240, 27, 134, 110}, 3.14159)
+	assertDecode(t, []byte{131, 70, 191, 224, 0, 0, 0, 0, 0, 0}, -0.5)
 
 	// Atom
 	assertDecode(t, []byte{131, 100, 0, 3, 102, 111, 111},
@@ -106,7 +125,7 @@ func TestDecode(t *testing.T) {
 		97, 23,
 		97, 42,
 	},
-		[]Term{Atom("coord"), 23, 42})
+		[]Term{Atom("coord"), int64(23), int64(42)})
 	assertDecode(t, []byte{131, 104, 4,
 		100, 0, 4, 99, 97, 108, 108,
 		100, 0, 6, 112, 104, 111, 116, 111, 120,
@@ -114,7 +133,7 @@ func TestDecode(t *testing.T) {
 		108, 0, 0, 0, 1, 97, 99,
 		106,
 	},
-		[]Term{Atom("call"), Atom("photox"), Atom("img_size"), []Term{99}})
+		[]Term{Atom("call"), Atom("photox"), Atom("img_size"), []Term{int64(99)}})
 
 	// Large Tuple
 
@@ -126,9 +145,9 @@ func TestDecode(t *testing.T) {
 	// List
 	assertDecode(t, []byte{131, 106}, []Term{})
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 1, 97, 1, 106},
-		[]Term{1})
+		[]Term{int64(1)})
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 1, 98, 0, 0, 1, 0, 106},
-		[]Term{256})
+		[]Term{int64(256)})
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 1, 107, 0, 1, 97, 106},
 		[]Term{"a"})
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 1, 100, 0, 1, 97, 106},
@@ -137,7 +156,7 @@ func TestDecode(t *testing.T) {
 		97, 1, 97, 2, 97, 3,
 		106,
 	},
-		[]Term{1, 2, 3})
+		[]Term{int64(1), int64(2), int64(3)})
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 2,
 		107, 0, 1, 97, 107, 0, 1, 98, 106,
 	},
@@ -149,7 +168,7 @@ func TestDecode(t *testing.T) {
 	},
 		[]Term{Atom("a"), Atom("b")})
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 2, 100, 0, 1, 97, 97, 1, 106},
-		[]Term{Atom("a"), 1})
+		[]Term{Atom("a"), int64(1)})
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 2,
 		107, 0, 1, 97, 107, 0, 2, 1, 2, 106,
 	},
@@ -161,7 +180,7 @@ func TestDecode(t *testing.T) {
 	assertDecode(t, []byte{131, 108, 0, 0, 0, 2, 100, 0, 1, 97, 108, 0, 0, 0, 1, 98, 0, 0, 1, 0, 106,
 		106,
 	},
-		[]Term{Atom("a"), []Term{256}})
+		[]Term{Atom("a"), []Term{int64(256)}})
 
 	// Binary
 	assertDecode(t, []byte{131, 109, 0, 0, 0, 3, 102, 111, 111},
@@ -184,7 +203,56 @@ func TestDecode(t *testing.T) {
 		108, 0, 0, 0, 1, 97, 99,
 		106,
 	},
-		[]Term{Atom("call"), Atom("photox"), Atom("img_size"), []Term{99}})
+		[]Term{Atom("call"), Atom("photox"), Atom("img_size"), []Term{int64(99)}})
+}
+
+func TestDecodeListsAndTuples(t *testing.T) {
+	// NIL inside a tuple
+	assertDecode(t, []byte{131, 104, 2, 106, 97, 1}, []Term{[]Term{}, int64(1)})
+	// improper list, whose tail is dropped
+	assertDecode(t, []byte{131, 104, 2, 108, 0, 0, 0, 1, 97, 1, 97, 2, 97, 3},
+		[]Term{[]Term{int64(1)}, int64(3)})
+
+	large := make([]Term, 256)
+	data := []byte{131, 105, 0, 0, 1, 0}
+	for i := range large {
+		large[i] = int64(1)
+		data = append(data, 97, 1)
+	}
+	assertDecode(t, data, large)
+	assertEncode(t, large, data)
+}
+
+func TestDecodeExact(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Encode([]Term{Atom("ok"), bytes.Repeat([]byte{1}, 10000)})
+	enc.CompressThreshold = 1
+	enc.Encode(bytes.Repeat([]byte{2}, 100))
+	buf.WriteString("HTTP/1.1")
+
+	r := bufio.NewReader(&buf)
+	for i := 0; i < 2; i++ {
+		if _, err := DecodeFrom(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rest, _ := ioutil.ReadAll(r)
+	assertEqual(t, "HTTP/1.1", string(rest))
+
+	for _, data := range [][]byte{
+		{131, 104},
+		{131, 104, 2, 97},
+		{131, 109, 0, 0, 0, 5, 1, 2},
+		{131, 109, 0, 1, 0, 0, 1, 2},
+	} {
+		if _, err := Decode(data); err != io.ErrUnexpectedEOF {
+			t.Errorf("Decode(%v) returned error '%v', expected unexpected EOF", data, err)
+		}
+	}
+	if _, err := Decode(nil); err != io.EOF {
+		t.Errorf("Decode of no input returned error '%v', expected EOF", err)
+	}
 }
 
 func assertDecode(t *testing.T, data []byte, expected interface{}) {
@@ -196,6 +264,77 @@ func assertDecode(t *testing.T, data []byte, expected interface{}) {
 	}
 }
 
+func TestDecodeHugeCounts(t *testing.T) {
+	for _, data := range [][]byte{
+		{131, 108, 0x7f, 0xff, 0xff, 0xff, 97, 1},
+		{131, 105, 0x7f, 0xff, 0xff, 0xff, 97, 1},
+		{131, 116, 0x7f, 0xff, 0xff, 0xff, 97, 1, 97, 2},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := Decode(data); err != io.ErrUnexpectedEOF {
+			t.Errorf("Decode(%v) returned error '%v', expected unexpected EOF", data, err)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("Decode(%v) allocated %d bytes", data, n)
+		}
+	}
+}
+
+func TestDecoderIntern(t *testing.T) {
+	data := []byte{131, 108, 0, 0, 0, 2,
+		104, 2, 100, 0, 2, 111, 107, 107, 0, 1, 97,
+		104, 2, 100, 0, 2, 111, 107, 107, 0, 1, 97,
+		106,
+	}
+
+	d := NewDecoder(bytes.NewReader(data))
+	d.InternAtoms = true
+	d.InternStrings = true
+	term, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{[]Term{Atom("ok"), "a"}, []Term{Atom("ok"), "a"}}, term)
+
+	list := term.([]Term)
+	first, second := list[0].([]Term), list[1].([]Term)
+	if stringData(string(first[0].(Atom))) != stringData(string(second[0].(Atom))) {
+		t.Errorf("expected repeated atoms to share storage")
+	}
+	if stringData(first[1].(string)) != stringData(second[1].(string)) {
+		t.Errorf("expected repeated strings to share storage")
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	data := []byte{131, 100, 0, 2, 111, 107}
+	d := NewDecoder(bytes.NewReader(data))
+	d.InternAtoms = true
+	first, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Reset(bytes.NewReader(data))
+	second, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("ok"), second)
+	if stringData(string(first.(Atom))) != stringData(string(second.(Atom))) {
+		t.Errorf("expected atoms interned before Reset to be shared")
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("expected EOF after the new input, got %v", err)
+	}
+}
+
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
 func TestUnmarshal(t *testing.T) {
 	var a struct {
 		First Atom
@@ -217,6 +356,56 @@ func TestUnmarshal(t *testing.T) {
 	assertEqual(t, Atom("foo"), c.First)
 	assertEqual(t, Atom("bar"), c.Second)
 
+	var f struct {
+		Single float32
+		Double float64
+	}
+	Unmarshal([]byte{131, 104, 2,
+		70, 64, 9, 33, 249, 240, 27, 134, 110,
+		70, 64, 9, 33, 249, 240, 27, 134, 110,
+	}, &f)
+	assertEqual(t, float32(3.14159), f.Single)
+	assertEqual(t, 3.14159, f.Double)
+
+	var ints struct {
+		Small  int8
+		Medium uint16
+		Large  uint64
+		Big    *big.Int
+	}
+	err := Unmarshal([]byte{131, 104, 4,
+		98, 255, 255, 255, 128,
+		98, 0, 0, 255, 255,
+		110, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255,
+		110, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+	}, &ints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int8(-128), ints.Small)
+	assertEqual(t, uint16(65535), ints.Medium)
+	assertEqual(t, uint64(18446744073709551615), ints.Large)
+	assertEqual(t, "18446744073709551616", ints.Big.String())
+
+	if err := Unmarshal([]byte{131, 104, 1, 98, 0, 0, 1, 0}, &ints); err == nil {
+		t.Errorf("expected overflow error for 256 in int8")
+	}
+	if err := Unmarshal([]byte{131, 104, 2, 97, 0, 98, 255, 255, 255, 255}, &ints); err == nil {
+		t.Errorf("expected range error for -1 in uint16")
+	} else {
+		assertEqual(t, "[1]", err.(*UnmarshalTypeError).Path)
+	}
+
+	// Floats out of the range of float32 are refused, infinities not.
+	var floats struct{ F float32 }
+	if err := UnmarshalTerm([]Term{1e40}, &floats); err == nil {
+		t.Errorf("expected overflow error for 1e40 in float32")
+	}
+	if err := UnmarshalTerm([]Term{math.Inf(-1)}, &floats); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, float32(math.Inf(-1)), floats.F)
+
 	var req Request
 	Unmarshal([]byte{131, 104, 4,
 		100, 0, 4, 99, 97, 108, 108,
@@ -229,7 +418,159 @@ func TestUnmarshal(t *testing.T) {
 	assertEqual(t, Atom("call"), req.Kind)
 	assertEqual(t, Atom("photox"), req.Module)
 	assertEqual(t, Atom("img_size"), req.Function)
-	assertEqual(t, []Term{99}, req.Arguments)
+	assertEqual(t, []Term{int64(99)}, req.Arguments)
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	type point struct{ X, Y int }
+	var v struct {
+		Kind    Atom
+		Payload Term
+		Extra   interface{}
+		Point   interface{}
+	}
+	v.Point = &point{}
+	data, _ := Encode([]Term{Atom("move"), []Term{1, "x"}, Atom("fast"), []Term{3, 4}})
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{int64(1), "x"}, v.Payload)
+	assertEqual(t, Atom("fast"), v.Extra)
+	assertEqual(t, &point{3, 4}, v.Point)
+
+	var s fmt.Stringer
+	if err := Unmarshal([]byte{131, 97, 1}, &s); err == nil {
+		t.Errorf("expected error unmarshaling an integer into fmt.Stringer")
+	}
+}
+
+func TestUnmarshalMap(t *testing.T) {
+	type user struct {
+		Name Atom
+		Age  int
+	}
+	data, _ := Encode(NewTermMap(
+		KV{Atom("joe"), []Term{Atom("joe"), 30}},
+		KV{[]byte("ann"), []Term{Atom("ann"), 25}},
+	))
+
+	var byAtom map[Atom]user
+	if err := Unmarshal(data, &byAtom); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, map[Atom]user{"joe": {"joe", 30}, "ann": {"ann", 25}}, byAtom)
+
+	var byString map[string]Term
+	if err := Unmarshal(data, &byString); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, map[string]Term{
+		"joe": []Term{Atom("joe"), int64(30)},
+		"ann": []Term{Atom("ann"), int64(25)},
+	}, byString)
+
+	var ages map[Atom]uint8
+	data, _ = Encode(map[Atom]int{"joe": 30, "old": 300})
+	err := Unmarshal(data, &ages)
+	if e, ok := err.(*UnmarshalTypeError); !ok || e.Path != `["old"]` {
+		t.Errorf("expected overflow error at [\"old\"], got %v", err)
+	}
+}
+
+func TestUnmarshalSlice(t *testing.T) {
+	var v struct {
+		IDs   []int
+		Names []string
+		Data  []byte
+	}
+	err := UnmarshalTerm([]Term{
+		[]Term{int64(1), int64(2)},
+		List{Items: []Term{"a", Atom("b")}},
+		[]Term{int64(1), int64(255)},
+	}, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []int{1, 2}, v.IDs)
+	assertEqual(t, []string{"a", "b"}, v.Names)
+	assertEqual(t, []byte{1, 255}, v.Data)
+
+	err = UnmarshalTerm([]Term{[]Term{int64(1), "x"}}, &v)
+	if e, ok := err.(*UnmarshalTypeError); !ok || e.Path != "[0][1]" {
+		t.Errorf("expected a type error at [0][1], got %v", err)
+	}
+}
+
+func TestUnmarshalWeak(t *testing.T) {
+	var v struct {
+		Port  int
+		Rate  float64
+		Ratio float32
+		Tags  []string
+		Name  string
+	}
+	term := []Term{"8080", int64(3), []byte("0.5"), Atom("prod"), Atom("web")}
+	if err := UnmarshalTerm(term, &v); err == nil {
+		t.Errorf("expected an error without Weak")
+	}
+
+	weak := UnmarshalOptions{Weak: true}
+	if err := weak.UnmarshalTerm(term, &v); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 8080, v.Port)
+	assertEqual(t, 3.0, v.Rate)
+	assertEqual(t, float32(0.5), v.Ratio)
+	assertEqual(t, []string{"prod"}, v.Tags)
+	assertEqual(t, "web", v.Name)
+
+	var small struct{ N int8 }
+	if err := weak.UnmarshalTerm([]Term{"300"}, &small); err == nil {
+		t.Errorf("expected an error for a number out of range")
+	}
+	if err := weak.UnmarshalTerm([]Term{"ten"}, &small); err == nil {
+		t.Errorf("expected an error for text that is not a number")
+	}
+	var ratio struct{ R float32 }
+	for _, term := range []Term{"1e40", new(big.Int).Lsh(big.NewInt(1), 200)} {
+		if err := weak.UnmarshalTerm([]Term{term}, &ratio); err == nil {
+			t.Errorf("expected an error for %v in float32", term)
+		}
+	}
+}
+
+func TestUnmarshalAllErrors(t *testing.T) {
+	type server struct {
+		Host  string
+		Port  uint16
+		Tags  []string
+		Limit map[string]int
+	}
+	term := []Term{
+		[]Term{"a", int64(70000), []Term{"x", int64(1), "y"}, TermMap{}},
+		[]Term{"b", int64(80), []Term{}, NewTermMap(KV{"max", "lots"}, KV{"min", int64(1)})},
+	}
+
+	var servers []server
+	err := UnmarshalOptions{AllErrors: true}.UnmarshalTerm(term, &servers)
+	errs, ok := err.(UnmarshalErrors)
+	if !ok {
+		t.Fatalf("expected UnmarshalErrors, got %v", err)
+	}
+	paths := make([]string, len(errs))
+	for i, err := range errs {
+		paths[i] = err.(*UnmarshalTypeError).Path
+	}
+	assertEqual(t, []string{"[0][1]", "[0][2][1]", `[1][3]["max"]`}, paths)
+
+	// The rest is stored.
+	assertEqual(t, "b", servers[1].Host)
+	assertEqual(t, []string{"x", "", "y"}, servers[0].Tags)
+	assertEqual(t, map[string]int{"min": 1}, servers[1].Limit)
+
+	// Otherwise only the first failure is reported.
+	err = UnmarshalTerm(term, &servers)
+	assertEqual(t, "[0][1]", err.(*UnmarshalTypeError).Path)
 }
 
 func TestUnmarshalRequest(t *testing.T) {
@@ -243,11 +584,41 @@ func TestUnmarshalRequest(t *testing.T) {
 		106,
 	})
 
-	req, _ := UnmarshalRequest(buf)
+	req, err := UnmarshalRequest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
 	assertEqual(t, Atom("call"), req.Kind)
 	assertEqual(t, Atom("photox"), req.Module)
 	assertEqual(t, Atom("img_size"), req.Function)
-	assertEqual(t, []Term{99}, req.Arguments)
+	assertEqual(t, []Term{int64(99)}, req.Arguments)
+}
+
+func TestUnmarshalRequestInvalid(t *testing.T) {
+	burp := func(term Term) []byte {
+		data, err := Encode(term)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append([]byte{0, 0, 0, byte(len(data))}, data...)
+	}
+	valid := burp([]Term{CastAtom, Atom("m"), Atom("f"), []Term{}})
+	if _, err := UnmarshalRequest(bytes.NewReader(valid)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		"kind":      burp([]Term{Atom("reply"), Atom("m"), Atom("f"), []Term{}}),
+		"arity":     burp([]Term{CallAtom, Atom("m"), Atom("f")}),
+		"module":    burp([]Term{CallAtom, "m", Atom("f"), []Term{}}),
+		"short":     valid[:len(valid)-1],
+		"trailing":  append([]byte{0, 0, 0, valid[3] + 1}, append(valid[4:], 106)...),
+		"undecoded": {0, 0, 0, 2, 131, 200},
+	} {
+		if _, err := UnmarshalRequest(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
 }
 
 func assertEqual(t *testing.T, expected interface{}, actual interface{}) {
@@ -0,0 +1,102 @@
+package bert
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// A subterm identifies a composite Go value by its address, so that the
+// same slice, map or pointed-to value reached several times while
+// encoding a term is recognized.
+type subterm struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// A dedupeCache holds the encodings of the atoms and repeated subterms of
+// the term being encoded by an Encoder with Dedupe set. The subterms seen
+// are kept in it, so that their addresses cannot be reused by values
+// made while encoding, such as those returned by registered encoders.
+type dedupeCache struct {
+	atoms   map[Atom][]byte
+	seen    map[subterm]reflect.Value
+	encoded map[subterm][]byte
+}
+
+func newDedupeCache() *dedupeCache {
+	return &dedupeCache{
+		atoms:   make(map[Atom][]byte),
+		seen:    make(map[subterm]reflect.Value),
+		encoded: make(map[subterm][]byte),
+	}
+}
+
+// subtermOf returns the identity of v if it is a non-empty slice or map or
+// a pointer to a composite value.
+func subtermOf(v reflect.Value) (subterm, bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return subterm{}, false
+		}
+		switch v.Elem().Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Map, reflect.Array:
+			return subterm{typ: v.Type(), ptr: v.Pointer()}, true
+		}
+	case reflect.Slice:
+		if v.Len() > 0 && v.Type().Elem().Kind() != reflect.Uint8 {
+			return subterm{typ: v.Type(), ptr: v.Pointer(), len: v.Len()}, true
+		}
+	case reflect.Map:
+		if v.Len() > 0 {
+			return subterm{typ: v.Type(), ptr: v.Pointer()}, true
+		}
+	}
+	return subterm{}, false
+}
+
+// writeShared writes the subterm v identified by key. The first time v is
+// seen it is written as usual; the second time its encoding is kept, to
+// be copied from then on.
+func (e *Encoder) writeShared(key subterm, v reflect.Value) error {
+	c := e.dedupe
+	if b, ok := c.encoded[key]; ok {
+		_, err := e.w.Write(b)
+		return err
+	}
+	if _, ok := c.seen[key]; !ok {
+		c.seen[key] = v
+		return e.writeValue(v)
+	}
+
+	var buf bytes.Buffer
+	sub := *e
+	sub.w = &buf
+	if err := sub.writeValue(v); err != nil {
+		return err
+	}
+	c.encoded[key] = buf.Bytes()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// writeCachedAtom writes a, encoding it only the first time.
+func (e *Encoder) writeCachedAtom(a Atom) error {
+	c := e.dedupe
+	if b, ok := c.atoms[a]; ok {
+		_, err := e.w.Write(b)
+		return err
+	}
+
+	var buf bytes.Buffer
+	sub := *e
+	sub.w = &buf
+	sub.dedupe = nil
+	if err := sub.writeAtom(a); err != nil {
+		return err
+	}
+	c.atoms[a] = buf.Bytes()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,128 @@
+package bert
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A DiffOp is the kind of a Difference.
+type DiffOp int
+
+const (
+	// Changed is a value that differs between the two terms.
+	Changed DiffOp = iota
+	// Added is an element or map entry only present in the second term.
+	Added
+	// Removed is an element or map entry only present in the first term.
+	Removed
+)
+
+func (op DiffOp) String() string {
+	switch op {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	}
+	return "changed"
+}
+
+// A Difference is a difference between two terms. Path locates it within
+// them using Go syntax for indexes, e.g. "[2][\"name\"]", and is empty for
+// the terms themselves. Old is unset for Added and New for Removed.
+type Difference struct {
+	Path     string
+	Op       DiffOp
+	Old, New Term
+}
+
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "term"
+	}
+	switch d.Op {
+	case Added:
+		return fmt.Sprintf("%s: added %v", path, d.New)
+	case Removed:
+		return fmt.Sprintf("%s: removed %v", path, d.Old)
+	}
+	return fmt.Sprintf("%s: changed %v to %v", path, d.Old, d.New)
+}
+
+// Diff returns the differences between the terms a and b, ordered by
+// path. Tuples, lists and maps are compared element by element, with map
+// keys matched as by Equal, and any other terms as a whole.
+func Diff(a, b Term) []Difference {
+	var diffs []Difference
+	diff("", a, b, &diffs)
+	return diffs
+}
+
+func diff(path string, a, b Term, diffs *[]Difference) {
+	if x, ok := a.(List); ok {
+		a = x.Items
+	}
+	if x, ok := b.(List); ok {
+		b = x.Items
+	}
+
+	if x, ok := a.([]Term); ok {
+		if y, ok := b.([]Term); ok {
+			diffItems(path, x, y, diffs)
+			return
+		}
+	}
+	va, vb := termValue(a), termValue(b)
+	if kindOf(va) == MapKind && kindOf(vb) == MapKind {
+		diffMaps(path, termMapOf(va), termMapOf(vb), diffs)
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, Difference{Path: path, Op: Changed, Old: a, New: b})
+	}
+}
+
+func diffItems(path string, a, b []Term, diffs *[]Difference) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		elem := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(a):
+			*diffs = append(*diffs, Difference{Path: elem, Op: Added, New: b[i]})
+		case i >= len(b):
+			*diffs = append(*diffs, Difference{Path: elem, Op: Removed, Old: a[i]})
+		default:
+			diff(elem, a[i], b[i], diffs)
+		}
+	}
+}
+
+func diffMaps(path string, a, b TermMap, diffs *[]Difference) {
+	i, j := 0, 0
+	for i < len(a.entries) || j < len(b.entries) {
+		c := 0
+		switch {
+		case i == len(a.entries):
+			c = 1
+		case j == len(b.entries):
+			c = -1
+		default:
+			c = Compare(a.entries[i].Key, b.entries[j].Key)
+		}
+
+		switch {
+		case c < 0:
+			elem := fmt.Sprintf("%s[%#v]", path, a.entries[i].Key)
+			*diffs = append(*diffs, Difference{Path: elem, Op: Removed, Old: a.entries[i].Value})
+			i++
+		case c > 0:
+			elem := fmt.Sprintf("%s[%#v]", path, b.entries[j].Key)
+			*diffs = append(*diffs, Difference{Path: elem, Op: Added, New: b.entries[j].Value})
+			j++
+		default:
+			elem := fmt.Sprintf("%s[%#v]", path, a.entries[i].Key)
+			diff(elem, a.entries[i].Value, b.entries[j].Value, diffs)
+			i, j = i+1, j+1
+		}
+	}
+}
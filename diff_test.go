@@ -0,0 +1,35 @@
+package bert
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	a := []Term{
+		Atom("user"),
+		List{Items: []Term{int64(1), int64(2), int64(3)}},
+		map[Atom]Term{"name": "joe", "age": int64(30), "city": "Paris"},
+	}
+	b := []Term{
+		Atom("user"),
+		[]Term{int64(1), int64(5)},
+		map[Atom]Term{"name": "joe", "age": int64(31), "email": []byte("joe@example.com")},
+	}
+
+	assertEqual(t, []Difference{
+		{Path: "[1][1]", Op: Changed, Old: int64(2), New: int64(5)},
+		{Path: "[1][2]", Op: Removed, Old: int64(3)},
+		{Path: `[2]["age"]`, Op: Changed, Old: int64(30), New: int64(31)},
+		{Path: `[2]["city"]`, Op: Removed, Old: "Paris"},
+		{Path: `[2]["email"]`, Op: Added, New: []byte("joe@example.com")},
+	}, Diff(a, b))
+
+	if diffs := Diff(a, a); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+	assertEqual(t, []Difference{{Op: Changed, Old: Atom("ok"), New: "ok"}}, Diff(Atom("ok"), "ok"))
+}
+
+func TestDifferenceString(t *testing.T) {
+	assertEqual(t, "[1]: changed 2 to 5", Difference{Path: "[1]", Op: Changed, Old: 2, New: 5}.String())
+	assertEqual(t, "[2]: added ok", Difference{Path: "[2]", Op: Added, New: Atom("ok")}.String())
+	assertEqual(t, "term: removed 1", Difference{Op: Removed, Old: 1}.String())
+}
@@ -0,0 +1,101 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// ErrNoHandler is returned by Dispatch for a message that no handler is
+// registered for.
+var ErrNoHandler = errors.New("no handler for message")
+
+// A MessageFunc handles a message routed by a Dispatcher. It is called
+// with the whole tuple, tag included.
+type MessageFunc func(ctx context.Context, msg []Term) error
+
+// A Dispatcher routes messages, tuples tagged with an atom such as
+// {block, Number, Hash}, to the handler registered for their tag. It is
+// safe for concurrent use.
+type Dispatcher struct {
+	// Default, if set, handles the messages no handler is registered for,
+	// including terms that are not tagged tuples.
+	Default func(ctx context.Context, term Term) error
+
+	mu       sync.RWMutex
+	handlers map[Atom]MessageFunc
+}
+
+// HandleFunc arranges for fn to handle messages tagged with tag,
+// replacing any previous handler.
+func (d *Dispatcher) HandleFunc(tag Atom, fn MessageFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.handlers == nil {
+		d.handlers = make(map[Atom]MessageFunc)
+	}
+	d.handlers[tag] = fn
+}
+
+// Handle arranges for fn to handle messages tagged with tag, with the
+// message unmarshaled into a T. If T is a struct, its fields are filled
+// from the elements following the tag, or from the whole tuple if T
+// declares a record tag. Otherwise the message must have a single element
+// after its tag, which is stored in the T. For example
+//
+//	type Block struct {
+//		Number int
+//		Hash   []byte
+//	}
+//
+//	bert.Handle(d, "block", func(ctx context.Context, b Block) error { ... })
+//
+// handles {block, Number, Hash} messages. Messages that cannot be
+// unmarshaled into a T make Dispatch return the error of Unmarshal.
+func Handle[T any](d *Dispatcher, tag Atom, fn func(ctx context.Context, msg T) error) {
+	d.HandleFunc(tag, func(ctx context.Context, msg []Term) error {
+		var v T
+		if err := unmarshalMessage(msg, &v); err != nil {
+			return err
+		}
+		return fn(ctx, v)
+	})
+}
+
+// unmarshalMessage stores the tagged tuple msg in the value val points to,
+// as described for Handle.
+func unmarshalMessage(msg []Term, val interface{}) error {
+	v := reflect.ValueOf(val).Elem()
+	if v.Kind() != reflect.Struct {
+		if len(msg) != 2 {
+			return &UnmarshalTypeError{Term: msg, Type: v.Type()}
+		}
+		return UnmarshalTerm(msg[1], val)
+	}
+	if _, ok := recordTag(v.Type()); ok {
+		return UnmarshalTerm(msg, val)
+	}
+	return UnmarshalTerm(msg[1:], val)
+}
+
+// Dispatch calls the handler registered for the tag of term with it and
+// returns the handler's error. Terms without a handler go to Default, or
+// else make Dispatch return ErrNoHandler.
+func (d *Dispatcher) Dispatch(ctx context.Context, term Term) error {
+	if msg, ok := term.([]Term); ok && len(msg) > 0 {
+		if tag, ok := msg[0].(Atom); ok {
+			d.mu.RLock()
+			fn := d.handlers[tag]
+			d.mu.RUnlock()
+			if fn != nil {
+				return fn(ctx, msg)
+			}
+		}
+	}
+	if d.Default != nil {
+		return d.Default(ctx, term)
+	}
+	return ErrNoHandler
+}
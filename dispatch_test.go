@@ -0,0 +1,58 @@
+package bert
+
+import (
+	"context"
+	"testing"
+)
+
+type dispatchBlock struct {
+	Number int
+	Hash   []byte
+}
+
+func TestDispatcher(t *testing.T) {
+	var d Dispatcher
+	var blocks []dispatchBlock
+	var tickets []int
+	var users []recordUser
+	Handle(&d, "block", func(ctx context.Context, b dispatchBlock) error {
+		blocks = append(blocks, b)
+		return nil
+	})
+	Handle(&d, "ticket", func(ctx context.Context, n int) error {
+		tickets = append(tickets, n)
+		return nil
+	})
+	Handle(&d, "user", func(ctx context.Context, u recordUser) error {
+		users = append(users, u)
+		return nil
+	})
+
+	ctx := context.Background()
+	for _, term := range []Term{
+		[]Term{Atom("block"), int64(7), []byte{1, 2}},
+		[]Term{Atom("ticket"), int64(3)},
+		[]Term{Atom("user"), "joe", int64(30)},
+	} {
+		if err := d.Dispatch(ctx, term); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertEqual(t, []dispatchBlock{{7, []byte{1, 2}}}, blocks)
+	assertEqual(t, []int{3}, tickets)
+	assertEqual(t, []recordUser{{Name: "joe", Age: 30}}, users)
+
+	if err := d.Dispatch(ctx, []Term{Atom("ticket"), Atom("x")}); err == nil {
+		t.Errorf("expected unmarshal error")
+	}
+	assertEqual(t, ErrNoHandler, d.Dispatch(ctx, []Term{Atom("other")}))
+	assertEqual(t, ErrNoHandler, d.Dispatch(ctx, int64(1)))
+
+	var unhandled []Term
+	d.Default = func(ctx context.Context, term Term) error {
+		unhandled = append(unhandled, term)
+		return nil
+	}
+	d.Dispatch(ctx, int64(1))
+	assertEqual(t, []Term{int64(1)}, unhandled)
+}
@@ -3,13 +3,210 @@ package bert
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"math/bits"
 	"reflect"
+	"sort"
+	"time"
+	"unicode/utf8"
 )
 
-func write1(w io.Writer, ui8 uint8) { w.Write([]byte{ui8}) }
+// NilPolicy selects how an Encoder represents nil pointers and nil
+// interfaces. The same rule applies wherever they appear: as the value
+// encoded, as elements of tuples and lists, as map keys and values, and
+// as struct fields. Nil slices and maps are not nil values but empty
+// ones.
+type NilPolicy int
+
+const (
+	// NilAsList encodes nil as NIL, the empty list. This is the default.
+	NilAsList NilPolicy = iota
+	// NilAsAtom encodes nil as the atom nil, as used by Elixir.
+	NilAsAtom
+	// NilAsUndefined encodes nil as the atom undefined, as used by Erlang
+	// records and APIs.
+	NilAsUndefined
+	// NilOmitted leaves entries with nil values out of encoded maps. Nil
+	// values elsewhere encode as NIL.
+	NilOmitted
+	// NilRejected makes encoding nil values fail with ErrNilValue, for
+	// peers that never expect them.
+	NilRejected
+)
+
+// ErrNilValue is returned for nil values encoded with NilRejected.
+var ErrNilValue = errors.New("nil value")
+
+// StringEncoding selects how an Encoder represents Go strings.
+type StringEncoding int
+
+const (
+	// StringAsList encodes strings as STRING_EXT, or as a list of byte
+	// integers if they are longer than the 65535 bytes STRING_EXT can
+	// hold, as term_to_binary does. This is the default.
+	StringAsList StringEncoding = iota
+	// LongStringAsBinary encodes strings as STRING_EXT, or as a binary if
+	// they are too long for it.
+	LongStringAsBinary
+	// StringAsBinary encodes strings as binaries holding their UTF-8
+	// text, which is how Elixir represents strings. Atom and Charlist
+	// values keep their own encodings.
+	StringAsBinary
+)
+
+// AtomEncoding selects how an Encoder represents atoms.
+type AtomEncoding int
+
+const (
+	// AtomLatin1 encodes atoms as ATOM_EXT holding their text in Latin-1,
+	// which every OTP release reads. This is the default. Atoms with
+	// characters outside Latin-1 cannot be encoded.
+	AtomLatin1 AtomEncoding = iota
+	// AtomUTF8 encodes atoms as SMALL_ATOM_UTF8_EXT or ATOM_UTF8_EXT
+	// holding their text in UTF-8, as OTP 20 and later do.
+	AtomUTF8
+)
+
+// SpecialFloatPolicy selects how NaN and infinite floats, which Erlang
+// floats cannot hold, are represented.
+type SpecialFloatPolicy int
+
+const (
+	// SpecialFloatsRejected makes an Encoder return an error for NaN and
+	// infinite floats. This is the default.
+	SpecialFloatsRejected SpecialFloatPolicy = iota
+	// SpecialFloatsAsAtoms encodes NaN, +Inf and -Inf as the atoms nan,
+	// infinity and neg_infinity, and makes a Decoder decode those atoms
+	// as floats.
+	SpecialFloatsAsAtoms
+)
+
+// The atoms standing for special floats with SpecialFloatsAsAtoms.
+const (
+	NaNAtom         = Atom("nan")
+	InfinityAtom    = Atom("infinity")
+	NegInfinityAtom = Atom("neg_infinity")
+)
+
+// UndefinedAtom is the atom Erlang uses for absent values.
+const UndefinedAtom = Atom("undefined")
+
+// An Encoder writes BERT terms to an output stream.
+type Encoder struct {
+	// Nil selects the representation of nil pointers and interfaces.
+	Nil NilPolicy
+
+	// Atoms selects the representation of atoms.
+	Atoms AtomEncoding
+
+	// Strings selects the representation of Go strings.
+	Strings StringEncoding
+
+	// BigFloats selects the representation of big.Float values and of
+	// json.Number values that are not integers. json.Number values
+	// holding integers always encode as integers, however large.
+	BigFloats BigFloatEncoding
+
+	// SpecialFloats selects the representation of NaN and infinite
+	// floats.
+	SpecialFloats SpecialFloatPolicy
+
+	// KeepBitstrings encodes Bitstring values holding a whole number of
+	// bytes as BIT_BINARY_EXT, as they were decoded, rather than as
+	// binaries. Empty bitstrings are always binaries.
+	KeepBitstrings bool
+
+	// Time selects the representation of time.Time values, and TimeUnit
+	// the unit of TimeAsInteger: one of time.Second, time.Millisecond (the
+	// default), time.Microsecond or time.Nanosecond. Struct fields
+	// tagged with one of the unit options s, ms, us or ns, as in
+	// `bert:"created,ms"`, always encode as integers in that unit.
+	Time     TimeEncoding
+	TimeUnit time.Duration
+
+	// DurationUnit is the unit time.Duration values are encoded in,
+	// defaulting to nanoseconds. Struct tags select it per field as for
+	// times.
+	DurationUnit time.Duration
+
+	// CompressThreshold, if positive, compresses terms whose encoding is
+	// at least that many bytes with zlib, as term_to_binary/2 does with the
+	// compressed option. The compressed form is only written if it is
+	// smaller.
+	CompressThreshold int
+
+	// StructMaps encodes structs as maps from atoms naming their fields to
+	// the field values, instead of as tuples. Fields are named by their tag
+	// or else their Go name. Struct fields tagged with the map option, as
+	// in `bert:"opts,map"`, always hold structs encoded this way. Structs
+	// declaring a record tag remain tuples.
+	StructMaps bool
+
+	// NoVersion leaves out the version tag that precedes each term, as
+	// within distribution messages and some container formats.
+	NoVersion bool
+
+	// Dedupe makes the encoder remember the encodings of atoms, and of
+	// slices, maps and pointers it meets more than once within a term, and
+	// copy them rather than encode them again. It suits large terms
+	// repeating the same keys or sharing the same values, such as a
+	// configuration block referenced from many places. The output is the
+	// same.
+	Dedupe bool
+
+	// Canonical writes the canonical encoding of terms, so that equal terms
+	// always encode to the same bytes, as content addressing and signing
+	// require: the entries of maps, including TermMap and []KV values and
+	// structs encoded as maps, are sorted by their encoded keys, proper
+	// lists of integers from 0 to 255 are written as strings, as Erlang
+	// writes them, Verbatim, RawTerm and Encoded values are encoded again
+	// from the terms they hold, and CompressThreshold is ignored.
+	Canonical bool
+
+	// Observe, if set, is called with the statistics of each term
+	// successfully encoded and written.
+	Observe func(CodecStats)
+
+	w       io.Writer
+	dedupe  *dedupeCache
+	scratch [11]byte // for integers, written without allocating
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Reset makes e write to w, keeping its options, so that encoders can be
+// pooled and reused.
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.dedupe = nil
+}
+
+// byteValues holds each byte value at its own index, so that single bytes
+// are written from it without allocating.
+var byteValues = func() (b [256]byte) {
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return
+}()
+
+// smallInts holds the SMALL_INTEGER_EXT encodings of 0 to 255.
+var smallInts = func() (t [256][2]byte) {
+	for i := range t {
+		t[i] = [2]byte{SmallIntTag, byte(i)}
+	}
+	return
+}()
+
+func write1(w io.Writer, ui8 uint8) { w.Write(byteValues[ui8 : int(ui8)+1]) }
 
 func write2(w io.Writer, ui16 uint16) {
 	b := make([]byte, 2)
@@ -24,8 +221,7 @@ func write4(w io.Writer, ui32 uint32) {
 }
 
 func writeSmallInt(w io.Writer, n uint8) {
-	write1(w, SmallIntTag)
-	write1(w, n)
+	w.Write(smallInts[n][:])
 }
 
 func writeInt(w io.Writer, n uint32) {
@@ -46,14 +242,19 @@ func writeNumber(w io.Writer, n big.Int) {
 		}
 	}
 
-	write1(w, SmallBignumTag)
 	bytes := n.Bytes()
 	// converting big endian to small endian
 	// http://erlang.org/doc/apps/erts/erl_ext_dist.html#small_big_ext
 	for i, j := 0, len(bytes)-1; i < j; i, j = i+1, j-1 {
 		bytes[i], bytes[j] = bytes[j], bytes[i]
 	}
-	write1(w, uint8(len(bytes)))
+	if len(bytes) < 256 {
+		write1(w, SmallBignumTag)
+		write1(w, uint8(len(bytes)))
+	} else {
+		write1(w, LargeBignumTag)
+		write4(w, uint32(len(bytes)))
+	}
 	if n.Sign() > -1 {
 		write1(w, 0)
 	} else {
@@ -62,36 +263,265 @@ func writeNumber(w io.Writer, n big.Int) {
 	w.Write(bytes)
 }
 
-func writeFloat(w io.Writer, f float32) {
+// writeInt64 writes n as writeNumber does, without going through a
+// big.Int or allocating.
+func (e *Encoder) writeInt64(n int64) {
+	switch {
+	case n >= 0 && n < 256:
+		writeSmallInt(e.w, uint8(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		b := e.scratch[:5]
+		b[0] = IntTag
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.w.Write(b)
+	case n < 0:
+		// -n overflows for math.MinInt64, whose magnitude is still
+		// uint64(n)
+		e.writeSmallBignum(1, uint64(-n))
+	default:
+		e.writeSmallBignum(0, uint64(n))
+	}
+}
+
+// writeUint64 writes n as writeNumber does, without going through a
+// big.Int or allocating.
+func (e *Encoder) writeUint64(n uint64) {
+	if n <= math.MaxInt32 {
+		e.writeInt64(int64(n))
+		return
+	}
+	e.writeSmallBignum(0, n)
+}
+
+// writeSmallBignum writes a SMALL_BIG_EXT of the given sign and
+// magnitude.
+func (e *Encoder) writeSmallBignum(sign byte, n uint64) {
+	size := (bits.Len64(n) + 7) / 8
+	b := e.scratch[:3+size]
+	b[0] = SmallBignumTag
+	b[1] = byte(size)
+	b[2] = sign
+	for i := 0; i < size; i++ {
+		b[3+i] = byte(n >> (8 * i))
+	}
+	e.w.Write(b)
+}
+
+func writeFloat(w io.Writer, f float64) {
 	write1(w, FloatTag)
 
-	s := fmt.Sprintf("%.20e", float32(f))
+	s := fmt.Sprintf("%.20e", f)
 	w.Write([]byte(s))
 
 	pad := make([]byte, 31-len(s))
 	w.Write(pad)
 }
 
-func writeAtom(w io.Writer, a string) {
-	write1(w, AtomTag)
-	write2(w, uint16(len(a)))
-	w.Write([]byte(a))
+// writeFloat writes f, which may be NaN or infinite, as selected by
+// e.SpecialFloats, and otherwise as FLOAT_EXT, or NEW_FLOAT_EXT if
+// newFloat is set.
+func (e *Encoder) writeFloat(f float64, newFloat bool) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		if e.SpecialFloats != SpecialFloatsAsAtoms {
+			return fmt.Errorf("%v is not representable as an Erlang float", f)
+		}
+		switch {
+		case math.IsNaN(f):
+			return e.writeAtom(NaNAtom)
+		case f > 0:
+			return e.writeAtom(InfinityAtom)
+		default:
+			return e.writeAtom(NegInfinityAtom)
+		}
+	}
+	if newFloat {
+		writeNewFloat(e.w, f)
+	} else {
+		writeFloat(e.w, f)
+	}
+	return nil
+}
+
+func writeNewFloat(w io.Writer, f float64) {
+	write1(w, NewFloatTag)
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	w.Write(b)
+}
+
+// MaxAtomLength is the maximum number of characters in an atom. Erlang
+// rejects longer atoms, so they cannot be encoded.
+const MaxAtomLength = 255
+
+func (e *Encoder) writeAtom(a Atom) error {
+	if e.dedupe != nil {
+		return e.writeCachedAtom(a)
+	}
+	if !utf8.ValidString(string(a)) {
+		return fmt.Errorf("invalid UTF-8 in atom %q", string(a))
+	}
+	if n := utf8.RuneCountInString(string(a)); n > MaxAtomLength {
+		return fmt.Errorf("atom of %d characters exceeds the limit of %d", n, MaxAtomLength)
+	}
+	if e.Atoms == AtomUTF8 {
+		if len(a) <= 255 {
+			write1(e.w, SmallAtomUTF8Tag)
+			write1(e.w, uint8(len(a)))
+		} else {
+			write1(e.w, AtomUTF8Tag)
+			write2(e.w, uint16(len(a)))
+		}
+		io.WriteString(e.w, string(a))
+		return nil
+	}
+
+	b := make([]byte, 0, len(a))
+	for _, r := range string(a) {
+		if r > 255 {
+			return fmt.Errorf("atom %q is not representable in Latin-1", string(a))
+		}
+		b = append(b, byte(r))
+	}
+	write1(e.w, AtomTag)
+	write2(e.w, uint16(len(b)))
+	e.w.Write(b)
+	return nil
+}
+
+// An EncodeError describes a value that could not be encoded. Path locates
+// the value within the one passed to Encode, using Go syntax for field
+// selectors and indexes, e.g. ".Events[2]".
+type EncodeError struct {
+	Type reflect.Type
+	Path string
+	Err  error
 }
 
-func writeSmallTuple(w io.Writer, t reflect.Value) (err error) {
-	write1(w, SmallTupleTag)
+func (e *EncodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("cannot encode %v: %v", e.Type, e.Err)
+	}
+	return fmt.Sprintf("cannot encode %v at %s: %v", e.Type, e.Path, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// encodeError wraps err, returned while encoding val, in an EncodeError
+// unless it already is one.
+func encodeError(val reflect.Value, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*EncodeError); ok {
+		return err
+	}
+	var t reflect.Type
+	if val.IsValid() {
+		t = val.Type()
+	}
+	return &EncodeError{Type: t, Err: err}
+}
+
+// withPath prefixes the path of an EncodeError returned for a nested value
+// with the selector or index that leads to it.
+func withPath(err error, elem string) error {
+	if e, ok := err.(*EncodeError); ok {
+		e.Path = elem + e.Path
+	}
+	return err
+}
+
+// writeTupleHeader writes the tag and arity of a tuple of size elements,
+// as a SMALL_TUPLE_EXT or, for more than 255, a LARGE_TUPLE_EXT.
+func (e *Encoder) writeTupleHeader(size int) {
+	if size > math.MaxUint8 {
+		write1(e.w, LargeTupleTag)
+		write4(e.w, uint32(size))
+	} else {
+		write1(e.w, SmallTupleTag)
+		write1(e.w, uint8(size))
+	}
+}
+
+func (e *Encoder) writeSmallTuple(t reflect.Value) (err error) {
 	size := t.Len()
-	write1(w, uint8(size))
+	e.writeTupleHeader(size)
 
 	for i := 0; i < size; i++ {
-		err = writeTag(w, t.Index(i))
+		err = e.writeTag(t.Index(i))
 		if err != nil {
+			err = withPath(err, fmt.Sprintf("[%d]", i))
 			break
 		}
 	}
 	return
 }
 
+func (e *Encoder) writeStruct(v reflect.Value) (err error) {
+	fields := structFields(v.Type())
+	if tag, ok := recordTag(v.Type()); ok {
+		e.writeTupleHeader(len(fields) + 1)
+		if err := e.writeAtom(tag); err != nil {
+			return encodeError(v, err)
+		}
+	} else {
+		e.writeTupleHeader(len(fields))
+	}
+
+	for _, f := range fields {
+		err = e.writeField(fieldByIndex(v, f.index), f)
+		if err != nil {
+			err = withPath(err, "."+v.Type().FieldByIndex(f.index).Name)
+			break
+		}
+	}
+	return
+}
+
+// writeStructMap writes v as a map keyed by the atom names of its fields.
+func (e *Encoder) writeStructMap(v reflect.Value) error {
+	fields := structFields(v.Type())
+	values := make([]reflect.Value, 0, len(fields))
+	kept := fields[:0:0]
+	for _, f := range fields {
+		fv := fieldByIndex(v, f.index)
+		if e.Nil == NilOmitted && isNil(fv) {
+			continue
+		}
+		kept = append(kept, f)
+		values = append(values, fv)
+	}
+
+	if e.Canonical {
+		entries := make([]mapEntry, len(kept))
+		for i, f := range kept {
+			key, err := e.encodeWith(func(sub *Encoder) error { return sub.writeAtom(Atom(f.name)) })
+			if err != nil {
+				return withPath(encodeError(v, err), "."+v.Type().FieldByIndex(f.index).Name)
+			}
+			value, err := e.encodeWith(func(sub *Encoder) error { return sub.writeField(values[i], f) })
+			if err != nil {
+				return withPath(err, "."+v.Type().FieldByIndex(f.index).Name)
+			}
+			entries[i] = mapEntry{key, value}
+		}
+		e.writeSortedMap(entries)
+		return nil
+	}
+
+	write1(e.w, MapTag)
+	write4(e.w, uint32(len(kept)))
+	for i, f := range kept {
+		if err := e.writeAtom(Atom(f.name)); err != nil {
+			return withPath(encodeError(v, err), "."+v.Type().FieldByIndex(f.index).Name)
+		}
+		if err := e.writeField(values[i], f); err != nil {
+			return withPath(err, "."+v.Type().FieldByIndex(f.index).Name)
+		}
+	}
+	return nil
+}
+
 func writeBinary(w io.Writer, a []byte) {
 	write1(w, BinTag)
 	size := len(a)
@@ -99,102 +529,368 @@ func writeBinary(w io.Writer, a []byte) {
 	w.Write(a)
 }
 
-func writeBitstring(w io.Writer, a []byte, bits uint8) {
+// writeBitstring writes b as a BIT_BINARY_EXT or, if it holds a whole
+// number of bytes and keep is not set, as a binary.
+func writeBitstring(w io.Writer, b Bitstring, keep bool) {
+	data := b.data()
+	if len(data) == 0 || b.Bits%8 == 0 && !keep {
+		writeBinary(w, data)
+		return
+	}
 	write1(w, BitTag)
-	size := (int(bits) + 7) / 8
-	write4(w, uint32(size))
-	write1(w, bits%8)
+	write4(w, uint32(len(data)))
+	write1(w, uint8(trailingBits(int(b.Bits))))
+	w.Write(data)
+}
+
+func writeNil(w io.Writer) { write1(w, NilTag) }
 
-	for len(a) < size {
-		a = append([]byte{0}, a...)
+// isNil reports whether v is a nil pointer or interface, or the invalid
+// value produced by indirecting one.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
 	}
-	w.Write(a[:size])
+	return false
 }
 
-func writeNil(w io.Writer) { write1(w, NilTag) }
+func (e *Encoder) writeNil() error {
+	switch e.Nil {
+	case NilAsAtom:
+		return e.writeAtom(NilAtom)
+	case NilAsUndefined:
+		return e.writeAtom(UndefinedAtom)
+	case NilRejected:
+		return ErrNilValue
+	}
+	writeNil(e.w)
+	return nil
+}
 
-func writeString(w io.Writer, s string) {
+// writeLatin1 writes the Latin-1 text s, of at most 65535 bytes, as
+// STRING_EXT.
+func writeLatin1(w io.Writer, s string) {
 	write1(w, StringTag)
 	write2(w, uint16(len(s)))
-	w.Write([]byte(s))
+	io.WriteString(w, s)
 }
 
-func writeList(w io.Writer, l reflect.Value) (err error) {
-	write1(w, ListTag)
+// writeString writes s as STRING_EXT or, if it is too long for that, as
+// selected by e.Strings.
+func (e *Encoder) writeString(s string) {
+	if e.Strings == StringAsBinary {
+		writeBinary(e.w, []byte(s))
+		return
+	}
+	if len(s) <= math.MaxUint16 {
+		writeLatin1(e.w, s)
+		return
+	}
+
+	if e.Strings == LongStringAsBinary {
+		writeBinary(e.w, []byte(s))
+		return
+	}
+	write1(e.w, ListTag)
+	write4(e.w, uint32(len(s)))
+	for i := 0; i < len(s); i++ {
+		write1(e.w, SmallIntTag)
+		write1(e.w, s[i])
+	}
+	writeNil(e.w)
+}
+
+// writeList writes the elements of l as a list ending with tail, or with
+// NIL if tail is nil. An empty proper list is written as NIL.
+func (e *Encoder) writeList(l reflect.Value, tail Term) (err error) {
 	size := l.Len()
-	write4(w, uint32(size))
+	if size == 0 && tail == nil {
+		writeNil(e.w)
+		return nil
+	}
+	if e.Canonical && tail == nil {
+		if s, ok := charlist(l); ok {
+			writeLatin1(e.w, s)
+			return nil
+		}
+	}
+	write1(e.w, ListTag)
+	write4(e.w, uint32(size))
 
 	for i := 0; i < size; i++ {
-		err = writeTag(w, l.Index(i))
+		err = e.writeTag(l.Index(i))
 		if err != nil {
-			break
+			return withPath(err, fmt.Sprintf("[%d]", i))
 		}
 	}
 
-	writeNil(w)
-	return
+	if tail == nil {
+		writeNil(e.w)
+		return nil
+	}
+	return withPath(e.writeTag(reflect.ValueOf(tail)), ".Tail")
 }
 
-func writeTag(w io.Writer, val reflect.Value) (err error) {
+// charlist returns the elements of l as a string if they are all integers
+// from 0 to 255, of builtin types, and few enough for STRING_EXT, which
+// Erlang writes such lists as.
+func charlist(l reflect.Value) (string, bool) {
+	if l.Len() > math.MaxUint16 {
+		return "", false
+	}
+	b := make([]byte, l.Len())
+	for i := range b {
+		v := l.Index(i)
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		if !v.IsValid() || v.Type().PkgPath() != "" {
+			return "", false
+		}
+		var n uint64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.Int() < 0 {
+				return "", false
+			}
+			n = uint64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = v.Uint()
+		default:
+			return "", false
+		}
+		if n > 255 {
+			return "", false
+		}
+		b[i] = byte(n)
+	}
+	return string(b), true
+}
+
+// writeField writes the value of struct field f, honoring its tag options.
+func (e *Encoder) writeField(v reflect.Value, f field) error {
+	if f.opts.Contains("map") {
+		if sv := reflect.Indirect(v); sv.Kind() == reflect.Struct && !isTermStruct(sv.Type()) {
+			return e.writeStructMap(sv)
+		}
+	}
+	if unit, ok := tagUnit(f.opts); ok && reflect.Indirect(v).IsValid() {
+		switch x := reflect.Indirect(v).Interface().(type) {
+		case time.Time:
+			e.writeTime(x, unit)
+			return nil
+		case time.Duration:
+			e.writeDuration(x, unit)
+			return nil
+		}
+	}
+	return e.writeTag(v)
+}
+
+// mapEntry is a map key and value encoded ahead of time so that entries can
+// be written in a deterministic order.
+type mapEntry struct {
+	key, value []byte
+}
+
+func (e *Encoder) encodeValue(v reflect.Value) ([]byte, error) {
+	return e.encodeWith(func(sub *Encoder) error { return sub.writeTag(v) })
+}
+
+// encodeWith returns what fn writes with a copy of e.
+func (e *Encoder) encodeWith(fn func(*Encoder) error) ([]byte, error) {
+	var buf bytes.Buffer
+	sub := *e
+	sub.w = &buf
+	err := fn(&sub)
+	return buf.Bytes(), err
+}
+
+// compress reports whether terms are compressed from CompressThreshold.
+func (e *Encoder) compress() bool {
+	return e.CompressThreshold > 0 && !e.Canonical
+}
+
+// writeMap writes m as a MAP_EXT with its entries sorted by their encoded
+// keys, so that equal maps always produce the same bytes.
+func (e *Encoder) writeMap(m reflect.Value) error {
+	entries := make([]mapEntry, 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		k, v := iter.Key(), iter.Value()
+		if e.Nil == NilOmitted && isNil(v) {
+			continue
+		}
+
+		key, err := e.encodeValue(k)
+		if err != nil {
+			return withPath(err, fmt.Sprintf("[%#v]", k.Interface()))
+		}
+		value, err := e.encodeValue(v)
+		if err != nil {
+			return withPath(err, fmt.Sprintf("[%#v]", k.Interface()))
+		}
+		entries = append(entries, mapEntry{key, value})
+	}
+	e.writeSortedMap(entries)
+	return nil
+}
+
+// writeSortedMap writes a MAP_EXT of entries sorted by their keys.
+func (e *Encoder) writeSortedMap(entries []mapEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	write1(e.w, MapTag)
+	write4(e.w, uint32(len(entries)))
+	for _, entry := range entries {
+		e.w.Write(entry.key)
+		e.w.Write(entry.value)
+	}
+}
+
+func (e *Encoder) writeTag(val reflect.Value) error {
+	if e.dedupe != nil {
+		if key, ok := subtermOf(val); ok {
+			return e.writeShared(key, val)
+		}
+	}
+	return e.writeValue(val)
+}
+
+// writeValue writes val, without looking it up in the dedupe cache.
+func (e *Encoder) writeValue(val reflect.Value) (err error) {
 	val = reflect.Indirect(val)
+	if val.IsValid() && val.CanInterface() {
+		if fn := encoderFor(val.Type()); fn != nil {
+			term, err := fn(val.Interface())
+			if err != nil {
+				return encodeError(val, err)
+			}
+			return e.writeTag(reflect.ValueOf(term))
+		}
+	}
+	// only Options, and structs embedding them, are optional, and other
+	// values, such as the integers of slice elements, are not copied out
+	if val.Kind() == reflect.Struct {
+		if o, ok := valueInterface(val).(optional); ok {
+			x, ok := o.optionTerm()
+			if !ok {
+				return e.writeAtom(UndefinedAtom)
+			}
+			return e.writeTag(reflect.ValueOf(x))
+		}
+	}
+	if a, ok := atomFor(val); ok {
+		return encodeError(val, e.writeAtom(a))
+	}
+
+	if val.IsValid() && val.Type() == durationType {
+		e.writeDuration(time.Duration(val.Int()), 0)
+		return
+	}
+
 	switch v := val; v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		n := v.Int()
-		writeNumber(w, *big.NewInt(n))
+		e.writeInt64(v.Int())
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		n := v.Uint()
-		var bn big.Int
-		bn.SetUint64(n)
-		writeNumber(w, bn)
+		e.writeUint64(v.Uint())
 	case reflect.Float32, reflect.Float64:
-		writeFloat(w, float32(v.Float()))
+		err = encodeError(v, e.writeFloat(v.Float(), false))
 	case reflect.String:
-		if v.Type().Name() == "Atom" {
-			writeAtom(w, v.String())
+		if isAtomType(v.Type()) {
+			err = encodeError(v, e.writeAtom(Atom(v.String())))
+		} else if v.Type() == binaryType {
+			writeBinary(e.w, []byte(v.String()))
+		} else if v.Type() == latin1StringType {
+			writeLatin1(e.w, v.String())
+		} else if v.Type() == jsonNumberType {
+			err = encodeError(v, e.writeJSONNumber(json.Number(v.String())))
 		} else {
-			writeString(w, v.String())
+			e.writeString(v.String())
 		}
 	case reflect.Slice:
-		if b, ok := v.Interface().([]byte); ok {
-			writeBinary(w, b)
+		if raw, ok := v.Interface().(RawTerm); ok {
+			err = encodeError(v, e.writeRaw(raw))
+		} else if b, ok := v.Interface().([]byte); ok {
+			writeBinary(e.w, b)
+		} else if kvs, ok := v.Interface().([]KV); ok {
+			err = e.writeTermMap(kvs)
 		} else {
-			err = writeSmallTuple(w, v)
+			err = e.writeSmallTuple(v)
 		}
 
 	case reflect.Array:
-		err = writeList(w, v)
+		err = e.writeList(v, nil)
 	case reflect.Interface:
-		err = writeTag(w, v.Elem())
+		err = e.writeTag(v.Elem())
+	case reflect.Map:
+		err = e.writeMap(v)
 	case reflect.Struct:
 		if b, ok := v.Interface().(Bitstring); ok {
-			if b.Bits%8 != 0 {
-				writeBitstring(w, b.Bytes, b.Bits)
+			writeBitstring(e.w, b, e.KeepBitstrings)
+		} else if l, ok := v.Interface().(List); ok {
+			err = e.writeList(reflect.ValueOf(l.Items), l.Tail)
+		} else if x, ok := v.Interface().(Encoded); ok {
+			err = encodeError(v, e.writeEncoded(x))
+		} else if x, ok := v.Interface().(Verbatim); ok {
+			if e.Canonical {
+				err = e.writeTag(reflect.ValueOf(x.Term))
 			} else {
-				writeBinary(w, b.Bytes[0:b.Bits/8])
+				_, err = e.w.Write(x.Raw)
 			}
-		} else if l, ok := v.Interface().(List); ok {
-			err = writeList(w, reflect.ValueOf(l.Items))
+		} else if m, ok := v.Interface().(TermMap); ok {
+			err = e.writeTermMap(m.entries)
+		} else if l, ok := v.Interface().(IOList); ok {
+			err = encodeError(v, writeIOList(e.w, l))
 		} else if bn, ok := v.Interface().(big.Int); ok {
-			writeNumber(w, bn)
+			writeNumber(e.w, bn)
+		} else if bf, ok := v.Interface().(big.Float); ok {
+			err = encodeError(v, e.writeBigFloat(&bf))
+		} else if t, ok := v.Interface().(time.Time); ok {
+			e.writeTime(t, 0)
+		} else if _, record := recordTag(v.Type()); e.StructMaps && !record {
+			err = e.writeStructMap(v)
 		} else {
-			err = ErrUnknownType
+			err = e.writeStruct(v)
 		}
 	default:
 		if !reflect.Indirect(val).IsValid() {
-			writeNil(w)
+			err = encodeError(v, e.writeNil())
 		} else {
-			err = ErrUnknownType
+			err = encodeError(v, ErrUnknownType)
 		}
 	}
 
 	return
 }
 
+// Encode writes the encoding of val to the encoder's output, returning any
+// error.
+func (e *Encoder) Encode(val interface{}) error {
+	if e.Dedupe && e.dedupe == nil {
+		e.dedupe = newDedupeCache()
+		defer func() { e.dedupe = nil }()
+	}
+	if e.Observe != nil {
+		return e.encodeObserved(val)
+	}
+	if e.compress() {
+		return e.encodeCompressed(val)
+	}
+	if !e.NoVersion {
+		write1(e.w, VersionTag)
+	}
+	return e.writeTag(reflect.ValueOf(val))
+}
+
 // EncodeTo encodes val and writes it to w, returning any error.
 func EncodeTo(w io.Writer, val interface{}) (err error) {
-	write1(w, VersionTag)
-	err = writeTag(w, reflect.ValueOf(val))
-	return
+	return NewEncoder(w).Encode(val)
 }
 
 // Encode encodes val and returns it or an error.
@@ -204,6 +900,16 @@ func Encode(val interface{}) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+// EncodeNoVersion encodes val without the leading version tag and returns
+// it or an error.
+func EncodeNoVersion(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.NoVersion = true
+	err := e.Encode(val)
+	return buf.Bytes(), err
+}
+
 // Marshal is an alias for EncodeTo.
 func Marshal(w io.Writer, val interface{}) error {
 	return EncodeTo(w, val)
@@ -2,8 +2,14 @@ package bert
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -31,6 +37,10 @@ func TestEncode(t *testing.T) {
 	assertEncode(t, n, []byte{131, 110, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
 	n.SetString("18446744073709551615", 10)
 	assertEncode(t, n, []byte{131, 110, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255})
+	n.Lsh(big.NewInt(1), 2048)
+	large, _ := Encode(n)
+	assertEqual(t, []byte{131, 111, 0, 0, 1, 1, 0}, large[:7])
+	assertEqual(t, 264, len(large))
 	n.SetString("5000", 10)
 	assertEncode(t, n, []byte{131, 98, 0, 0, 19, 136})
 
@@ -39,11 +49,19 @@ func TestEncode(t *testing.T) {
 		48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 101,
 		45, 48, 49, 0, 0, 0, 0, 0,
 	})
-	assertEncode(t, 3.14159, []byte{131, 99, 51, 46, 49, 52, 49, 53, 57,
-		48, 49, 49, 56, 52, 48, 56, 50, 48, 51, 49, 50, 53, 48, 48,
+	assertEncode(t, 3.14159, []byte{131, 99, 51, 46, 49, 52, 49, 53, 56,
+		57, 57, 57, 57, 57, 57, 57, 57, 57, 57, 56, 56, 50, 54, 50,
 		101, 43, 48, 48, 0, 0, 0, 0, 0,
 	})
 	assertEncode(t, -3.14159, []byte{131, 99, 45, 51, 46, 49, 52, 49, 53,
+		56, 57, 57, 57, 57, 57, 57, 57, 57, 57, 57, 56, 56, 50, 54,
+		50, 101, 43, 48, 48, 0, 0, 0, 0,
+	})
+	assertEncode(t, float32(3.14159), []byte{131, 99, 51, 46, 49, 52, 49, 53, 57,
+		48, 49, 49, 56, 52, 48, 56, 50, 48, 51, 49, 50, 53, 48, 48,
+		101, 43, 48, 48, 0, 0, 0, 0, 0,
+	})
+	assertEncode(t, float32(-3.14159), []byte{131, 99, 45, 51, 46, 49, 52, 49, 53,
 		57, 48, 49, 49, 56, 52, 48, 56, 50, 48, 51, 49, 50, 53, 48,
 		48, 101, 43, 48, 48, 0, 0, 0, 0,
 	})
@@ -116,6 +134,506 @@ func TestEncode(t *testing.T) {
 	assertEncode(t, -big, []byte{131, 110, 5, 1, 0, 232, 118, 72, 23})
 }
 
+func TestEncodeStruct(t *testing.T) {
+	type point struct {
+		X, Y   int
+		hidden int
+		Label  Atom
+	}
+	assertEncode(t, point{1, 2, 3, Atom("a")}, []byte{131, 104, 3,
+		97, 1, 97, 2, 100, 0, 1, 97,
+	})
+	assertEncode(t, &point{X: 1}, []byte{131, 104, 3,
+		97, 1, 97, 0, 100, 0, 0,
+	})
+}
+
+func TestEncodeLargeStruct(t *testing.T) {
+	// Structs of more than 255 fields encode as LARGE_TUPLE_EXT.
+	fields := make([]reflect.StructField, 256)
+	for i := range fields {
+		fields[i] = reflect.StructField{Name: fmt.Sprintf("F%d", i), Type: reflect.TypeOf(0)}
+	}
+	v := reflect.New(reflect.StructOf(fields)).Elem()
+	v.Field(255).SetInt(7)
+	data, err := Encode(v.Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 105, 0, 0, 1, 0}, data[:6])
+	term, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuple := term.([]Term)
+	assertEqual(t, 256, len(tuple))
+	assertEqual(t, int64(7), tuple[255])
+}
+
+func TestEncodeError(t *testing.T) {
+	type event struct {
+		Name    Atom
+		Handler func()
+	}
+	type batch struct {
+		Events [2]event
+	}
+
+	_, err := Encode(batch{})
+	assertNotEncode(t, batch{}, "cannot encode func() at .Events[0].Handler: unknown type")
+
+	e, ok := err.(*EncodeError)
+	if !ok {
+		t.Fatalf("expected *EncodeError, got %T", err)
+	}
+	assertEqual(t, reflect.TypeOf(func() {}), e.Type)
+	assertEqual(t, ".Events[0].Handler", e.Path)
+	if !errors.Is(err, ErrUnknownType) {
+		t.Errorf("expected error to wrap ErrUnknownType")
+	}
+
+	assertNotEncode(t, []Term{1, make(chan int)}, "cannot encode chan int at [1]: unknown type")
+}
+
+func TestEncodeMap(t *testing.T) {
+	assertEncode(t, map[Atom]int{"b": 2, "a": 1}, []byte{131, 116, 0, 0, 0, 2,
+		100, 0, 1, 97, 97, 1,
+		100, 0, 1, 98, 97, 2,
+	})
+	assertEncode(t, map[string]Term{}, []byte{131, 116, 0, 0, 0, 0})
+	assertNotEncode(t, map[Atom]Term{"f": func() {}}, `cannot encode func() at ["f"]: unknown type`)
+}
+
+func TestEncodeStructMap(t *testing.T) {
+	type options struct {
+		Timeout int `bert:"timeout"`
+		Retry   *int
+	}
+	type request struct {
+		ID   int
+		Opts options `bert:"opts,map"`
+	}
+
+	assertEncode(t, request{1, options{Timeout: 5}}, []byte{131, 104, 2,
+		97, 1,
+		116, 0, 0, 0, 2,
+		100, 0, 7, 116, 105, 109, 101, 111, 117, 116, 97, 5,
+		100, 0, 5, 82, 101, 116, 114, 121, 106,
+	})
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.StructMaps = true
+	enc.Nil = NilOmitted
+	if err := enc.Encode(request{1, options{Timeout: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 116, 0, 0, 0, 2,
+		100, 0, 2, 73, 68, 97, 1,
+		100, 0, 4, 111, 112, 116, 115,
+		116, 0, 0, 0, 1,
+		100, 0, 7, 116, 105, 109, 101, 111, 117, 116, 97, 5,
+	}, buf.Bytes())
+
+	var decoded request
+	if err := Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, request{1, options{Timeout: 5}}, decoded)
+}
+
+func TestEncoderNilPolicy(t *testing.T) {
+	var p *int
+	value := struct {
+		Ptr   *int
+		Iface Term
+	}{}
+	m := map[Atom]Term{"a": nil, "b": 1}
+
+	tests := []struct {
+		policy NilPolicy
+		val    interface{}
+		want   []byte
+	}{
+		{NilAsList, p, []byte{131, 106}},
+		{NilAsList, value, []byte{131, 104, 2, 106, 106}},
+		{NilAsAtom, value, []byte{131, 104, 2,
+			100, 0, 3, 110, 105, 108,
+			100, 0, 3, 110, 105, 108,
+		}},
+		{NilAsUndefined, []Term{nil}, []byte{131, 104, 1,
+			100, 0, 9, 117, 110, 100, 101, 102, 105, 110, 101, 100,
+		}},
+		{NilAsList, m, []byte{131, 116, 0, 0, 0, 2,
+			100, 0, 1, 97, 106,
+			100, 0, 1, 98, 97, 1,
+		}},
+		{NilOmitted, m, []byte{131, 116, 0, 0, 0, 1, 100, 0, 1, 98, 97, 1}},
+		{NilOmitted, value, []byte{131, 104, 2, 106, 106}},
+		{NilAsAtom, List{Items: []Term{p, nil}}, []byte{131, 108, 0, 0, 0, 2,
+			100, 0, 3, 110, 105, 108,
+			100, 0, 3, 110, 105, 108,
+			106,
+		}},
+		{NilAsAtom, NewTermMap(KV{nil, p}), []byte{131, 116, 0, 0, 0, 1,
+			100, 0, 3, 110, 105, 108,
+			100, 0, 3, 110, 105, 108,
+		}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Nil = test.policy
+		if err := enc.Encode(test.val); err != nil {
+			t.Errorf("Encode(%v) with policy %d returned error '%v'", test.val, test.policy, err)
+			continue
+		}
+		if !reflect.DeepEqual(buf.Bytes(), test.want) {
+			t.Errorf("Encode(%v) with policy %d = %v, but expected %v", test.val, test.policy, buf.Bytes(), test.want)
+		}
+	}
+}
+
+func TestEncodeNilRejected(t *testing.T) {
+	var p *int
+	tests := []struct {
+		val  interface{}
+		want string
+	}{
+		{nil, "cannot encode <nil>: nil value"},
+		{[]Term{1, p}, "cannot encode <nil> at [1]: nil value"},
+		{List{Items: []Term{nil}}, "cannot encode <nil> at [0]: nil value"},
+		{map[string]Term{"a": nil}, `cannot encode <nil> at ["a"]: nil value`},
+		{struct{ Ptr *int }{}, "cannot encode <nil> at .Ptr: nil value"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Nil = NilRejected
+		err := enc.Encode(test.val)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("Encode(%v) returned error %v, expected %s", test.val, err, test.want)
+		}
+		if !errors.Is(err, ErrNilValue) {
+			t.Errorf("Encode(%v) returned error %v, expected ErrNilValue", test.val, err)
+		}
+	}
+}
+
+func TestEncodeAtoms(t *testing.T) {
+	tests := []struct {
+		encoding AtomEncoding
+		val      Atom
+		want     []byte
+	}{
+		{AtomLatin1, Atom("ok"), []byte{131, 100, 0, 2, 111, 107}},
+		{AtomLatin1, Atom("café"), []byte{131, 100, 0, 4, 99, 97, 102, 233}},
+		{AtomUTF8, Atom("café"), []byte{131, 119, 5, 99, 97, 102, 195, 169}},
+		{AtomUTF8, Atom("€"), []byte{131, 119, 3, 226, 130, 172}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Atoms = test.encoding
+		if err := enc.Encode(test.val); err != nil {
+			t.Errorf("Encode(%v) with encoding %d returned error '%v'", test.val, test.encoding, err)
+			continue
+		}
+		if !reflect.DeepEqual(buf.Bytes(), test.want) {
+			t.Errorf("Encode(%v) with encoding %d = %v, but expected %v", test.val, test.encoding, buf.Bytes(), test.want)
+		}
+		assertDecode(t, test.want, test.val)
+	}
+
+	assertNotEncode(t, Atom("€"), `cannot encode bert.Atom: atom "€" is not representable in Latin-1`)
+	assertNotEncode(t, Atom("\xff"), `cannot encode bert.Atom: invalid UTF-8 in atom "\xff"`)
+	assertNotEncode(t, Atom(strings.Repeat("a", 256)), "cannot encode bert.Atom: atom of 256 characters exceeds the limit of 255")
+	assertNotEncode(t, []Term{1, Atom(strings.Repeat("é", 300))}, "cannot encode bert.Atom at [1]: atom of 300 characters exceeds the limit of 255")
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Atoms = AtomUTF8
+	if err := enc.Encode(Atom(strings.Repeat("é", 255))); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 118, 1, 254}, buf.Bytes()[:4])
+
+	assertDecode(t, []byte{131, 115, 2, 111, 107}, Atom("ok"))
+	assertDecode(t, []byte{131, 118, 0, 3, 226, 130, 172}, Atom("€"))
+}
+
+func TestEncodeLongString(t *testing.T) {
+	s := strings.Repeat("a", 65536)
+
+	want := []byte{131, 108, 0, 1, 0, 0}
+	for i := 0; i < len(s); i++ {
+		want = append(want, 97, 'a')
+	}
+	want = append(want, 106)
+	assertEncode(t, s, want)
+	var back string
+	if err := Unmarshal(want, &back); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, s, back)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Strings = LongStringAsBinary
+	if err := enc.Encode(s); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, append([]byte{131, 109, 0, 1, 0, 0}, s...), buf.Bytes())
+
+	buf.Reset()
+	enc.Encode("abc")
+	assertEqual(t, []byte{131, 107, 0, 3, 97, 98, 99}, buf.Bytes())
+}
+
+func TestEncodeBinary(t *testing.T) {
+	assertEncode(t, Binary("abc"), []byte{131, 109, 0, 0, 0, 3, 97, 98, 99})
+	assertEncode(t, []Term{Binary(""), "abc"}, []byte{131, 104, 2,
+		109, 0, 0, 0, 0,
+		107, 0, 3, 97, 98, 99,
+	})
+
+	var b Binary
+	if err := Unmarshal([]byte{131, 109, 0, 0, 0, 3, 97, 98, 99}, &b); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Binary("abc"), b)
+	assertEqual(t, BinaryKind, KindOf(b))
+	assertEqual(t, true, Equal(b, []byte("abc")))
+}
+
+func TestEncodeDecimals(t *testing.T) {
+	assertEncode(t, json.Number("42"), []byte{131, 97, 42})
+	assertEncode(t, json.Number("-123456789012345678901234567890"), []byte{131, 110, 13, 1,
+		210, 10, 63, 78, 238, 224, 115, 195, 246, 15, 233, 142, 1,
+	})
+	assertEncode(t, big.NewFloat(0.5), []byte{131, 70, 63, 224, 0, 0, 0, 0, 0, 0})
+	assertNotEncode(t, json.Number("x"), `cannot encode json.Number: invalid number "x"`)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.BigFloats = BigFloatAsDecimal
+	err := enc.Encode([]Term{json.Number("1.50"), new(big.Float).SetFloat64(-0.125), json.Number("7")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	term, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{
+		[]Term{DecimalAtom, int64(150), int64(-2)},
+		[]Term{DecimalAtom, int64(-125), int64(-3)},
+		int64(7),
+	}, term)
+
+	var v struct {
+		Price  json.Number
+		Amount big.Float
+		Rate   json.Number
+	}
+	if err := Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, json.Number("150e-2"), v.Price)
+	if f, _ := v.Amount.Float64(); f != -0.125 {
+		t.Errorf("expected -0.125, got %v", f)
+	}
+	assertEqual(t, json.Number("7"), v.Rate)
+
+	if _, err := Encode(new(big.Float).SetInf(false)); err == nil {
+		t.Errorf("expected an error encoding an infinite big.Float")
+	}
+}
+
+func TestEncodeSpecialFloats(t *testing.T) {
+	assertNotEncode(t, math.NaN(), "cannot encode float64: NaN is not representable as an Erlang float")
+	assertNotEncode(t, []Term{float32(math.Inf(-1))}, "cannot encode float32 at [0]: -Inf is not representable as an Erlang float")
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SpecialFloats = SpecialFloatsAsAtoms
+	err := enc.Encode([]Term{math.NaN(), math.Inf(1), new(big.Float).SetInf(true), 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	term, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{NaNAtom, InfinityAtom, NegInfinityAtom, 1.5}, term)
+
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	d.SpecialFloats = SpecialFloatsAsAtoms
+	term, err = d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	floats := term.([]Term)
+	assertEqual(t, true, math.IsNaN(floats[0].(float64)))
+	assertEqual(t, []Term{math.Inf(1), math.Inf(-1), 1.5}, floats[1:])
+}
+
+func TestEncodeKeepBitstrings(t *testing.T) {
+	data := []byte{131, 77, 0, 0, 0, 2, 8, 1, 2}
+	term, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Bitstring{[]byte{1, 2}, 8}, term)
+	assertEncode(t, term, []byte{131, 109, 0, 0, 0, 2, 1, 2})
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.KeepBitstrings = true
+	if err := enc.Encode(term); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, data, buf.Bytes())
+
+	buf.Reset()
+	enc.Encode([]Term{Bitstring{}, Bitstring{[]byte{0xc0}, 2}})
+	assertEqual(t, []byte{131, 104, 2,
+		109, 0, 0, 0, 0,
+		77, 0, 0, 0, 1, 2, 0xc0,
+	}, buf.Bytes())
+}
+
+func TestEncodeStringAsBinary(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Strings = StringAsBinary
+	err := enc.Encode([]Term{"héllo", Atom("ok"), Charlist("ok")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{131, 104, 3,
+		109, 0, 0, 0, 6, 104, 195, 169, 108, 108, 111,
+		100, 0, 2, 111, 107,
+		107, 0, 2, 111, 107,
+	}
+	assertEqual(t, want, buf.Bytes())
+
+	var back struct {
+		Text string
+		Atom Atom
+		List Charlist
+	}
+	if err := Unmarshal(want, &back); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "héllo", back.Text)
+	assertEqual(t, Charlist("ok"), back.List)
+}
+
+func TestNoVersion(t *testing.T) {
+	data, err := EncodeNoVersion([]Term{Atom("ok"), 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{104, 2, 100, 0, 2, 111, 107, 97, 1}, data)
+	term, err := DecodeNoVersion(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("ok"), int64(1)}, term)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.NoVersion = true
+	enc.CompressThreshold = 1
+	big := bytes.Repeat([]byte{1}, 100)
+	enc.Encode(big)
+	enc.Encode(1)
+	assertEqual(t, byte(CompressedTag), buf.Bytes()[0])
+
+	d := NewDecoder(&buf)
+	d.NoVersion = true
+	for _, want := range []Term{big, int64(1)} {
+		term, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, want, term)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("expected EOF after the last term, got %v", err)
+	}
+}
+
+func TestEncodeDedupe(t *testing.T) {
+	config := map[string]Term{"retries": 3, "hosts": []Term{"a", "b"}}
+	block := &recordUser{Name: "ann", Age: 30}
+	term := []Term{
+		config, config, config,
+		block, block,
+		[]Term{Atom("ok"), Atom("ok"), Atom("\u00e9t\u00e9")},
+		Charlist("abc"), Charlist("abc"),
+	}
+
+	expected, err := Encode(term)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Dedupe = true
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		if err := e.Encode(term); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, expected, buf.Bytes())
+	}
+
+	// Errors are reported as without Dedupe.
+	bad := []Term{Atom("\u4e16")}
+	if err := e.Encode([]Term{bad, bad, bad}); err == nil {
+		t.Errorf("expected an error encoding an atom outside Latin-1")
+	}
+}
+
+func TestEncodeIntegers(t *testing.T) {
+	// Integers encode as their big.Int values do.
+	for _, n := range []int64{0, 1, 255, 256, -1, -256, math.MaxInt32, math.MaxInt32 + 1,
+		math.MinInt32, math.MinInt32 - 1, 1 << 40, -1 << 40, math.MaxInt64, math.MinInt64} {
+		want, _ := Encode(big.NewInt(n))
+		assertEncode(t, n, want)
+	}
+	for _, n := range []uint64{0, 255, math.MaxInt32, math.MaxInt32 + 1, math.MaxUint32, math.MaxUint64} {
+		want, _ := Encode(new(big.Int).SetUint64(n))
+		assertEncode(t, n, want)
+	}
+	assertEncode(t, int8(-3), []byte{131, 98, 255, 255, 255, 253})
+	assertEncode(t, uint16(65535), []byte{131, 98, 0, 0, 255, 255})
+
+	// They are written without allocating.
+	e := NewEncoder(io.Discard)
+	vals := []interface{}{7, int64(-5000), int64(1) << 40, uint64(math.MaxUint64), []int{1, 300, 1 << 40}}
+	for _, val := range vals {
+		if n := testing.AllocsPerRun(100, func() { e.Encode(val) }); n != 0 {
+			t.Errorf("encoding %v allocates %v times", val, n)
+		}
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	var first, second bytes.Buffer
+	e := NewEncoder(&first)
+	e.Strings = StringAsBinary
+	e.Encode("a")
+	e.Reset(&second)
+	e.Encode("b")
+	assertEqual(t, []byte{131, 109, 0, 0, 0, 1, 97}, first.Bytes())
+	assertEqual(t, []byte{131, 109, 0, 0, 0, 1, 98}, second.Bytes())
+}
+
 func TestMarshal(t *testing.T) {
 	var buf bytes.Buffer
 	Marshal(&buf, 42)
@@ -0,0 +1,76 @@
+package bert
+
+import (
+	"io"
+	"reflect"
+)
+
+// An Encoded is a term encoded once, to be written or embedded in other
+// terms any number of times without being encoded again, as suits
+// constant sub-terms sent with many messages, such as status atoms or
+// headers:
+//
+//	ok, err := bert.Preencode(bert.Atom("ok"))
+//	...
+//	err = bert.EncodeTo(w, []bert.Term{ok, result})
+//
+// An Encoded encodes as the term it holds, its bytes copied unchanged,
+// except by a Canonical Encoder, which encodes the term again. The zero
+// Encoded holds no term and encodes as nil values do. An Encoded is
+// immutable and may be used concurrently.
+type Encoded struct {
+	data []byte // with the version tag
+}
+
+// Preencode encodes val with the default options and returns it as an
+// Encoded.
+func Preencode(val interface{}) (Encoded, error) {
+	return NewEncoder(nil).Preencode(val)
+}
+
+// Preencode encodes val with the options of e and returns it as an
+// Encoded, without writing it. The term is not compressed, whatever
+// CompressThreshold, so that it can be embedded in other terms, and
+// Observe is not called.
+func (e *Encoder) Preencode(val interface{}) (Encoded, error) {
+	data, err := e.encodeWith(func(sub *Encoder) error {
+		write1(sub.w, VersionTag)
+		return sub.writeTag(reflect.ValueOf(val))
+	})
+	if err != nil {
+		return Encoded{}, err
+	}
+	return Encoded{data: data}, nil
+}
+
+// Bytes returns the encoding of the term, starting with the version tag,
+// as Encode would return it. It must not be modified.
+func (x Encoded) Bytes() []byte {
+	if x.data == nil {
+		return []byte{VersionTag, NilTag}
+	}
+	return x.data
+}
+
+// WriteTo writes the encoding of the term to w, as Bytes returns it.
+func (x Encoded) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(x.Bytes())
+	return int64(n), err
+}
+
+// Term returns the term, decoded.
+func (x Encoded) Term() (Term, error) {
+	return Decode(x.Bytes())
+}
+
+// writeEncoded writes the term held by x.
+func (e *Encoder) writeEncoded(x Encoded) error {
+	if x.data == nil {
+		return e.writeNil()
+	}
+	if e.Canonical {
+		return e.writeRaw(RawTerm(x.data))
+	}
+	_, err := e.w.Write(x.data[1:])
+	return err
+}
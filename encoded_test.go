@@ -0,0 +1,76 @@
+package bert
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestEncoded(t *testing.T) {
+	ok, err := Preencode(Atom("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 100, 0, 2, 'o', 'k'}, ok.Bytes())
+
+	var buf bytes.Buffer
+	var w io.WriterTo = ok
+	n, err := w.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(6), n)
+	assertEqual(t, ok.Bytes(), buf.Bytes())
+
+	// Embedded in other terms, the bytes are copied as they are.
+	data, err := Encode([]Term{ok, 1, &ok})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Encode([]Term{Atom("ok"), 1, Atom("ok")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, want, data)
+	term, err := ok.Term()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("ok"), term)
+
+	// The options of the encoder apply.
+	e := NewEncoder(nil)
+	e.Strings = StringAsBinary
+	s, err := e.Preencode("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 109, 0, 0, 0, 2, 'h', 'i'}, s.Bytes())
+
+	// Canonical encoders encode the term again.
+	m, err := Preencode([]KV{{Atom("b"), 2}, {Atom("a"), 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := encodeCanonical([]Term{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err = encodeCanonical([]Term{[]KV{{Atom("a"), 1}, {Atom("b"), 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, want, canonical)
+
+	var zero Encoded
+	data, err = Encode(zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 106}, data)
+	assertEqual(t, data, zero.Bytes())
+
+	if _, err := Preencode(make(chan int)); err == nil {
+		t.Error("expected an error")
+	}
+}
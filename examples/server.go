@@ -21,7 +21,7 @@ func handle(c net.Conn) {
 	var response []bert.Term
 
 	if request.Function == bert.Atom("fib") {
-		result := fib(request.Arguments[0].(int))
+		result := fib(int(request.Arguments[0].(int64)))
 		response = []bert.Term{bert.Atom("reply"), result}
 	} else {
 		msg := "function '" + request.Function + "' not found"
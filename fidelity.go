@@ -0,0 +1,66 @@
+package bert
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+)
+
+// A Verbatim is a term decoded with Decoder.Fidelity whose encoding
+// differs from the one an Encoder would write for it. It encodes as Raw,
+// the bytes it was decoded from, while Term holds its value.
+type Verbatim struct {
+	Term Term
+	Raw  []byte
+}
+
+// A recordingReader keeps the bytes read through it.
+type recordingReader struct {
+	io.Reader
+	buf []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// readVerbatim reads a term with the given tag, wrapping it in a Verbatim
+// if it would not encode as read.
+func (d *Decoder) readVerbatim(tag int) (Term, error) {
+	start := len(d.rec.buf) - 1
+	term, err := d.readBody(tag)
+	if err != nil {
+		return nil, err
+	}
+	raw := d.rec.buf[start:]
+	if canonical(tag, term, raw) {
+		return term, nil
+	}
+	return Verbatim{Term: term, Raw: append([]byte(nil), raw...)}, nil
+}
+
+// canonical reports whether raw, from which term was decoded, is what an
+// Encoder writes for term. The elements of tuples, lists and maps have
+// already been checked.
+func canonical(tag int, term Term, raw []byte) bool {
+	switch tag {
+	case SmallTupleTag, MapTag:
+		return true
+	case ListTag:
+		// an Encoder writes NIL, not an empty LIST_EXT, for empty lists
+		return len(term.(List).Items) > 0
+	case LargeTupleTag:
+		return len(term.([]Term)) > 255
+	case CompressedTag:
+		return false
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.writeTag(reflect.ValueOf(term)); err != nil {
+		return false
+	}
+	return bytes.Equal(buf.Bytes(), raw)
+}
@@ -0,0 +1,63 @@
+package bert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFidelity(t *testing.T) {
+	newFloat := []byte{131, 70, 63, 248, 0, 0, 0, 0, 0, 0}
+
+	var compressed bytes.Buffer
+	enc := NewEncoder(&compressed)
+	enc.CompressThreshold = 1
+	if err := enc.Encode(bytes.Repeat([]byte{1}, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data []byte
+		want Term
+	}{
+		{newFloat, nil},
+		{[]byte{131, 98, 0, 0, 0, 5}, nil},
+		{[]byte{131, 119, 2, 111, 107}, nil},
+		{[]byte{131, 105, 0, 0, 0, 2, 97, 1, 97, 2}, nil},
+		{compressed.Bytes(), nil},
+		{[]byte{131, 106}, List{}},
+		{[]byte{131, 108, 0, 0, 0, 2, 97, 1, 97, 2, 106}, List{Items: []Term{int64(1), int64(2)}}},
+		{[]byte{131, 108, 0, 0, 0, 1, 97, 1, 97, 2}, List{Items: []Term{int64(1)}, Tail: int64(2)}},
+		{[]byte{131, 108, 0, 0, 0, 0, 106}, nil},
+		{[]byte{131, 104, 1, 108, 0, 0, 0, 0, 106}, nil},
+		{[]byte{131, 108, 0, 0, 0, 1, 97, 1, 108, 0, 0, 0, 0, 106}, nil},
+		{[]byte{131, 116, 0, 0, 0, 2, 100, 0, 1, 98, 97, 1, 100, 0, 1, 97, 97, 2},
+			[]KV{{Atom("b"), int64(1)}, {Atom("a"), int64(2)}}},
+		{[]byte{131, 104, 2, 100, 0, 4, 98, 101, 114, 116, 100, 0, 4, 116, 114, 117, 101},
+			[]Term{Atom("bert"), Atom("true")}},
+		{[]byte{131, 104, 2, 107, 0, 2, 104, 105, 104, 2, 106, 98, 0, 0, 0, 1},
+			nil},
+	}
+
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(test.data))
+		d.Fidelity = true
+		term, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Decode(%v) returned error '%v'", test.data, err)
+		}
+		if test.want != nil {
+			assertEqual(t, test.want, term)
+		}
+		assertEncode(t, term, test.data)
+	}
+
+	d := NewDecoder(bytes.NewReader(newFloat))
+	d.Fidelity = true
+	term, _ := d.Decode()
+	assertEqual(t, 1.5, term.(Verbatim).Term)
+	var f float64
+	if err := UnmarshalTerm(term, &f); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1.5, f)
+}
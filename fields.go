@@ -0,0 +1,232 @@
+package bert
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A field is a struct field taking part in encoding and decoding, possibly
+// promoted from an embedded struct.
+type field struct {
+	name  string
+	index []int
+	typ   reflect.Type
+	tag   bool
+	opts  tagOptions
+}
+
+// tagOptions is the comma-separated list of options following the name in a
+// bert struct tag.
+type tagOptions string
+
+// Contains reports whether the options include opt.
+func (o tagOptions) Contains(opt string) bool {
+	for s := string(o); s != ""; {
+		var next string
+		if i := strings.Index(s, ","); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == opt {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// structFields returns the fields of struct type t in tuple order.
+//
+// Unexported fields and fields tagged `bert:"-"` are skipped. The fields of
+// anonymous struct fields are promoted into the parent as if they were
+// declared there, unless the embedded field is given a name by its tag.
+// When several promoted fields share a name, the shallowest one wins, with a
+// tagged field preferred at equal depth; if that leaves a tie, all of them
+// are dropped. These are the rules encoding/json uses.
+func structFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+func typeFields(t reflect.Type) []field {
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+	current := []queued{}
+	next := []queued{{typ: t}}
+	visited := map[reflect.Type]bool{}
+
+	// count and nextCount record how many times a type is embedded at the
+	// current and next depth, to detect ambiguous promotions.
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+
+	var fields []field
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if sf.PkgPath != "" && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+
+				tag := sf.Tag.Get("bert")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct && !isTermStruct(ft) {
+					nextCount[ft]++
+					if nextCount[ft] == 1 {
+						next = append(next, queued{ft, index})
+					}
+					continue
+				}
+				if sf.PkgPath != "" {
+					// unexported embedded structs only promote their fields
+					continue
+				}
+
+				if count[q.typ] > 1 {
+					// an ambiguous embedding contributes nothing
+					continue
+				}
+
+				tagged := name != ""
+				if name == "" {
+					name = sf.Name
+				}
+				fields = append(fields, field{
+					name:  name,
+					index: index,
+					typ:   sf.Type,
+					tag:   tagged,
+					opts:  opts,
+				})
+			}
+		}
+	}
+
+	// apply the dominance rules
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		return fields[i].tag && !fields[j].tag
+	})
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		if dominant, ok := dominantField(fields[i:j]); ok {
+			out = append(out, dominant)
+		}
+		i = j
+	}
+	fields = out
+
+	// restore declaration order
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return fields
+}
+
+// dominantField returns the field that wins among fields sharing a name,
+// which are sorted by depth and then taggedness.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tag == fields[1].tag {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+// isTermStruct reports whether t is one of the struct types this package
+// encodes as a single term, which are never flattened.
+func isTermStruct(t reflect.Type) bool {
+	switch t {
+	case reflect.TypeOf(Bitstring{}), reflect.TypeOf(List{}), reflect.TypeOf(IOList{}), bigIntType, timeType, termMapType:
+		return true
+	}
+	return false
+}
+
+// fieldByIndex returns the field of v at index, following embedded
+// pointers. It returns the invalid Value if one of them is nil.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// settableFieldByIndex is like fieldByIndex but allocates nil embedded
+// pointers on the way.
+func settableFieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
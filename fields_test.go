@@ -0,0 +1,104 @@
+package bert
+
+import (
+	"reflect"
+	"testing"
+)
+
+type embeddedBase struct {
+	ID   int
+	Kind Atom
+}
+
+type EmbeddedHeader struct {
+	ID   int
+	Kind Atom
+}
+
+type embeddedExtra struct {
+	Note string
+	ID   int
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	type msg struct {
+		embeddedBase
+		Value int
+	}
+	assertEncode(t, msg{embeddedBase{1, "a"}, 2}, []byte{131, 104, 3,
+		97, 1, 100, 0, 1, 97, 97, 2,
+	})
+
+	var m msg
+	if err := Unmarshal([]byte{131, 104, 3, 97, 1, 100, 0, 1, 97, 97, 2}, &m); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, msg{embeddedBase{1, "a"}, 2}, m)
+
+	// pointers to embedded structs are allocated on unmarshal
+	type ptrMsg struct {
+		*EmbeddedHeader
+		Value int
+	}
+	var p ptrMsg
+	if err := Unmarshal([]byte{131, 104, 3, 97, 1, 100, 0, 1, 97, 97, 2}, &p); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, EmbeddedHeader{1, "a"}, *p.EmbeddedHeader)
+	assertEqual(t, 2, p.Value)
+
+	// which is impossible through unexported ones
+	type unexportedPtrMsg struct {
+		*embeddedBase
+		Value int
+	}
+	var u unexportedPtrMsg
+	if err := Unmarshal([]byte{131, 104, 3, 97, 1, 100, 0, 1, 97, 97, 2}, &u); err == nil {
+		t.Errorf("expected error unmarshaling into unexported embedded pointer")
+	}
+}
+
+func TestEmbeddedStructConflicts(t *testing.T) {
+	// the shallower ID hides both embedded ones
+	type shadowed struct {
+		embeddedBase
+		ID int
+	}
+	assertEqual(t, []string{"Kind", "ID"}, fieldNames(shadowed{}))
+
+	// ambiguous fields at the same depth are dropped
+	type ambiguous struct {
+		embeddedBase
+		embeddedExtra
+	}
+	assertEqual(t, []string{"Kind", "Note"}, fieldNames(ambiguous{}))
+
+	// a tag breaks the tie
+	type tagged struct {
+		embeddedBase
+		Extra struct {
+			ID int `bert:"ID"`
+		}
+	}
+	assertEqual(t, []string{"ID", "Kind", "Extra"}, fieldNames(tagged{}))
+
+	// a named embedded struct is not flattened, and "-" skips a field
+	type named struct {
+		EmbeddedHeader `bert:"header"`
+		Skipped        int `bert:"-"`
+		Value          int
+	}
+	assertEqual(t, []string{"header", "Value"}, fieldNames(named{}))
+	assertEncode(t, named{EmbeddedHeader{1, "a"}, 5, 2}, []byte{131, 104, 2,
+		104, 2, 97, 1, 100, 0, 1, 97,
+		97, 2,
+	})
+}
+
+func fieldNames(v interface{}) []string {
+	var names []string
+	for _, f := range structFields(reflect.TypeOf(v)) {
+		names = append(names, f.name)
+	}
+	return names
+}
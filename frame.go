@@ -0,0 +1,173 @@
+package bert
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// ScanFrames is a split function for a bufio.Scanner that returns each
+// frame of a stream of 4-byte big endian length-prefixed messages, as used
+// by BURP, as a token. Tokens exclude the length prefix, so each can be
+// passed directly to Decode. A stream ending inside a frame yields
+// io.ErrUnexpectedEOF. Frames larger than the scanner's buffer fail with
+// bufio.ErrTooLong; use Scanner.Buffer to raise the limit.
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) >= 4 {
+		size := int(binary.BigEndian.Uint32(data))
+		if len(data)-4 >= size {
+			return 4 + size, data[4 : 4+size], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return 0, nil, nil
+}
+
+// A FrameChecksum selects the checksum trailing the payload of each frame,
+// so that frames corrupted by unreliable links or disks are detected before
+// they are decoded. The length prefix of a frame counts the checksum, so
+// that streams can still be split with ScanFrames. Both ends of a stream
+// must use the same checksum.
+type FrameChecksum int
+
+const (
+	// NoChecksum sends frames without a checksum, as BERT-RPC does.
+	NoChecksum FrameChecksum = iota
+	// CRC32Checksum trails frames with their 4-byte CRC-32 (IEEE).
+	CRC32Checksum
+	// CRC32CChecksum trails frames with their 4-byte CRC-32C (Castagnoli),
+	// which most CPUs compute in hardware.
+	CRC32CChecksum
+)
+
+// ErrChecksum is returned when reading a frame that fails its checksum.
+var ErrChecksum = errors.New("frame checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// size returns the size of the checksum trailing each frame.
+func (c FrameChecksum) size() int {
+	if c == CRC32Checksum || c == CRC32CChecksum {
+		return 4
+	}
+	return 0
+}
+
+// sum returns the checksum of payload.
+func (c FrameChecksum) sum(payload []byte) uint32 {
+	if c == CRC32CChecksum {
+		return crc32.Checksum(payload, castagnoli)
+	}
+	return crc32.ChecksumIEEE(payload)
+}
+
+// trailer returns the checksum trailing a frame of payload.
+func (c FrameChecksum) trailer(payload []byte) []byte {
+	if c.size() == 0 {
+		return nil
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, c.sum(payload))
+	return b
+}
+
+// verify checks the checksum trailing frame and returns its payload.
+func (c FrameChecksum) verify(frame []byte) ([]byte, error) {
+	n := c.size()
+	if n == 0 {
+		return frame, nil
+	}
+	if len(frame) < n {
+		return nil, ErrChecksum
+	}
+	payload := frame[:len(frame)-n]
+	if c.sum(payload) != binary.BigEndian.Uint32(frame[len(payload):]) {
+		return nil, ErrChecksum
+	}
+	return payload, nil
+}
+
+// ScanChecksummedFrames returns a split function for a bufio.Scanner that
+// returns the payload of each frame of a stream written with checksum c,
+// as ScanFrames does, failing with ErrChecksum at the first corrupted one.
+func ScanChecksummedFrames(c FrameChecksum) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := ScanFrames(data, atEOF)
+		if err != nil || token == nil {
+			return advance, token, err
+		}
+		payload, err := c.verify(token)
+		if err != nil {
+			return 0, nil, err
+		}
+		return advance, payload, nil
+	}
+}
+
+// DefaultMaxFrameSize is the frame size limit used when none is configured.
+const DefaultMaxFrameSize = 16 << 20
+
+// ErrFrameTooLarge is returned when a frame exceeds the configured size
+// limit.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// readFrame reads one length-prefixed frame from r into buf, growing it as
+// needed, and returns the payload. Frames longer than max are rejected
+// before their payload is read.
+func readFrame(r io.Reader, buf []byte, max int) ([]byte, error) {
+	size, err := readFrameSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > max {
+		return nil, ErrFrameTooLarge
+	}
+	return readFramePayload(r, buf, size)
+}
+
+// skipFrame reads a frame of size bytes whose header has already been read,
+// keeping at most the first len(head) bytes of it in head, and discards the
+// rest so that the stream stays in sync.
+func skipFrame(r io.Reader, head []byte, size int) ([]byte, error) {
+	if size < len(head) {
+		head = head[:size]
+	}
+	head, err := readFramePayload(r, head, len(head))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(size-len(head))); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return head, nil
+}
+
+func readFrameSize(r io.Reader) (int, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(header[:])), nil
+}
+
+func readFramePayload(r io.Reader, buf []byte, size int) ([]byte, error) {
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,72 @@
+package bert
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestScanFrames(t *testing.T) {
+	var stream bytes.Buffer
+	MarshalResponse(&stream, []Term{Atom("reply"), 42})
+	stream.Write([]byte{0, 0, 0, 0})
+	MarshalResponse(&stream, "foo")
+
+	s := bufio.NewScanner(&stream)
+	s.Split(ScanFrames)
+	var frames [][]byte
+	for s.Scan() {
+		frames = append(frames, append([]byte{}, s.Bytes()...))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(frames))
+	}
+	assertDecode(t, frames[0], []Term{Atom("reply"), int64(42)})
+	assertEqual(t, []byte{}, frames[1])
+	assertDecode(t, frames[2], "foo")
+
+	s = bufio.NewScanner(bytes.NewReader([]byte{0, 0, 0, 3, 131, 97}))
+	s.Split(ScanFrames)
+	for s.Scan() {
+		t.Errorf("unexpected token %v", s.Bytes())
+	}
+	if s.Err() != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", s.Err())
+	}
+}
+
+func TestScanChecksummedFrames(t *testing.T) {
+	var stream bytes.Buffer
+	for _, payload := range [][]byte{{131, 97, 1}, {}} {
+		write4(&stream, uint32(len(payload)+4))
+		stream.Write(payload)
+		stream.Write(CRC32Checksum.trailer(payload))
+	}
+	data := stream.Bytes()
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Split(ScanChecksummedFrames(CRC32Checksum))
+	var frames [][]byte
+	for s.Scan() {
+		frames = append(frames, append([]byte{}, s.Bytes()...))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, [][]byte{{131, 97, 1}, {}}, frames)
+
+	data[6]++
+	s = bufio.NewScanner(bytes.NewReader(data))
+	s.Split(ScanChecksummedFrames(CRC32Checksum))
+	for s.Scan() {
+		t.Errorf("unexpected token %v", s.Bytes())
+	}
+	if s.Err() != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", s.Err())
+	}
+}
@@ -0,0 +1,82 @@
+package bert
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxFrameSizeAtom is the info command with which a peer announces the
+// size of the largest frame it accepts, as {info, max_frame_size, [Bytes]}.
+// A client sends it with NegotiateMaxFrameSize, and Server answers with
+// its MaxRequestSize. Each side then refuses to send frames the other
+// would drop: calls fail with ErrFrameTooLarge, responses are replaced by
+// protocol error 7, and streamed responses are sent in chunks that fit.
+const MaxFrameSizeAtom = Atom("max_frame_size")
+
+// NegotiateMaxFrameSize announces to the server that the client accepts
+// frames of up to max bytes, or DefaultMaxFrameSize if max is not
+// positive, and limits the frames it reads to that. It returns the limit
+// of the server, which then bounds the frames the client sends. It must be
+// called before any call is made, and only with servers that answer the
+// {info, max_frame_size, [Bytes]} request, such as Server.
+func (c *Client) NegotiateMaxFrameSize(ctx context.Context, max int) (int, error) {
+	c.serial.Lock()
+	defer c.serial.Unlock()
+	c.setup()
+
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+	if err := c.failed(); err != nil {
+		return 0, err
+	}
+	if err := c.conn.WriteTerm(ctx, infoTerm(MaxFrameSizeAtom, max)); err != nil {
+		return 0, c.fail(err)
+	}
+	term, err := c.conn.ReadTerm(ctx)
+	if err != nil {
+		return 0, c.fail(err)
+	}
+	command, options, ok := parseInfo(term)
+	if !ok || command != MaxFrameSizeAtom {
+		return 0, c.fail(fmt.Errorf("unexpected packet %v", term))
+	}
+	limit, ok := parseFrameSize(options)
+	if !ok {
+		return 0, c.fail(fmt.Errorf("malformed frame size %v", term))
+	}
+
+	c.conn.rmu.Lock()
+	c.conn.MaxFrameSize = max
+	c.conn.rmu.Unlock()
+	c.conn.limitWrites(limit)
+	return limit, nil
+}
+
+// negotiateMaxFrameSize answers a client's announcement of its frame size.
+func (sc *serverConn) negotiateMaxFrameSize(options []Term) {
+	if limit, ok := parseFrameSize(options); ok {
+		sc.conn.limitWrites(limit)
+	}
+	sc.conn.WriteTerm(sc.ctx, infoTerm(MaxFrameSizeAtom, sc.server.maxRequestSize()))
+}
+
+// maxRequestSize returns the size of the largest request s accepts.
+func (s *Server) maxRequestSize() int {
+	if s.MaxRequestSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return s.MaxRequestSize
+}
+
+// parseFrameSize returns the size sent in a max_frame_size info packet.
+func parseFrameSize(options []Term) (int, bool) {
+	if len(options) != 1 {
+		return 0, false
+	}
+	n, ok := options[0].(int64)
+	if !ok || n <= 0 || n > 1<<32-1 {
+		return 0, false
+	}
+	return int(n), true
+}
@@ -0,0 +1,59 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateMaxFrameSize(t *testing.T) {
+	s := newTestServer()
+	s.MaxRequestSize = 64
+	s.Register("data", "big", func(ctx context.Context, args []Term) (Term, error) {
+		return strings.Repeat("x", 200), nil
+	})
+	s.Register("data", "stream", func(ctx context.Context, args []Term) (Term, error) {
+		return bytes.NewReader(bytes.Repeat([]byte("y"), 1000)), nil
+	})
+	ctx := context.Background()
+
+	for _, multiplex := range []bool{false, true} {
+		c := pipe(s)
+		limit, err := c.NegotiateMaxFrameSize(ctx, 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, 64, limit)
+		c.Multiplex = multiplex
+
+		if _, err := c.Call(ctx, "math", "add", strings.Repeat("1", 100)); err != ErrFrameTooLarge {
+			t.Errorf("expected ErrFrameTooLarge with multiplex %v, got %v", multiplex, err)
+		}
+		_, err = c.Call(ctx, "data", "big")
+		if e, ok := err.(*RPCError); !ok || e.Type != ProtocolError || e.Code != 7 {
+			t.Errorf("expected protocol error 7 with multiplex %v, got %v", multiplex, err)
+		}
+
+		r, err := c.CallStream(ctx, "data", "stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		assertEqual(t, 1000, len(data))
+
+		// The connection is still usable.
+		result, err := c.Call(ctx, "math", "add", 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, int64(3), result)
+		c.Close()
+	}
+}
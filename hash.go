@@ -0,0 +1,40 @@
+package bert
+
+import (
+	"bufio"
+	"bytes"
+	"hash"
+)
+
+// Hash writes the canonical encoding of term, as an Encoder with Canonical
+// set writes it, to h, which is reset first, and returns the digest, so
+// that terms can be addressed by their content. Equal terms have equal
+// hashes, whatever the order of their map entries, and whether strings
+// were received as STRING_EXT or as lists of integers. Lists and tuples
+// hash differently as long as they are told apart: lists decode as []Term,
+// like tuples, unless Decoder.Fidelity makes them decode as List. The
+// encoding is streamed into h rather than held in memory, besides that of
+// map entries, which are sorted.
+func Hash(term Term, h hash.Hash) ([]byte, error) {
+	h.Reset()
+	w := bufio.NewWriter(h)
+	e := NewEncoder(w)
+	e.Canonical = true
+	if err := e.Encode(term); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// encodeCanonical returns the canonical encoding of val, with the version
+// tag.
+func encodeCanonical(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Canonical = true
+	err := e.Encode(val)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,120 @@
+package bert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	type config struct {
+		Retries int    `bert:"retries"`
+		Name    string `bert:"name"`
+	}
+	maps := []Term{
+		map[Atom]Term{"name": "db", "retries": 3},
+		NewTermMap(KV{Atom("retries"), 3}, KV{Atom("name"), "db"}),
+		[]KV{{Atom("retries"), 3}, {Atom("name"), "db"}},
+	}
+	want, err := Hash(maps[0], sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range maps[1:] {
+		got, err := Hash(m, sha256.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, want, got)
+	}
+
+	// structs encoded as maps are sorted too, as are RawTerm values
+	var canonical, structMap bytes.Buffer
+	e := NewEncoder(&canonical)
+	e.Canonical = true
+	if err := e.Encode(maps[0]); err != nil {
+		t.Fatal(err)
+	}
+	e = NewEncoder(&structMap)
+	e.Canonical, e.StructMaps = true, true
+	if err := e.Encode(config{3, "db"}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, canonical.Bytes(), structMap.Bytes())
+	raw, err := Encode([]KV{{Atom("retries"), 3}, {Atom("name"), "db"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Hash(RawTerm(raw), sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, want, got)
+
+	// a term decoded with Fidelity hashes like the original
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.CompressThreshold = 1
+	if err := enc.Encode([]KV{{Atom("retries"), 3}, {Atom("name"), "db"}}); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(&buf)
+	d.Fidelity = true
+	term, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = Hash(term, sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, want, got)
+
+	other, err := Hash(map[Atom]Term{"name": "db", "retries": 4}, sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(want, other) {
+		t.Error("different terms hash alike")
+	}
+}
+
+func TestHashStringsAndLists(t *testing.T) {
+	hash := func(term Term) []byte {
+		t.Helper()
+		h, err := Hash(term, sha256.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	decode := func(data []byte) Term {
+		t.Helper()
+		d := NewDecoder(bytes.NewReader(data))
+		d.Fidelity = true
+		term, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return term
+	}
+
+	// A string hashes like the list of its characters, however received.
+	str := decode([]byte{131, 107, 0, 3, 'a', 'b', 'c'})
+	chars := decode([]byte{131, 108, 0, 0, 0, 3, 97, 'a', 97, 'b', 97, 'c', 106})
+	assertEqual(t, hash("abc"), hash(str))
+	assertEqual(t, hash(str), hash(chars))
+	assertEqual(t, hash(str), hash(List{Items: []Term{97, 98, 99}}))
+	if bytes.Equal(hash(str), hash(List{Items: []Term{97, 98, 256}})) {
+		t.Error("list of larger integers hashes like a string")
+	}
+
+	// Lists and tuples hash differently.
+	list := decode([]byte{131, 108, 0, 0, 0, 1, 100, 0, 1, 'x', 106})
+	tuple := decode([]byte{131, 104, 1, 100, 0, 1, 'x'})
+	if bytes.Equal(hash(list), hash(tuple)) {
+		t.Error("[x] and {x} hash alike")
+	}
+	assertEqual(t, hash(List{Items: []Term{Atom("x")}}), hash(list))
+	assertEqual(t, hash([]Term{Atom("x")}), hash(tuple))
+}
@@ -0,0 +1,96 @@
+package bert
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Info packet commands used for heartbeats. A server answers
+// {info, ping, Options} with {info, pong, Options}.
+const (
+	PingAtom = Atom("ping")
+	PongAtom = Atom("pong")
+)
+
+// Ping checks that the server is responsive, returning once it has
+// answered a ping. Without Multiplex, it waits for any call in progress to
+// finish first.
+func (c *Client) Ping(ctx context.Context) error {
+	if c.Multiplex {
+		_, err := c.exchange(ctx, &outgoing{}, func(seq int64) Term { return infoTerm(PingAtom, seq) })
+		return err
+	}
+
+	c.serial.Lock()
+	defer c.serial.Unlock()
+	return c.ping(ctx)
+}
+
+// ping exchanges a ping and pong while c.serial is held.
+func (c *Client) ping(ctx context.Context) error {
+	c.setup()
+	if err := c.failed(); err != nil {
+		return err
+	}
+	if err := c.conn.WriteTerm(ctx, infoTerm(PingAtom)); err != nil {
+		return c.fail(err)
+	}
+	term, err := c.conn.ReadTerm(ctx)
+	if err != nil {
+		return c.fail(err)
+	}
+	if command, _, ok := parseInfo(term); !ok || command != PongAtom {
+		return c.fail(fmt.Errorf("unexpected packet %v", term))
+	}
+	c.touch()
+	return nil
+}
+
+// touch records that a packet has been received.
+func (c *Client) touch() {
+	atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano())
+}
+
+func (c *Client) startHeartbeat() {
+	if c.HeartbeatInterval > 0 {
+		c.touch()
+		go c.heartbeatLoop()
+	}
+}
+
+// heartbeatLoop pings the server whenever it has been silent for an
+// interval, until the connection fails.
+func (c *Client) heartbeatLoop() {
+	timeout := c.HeartbeatTimeout
+	if timeout <= 0 {
+		timeout = c.HeartbeatInterval
+	}
+	ticker := time.NewTicker(c.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if c.failed() != nil {
+			return
+		}
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastUsed)))
+		if idle < c.HeartbeatInterval {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		var err error
+		if c.Multiplex {
+			err = c.Ping(ctx)
+		} else if c.serial.TryLock() {
+			err = c.ping(ctx)
+			c.serial.Unlock()
+		}
+		cancel()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package bert
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, multiplex := range []bool{false, true} {
+		c := pipe(newTestServer())
+		c.Multiplex = multiplex
+		if err := c.Ping(ctx); err != nil {
+			t.Errorf("ping with multiplex %v failed: %v", multiplex, err)
+		}
+		result, err := c.Call(ctx, "math", "add", 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, int64(3), result)
+		c.Close()
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, multiplex := range []bool{false, true} {
+		// a live server keeps the connection up
+		c := pipe(newTestServer())
+		c.Multiplex = multiplex
+		c.HeartbeatInterval = 5 * time.Millisecond
+		if _, err := c.Call(ctx, "math", "add", 1); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if _, err := c.Call(ctx, "math", "add", 1); err != nil {
+			t.Errorf("call with multiplex %v after heartbeats failed: %v", multiplex, err)
+		}
+		c.Close()
+
+		// a peer that stops answering is detected
+		a, b := net.Pipe()
+		go func() {
+			tc := NewTermConn(b)
+			term, _ := tc.ReadTerm(ctx)
+			if seq, _, ok := envelope(term); ok {
+				tc.WriteTerm(ctx, []Term{seq, []Term{ReplyAtom, 1}})
+			} else {
+				tc.WriteTerm(ctx, []Term{ReplyAtom, 1})
+			}
+			for {
+				if _, err := tc.ReadTerm(ctx); err != nil {
+					return
+				}
+			}
+		}()
+		c = NewClient(a)
+		c.Multiplex = multiplex
+		c.HeartbeatInterval = 5 * time.Millisecond
+		if _, err := c.Call(ctx, "math", "add", 1); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for c.failed() == nil && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if _, err := c.Call(ctx, "math", "add", 1); err != context.DeadlineExceeded {
+			t.Errorf("expected heartbeat with multiplex %v to fail with a deadline error, got %v", multiplex, err)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package bert
+
+import (
+	"reflect"
+	"time"
+)
+
+// A DecodeHook transforms a term before Unmarshal stores it in a value of
+// type t, returning the term to store instead. It may return a Go value of
+// type t, which is then stored as is, or the term unchanged if it does
+// not apply. Hooks are called for every value filled, including struct
+// fields, elements and map entries, and, for pointers, both for the
+// pointer type and the type it points to.
+type DecodeHook func(term Term, t reflect.Type) (Term, error)
+
+// ComposeDecodeHooks returns a hook calling hooks in order, each with the
+// term returned by the previous one, and stopping at the first error.
+func ComposeDecodeHooks(hooks ...DecodeHook) DecodeHook {
+	return func(term Term, t reflect.Type) (Term, error) {
+		for _, hook := range hooks {
+			var err error
+			if term, err = hook(term, t); err != nil {
+				return nil, err
+			}
+		}
+		return term, nil
+	}
+}
+
+// TimestampHook is a DecodeHook storing the {MegaSecs, Secs, MicroSecs}
+// tuples returned by Erlang's os:timestamp/0 and erlang:timestamp/0 in
+// time.Time values.
+func TimestampHook(term Term, t reflect.Type) (Term, error) {
+	if t != timeType {
+		return term, nil
+	}
+	tuple, ok := term.([]Term)
+	if !ok || len(tuple) != 3 {
+		return term, nil
+	}
+	var parts [3]int64
+	for i, x := range tuple {
+		n, ok := x.(int64)
+		if !ok {
+			return term, nil
+		}
+		parts[i] = n
+	}
+	return time.Unix(parts[0]*1e6+parts[1], parts[2]*1e3).UTC(), nil
+}
@@ -0,0 +1,45 @@
+package bert
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeHook(t *testing.T) {
+	type event struct {
+		Name string
+		At   *time.Time
+	}
+	upper := func(term Term, t reflect.Type) (Term, error) {
+		if s, ok := term.(string); ok && t.Kind() == reflect.String {
+			return strings.ToUpper(s), nil
+		}
+		return term, nil
+	}
+	opts := UnmarshalOptions{DecodeHook: ComposeDecodeHooks(TimestampHook, upper)}
+
+	var events []event
+	err := opts.UnmarshalTerm([]Term{
+		[]Term{"boot", []Term{int64(1700), int64(1234), int64(500)}},
+	}, &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(events))
+	assertEqual(t, "BOOT", events[0].Name)
+	assertEqual(t, time.Date(2023, 11, 14, 22, 33, 54, 500000, time.UTC), *events[0].At)
+
+	failing := UnmarshalOptions{DecodeHook: func(term Term, t reflect.Type) (Term, error) {
+		if t.Kind() == reflect.Int {
+			return nil, errors.New("no ints")
+		}
+		return term, nil
+	}}
+	var n []int
+	if err := failing.UnmarshalTerm([]Term{int64(1)}, &n); err == nil || err.Error() != "no ints" {
+		t.Errorf("expected the hook's error, got %v", err)
+	}
+}
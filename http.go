@@ -0,0 +1,375 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of BERT payloads sent over HTTP.
+const ContentType = "application/bert"
+
+// Headers carrying, for requests sent over HTTP, what info packets carry
+// ahead of requests on a connection: the time left before the deadline of
+// the request, in milliseconds, as described for DeadlineAtom, and its
+// correlation ID, as described for CorrelationAtom. The trace context of
+// a request travels in the headers its Tracer injects.
+const (
+	DeadlineHeader    = "Bert-Deadline"
+	CorrelationHeader = "Bert-Correlation-Id"
+)
+
+// An HTTPAuthenticator verifies a request served by HTTPHandler, from its
+// headers or TLS connection state. It returns a non-nil error to reject
+// the request, which is then answered with protocol error 6.
+type HTTPAuthenticator func(r *http.Request) error
+
+// BearerAuth returns an HTTPAuthenticator that expects an
+// "Authorization: Bearer <token>" header, as sent by HTTPClient.Token, and
+// accepts the request if verify returns nil for the token.
+func BearerAuth(verify func(token string) error) HTTPAuthenticator {
+	return func(r *http.Request) error {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return errUnauthorized
+		}
+		return verify(strings.TrimPrefix(auth, "Bearer "))
+	}
+}
+
+// HTTPTLSAuth returns an HTTPAuthenticator passing the TLS connection
+// state of the request to verify. With a nil verify, any request made
+// with a client certificate is accepted, as for TLSAuth.
+func HTTPTLSAuth(verify func(tls.ConnectionState) error) HTTPAuthenticator {
+	return func(r *http.Request) error {
+		if r.TLS == nil {
+			return errors.New("not a TLS connection")
+		}
+		if verify == nil {
+			if len(r.TLS.PeerCertificates) == 0 {
+				return errUnauthorized
+			}
+			return nil
+		}
+		return verify(*r.TLS)
+	}
+}
+
+// HTTPHandler returns an http.Handler serving BERT-RPC with s over HTTP.
+// Each POST request carries one request packet as its body and is answered
+// with the response packet, both of type application/bert. Streamed
+// responses are sent as an application/octet-stream body instead.
+//
+// Requests are admitted as on a connection. A server with Authenticate
+// set, which needs a connection, refuses them unless AuthenticateHTTP is
+// set too. MaxConcurrentRequests and RateLimit apply to all the requests
+// served by the handler, as if they shared a connection. The deadline,
+// correlation ID and trace context of a request are read from its
+// headers.
+func HTTPHandler(s *Server) http.Handler {
+	h := &httpHandler{server: s}
+	if s.RateLimit > 0 {
+		h.limiter = newTokenBucket(s.RateLimit, s.RateBurst)
+	}
+	return h
+}
+
+// An httpHandler serves BERT-RPC over HTTP, keeping the state of the
+// server's limits.
+type httpHandler struct {
+	server *Server
+
+	mu       sync.Mutex
+	limiter  *tokenBucket
+	inflight int
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.authenticate(r); err != nil {
+		logf(s.Logger, "bert: HTTP request from %s refused: authentication failed: %v", r.RemoteAddr, err)
+		writeHTTPTerm(w, http.StatusUnauthorized, (&RPCError{Type: ProtocolError, Code: 6, Detail: err.Error()}).term())
+		return
+	}
+
+	maxSize := s.maxRequestSize()
+	body, err := readLimited(r.Body, maxSize)
+	if err == ErrFrameTooLarge {
+		logf(s.Logger, "bert: HTTP request from %s exceeds %d bytes", r.RemoteAddr, maxSize)
+		writeHTTPTerm(w, http.StatusRequestEntityTooLarge,
+			(&RPCError{Type: ProtocolError, Code: 3, Detail: fmt.Sprintf("request exceeds %d bytes", maxSize)}).term())
+		return
+	}
+	if err != nil {
+		logf(s.Logger, "bert: reading HTTP request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "reading request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, err := Decode(body)
+	if err != nil {
+		writeHTTPTerm(w, http.StatusBadRequest, (&RPCError{Type: ProtocolError, Code: 0, Detail: err.Error()}).term())
+		return
+	}
+
+	if rpcErr := h.admit(); rpcErr != nil {
+		logf(s.Logger, "bert: refused request from %s: %v", r.RemoteAddr, rpcErr)
+		writeHTTPTerm(w, http.StatusTooManyRequests, rpcErr.term())
+		return
+	}
+	defer h.release()
+
+	ctx := r.Context()
+	if id := r.Header.Get(CorrelationHeader); id != "" {
+		ctx = WithCorrelationID(ctx, id)
+	}
+	if left, err := strconv.ParseInt(r.Header.Get(DeadlineHeader), 10, 64); err == nil {
+		if deadline, ok := parseDeadline([]Term{left}, time.Now()); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+	}
+	ctx, end := s.startSpan(ctx, req, httpCarrier(r.Header))
+
+	resp := s.handle(ctx, req, h)
+	if fn, ok := resp.(streamResponse); ok {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		fn(flushWriter{w})
+		end(len(body), 0, resp)
+		return
+	}
+	end(len(body), writeHTTPTerm(w, http.StatusOK, resp), resp)
+}
+
+// authenticate verifies r with the authenticator of the server, if any.
+func (h *httpHandler) authenticate(r *http.Request) error {
+	s := h.server
+	if s.AuthenticateHTTP != nil {
+		return s.AuthenticateHTTP(r)
+	}
+	if s.Authenticate != nil {
+		return errors.New("connection authentication required")
+	}
+	return nil
+}
+
+// admit checks a new request against the limits of the server, counting
+// it as in flight until release is called if it is admitted.
+func (h *httpHandler) admit() *RPCError {
+	if !h.allow() {
+		return &RPCError{Type: ProtocolError, Code: 5, Detail: "rate limit exceeded"}
+	}
+	if !h.acquire() {
+		max := h.server.MaxConcurrentRequests
+		return &RPCError{Type: ProtocolError, Code: 4, Detail: fmt.Sprintf("more than %d concurrent requests", max)}
+	}
+	return nil
+}
+
+func (h *httpHandler) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.limiter == nil || h.limiter.allow(time.Now())
+}
+
+func (h *httpHandler) acquire() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if max := h.server.MaxConcurrentRequests; max > 0 && h.inflight >= max {
+		return false
+	}
+	h.inflight++
+	return true
+}
+
+func (h *httpHandler) release() {
+	h.mu.Lock()
+	h.inflight--
+	h.mu.Unlock()
+}
+
+func (h *httpHandler) detach(fn func(ctx context.Context)) {
+	h.mu.Lock()
+	h.inflight++
+	h.mu.Unlock()
+	s := h.server
+	s.casts.Add(1)
+	go func() {
+		defer s.casts.Done()
+		defer h.release()
+		fn(s.ctx)
+	}()
+}
+
+// httpCarrier returns the trace carrier of the request headers, keyed by
+// their lower-case names as trace propagators expect.
+func httpCarrier(header http.Header) map[string]string {
+	carrier := make(map[string]string, len(header))
+	for k, v := range header {
+		if len(v) > 0 {
+			carrier[strings.ToLower(k)] = v[0]
+		}
+	}
+	return carrier
+}
+
+// writeHTTPTerm answers with term, returning its encoded size.
+func writeHTTPTerm(w http.ResponseWriter, status int, term Term) int {
+	data, err := Encode(term)
+	if err != nil {
+		data, _ = Encode(userError(err).term())
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	w.Write(data)
+	return len(data)
+}
+
+// A flushWriter flushes each write of a streamed response to the client.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// An HTTPClient makes BERT-RPC calls to a server reached over HTTP, such as
+// one served by HTTPHandler.
+type HTTPClient struct {
+	// URL is the endpoint requests are POSTed to.
+	URL string
+
+	// Client is the HTTP client used. Nil means http.DefaultClient.
+	Client *http.Client
+
+	// Token, if set, is sent as a bearer token, for servers using
+	// BearerAuth.
+	Token string
+
+	// SendDeadlines and CorrelationIDs send the deadline and a correlation
+	// ID of each request in its headers, as Client does in info packets.
+	SendDeadlines  bool
+	CorrelationIDs bool
+
+	// MaxResponseSize limits the size of response bodies, streamed ones
+	// included; larger ones fail the call with ErrFrameTooLarge. Zero means
+	// DefaultMaxFrameSize.
+	MaxResponseSize int
+}
+
+// NewHTTPClient returns a client posting requests to url.
+func NewHTTPClient(url string) *HTTPClient {
+	return &HTTPClient{URL: url}
+}
+
+// Call calls module:function with args and returns the result of the
+// reply. An error response is returned as an *RPCError, and a streamed
+// response as a []byte.
+func (c *HTTPClient) Call(ctx context.Context, module, function Atom, args ...Term) (Term, error) {
+	return c.do(ctx, requestTerm(CallAtom, module, function, args))
+}
+
+// Cast sends a cast of module:function with args, returning once the
+// server has acknowledged it.
+func (c *HTTPClient) Cast(ctx context.Context, module, function Atom, args ...Term) error {
+	_, err := c.do(ctx, requestTerm(CastAtom, module, function, args))
+	return err
+}
+
+func (c *HTTPClient) maxResponseSize() int {
+	if c.MaxResponseSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return c.MaxResponseSize
+}
+
+func (c *HTTPClient) do(ctx context.Context, req Term) (Term, error) {
+	body, err := Encode(req)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", ContentType)
+	hreq.Header.Set("Accept", ContentType)
+	if c.Token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	var correlation string
+	if c.CorrelationIDs {
+		if correlation = CorrelationID(ctx); correlation == "" {
+			correlation = newCorrelationID()
+		}
+		hreq.Header.Set(CorrelationHeader, correlation)
+	}
+	if left, ok := deadlineLeft(ctx); ok && c.SendDeadlines {
+		hreq.Header.Set(DeadlineHeader, strconv.FormatInt(left, 10))
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+
+	data, err := readLimited(hresp.Body, c.maxResponseSize())
+	if err != nil {
+		return nil, err
+	}
+	switch hresp.Header.Get("Content-Type") {
+	case ContentType:
+		resp, err := Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		result, err := parseResponse(resp)
+		if rpcErr, ok := err.(*RPCError); ok && correlation != "" {
+			rpcErr.CorrelationID = correlation
+		}
+		return result, err
+	case "application/octet-stream":
+		if hresp.StatusCode == http.StatusOK {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected HTTP response %s", hresp.Status)
+}
+
+// readLimited reads r to the end, failing with ErrFrameTooLarge once more
+// than max bytes have been read.
+func readLimited(r io.Reader, max int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		return nil, ErrFrameTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,153 @@
+package bert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	s := newStreamServer()
+	s.MaxRequestSize = 256
+	hs := httptest.NewServer(HTTPHandler(s))
+	defer hs.Close()
+	c := NewHTTPClient(hs.URL)
+	ctx := context.Background()
+
+	result, err := c.Call(ctx, "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+
+	_, err = c.Call(ctx, "math", "fail")
+	assertEqual(t, &RPCError{Type: UserError, Detail: "boom"}, err)
+	if err := c.Cast(ctx, "math", "add", 1); err != nil {
+		t.Errorf("cast failed: %v", err)
+	}
+
+	result, err = c.Call(ctx, "stream", "push", "a", "b")
+	assertEqual(t, nil, err)
+	assertEqual(t, []byte("ab"), result)
+
+	_, err = c.Call(ctx, "math", "add", make([]Term, 300)...)
+	assertProtocolError(t, 3, err)
+
+	resp, err := http.Post(hs.URL, ContentType, bytes.NewReader([]byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertEqual(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Bodies failing to be read are bad requests, not oversized ones.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", iotest.ErrReader(errors.New("reset")))
+	HTTPHandler(s).ServeHTTP(rec, req)
+	assertEqual(t, http.StatusBadRequest, rec.Code)
+
+	// Responses are limited too.
+	c.MaxResponseSize = 4
+	if _, err := c.Call(ctx, "math", "add", 1, 2); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+
+	resp, err = http.Get(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertEqual(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestHTTPAdmission(t *testing.T) {
+	ctx := context.Background()
+
+	// A server authenticating connections refuses anonymous HTTP calls.
+	s := newTestServer()
+	s.Authenticate = TokenAuth(func(token Term) error { return nil })
+	hs := httptest.NewServer(HTTPHandler(s))
+	_, err := NewHTTPClient(hs.URL).Call(ctx, "math", "add", 1)
+	assertProtocolError(t, 6, err)
+	hs.Close()
+
+	s.AuthenticateHTTP = BearerAuth(func(token string) error {
+		if token != "secret" {
+			return errUnauthorized
+		}
+		return nil
+	})
+	hs = httptest.NewServer(HTTPHandler(s))
+	defer hs.Close()
+	c := NewHTTPClient(hs.URL)
+	_, err = c.Call(ctx, "math", "add", 1)
+	assertProtocolError(t, 6, err)
+	c.Token = "secret"
+	result, err := c.Call(ctx, "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+
+	// Limits apply across the requests served by the handler.
+	s = newTestServer()
+	s.RateLimit = 0.001
+	s.RateBurst = 1
+	hs2 := httptest.NewServer(HTTPHandler(s))
+	defer hs2.Close()
+	c = NewHTTPClient(hs2.URL)
+	if _, err := c.Call(ctx, "math", "add", 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Call(ctx, "math", "add", 1)
+	assertProtocolError(t, 5, err)
+
+	s = newTestServer()
+	s.MaxConcurrentRequests = 1
+	release := make(chan struct{})
+	s.Register("sync", "wait", func(ctx context.Context, args []Term) (Term, error) {
+		<-release
+		return Atom("released"), nil
+	})
+	hs3 := httptest.NewServer(HTTPHandler(s))
+	defer hs3.Close()
+	c = NewHTTPClient(hs3.URL)
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Call(ctx, "sync", "wait")
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_, err = c.Call(ctx, "math", "add", 1)
+	assertProtocolError(t, 4, err)
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	// Handlers see the deadline and correlation ID of the request.
+	s = newTestServer()
+	s.Register("ctx", "info", func(ctx context.Context, args []Term) (Term, error) {
+		deadline := Atom("none")
+		if _, ok := ctx.Deadline(); ok {
+			deadline = "set"
+		}
+		return []Term{Binary(CorrelationID(ctx)), deadline}, nil
+	})
+	hs4 := httptest.NewServer(HTTPHandler(s))
+	defer hs4.Close()
+	c = NewHTTPClient(hs4.URL)
+	c.SendDeadlines, c.CorrelationIDs = true, true
+	dctx, cancel := context.WithTimeout(WithCorrelationID(ctx, "abc"), 5*time.Second)
+	defer cancel()
+	result, err = c.Call(dctx, "ctx", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{[]byte("abc"), Atom("set")}, result)
+}
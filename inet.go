@@ -0,0 +1,96 @@
+package bert
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+)
+
+// IP addresses are represented as Erlang does in the inet module: IPv4
+// addresses as {A, B, C, D} tuples of bytes and IPv6 addresses as
+// {A, B, C, D, E, F, G, H} tuples of 16-bit groups. net.IP and netip.Addr
+// values encode in this form, and Unmarshal fills them from it. Both
+// mappings are registered through RegisterEncoder and so can be replaced.
+func init() {
+	RegisterEncoder(reflect.TypeOf(net.IP{}), func(v interface{}) (Term, error) {
+		ip := v.(net.IP)
+		if len(ip) == 0 {
+			return nil, nil
+		}
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			return nil, fmt.Errorf("invalid IP address %v", []byte(ip))
+		}
+		if ip.To4() != nil {
+			addr = addr.Unmap()
+		}
+		return addrTuple(addr), nil
+	})
+	RegisterEncoder(reflect.TypeOf(netip.Addr{}), func(v interface{}) (Term, error) {
+		addr := v.(netip.Addr)
+		if !addr.IsValid() {
+			return nil, nil
+		}
+		return addrTuple(addr), nil
+	})
+
+	registerConverter(reflect.TypeOf(net.IP{}), func(term Term) (interface{}, bool, error) {
+		addr, ok := tupleAddr(term)
+		if !ok {
+			return nil, false, nil
+		}
+		return net.IP(addr.AsSlice()), true, nil
+	})
+	registerConverter(reflect.TypeOf(netip.Addr{}), func(term Term) (interface{}, bool, error) {
+		addr, ok := tupleAddr(term)
+		if !ok {
+			return nil, false, nil
+		}
+		return addr, true, nil
+	})
+}
+
+// addrTuple returns the inet tuple for addr, dropping any IPv6 zone.
+func addrTuple(addr netip.Addr) []Term {
+	if addr.Is4() {
+		b := addr.As4()
+		return []Term{int(b[0]), int(b[1]), int(b[2]), int(b[3])}
+	}
+	b := addr.As16()
+	tuple := make([]Term, 8)
+	for i := range tuple {
+		tuple[i] = int(b[2*i])<<8 | int(b[2*i+1])
+	}
+	return tuple
+}
+
+// tupleAddr parses an inet address tuple.
+func tupleAddr(term Term) (netip.Addr, bool) {
+	tuple, ok := term.([]Term)
+	if !ok || (len(tuple) != 4 && len(tuple) != 8) {
+		return netip.Addr{}, false
+	}
+
+	max := int64(255)
+	if len(tuple) == 8 {
+		max = 65535
+	}
+	parts := make([]int64, len(tuple))
+	for i, t := range tuple {
+		n, ok := t.(int64)
+		if !ok || n < 0 || n > max {
+			return netip.Addr{}, false
+		}
+		parts[i] = n
+	}
+
+	if len(parts) == 4 {
+		return netip.AddrFrom4([4]byte{byte(parts[0]), byte(parts[1]), byte(parts[2]), byte(parts[3])}), true
+	}
+	var b [16]byte
+	for i, n := range parts {
+		b[2*i], b[2*i+1] = byte(n>>8), byte(n)
+	}
+	return netip.AddrFrom16(b), true
+}
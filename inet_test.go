@@ -0,0 +1,42 @@
+package bert
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestIPAddresses(t *testing.T) {
+	v4 := []byte{131, 104, 4, 97, 192, 97, 168, 97, 0, 97, 1}
+	v6 := []byte{131, 104, 8,
+		98, 0, 0, 32, 1, 98, 0, 0, 13, 184,
+		97, 0, 97, 0, 97, 0, 97, 0, 97, 0, 97, 1,
+	}
+
+	assertEncode(t, net.ParseIP("192.168.0.1"), v4)
+	assertEncode(t, net.ParseIP("192.168.0.1").To4(), v4)
+	assertEncode(t, net.ParseIP("2001:db8::1"), v6)
+	assertEncode(t, netip.MustParseAddr("192.168.0.1"), v4)
+	assertEncode(t, netip.MustParseAddr("2001:db8::1%eth0"), v6)
+	assertEncode(t, net.IP(nil), []byte{131, 106})
+	assertNotEncode(t, net.IP{1, 2, 3}, "cannot encode net.IP: invalid IP address [1 2 3]")
+
+	var peer struct {
+		IP   net.IP
+		Addr netip.Addr
+	}
+	data := []byte{131, 104, 2}
+	data = append(data, v4[1:]...)
+	data = append(data, v6[1:]...)
+	if err := Unmarshal(data, &peer); err != nil {
+		t.Fatal(err)
+	}
+	if !peer.IP.Equal(net.ParseIP("192.168.0.1")) {
+		t.Errorf("expected 192.168.0.1, but was %v", peer.IP)
+	}
+	assertEqual(t, netip.MustParseAddr("2001:db8::1"), peer.Addr)
+
+	if err := Unmarshal([]byte{131, 104, 1, 104, 4, 97, 1, 97, 2, 97, 3, 98, 0, 0, 1, 0}, &peer); err == nil {
+		t.Errorf("expected error for out of range address part")
+	}
+}
@@ -0,0 +1,70 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// Every Server answers calls to RPCModule, so that clients and load
+// balancers can check its health and discover its handlers without custom
+// code:
+//
+//	{call, bert_rpc, ping, []}                 -> {reply, pong}
+//	{call, bert_rpc, list_functions, []}       -> {reply, [{Module, Function}, ...]}
+//	{call, bert_rpc, list_functions, [Module]} -> {reply, [{Module, Function}, ...]}
+//
+// Functions are listed sorted, including those of RPCModule. Registering a
+// handler for one of these functions replaces the built-in one.
+const (
+	RPCModule         = Atom("bert_rpc")
+	ListFunctionsAtom = Atom("list_functions")
+)
+
+// registerBuiltins registers the handlers of RPCModule.
+func (s *Server) registerBuiltins() {
+	s.Register(RPCModule, PingAtom, func(ctx context.Context, args []Term) (Term, error) {
+		return PongAtom, nil
+	})
+	s.Register(RPCModule, ListFunctionsAtom, s.listFunctions)
+}
+
+// listFunctions implements bert_rpc:list_functions.
+func (s *Server) listFunctions(ctx context.Context, args []Term) (Term, error) {
+	var only Atom
+	switch len(args) {
+	case 0:
+	case 1:
+		var ok bool
+		if only, ok = args[0].(Atom); ok {
+			break
+		}
+		fallthrough
+	default:
+		return nil, errors.New("list_functions takes no arguments or a module")
+	}
+
+	s.mu.RLock()
+	var names [][2]Atom
+	for module, functions := range s.modules {
+		if only != "" && module != only {
+			continue
+		}
+		for function := range functions {
+			names = append(names, [2]Atom{module, function})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(names, func(i, j int) bool {
+		if names[i][0] != names[j][0] {
+			return names[i][0] < names[j][0]
+		}
+		return names[i][1] < names[j][1]
+	})
+	list := make([]Term, len(names))
+	for i, name := range names {
+		list[i] = []Term{name[0], name[1]}
+	}
+	return List{Items: list}, nil
+}
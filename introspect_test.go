@@ -0,0 +1,39 @@
+package bert
+
+import (
+	"context"
+	"testing"
+)
+
+func TestServerIntrospection(t *testing.T) {
+	c := pipe(newTestServer())
+	defer c.Close()
+	ctx := context.Background()
+
+	result, err := c.Call(ctx, RPCModule, PingAtom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, PongAtom, result)
+
+	result, err = c.Call(ctx, RPCModule, ListFunctionsAtom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{
+		[]Term{RPCModule, ListFunctionsAtom},
+		[]Term{RPCModule, PingAtom},
+		[]Term{Atom("math"), Atom("add")},
+		[]Term{Atom("math"), Atom("fail")},
+	}, result)
+
+	result, err = c.Call(ctx, RPCModule, ListFunctionsAtom, Atom("math"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{[]Term{Atom("math"), Atom("add")}, []Term{Atom("math"), Atom("fail")}}, result)
+
+	if _, err := c.Call(ctx, RPCModule, ListFunctionsAtom, "math"); err == nil {
+		t.Errorf("expected an error listing the functions of a non-atom")
+	}
+}
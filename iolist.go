@@ -0,0 +1,131 @@
+package bert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// IOList is an Erlang iolist: an arbitrarily nested list of bytes, binaries
+// and strings. Items may hold byte values (any integer in 0..255), []byte,
+// string, IOList or []Term holding any of those.
+//
+// An IOList encodes as a single binary holding the flattened bytes, unless
+// Nested is set, in which case it keeps its structure and encodes as a list
+// of small integers, binaries and nested lists.
+type IOList struct {
+	Items  []Term
+	Nested bool
+}
+
+// Append adds items to the end of l and returns l to allow chaining.
+func (l *IOList) Append(items ...Term) *IOList {
+	l.Items = append(l.Items, items...)
+	return l
+}
+
+// Bytes returns the flattened contents of l.
+func (l IOList) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	err := flattenIOList(&buf, l.Items)
+	return buf.Bytes(), err
+}
+
+// Len returns the number of bytes in the flattened contents of l.
+func (l IOList) Len() (int, error) {
+	b, err := l.Bytes()
+	return len(b), err
+}
+
+// ioByte returns item as a byte if it is an integer in 0..255.
+func ioByte(item Term) (byte, bool) {
+	v := reflect.ValueOf(item)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n >= 0 && n <= 255 {
+			return byte(n), true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := v.Uint(); n <= 255 {
+			return byte(n), true
+		}
+	}
+	return 0, false
+}
+
+func flattenIOList(w io.Writer, items []Term) error {
+	for _, item := range items {
+		if b, ok := ioByte(item); ok {
+			write1(w, b)
+			continue
+		}
+
+		switch x := item.(type) {
+		case []byte:
+			w.Write(x)
+		case string:
+			io.WriteString(w, x)
+		case IOList:
+			if err := flattenIOList(w, x.Items); err != nil {
+				return err
+			}
+		case *IOList:
+			if err := flattenIOList(w, x.Items); err != nil {
+				return err
+			}
+		case []Term:
+			if err := flattenIOList(w, x); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid iolist element %#v", item)
+		}
+	}
+	return nil
+}
+
+func writeIOList(w io.Writer, l IOList) error {
+	if !l.Nested {
+		b, err := l.Bytes()
+		if err != nil {
+			return err
+		}
+		writeBinary(w, b)
+		return nil
+	}
+	return writeNestedIOList(w, l.Items)
+}
+
+func writeNestedIOList(w io.Writer, items []Term) error {
+	// validate everything up front so that errors never leave a
+	// half-written list behind
+	if err := flattenIOList(ioutil.Discard, items); err != nil {
+		return err
+	}
+
+	write1(w, ListTag)
+	write4(w, uint32(len(items)))
+	for _, item := range items {
+		if b, ok := ioByte(item); ok {
+			writeSmallInt(w, b)
+			continue
+		}
+
+		switch x := item.(type) {
+		case []byte:
+			writeBinary(w, x)
+		case string:
+			writeBinary(w, []byte(x))
+		case IOList:
+			writeNestedIOList(w, x.Items)
+		case *IOList:
+			writeNestedIOList(w, x.Items)
+		case []Term:
+			writeNestedIOList(w, x)
+		}
+	}
+	writeNil(w)
+	return nil
+}
@@ -0,0 +1,32 @@
+package bert
+
+import "testing"
+
+func TestIOList(t *testing.T) {
+	l := IOList{}
+	l.Append("ab", []byte{99}, 100, IOList{Items: []Term{byte(101), []Term{"f"}}})
+
+	b, err := l.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte("abcdef"), b)
+
+	assertEncode(t, l, []byte{131, 109, 0, 0, 0, 6, 97, 98, 99, 100, 101, 102})
+	assertEncode(t, []Term{Atom("a"), l}, []byte{131, 104, 2,
+		100, 0, 1, 97,
+		109, 0, 0, 0, 6, 97, 98, 99, 100, 101, 102,
+	})
+
+	l.Nested = true
+	assertEncode(t, l, []byte{131, 108, 0, 0, 0, 4,
+		109, 0, 0, 0, 2, 97, 98,
+		109, 0, 0, 0, 1, 99,
+		97, 100,
+		108, 0, 0, 0, 2, 97, 101, 108, 0, 0, 0, 1, 109, 0, 0, 0, 1, 102, 106, 106,
+		106,
+	})
+
+	assertNotEncode(t, IOList{Items: []Term{256}}, "cannot encode bert.IOList: invalid iolist element 256")
+	assertNotEncode(t, IOList{Items: []Term{Atom("a")}, Nested: true}, `cannot encode bert.IOList: invalid iolist element "a"`)
+}
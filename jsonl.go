@@ -0,0 +1,190 @@
+package bert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"unicode/utf8"
+)
+
+// JSONValue converts term, as returned by Decode, to a value that
+// encoding/json marshals as its natural JSON counterpart: integers and
+// floats as numbers, the atoms true and false as booleans, other atoms,
+// strings and UTF-8 binaries as strings, tuples and lists as arrays, and
+// maps as objects. Other binaries become
+// {"binary": Base64} objects, bitstrings {"bits": N, "binary": Base64},
+// improper lists {"list": Items, "tail": Tail}, and maps with keys that are
+// not atoms, strings, binaries or integers arrays of [Key, Value] pairs.
+// NaN and infinite floats become strings, and values of other types are
+// left for encoding/json to marshal.
+func JSONValue(term Term) interface{} {
+	switch x := term.(type) {
+	case nil, bool, string, int, int64, json.Number:
+		return x
+	case Atom:
+		if x == "true" || x == "false" {
+			return x == "true"
+		}
+		return string(x)
+	case Binary:
+		return string(x)
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return strconv.FormatFloat(x, 'g', -1, 64)
+		}
+		return x
+	case big.Int:
+		return json.Number(x.String())
+	case *big.Int:
+		return json.Number(x.String())
+	case []byte:
+		if utf8.Valid(x) {
+			return string(x)
+		}
+		return map[string]interface{}{"binary": base64.StdEncoding.EncodeToString(x)}
+	case Bitstring:
+		return map[string]interface{}{"bits": x.Bits, "binary": base64.StdEncoding.EncodeToString(x.Bytes)}
+	case []Term:
+		return jsonItems(x)
+	case List:
+		if l, ok := x.Tail.([]Term); x.Tail == nil || ok && len(l) == 0 {
+			return jsonItems(x.Items)
+		}
+		return map[string]interface{}{"list": jsonItems(x.Items), "tail": JSONValue(x.Tail)}
+	case TermMap:
+		return jsonEntries(x.Entries())
+	case []KV:
+		return jsonEntries(x)
+	case Verbatim:
+		return JSONValue(x.Term)
+	}
+
+	if v := reflect.ValueOf(term); v.Kind() == reflect.Map {
+		kvs := make([]KV, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			kvs = append(kvs, KV{iter.Key().Interface(), iter.Value().Interface()})
+		}
+		return jsonEntries(kvs)
+	}
+	return term
+}
+
+func jsonItems(items []Term) []interface{} {
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		values[i] = JSONValue(item)
+	}
+	return values
+}
+
+// jsonEntries converts the entries of a map to an object, or to an array
+// of pairs if some key cannot be an object key.
+func jsonEntries(kvs []KV) interface{} {
+	object := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		key, ok := jsonKey(kv.Key)
+		if !ok {
+			pairs := make([]interface{}, len(kvs))
+			for i, kv := range kvs {
+				pairs[i] = []interface{}{JSONValue(kv.Key), JSONValue(kv.Value)}
+			}
+			return pairs
+		}
+		object[key] = JSONValue(kv.Value)
+	}
+	return object
+}
+
+// jsonKey returns key as the key of a JSON object.
+func jsonKey(key Term) (string, bool) {
+	switch k := key.(type) {
+	case Atom:
+		return string(k), true
+	case string:
+		return k, true
+	case Binary:
+		return string(k), true
+	case []byte:
+		return string(k), utf8.Valid(k)
+	case int:
+		return strconv.Itoa(k), true
+	case int64:
+		return strconv.FormatInt(k, 10), true
+	}
+	return "", false
+}
+
+// JSONLinesOptions configure WriteJSONLines.
+type JSONLinesOptions struct {
+	// Unframed reads a stream of terms following each other, each starting
+	// with the version tag, instead of 4-byte length-prefixed frames.
+	Unframed bool
+
+	// MaxFrameSize limits the size of frames. Zero means
+	// DefaultMaxFrameSize.
+	MaxFrameSize int
+}
+
+// WriteJSONLines reads terms from r until its end and writes each to w, as
+// converted by JSONValue, on a line of its own, so that captures of BERT
+// traffic can be fed to JSON tools. Terms are converted as they are read,
+// holding a single one in memory at a time, and lines are written out
+// whenever reading more would wait. Empty frames are skipped.
+func WriteJSONLines(w io.Writer, r io.Reader, opts JSONLinesOptions) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	max := opts.MaxFrameSize
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+
+	// terms are decoded as they were sent, keeping the tails of improper
+	// lists
+	dec := &Decoder{Fidelity: true}
+	dec.Reset(br)
+	var frame bytes.Reader
+	var buf []byte
+	for n := 1; ; n++ {
+		var term Term
+		var err error
+		if opts.Unframed {
+			term, err = dec.Decode()
+		} else if buf, err = readFrame(br, buf, max); err == nil {
+			if len(buf) == 0 {
+				continue // as ends chunked responses
+			}
+			frame.Reset(buf)
+			dec.Reset(&frame)
+			if term, err = dec.Decode(); err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			bw.Flush()
+			return fmt.Errorf("term %d: %v", n, err)
+		}
+		if err := enc.Encode(JSONValue(term)); err != nil {
+			bw.Flush()
+			return fmt.Errorf("term %d: %v", n, err)
+		}
+		// lines are written out before waiting for more input
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package bert
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSONLines(t *testing.T) {
+	var stream bytes.Buffer
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	terms := []interface{}{
+		[]Term{Atom("reply"), 42, 1.5, huge},
+		map[Atom]Term{"name": Binary("ann"), "raw": []byte{0xff}, "ok": Atom("true")},
+		map[int]Term{7: List{Items: []Term{1}, Tail: Atom("t")}},
+		[]KV{{[]Term{1}, 2}},
+	}
+	for _, term := range terms {
+		MarshalResponse(&stream, term)
+	}
+	stream.Write([]byte{0, 0, 0, 0})
+
+	var out bytes.Buffer
+	if err := WriteJSONLines(&out, &stream, JSONLinesOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join([]string{
+		`["reply",42,1.5,123456789012345678901234567890]`,
+		`{"name":"ann","ok":true,"raw":{"binary":"/w=="}}`,
+		`{"7":{"list":[1],"tail":"t"}}`,
+		`[[[1],2]]`,
+		``,
+	}, "\n"), out.String())
+
+	assertEqual(t, "+Inf", JSONValue(math.Inf(1)))
+
+	// unframed terms, and errors naming the term at fault
+	var unframed bytes.Buffer
+	EncodeTo(&unframed, Atom("a"))
+	EncodeTo(&unframed, "b")
+	unframed.Write([]byte{131, 200})
+	out.Reset()
+	err := WriteJSONLines(&out, &unframed, JSONLinesOptions{Unframed: true})
+	if err == nil || !strings.HasPrefix(err.Error(), "term 3:") {
+		t.Errorf("expected error for term 3, got %v", err)
+	}
+	assertEqual(t, "\"a\"\n\"b\"\n", out.String())
+}
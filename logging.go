@@ -0,0 +1,15 @@
+package bert
+
+// A Logger receives diagnostic messages about events such as connections
+// opening and closing, malformed or oversized packets and slow calls.
+// *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf logs to l unless it is nil.
+func logf(l Logger, format string, v ...interface{}) {
+	if l != nil {
+		l.Printf(format, v...)
+	}
+}
@@ -0,0 +1,77 @@
+package bert
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger records the messages logged to it.
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+	l.mu.Unlock()
+}
+
+func (l *testLogger) contains(t *testing.T, substr string) {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.messages {
+		if strings.Contains(m, substr) {
+			return
+		}
+	}
+	t.Errorf("no message containing %q in %q", substr, l.messages)
+}
+
+func TestLogger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	slog := &testLogger{}
+	s := newTestServer()
+	s.Logger = slog
+	s.SlowCall = time.Millisecond
+	s.MaxRequestSize = 64
+	s.Register("sync", "sleep", func(ctx context.Context, args []Term) (Term, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	})
+
+	clog := &testLogger{}
+	a, b := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(b)
+		close(done)
+	}()
+	c := NewClient(a)
+	c.Logger = clog
+	c.SlowCall = time.Millisecond
+
+	if _, err := c.Call(ctx, "sync", "sleep"); err != nil {
+		t.Fatal(err)
+	}
+	c.Call(ctx, "math", "add", make([]Term, 100)...)
+	c.conn.WriteFrame(ctx, []byte{131, 1})
+	c.conn.ReadTerm(ctx)
+	c.Close()
+	<-done
+
+	slog.contains(t, "bert: connection from pipe opened")
+	slog.contains(t, "bert: slow call sync:sleep took")
+	slog.contains(t, "bert: request of 129 bytes from pipe exceeds 64 bytes")
+	slog.contains(t, "bert: decoding frame of 2 bytes from pipe: unknown type")
+	slog.contains(t, "bert: connection from pipe closed: unknown type")
+	clog.contains(t, "bert: slow call sync:sleep took")
+}
@@ -0,0 +1,122 @@
+package bert
+
+import "reflect"
+
+// A Var in a pattern matches any term and binds it to the variable's
+// name. A Var appearing more than once in a pattern only matches equal
+// terms, as in Erlang. The Var Wildcard matches anything without binding.
+type Var string
+
+// Wildcard is the pattern matching any term, like _ in Erlang.
+const Wildcard = Var("_")
+
+// Bindings holds the terms bound to the variables of a pattern by Match.
+type Bindings map[string]Term
+
+// A capture is a pattern storing the term it matches in a Go value.
+type capture struct {
+	ptr reflect.Value
+}
+
+// Bind returns a pattern matching the terms that Unmarshal can store in
+// the value ptr points to, and storing the matched term there when the
+// whole pattern matches.
+func Bind(ptr interface{}) Term {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		panic("bert: Bind of non-pointer")
+	}
+	return capture{ptr: v}
+}
+
+// A matcher holds the state of matching a term against a pattern.
+type matcher struct {
+	vars   Bindings
+	stores []func()
+}
+
+// Match reports whether term, as returned by Decode, matches pattern.
+// Patterns are terms that may contain Var, Wildcard and Bind values:
+// tuples and lists match element by element, maps match terms holding at
+// least their keys with matching values, and other terms match equal
+// terms as by Equal. For example
+//
+//	var result int
+//	Match(term, []Term{Atom("reply"), Bind(&result)}, nil)
+//
+// matches {reply, 42} and sets result to 42. On a match the variables of
+// the pattern are added to *bindings, if bindings is not nil, and Bind
+// values are stored; otherwise neither is changed.
+func Match(term, pattern Term, bindings *Bindings) bool {
+	m := &matcher{vars: Bindings{}}
+	if !m.match(term, pattern) {
+		return false
+	}
+	if bindings != nil {
+		if *bindings == nil {
+			*bindings = make(Bindings, len(m.vars))
+		}
+		for name, value := range m.vars {
+			(*bindings)[name] = value
+		}
+	}
+	for _, store := range m.stores {
+		store()
+	}
+	return true
+}
+
+func (m *matcher) match(term, pattern Term) bool {
+	switch p := pattern.(type) {
+	case Var:
+		if p == Wildcard {
+			return true
+		}
+		if bound, ok := m.vars[string(p)]; ok {
+			return Equal(bound, term)
+		}
+		m.vars[string(p)] = term
+		return true
+	case capture:
+		v := reflect.New(p.ptr.Type().Elem())
+		if UnmarshalTerm(term, v.Interface()) != nil {
+			return false
+		}
+		m.stores = append(m.stores, func() { p.ptr.Elem().Set(v.Elem()) })
+		return true
+	case []Term:
+		items, ok := term.([]Term)
+		return ok && m.matchItems(items, p)
+	case List:
+		items, ok := listOf(term)
+		return ok && p.Tail == nil && m.matchItems(items, p.Items)
+	}
+
+	if pv := termValue(pattern); kindOf(pv) == MapKind {
+		tv := termValue(term)
+		if kindOf(tv) != MapKind {
+			return false
+		}
+		tm := termMapOf(tv)
+		for _, kv := range termMapOf(pv).entries {
+			value, ok := tm.Get(kv.Key)
+			if !ok || !m.match(value, kv.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return Equal(term, pattern)
+}
+
+func (m *matcher) matchItems(items, patterns []Term) bool {
+	if len(items) != len(patterns) {
+		return false
+	}
+	for i := range items {
+		if !m.match(items[i], patterns[i]) {
+			return false
+		}
+	}
+	return true
+}
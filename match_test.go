@@ -0,0 +1,43 @@
+package bert
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	reply := []Term{Atom("reply"), []Term{int64(42), []byte("ok")}}
+
+	var b Bindings
+	if !Match(reply, []Term{Atom("reply"), []Term{Var("n"), Wildcard}}, &b) {
+		t.Fatal("expected match")
+	}
+	assertEqual(t, Bindings{"n": int64(42)}, b)
+
+	var n int
+	var text string
+	if !Match(reply, []Term{Atom("reply"), []Term{Bind(&n), Bind(&text)}}, nil) {
+		t.Fatal("expected match with captures")
+	}
+	assertEqual(t, 42, n)
+	assertEqual(t, "ok", text)
+
+	var a Atom
+	tests := []Term{
+		[]Term{Atom("error"), Wildcard},
+		[]Term{Atom("reply")},
+		[]Term{Atom("reply"), []Term{Bind(&a), Wildcard}},
+		[]Term{Var("x"), []Term{Var("x"), Wildcard}},
+	}
+	for _, pattern := range tests {
+		if Match(reply, pattern, &b) {
+			t.Errorf("expected %v not to match", pattern)
+		}
+	}
+	assertEqual(t, Atom(""), a)
+	assertEqual(t, Bindings{"n": int64(42)}, b)
+
+	assertEqual(t, true, Match([]Term{int64(1), int64(1)}, []Term{Var("x"), Var("x")}, nil))
+
+	m := NewTermMap(KV{Atom("id"), int64(7)}, KV{Atom("name"), []byte("joe")})
+	assertEqual(t, true, Match(m, map[Atom]Term{"id": Var("id")}, &b))
+	assertEqual(t, int64(7), b["id"])
+	assertEqual(t, false, Match(m, map[Atom]Term{"age": Wildcard}, nil))
+}
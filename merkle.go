@@ -0,0 +1,274 @@
+package bert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"reflect"
+	"sort"
+)
+
+// Prefixes of the data hashed for leaves and inner nodes of a MerkleTree,
+// so that one cannot pass for the other.
+const (
+	merkleLeaf = 0
+	merkleNode = 1
+)
+
+// The kinds of terms whose elements a MerkleTree holds, hashed into each
+// of its nodes, so that terms with the same elements have distinct trees.
+const (
+	merkleTerm = iota
+	merkleTuple
+	merkleList
+	merkleMap
+)
+
+// MerkleOptions configure the MerkleTree built by NewMerkleTree.
+type MerkleOptions struct {
+	// Hash returns the hash of the tree. Nil means sha256.New.
+	Hash func() hash.Hash
+
+	// ChunkSize is the number of elements, or map entries, of each leaf.
+	// Zero means one.
+	ChunkSize int
+}
+
+func (o MerkleOptions) newHash() hash.Hash {
+	if o.Hash == nil {
+		return sha256.New()
+	}
+	return o.Hash()
+}
+
+// A MerkleTree hashes the elements of a term in chunks, its leaves, so
+// that a chunk received on its own can be verified against the root hash
+// of the whole term with a MerkleProof, as when a large term is
+// transmitted piecewise.
+//
+// The elements of a tuple or list are taken in order, and the entries of a
+// map sorted by their encoded keys. Each leaf holds the canonical encoding
+// of a chunk of ChunkSize of them, as a list or, for maps, a map, and other
+// terms have a single leaf holding their encoding. Each node is hashed
+// with a 0 byte prefixed for leaves and a 1 for inner nodes, followed by
+// the container of the elements: a byte telling tuples, lists, maps and
+// other terms apart and, for improper lists, the canonical encoding of
+// their tail. The last node of a level with an odd number of nodes moves
+// up a level unchanged.
+type MerkleTree struct {
+	opts      MerkleOptions
+	container []byte
+	leaves    [][]byte
+	levels    [][][]byte // of hashes, from the leaves up to the root
+}
+
+// A MerkleProof proves that a leaf belongs to a MerkleTree. It holds the
+// container hashed into each node of the tree and the hashes of the
+// siblings of the nodes on the path from the leaf to the root.
+type MerkleProof struct {
+	Index     int // of the leaf
+	Leaves    int // of the tree
+	Container []byte
+	Siblings  [][]byte
+}
+
+// NewMerkleTree builds the Merkle tree of term.
+func NewMerkleTree(term Term, opts MerkleOptions) (*MerkleTree, error) {
+	elems, kind, tail, err := merkleElements(term)
+	if err != nil {
+		return nil, err
+	}
+	container := []byte{kind}
+	if tail != nil {
+		enc, err := encodeCanonical(tail)
+		if err != nil {
+			return nil, err
+		}
+		container = append(container, enc...)
+	}
+	size := opts.ChunkSize
+	if size <= 0 {
+		size = 1
+	}
+
+	t := &MerkleTree{opts: opts, container: container}
+	if len(elems) == 0 {
+		leaf, err := encodeCanonical(term)
+		if err != nil {
+			return nil, err
+		}
+		t.leaves = [][]byte{leaf}
+	}
+	for start := 0; start < len(elems); start += size {
+		end := start + size
+		if end > len(elems) {
+			end = len(elems)
+		}
+		var chunk interface{} = List{Items: elems[start:end]}
+		if kind == merkleMap {
+			kvs := make([]KV, end-start)
+			for i, e := range elems[start:end] {
+				kvs[i] = e.(KV)
+			}
+			chunk = kvs
+		}
+		leaf, err := encodeCanonical(chunk)
+		if err != nil {
+			return nil, err
+		}
+		t.leaves = append(t.leaves, leaf)
+	}
+
+	level := make([][]byte, len(t.leaves))
+	for i, leaf := range t.leaves {
+		level[i] = merkleHash(opts, merkleLeaf, container, leaf, nil)
+	}
+	t.levels = append(t.levels, level)
+	for len(level) > 1 {
+		up := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				up = append(up, level[i])
+			} else {
+				up = append(up, merkleHash(opts, merkleNode, container, level[i], level[i+1]))
+			}
+		}
+		t.levels = append(t.levels, up)
+		level = up
+	}
+	return t, nil
+}
+
+// merkleElements returns the elements of term, the kind of term holding
+// them and the tail of an improper list. The elements of maps are KV
+// entries sorted by their encoded keys. Slices other than binaries are
+// tuples and arrays lists, as Encoder writes them.
+func merkleElements(term Term) ([]Term, byte, Term, error) {
+	switch x := term.(type) {
+	case List:
+		return x.Items, merkleList, x.Tail, nil
+	case TermMap:
+		elems, err := sortedEntries(x.Entries())
+		return elems, merkleMap, nil, err
+	case []KV:
+		elems, err := sortedEntries(x)
+		return elems, merkleMap, nil, err
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(term))
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil, merkleTerm, nil, nil // a binary
+		}
+		elems := make([]Term, v.Len())
+		for i := range elems {
+			elems[i] = v.Index(i).Interface()
+		}
+		if v.Kind() == reflect.Array {
+			return elems, merkleList, nil, nil
+		}
+		return elems, merkleTuple, nil, nil
+	case reflect.Map:
+		kvs := make([]KV, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			kvs = append(kvs, KV{iter.Key().Interface(), iter.Value().Interface()})
+		}
+		elems, err := sortedEntries(kvs)
+		return elems, merkleMap, nil, err
+	}
+	return nil, merkleTerm, nil, nil
+}
+
+// sortedEntries returns the entries of a map as elements sorted by their
+// encoded keys.
+func sortedEntries(kvs []KV) ([]Term, error) {
+	keys := make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		key, err := encodeCanonical(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	elems := make([]Term, len(kvs))
+	order := make([]int, len(kvs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return bytes.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+	for i, j := range order {
+		elems[i] = kvs[j]
+	}
+	return elems, nil
+}
+
+// merkleHash returns the hash of a node of the given kind holding a and b,
+// within a tree of the given container.
+func merkleHash(opts MerkleOptions, kind byte, container, a, b []byte) []byte {
+	h := opts.newHash()
+	h.Write([]byte{kind})
+	h.Write(container)
+	h.Write(a)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// Root returns the root hash of the tree.
+func (t *MerkleTree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Len returns the number of leaves of the tree.
+func (t *MerkleTree) Len() int {
+	return len(t.leaves)
+}
+
+// Leaf returns the canonical encoding of the chunk of leaf i, which decodes
+// as a list of elements or a map of entries.
+func (t *MerkleTree) Leaf(i int) []byte {
+	return t.leaves[i]
+}
+
+// Proof returns the proof that leaf i belongs to the tree.
+func (t *MerkleTree) Proof(i int) MerkleProof {
+	p := MerkleProof{Index: i, Leaves: len(t.leaves), Container: t.container}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if i%2 == 1 {
+			p.Siblings = append(p.Siblings, level[i-1])
+		} else if i+1 < len(level) {
+			p.Siblings = append(p.Siblings, level[i+1])
+		}
+		i /= 2
+	}
+	return p
+}
+
+// Verify reports whether leaf, the encoding of a chunk as returned by
+// MerkleTree.Leaf, belongs with proof to the tree of the given root hash,
+// built with the same options.
+func (o MerkleOptions) Verify(root, leaf []byte, proof MerkleProof) bool {
+	if proof.Index < 0 || proof.Index >= proof.Leaves {
+		return false
+	}
+	h := merkleHash(o, merkleLeaf, proof.Container, leaf, nil)
+	i, n, siblings := proof.Index, proof.Leaves, proof.Siblings
+	for ; n > 1; i, n = i/2, (n+1)/2 {
+		if i%2 == 0 && i+1 == n {
+			continue // moved up unchanged
+		}
+		if len(siblings) == 0 {
+			return false
+		}
+		if i%2 == 1 {
+			h = merkleHash(o, merkleNode, proof.Container, siblings[0], h)
+		} else {
+			h = merkleHash(o, merkleNode, proof.Container, h, siblings[0])
+		}
+		siblings = siblings[1:]
+	}
+	return len(siblings) == 0 && bytes.Equal(h, root)
+}
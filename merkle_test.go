@@ -0,0 +1,119 @@
+package bert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMerkleTree(t *testing.T) {
+	items := make([]Term, 10)
+	for i := range items {
+		items[i] = []Term{Atom("block"), i}
+	}
+	opts := MerkleOptions{ChunkSize: 3}
+	tree, err := NewMerkleTree(List{Items: items}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 4, tree.Len())
+	root := tree.Root()
+
+	for i := 0; i < tree.Len(); i++ {
+		if !opts.Verify(root, tree.Leaf(i), tree.Proof(i)) {
+			t.Errorf("leaf %d not verified", i)
+		}
+	}
+	chunk, err := Decode(tree.Leaf(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{[]Term{Atom("block"), int64(9)}}, chunk)
+
+	// a leaf does not verify altered, in another position or as another
+	// leaf's
+	leaf := append([]byte(nil), tree.Leaf(1)...)
+	leaf[len(leaf)-1]++
+	if opts.Verify(root, leaf, tree.Proof(1)) {
+		t.Error("altered leaf verified")
+	}
+	proof := tree.Proof(1)
+	proof.Index = 0
+	if opts.Verify(root, tree.Leaf(1), proof) {
+		t.Error("leaf verified in another position")
+	}
+	if opts.Verify(root, tree.Leaf(2), tree.Proof(1)) {
+		t.Error("leaf verified with another proof")
+	}
+
+	// other chunk sizes give other roots
+	other, err := NewMerkleTree(items, MerkleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 10, other.Len())
+	if bytes.Equal(root, other.Root()) {
+		t.Error("roots of different trees equal")
+	}
+}
+
+func TestMerkleTreeMap(t *testing.T) {
+	a, err := NewMerkleTree(map[string]int{"a": 1, "b": 2, "c": 3}, MerkleOptions{ChunkSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMerkleTree([]KV{{"c", 3}, {"a", 1}, {"b", 2}}, MerkleOptions{ChunkSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, a.Root(), b.Root())
+	assertEqual(t, 2, a.Len())
+
+	// terms without elements have a single leaf
+	s, err := NewMerkleTree(Binary("blob"), MerkleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, s.Len())
+	if !(MerkleOptions{}).Verify(s.Root(), s.Leaf(0), s.Proof(0)) {
+		t.Error("single leaf not verified")
+	}
+}
+
+func TestMerkleTreeContainers(t *testing.T) {
+	// Tuples, proper and improper lists with the same elements differ.
+	terms := []Term{
+		[]Term{1, 2},
+		List{Items: []Term{1, 2}},
+		List{Items: []Term{1}, Tail: 2},
+		List{Items: []Term{1, 2}, Tail: 3},
+		[]Term{1},
+		List{Items: []Term{1}},
+	}
+	roots := make([][]byte, len(terms))
+	for i, term := range terms {
+		tree, err := NewMerkleTree(term, MerkleOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots[i] = tree.Root()
+		for j := 0; j < tree.Len(); j++ {
+			if !(MerkleOptions{}).Verify(roots[i], tree.Leaf(j), tree.Proof(j)) {
+				t.Errorf("%v: leaf %d not verified", term, j)
+			}
+		}
+		for j := 0; j < i; j++ {
+			if bytes.Equal(roots[i], roots[j]) {
+				t.Errorf("%v and %v have the same root", terms[j], term)
+			}
+		}
+	}
+
+	// A leaf does not verify as one of a term of another kind.
+	tuple, _ := NewMerkleTree(terms[0], MerkleOptions{})
+	list, _ := NewMerkleTree(terms[1], MerkleOptions{})
+	proof := list.Proof(0)
+	proof.Container = tuple.Proof(0).Container
+	if (MerkleOptions{}).Verify(list.Root(), list.Leaf(0), proof) {
+		t.Error("leaf verified with another container")
+	}
+}
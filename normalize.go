@@ -0,0 +1,138 @@
+package bert
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// NormalizeRules selects the rewrites Normalize applies. Its zero value
+// rewrites nothing.
+type NormalizeRules struct {
+	// CharlistsToStrings turns charlists into Go strings: STRING_EXT
+	// terms, whose bytes are Latin-1 code points, and lists of integers
+	// that are all printable Unicode code points or whitespace, as
+	// Erlang's io_lib:printable_unicode_list/1 decides. Since Decode
+	// returns tuples as []Term too, tuples of such integers are also
+	// rewritten.
+	CharlistsToStrings bool
+
+	// BinariesToStrings turns binaries holding valid UTF-8 into strings.
+	BinariesToStrings bool
+
+	// Booleans turns the atoms true and false into Go bools.
+	Booleans bool
+
+	// ProplistsToMaps turns non-empty lists whose elements are all
+	// {Key, Value} pairs with atom keys into a TermMap. As with
+	// proplists:get_value/2, the first entry for a key wins. Bare atoms,
+	// which proplists also allow, are not recognized, so that tuples such
+	// as {ok, {Key, Value}} are left alone.
+	ProplistsToMaps bool
+}
+
+// Normalize returns a copy of term, as returned by Decode, rewritten
+// according to r, to give the many equivalent Erlang representations of
+// a value a single Go shape. Values of other types are returned unchanged.
+func Normalize(term Term, r NormalizeRules) Term {
+	switch x := term.(type) {
+	case string:
+		if r.CharlistsToStrings {
+			return string(latin1Charlist(x))
+		}
+	case []byte:
+		if r.BinariesToStrings && utf8.Valid(x) {
+			return string(x)
+		}
+	case Atom:
+		if r.Booleans && (x == TrueAtom || x == FalseAtom) {
+			return x == TrueAtom
+		}
+	case []Term:
+		return r.normalizeItems(x)
+	case List:
+		if x.Tail != nil {
+			return List{Items: r.normalizeList(x.Items), Tail: Normalize(x.Tail, r)}
+		}
+		return r.normalizeItems(x.Items)
+	case TermMap:
+		return NewTermMap(r.normalizeEntries(x.entries)...)
+	case []KV:
+		return r.normalizeEntries(x)
+	}
+	return term
+}
+
+// normalizeItems normalizes the elements of a list or tuple, which may
+// itself be rewritten as a whole.
+func (r NormalizeRules) normalizeItems(items []Term) Term {
+	if r.CharlistsToStrings {
+		if s, ok := printableString(items); ok {
+			return s
+		}
+	}
+	if r.ProplistsToMaps {
+		if m, ok := r.proplistMap(items); ok {
+			return m
+		}
+	}
+	return r.normalizeList(items)
+}
+
+func (r NormalizeRules) normalizeList(items []Term) []Term {
+	normalized := make([]Term, len(items))
+	for i, item := range items {
+		normalized[i] = Normalize(item, r)
+	}
+	return normalized
+}
+
+func (r NormalizeRules) normalizeEntries(entries []KV) []KV {
+	normalized := make([]KV, len(entries))
+	for i, kv := range entries {
+		normalized[i] = KV{Normalize(kv.Key, r), Normalize(kv.Value, r)}
+	}
+	return normalized
+}
+
+// printableString returns the string of a non-empty list of printable
+// code points.
+func printableString(items []Term) (string, bool) {
+	if len(items) == 0 {
+		return "", false
+	}
+	runes := make([]rune, len(items))
+	for i, item := range items {
+		n, ok := item.(int64)
+		if !ok || n < 0 || n > unicode.MaxRune {
+			return "", false
+		}
+		c := rune(n)
+		if !unicode.IsPrint(c) && !unicode.IsSpace(c) {
+			return "", false
+		}
+		runes[i] = c
+	}
+	return string(runes), true
+}
+
+// proplistMap returns the map of a proplist.
+func (r NormalizeRules) proplistMap(items []Term) (TermMap, bool) {
+	if len(items) == 0 {
+		return TermMap{}, false
+	}
+	var m TermMap
+	for _, item := range items {
+		pair, ok := item.([]Term)
+		if !ok || len(pair) != 2 {
+			return TermMap{}, false
+		}
+		key, ok := pair[0].(Atom)
+		if !ok {
+			return TermMap{}, false
+		}
+		if _, ok := m.Get(key); !ok {
+			m.Set(key, Normalize(pair[1], r))
+		}
+	}
+	return m, true
+}
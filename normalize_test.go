@@ -0,0 +1,41 @@
+package bert
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	all := NormalizeRules{
+		CharlistsToStrings: true,
+		BinariesToStrings:  true,
+		Booleans:           true,
+		ProplistsToMaps:    true,
+	}
+
+	tests := []struct {
+		rules NormalizeRules
+		term  Term
+		want  Term
+	}{
+		{all, "h\xe9", "hé"},
+		{all, []Term{int64(8364), int64(49)}, "€1"},
+		{all, []Term{int64(1), int64(2)}, []Term{int64(1), int64(2)}},
+		{all, []byte("héllo"), "héllo"},
+		{all, []byte{0xff}, []byte{0xff}},
+		{all, Atom("true"), true},
+		{all, []Term{Atom("ok"), Atom("false")}, []Term{Atom("ok"), false}},
+		{all, []Term{Atom("ok"), Atom("error")}, []Term{Atom("ok"), Atom("error")}},
+		{all, []Term{
+			[]Term{Atom("name"), []byte("joe")},
+			[]Term{Atom("admin"), Atom("true")},
+			[]Term{Atom("name"), []byte("bob")},
+		}, NewTermMap(KV{Atom("name"), "joe"}, KV{Atom("admin"), true})},
+		{all, []Term{Atom("ok"), []Term{Atom("id"), int64(1)}},
+			[]Term{Atom("ok"), []Term{Atom("id"), int64(1)}}},
+		{NormalizeRules{}, []byte("joe"), []byte("joe")},
+		{NormalizeRules{Booleans: true}, NewTermMap(KV{[]byte("on"), Atom("false")}),
+			NewTermMap(KV{[]byte("on"), false})},
+	}
+
+	for _, test := range tests {
+		assertEqual(t, test.want, Normalize(test.term, test.rules))
+	}
+}
@@ -0,0 +1,156 @@
+package bert
+
+import (
+	"io"
+	"reflect"
+	"time"
+)
+
+// CodecStats describes a single term read by a Decoder or written by an
+// Encoder, as reported to their Observe hooks.
+type CodecStats struct {
+	// Bytes is the size of the term as read or written, including the
+	// version byte. For compressed terms it is the compressed size.
+	Bytes int
+
+	// Tags counts the occurrences of each tag in the term's uncompressed
+	// encoding, such as SmallIntTag or BinTag. The tail of a proper list
+	// is not counted.
+	Tags map[byte]int
+
+	// MaxDepth is the nesting depth of the term: 1 for a term without
+	// elements, 2 for a tuple or list of such terms, and so on.
+	MaxDepth int
+
+	// Duration is the time taken to read and decode, or to encode and
+	// write, the term.
+	Duration time.Duration
+}
+
+func newCodecStats() CodecStats {
+	return CodecStats{Tags: make(map[byte]int)}
+}
+
+// enter records a term with the given tag at depth.
+func (s *CodecStats) enter(tag byte, depth int) {
+	s.Tags[tag]++
+	if depth > s.MaxDepth {
+		s.MaxDepth = depth
+	}
+}
+
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+type countingWriter struct {
+	io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += n
+	return n, err
+}
+
+// decodeObserved decodes a term, reporting its statistics to d.Observe.
+func (d *Decoder) decodeObserved() (Term, error) {
+	start := time.Now()
+	stats := newCodecStats()
+	r := &countingReader{Reader: d.r}
+	saved := d.r
+	d.r, d.stats, d.depth = r, &stats, 0
+	defer func() { d.r, d.stats = saved, nil }()
+
+	tag, err := d.readStart()
+	if err != nil {
+		return nil, err
+	}
+	term, err := d.readTagged(tag)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	stats.Bytes = r.n
+	stats.Duration = time.Since(start)
+	d.Observe(stats)
+	return term, nil
+}
+
+// readObserved reads a term with the given tag, recording it in d.stats.
+func (d *Decoder) readObserved(tag int) (Term, error) {
+	d.depth++
+	d.stats.enter(byte(tag), d.depth)
+	term, err := d.readValue(tag)
+	d.depth--
+	return term, err
+}
+
+// encodeObserved encodes val, reporting its statistics to e.Observe.
+func (e *Encoder) encodeObserved(val interface{}) error {
+	start := time.Now()
+	data, err := e.encodeValue(reflect.ValueOf(val))
+	if err != nil {
+		return err
+	}
+
+	stats := newCodecStats()
+	if _, err := scanStats(data, 1, &stats); err != nil {
+		return err
+	}
+	w := &countingWriter{Writer: e.w}
+	if e.compress() {
+		err = writeCompressed(w, data, e.CompressThreshold, !e.NoVersion)
+	} else {
+		if !e.NoVersion {
+			write1(w, VersionTag)
+		}
+		_, err = w.Write(data)
+	}
+	if err != nil {
+		return err
+	}
+	stats.Bytes = w.n
+	stats.Duration = time.Since(start)
+	e.Observe(stats)
+	return nil
+}
+
+// scanStats records the term encoded at the start of b, at the given depth,
+// in stats and returns its size.
+func scanStats(b []byte, depth int, stats *CodecStats) (int, error) {
+	size, children, ok, err := termHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, io.ErrUnexpectedEOF
+	}
+	stats.enter(b[0], depth)
+
+	if b[0] == ListTag {
+		children--
+	}
+	for i := 0; i < children; i++ {
+		n, err := scanStats(b[size:], depth+1, stats)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	if b[0] == ListTag {
+		if len(b) > size && b[size] == NilTag {
+			return size + 1, nil
+		}
+		n, err := scanStats(b[size:], depth+1, stats)
+		return size + n, err
+	}
+	return size, nil
+}
@@ -0,0 +1,81 @@
+package bert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecoderObserve(t *testing.T) {
+	data := []byte{131, 104, 2,
+		100, 0, 2, 111, 107,
+		108, 0, 0, 0, 2, 97, 1, 109, 0, 0, 0, 1, 2, 106,
+	}
+
+	var got []CodecStats
+	d := NewDecoder(bytes.NewReader(data))
+	d.Observe = func(s CodecStats) { got = append(got, s) }
+	term, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("ok"), []Term{int64(1), []byte{2}}}, term)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 observation, got %d", len(got))
+	}
+	assertEqual(t, len(data), got[0].Bytes)
+	assertEqual(t, map[byte]int{SmallTupleTag: 1, AtomTag: 1, ListTag: 1, SmallIntTag: 1, BinTag: 1}, got[0].Tags)
+	assertEqual(t, 3, got[0].MaxDepth)
+
+	d = NewDecoder(bytes.NewReader([]byte{131, 104, 1, 255}))
+	d.Observe = func(s CodecStats) { t.Errorf("unexpected observation of a malformed term") }
+	if _, err := d.Decode(); err == nil {
+		t.Errorf("expected error decoding a malformed term")
+	}
+}
+
+func TestEncoderObserve(t *testing.T) {
+	val := []Term{Atom("ok"), [2]Term{1, []byte{2}}}
+	want, err := Encode(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []CodecStats
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Observe = func(s CodecStats) { got = append(got, s) }
+	if err := e.Encode(val); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, want, buf.Bytes())
+	if len(got) != 1 {
+		t.Fatalf("expected 1 observation, got %d", len(got))
+	}
+	assertEqual(t, len(want), got[0].Bytes)
+	assertEqual(t, map[byte]int{SmallTupleTag: 1, AtomTag: 1, ListTag: 1, SmallIntTag: 1, BinTag: 1}, got[0].Tags)
+	assertEqual(t, 3, got[0].MaxDepth)
+}
+
+func TestObserveCompressed(t *testing.T) {
+	val := strings.Repeat("a", 1000)
+
+	var buf bytes.Buffer
+	var written, read CodecStats
+	e := NewEncoder(&buf)
+	e.CompressThreshold = 100
+	e.Observe = func(s CodecStats) { written = s }
+	if err := e.Encode(val); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, buf.Len(), written.Bytes)
+
+	d := NewDecoder(&buf)
+	d.Observe = func(s CodecStats) { read = s }
+	if _, err := d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, written.Bytes, read.Bytes)
+	assertEqual(t, map[byte]int{StringTag: 1}, written.Tags)
+	assertEqual(t, map[byte]int{StringTag: 1}, read.Tags)
+}
@@ -0,0 +1,65 @@
+package bert
+
+import "reflect"
+
+// An Option holds a value of type T or nothing, as Erlang APIs express
+// with the atom undefined. An empty Option encodes as undefined whatever
+// the Encoder's NilPolicy, and Unmarshal stores undefined, nil (the atom
+// or a nil term) in an Option as the empty state. Any other term is
+// stored in the value.
+//
+// The zero value is empty.
+type Option[T any] struct {
+	value T
+	ok    bool
+}
+
+// Some returns an Option holding v.
+func Some[T any](v T) Option[T] {
+	return Option[T]{value: v, ok: true}
+}
+
+// Get returns the value held by o and whether there is one.
+func (o Option[T]) Get() (T, bool) {
+	return o.value, o.ok
+}
+
+// OrElse returns the value held by o, or def if it is empty.
+func (o Option[T]) OrElse(def T) T {
+	if !o.ok {
+		return def
+	}
+	return o.value
+}
+
+// optionTerm returns the value of o as a term, and whether there is one.
+func (o Option[T]) optionTerm() (Term, bool) {
+	return o.value, o.ok
+}
+
+// setOption empties o if present is false, and otherwise marks it as
+// holding a value and returns the value to fill in.
+func (o *Option[T]) setOption(present bool) reflect.Value {
+	var zero T
+	o.value, o.ok = zero, present
+	return reflect.ValueOf(&o.value).Elem()
+}
+
+// An optional is an Option of any type.
+type optional interface {
+	optionTerm() (Term, bool)
+}
+
+// An optionalPtr is a pointer to an Option of any type.
+type optionalPtr interface {
+	setOption(present bool) reflect.Value
+}
+
+// isAbsent reports whether term stands for an empty Option.
+func isAbsent(term Term) bool {
+	switch term {
+	case nil, UndefinedAtom, NilAtom:
+		return true
+	}
+	return false
+}
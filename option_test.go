@@ -0,0 +1,48 @@
+package bert
+
+import "testing"
+
+type optionUser struct {
+	Name  string
+	Email Option[string]
+	Age   Option[int]
+}
+
+func TestOption(t *testing.T) {
+	data := []byte{131, 104, 3,
+		107, 0, 3, 106, 111, 101,
+		100, 0, 9, 117, 110, 100, 101, 102, 105, 110, 101, 100,
+		97, 30,
+	}
+	u := optionUser{Name: "joe", Age: Some(30)}
+	assertEncode(t, u, data)
+
+	var back optionUser
+	back.Email = Some("stale")
+	if err := Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, u, back)
+	assertEqual(t, "none", back.Email.OrElse("none"))
+	if age, ok := back.Age.Get(); !ok || age != 30 {
+		t.Errorf("expected age 30, got %v, %v", age, ok)
+	}
+
+	for _, term := range []Term{NilAtom, UndefinedAtom, nil} {
+		o := Some(1)
+		if err := UnmarshalTerm(term, &o); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := o.Get(); ok {
+			t.Errorf("expected %v to empty the option", term)
+		}
+	}
+
+	var o Option[int]
+	if err := UnmarshalTerm(Atom("other"), &o); err == nil {
+		t.Errorf("expected error unmarshaling an atom into Option[int]")
+	}
+
+	assertEqual(t, true, Equal(Option[int]{}, UndefinedAtom))
+	assertEqual(t, true, Equal(Some(int64(1)), 1))
+}
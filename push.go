@@ -0,0 +1,201 @@
+package bert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrNeedMore is returned by PushDecoder.Next when the data fed so far
+// does not yet hold a complete term.
+var ErrNeedMore = errors.New("need more data")
+
+// A PushDecoder decodes terms from data pushed into it in arbitrary chunks,
+// for event-driven code that cannot block in an io.Reader. Terms are
+// returned by Next once all of their bytes have arrived.
+//
+// The decoder tracks its progress through a partially received term, so
+// each byte is only scanned once however the input is split.
+type PushDecoder struct {
+	// Decoder holds the options used to decode each complete term. Its
+	// intern tables persist across terms.
+	Decoder *Decoder
+
+	buf     []byte
+	reader  bytes.Reader
+	pos     int   // scanned bytes of the current term
+	pending []int // elements still expected by each open container
+	started bool  // whether the version byte has been scanned
+}
+
+// NewPushDecoder returns an empty PushDecoder.
+func NewPushDecoder() *PushDecoder {
+	p := &PushDecoder{}
+	p.Decoder = NewDecoder(&p.reader)
+	return p
+}
+
+// Feed appends data to the decoder's input. The data is copied.
+func (p *PushDecoder) Feed(data []byte) {
+	p.buf = append(p.buf, data...)
+}
+
+// Buffered returns the number of bytes fed but not yet returned as terms.
+func (p *PushDecoder) Buffered() int { return len(p.buf) }
+
+// Next returns the next complete term, or ErrNeedMore if more data must be
+// fed first. Any other error means the input is malformed and the decoder
+// cannot make further progress.
+func (p *PushDecoder) Next() (Term, error) {
+	complete, err := p.scan()
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		return nil, ErrNeedMore
+	}
+
+	n := p.pos
+	p.reader.Reset(p.buf[:n])
+	p.Decoder.r = &p.reader
+	term, err := p.Decoder.Decode()
+
+	p.buf = p.buf[n:]
+	if len(p.buf) == 0 {
+		p.buf = nil
+	}
+	p.pos, p.pending, p.started = 0, p.pending[:0], false
+	return term, err
+}
+
+// scan advances through the buffered input and reports whether a complete
+// term is available.
+func (p *PushDecoder) scan() (bool, error) {
+	if !p.started {
+		if len(p.buf) == 0 {
+			return false, nil
+		}
+		if p.buf[0] != VersionTag {
+			return false, ErrBadMagic
+		}
+		p.pos, p.started = 1, true
+		p.pending = append(p.pending[:0], 1)
+	}
+
+	if p.pos == 1 && len(p.buf) > 1 && p.buf[1] == CompressedTag {
+		// the end of compressed data is only found by decompressing it,
+		// which starts over each time more data arrives
+		if p.Decoder.RejectCompressed {
+			return false, errCompressedRefused
+		}
+		size, ok, err := compressedSize(p.buf[1:], p.Decoder.MaxUncompressedSize)
+		if err != nil || !ok {
+			return false, err
+		}
+		p.pos += size
+		p.pending = p.pending[:0]
+		return true, nil
+	}
+
+	for len(p.pending) > 0 {
+		size, children, ok, err := termHeader(p.buf[p.pos:])
+		if err != nil || !ok {
+			return false, err
+		}
+		p.pos += size
+
+		if children > 0 {
+			p.pending = append(p.pending, children)
+			continue
+		}
+		// the element is complete, as may be its enclosing containers
+		for len(p.pending) > 0 {
+			p.pending[len(p.pending)-1]--
+			if p.pending[len(p.pending)-1] > 0 {
+				break
+			}
+			p.pending = p.pending[:len(p.pending)-1]
+		}
+	}
+	return true, nil
+}
+
+// termHeader inspects the term starting at b. It returns the size of the
+// term excluding any elements it contains, and the number of those
+// elements. ok is false if b is too short to tell.
+func termHeader(b []byte) (size, children int, ok bool, err error) {
+	fixed, body, children, ok, err := termLayout(b)
+	if !ok || err != nil || len(b) < fixed+body {
+		return 0, 0, false, err
+	}
+	return fixed + body, children, true, nil
+}
+
+// termLayout inspects the header of the term starting at b, without
+// requiring its body. It returns the size of the tag and any length fields,
+// the size of the body they announce and the number of elements the term
+// contains. ok is false if b is too short to tell.
+func termLayout(b []byte) (fixed, body, children int, ok bool, err error) {
+	if len(b) < 1 {
+		return 0, 0, 0, false, nil
+	}
+
+	// lengthAt and lengthSize locate the length of a variable-sized body
+	var lengthAt, lengthSize int
+	switch b[0] {
+	case NilTag:
+		return 1, 0, 0, true, nil
+	case SmallIntTag:
+		fixed = 2
+	case IntTag:
+		fixed = 5
+	case FloatTag:
+		fixed = 32
+	case NewFloatTag:
+		fixed = 9
+	case SmallBignumTag:
+		fixed, lengthAt, lengthSize = 3, 1, 1
+	case LargeBignumTag:
+		fixed, lengthAt, lengthSize = 6, 1, 4
+	case AtomTag, AtomUTF8Tag, StringTag:
+		fixed, lengthAt, lengthSize = 3, 1, 2
+	case SmallAtomTag, SmallAtomUTF8Tag:
+		fixed, lengthAt, lengthSize = 2, 1, 1
+	case BinTag:
+		fixed, lengthAt, lengthSize = 5, 1, 4
+	case BitTag:
+		fixed, lengthAt, lengthSize = 6, 1, 4
+	case SmallTupleTag:
+		if len(b) < 2 {
+			return 0, 0, 0, false, nil
+		}
+		return 2, 0, int(b[1]), true, nil
+	case LargeTupleTag, ListTag, MapTag:
+		if len(b) < 5 {
+			return 0, 0, 0, false, nil
+		}
+		n := int(binary.BigEndian.Uint32(b[1:5]))
+		switch b[0] {
+		case ListTag:
+			n++ // the tail
+		case MapTag:
+			n *= 2
+		}
+		return 5, 0, n, true, nil
+	default:
+		return 0, 0, 0, false, ErrUnknownType
+	}
+
+	if len(b) < fixed {
+		return 0, 0, 0, false, nil
+	}
+	switch lengthSize {
+	case 1:
+		body = int(b[lengthAt])
+	case 2:
+		body = int(binary.BigEndian.Uint16(b[lengthAt:]))
+	case 4:
+		body = int(binary.BigEndian.Uint32(b[lengthAt:]))
+	}
+	return fixed, body, 0, true, nil
+}
@@ -0,0 +1,54 @@
+package bert
+
+import "testing"
+
+func TestPushDecoder(t *testing.T) {
+	var stream []byte
+	terms := []Term{
+		[]Term{Atom("call"), Atom("photox"), Atom("img_size"), []Term{int64(99)}},
+		"foo",
+		[]Term{},
+		[]Term{[]Term{}, []Term{int64(1), []byte{1, 2}}},
+	}
+	for _, term := range terms {
+		data, err := Encode(term)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, data...)
+	}
+
+	p := NewPushDecoder()
+	var got []Term
+	for _, b := range stream {
+		p.Feed([]byte{b})
+		for {
+			term, err := p.Next()
+			if err == ErrNeedMore {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, term)
+		}
+	}
+	assertEqual(t, terms, got)
+	assertEqual(t, 0, p.Buffered())
+
+	// several terms in one chunk
+	p.Feed(stream)
+	for range terms {
+		if _, err := p.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.Next(); err != ErrNeedMore {
+		t.Errorf("expected ErrNeedMore, got %v", err)
+	}
+
+	p.Feed([]byte{1, 2, 3})
+	if _, err := p.Next(); err != ErrBadMagic {
+		t.Errorf("expected ErrBadMagic, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package bert
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// A RawTerm is the complete encoding of a term, starting with the version
+// tag, that is left undecoded. A struct field or slice element of type
+// RawTerm captures the bytes of its position when unmarshaled with
+// Unmarshal, so that a routing layer can unmarshal the envelope of a
+// message and hand its payload to the component that knows its schema:
+//
+//	var msg struct {
+//		To      bert.Atom
+//		Payload bert.RawTerm
+//	}
+//	err := bert.Unmarshal(data, &msg)
+//	...
+//	err = bert.Unmarshal(msg.Payload, &order)
+//
+// The bytes are captured only through tuples and lists; elsewhere, and
+// when unmarshaling an already decoded term, a RawTerm holds the term
+// encoded again. A RawTerm encodes as the term it holds, and a nil one as
+// nil values do.
+type RawTerm []byte
+
+var (
+	rawTermType = reflect.TypeOf(RawTerm(nil))
+	termType    = reflect.TypeOf((*Term)(nil)).Elem()
+)
+
+var errInvalidRawTerm = errors.New("invalid RawTerm")
+
+// A rawBytes holds the undecoded bytes, without the version tag, of a
+// term being unmarshaled into a RawTerm.
+type rawBytes []byte
+
+// rawTypes caches the result of holdsRaw by type.
+var rawTypes sync.Map
+
+// holdsRaw reports whether values of type t may hold a RawTerm, through
+// pointers, struct fields and slice elements.
+func holdsRaw(t reflect.Type) bool {
+	if ok, cached := rawTypes.Load(t); cached {
+		return ok.(bool)
+	}
+	ok := typeHoldsRaw(t, map[reflect.Type]bool{})
+	rawTypes.Store(t, ok)
+	return ok
+}
+
+func typeHoldsRaw(t reflect.Type, visited map[reflect.Type]bool) bool {
+	t = derefType(t)
+	if t == rawTermType {
+		return true
+	}
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Struct:
+		for _, f := range structFields(t) {
+			if typeHoldsRaw(t.FieldByIndex(f.index).Type, visited) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		return typeHoldsRaw(t.Elem(), visited)
+	}
+	return false
+}
+
+// termSize returns the size of the term encoded at the start of b.
+func termSize(b []byte) (int, error) {
+	size, children, ok, err := termHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, io.ErrUnexpectedEOF
+	}
+	for i := 0; i < children; i++ {
+		n, err := termSize(b[size:])
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// A rawDecoder decodes terms to be stored in types holding RawTerm values,
+// leaving the elements stored in those values undecoded.
+type rawDecoder struct {
+	r bytes.Reader
+	d Decoder
+}
+
+func newRawDecoder(o UnmarshalOptions) *rawDecoder {
+	rd := &rawDecoder{}
+	rd.d.r = &rd.r
+	rd.d.NoVersion = true
+	rd.d.LenientArity = !o.StrictArity
+	return rd
+}
+
+// decode returns the term encoded at the start of b, without the version
+// tag, to be stored in a value of type t, and its size.
+func (rd *rawDecoder) decode(b []byte, t reflect.Type) (Term, int, error) {
+	t = derefType(t)
+	if t == rawTermType {
+		n, err := termSize(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		return rawBytes(b[:n]), n, nil
+	}
+	if len(b) > 0 && holdsRaw(t) {
+		switch {
+		case t.Kind() == reflect.Struct && (b[0] == SmallTupleTag || b[0] == LargeTupleTag):
+			return rd.decodeTuple(b, t)
+		case t.Kind() == reflect.Slice && b[0] == ListTag:
+			return rd.decodeList(b, t.Elem())
+		}
+	}
+	rd.r.Reset(b)
+	term, err := rd.d.Decode()
+	return term, len(b) - rd.r.Len(), err
+}
+
+// decodeTuple decodes the tuple at the start of b for the struct type t.
+func (rd *rawDecoder) decodeTuple(b []byte, t reflect.Type) (Term, int, error) {
+	size, n, ok, err := termHeader(b)
+	if !ok && err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	fields := structFields(t)
+	first := 0
+	if _, ok := recordTag(t); ok {
+		first = 1
+	}
+	tuple := rd.d.makeTerms(n)
+	for i := 0; i < n; i++ {
+		var ft reflect.Type
+		if j := i - first; j >= 0 && j < len(fields) {
+			ft = t.FieldByIndex(fields[j].index).Type
+		} else {
+			ft = termType
+		}
+		elem, m, err := rd.decode(b[size:], ft)
+		if err != nil {
+			return nil, 0, err
+		}
+		tuple = append(tuple, elem)
+		size += m
+	}
+	return tuple, size, nil
+}
+
+// decodeList decodes the proper list at the start of b for a slice of
+// elements of type t. Improper lists are decoded as is.
+func (rd *rawDecoder) decodeList(b []byte, t reflect.Type) (Term, int, error) {
+	size, n, ok, err := termHeader(b)
+	if !ok && err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	items := rd.d.makeTerms(n - 1)
+	for i := 0; i < n-1; i++ {
+		elem, m, err := rd.decode(b[size:], t)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, elem)
+		size += m
+	}
+	tail, m, err := rd.decode(b[size:], termType)
+	if err != nil {
+		return nil, 0, err
+	}
+	if l, ok := tail.([]Term); !ok || len(l) != 0 {
+		return List{Items: items, Tail: tail}, size + m, nil
+	}
+	return items, size + m, nil
+}
+
+// unmarshalRaw decodes data, which starts with the version tag, and stores
+// it in v, of a type holding RawTerm values.
+func (u *unmarshaler) unmarshalRaw(data []byte, v reflect.Value) error {
+	if len(data) == 0 {
+		return io.EOF
+	}
+	if data[0] != VersionTag {
+		return ErrBadMagic
+	}
+	term, _, err := newRawDecoder(u.opts).decode(data[1:], v.Type())
+	if err != nil {
+		return noEOF(err)
+	}
+	return u.unmarshalTerm(term, v)
+}
+
+// unmarshalRawTerm stores term in v, of type RawTerm.
+func unmarshalRawTerm(term Term, v reflect.Value) error {
+	var data []byte
+	switch x := term.(type) {
+	case rawBytes:
+		data = append([]byte{VersionTag}, x...)
+	case Verbatim:
+		data = append([]byte{VersionTag}, x.Raw...)
+	default:
+		var err error
+		if data, err = Encode(term); err != nil {
+			return err
+		}
+	}
+	v.SetBytes(data)
+	return nil
+}
+
+// writeRaw writes the term encoded in raw, decoding it first to write it
+// canonically if need be.
+func (e *Encoder) writeRaw(raw RawTerm) error {
+	if raw == nil {
+		return e.writeNil()
+	}
+	if len(raw) < 2 || raw[0] != VersionTag {
+		return errInvalidRawTerm
+	}
+	if e.Canonical {
+		d := &Decoder{r: bytes.NewReader(raw), Fidelity: true}
+		term, err := d.Decode()
+		if err != nil {
+			return errInvalidRawTerm
+		}
+		return e.writeTag(reflect.ValueOf(term))
+	}
+	if raw[1] != CompressedTag {
+		if n, err := termSize(raw[1:]); err != nil || n != len(raw)-1 {
+			return errInvalidRawTerm
+		}
+	}
+	_, err := e.w.Write(raw[1:])
+	return err
+}
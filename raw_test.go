@@ -0,0 +1,57 @@
+package bert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRawTerm(t *testing.T) {
+	type envelope struct {
+		To      Atom
+		Payload RawTerm
+		Extra   []RawTerm
+	}
+
+	// an old-style float and a UTF-8 atom, which would encode differently
+	payload := []byte{131, 104, 2, 99}
+	payload = append(payload, bytes.Repeat([]byte{'0'}, 31)...)
+	payload = append(payload, 118, 0, 1, 'x')
+	data := []byte{131, 104, 3, 100, 0, 2, 'h', 'i'}
+	data = append(data, payload[1:]...)
+	data = append(data, 108, 0, 0, 0, 2, 97, 1, 104, 0, 106)
+
+	var env envelope
+	if err := Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("hi"), env.To)
+	assertEqual(t, RawTerm(payload), env.Payload)
+	assertEqual(t, []RawTerm{{131, 97, 1}, {131, 104, 0}}, env.Extra)
+
+	// Encoding writes the captured bytes back unchanged, though slices
+	// encode as tuples.
+	out, err := Encode(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, append(data[:len(data)-10:len(data)-10], 104, 2, 97, 1, 104, 0), out)
+
+	var p []Term
+	if err := Unmarshal(env.Payload, &p); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("x"), p[1])
+
+	// Already decoded terms are encoded again.
+	var raw RawTerm
+	if err := UnmarshalTerm([]Term{Atom("ok"), int64(1)}, &raw); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, RawTerm{131, 104, 2, 100, 0, 2, 'o', 'k', 97, 1}, raw)
+
+	if err := Unmarshal(data[:len(data)-3], &env); err == nil {
+		t.Errorf("expected an error unmarshaling a truncated term")
+	}
+	assertNotEncode(t, RawTerm{131, 97}, "cannot encode bert.RawTerm: invalid RawTerm")
+	assertNotEncode(t, RawTerm{97, 1}, "cannot encode bert.RawTerm: invalid RawTerm")
+}
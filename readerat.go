@@ -0,0 +1,294 @@
+package bert
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// atBufferSize is the size of the reads made by ValidateAt and IndexAt.
+const atBufferSize = 64 << 10
+
+// maxHeader is the most bytes termLayout needs to inspect a term.
+const maxHeader = 32
+
+var errTrailingBytes = errors.New("trailing bytes after term")
+
+// A TermIndex locates the elements of a term encoded in an io.ReaderAt, so
+// that each can be decoded with DecodeAt without decoding the others.
+type TermIndex struct {
+	// Kind is the kind of the term. Compressed terms are of OtherKind.
+	Kind TermKind
+
+	// Offset and Size locate the term, excluding the version tag.
+	Offset, Size int64
+
+	// Elements holds the offsets of the elements of tuples, of the items
+	// of lists, excluding their tail, and of the keys and values of maps,
+	// alternating. It is empty for other terms, including compressed ones.
+	Elements []int64
+}
+
+// DecodeAt decodes the term encoded at offset off of r, which is not
+// preceded by the version tag, such as an element located by a TermIndex.
+// Only the bytes of the term and a buffer's worth after them are read.
+func DecodeAt(r io.ReaderAt, off int64) (Term, error) {
+	d := &Decoder{NoVersion: true}
+	return d.DecodeAt(r, off)
+}
+
+// DecodeAt decodes the term encoded at offset off of r with the options of
+// d, which read the version tag first unless NoVersion is set.
+func (d *Decoder) DecodeAt(r io.ReaderAt, off int64) (Term, error) {
+	if off < 0 {
+		return nil, errors.New("negative offset")
+	}
+	d.Reset(bufio.NewReader(io.NewSectionReader(r, off, 1<<63-1-off)))
+	term, err := d.Decode()
+	d.r = nil
+	return term, err
+}
+
+// UnmarshalAt decodes the term encoded at offset off of r, which is not
+// preceded by the version tag, and stores it in val as Unmarshal does.
+func UnmarshalAt(r io.ReaderAt, off int64, val interface{}) error {
+	term, err := DecodeAt(r, off)
+	if err != nil {
+		return err
+	}
+	return UnmarshalTerm(term, val)
+}
+
+// ValidateAt checks that the first size bytes of r hold exactly one term,
+// preceded by the version tag, and returns the index of its elements.
+// Terms are checked by their headers: the bodies of atoms, binaries and
+// such are skipped without being read, so that large files are validated
+// quickly, but their contents are not checked. Compressed terms are
+// decompressed and checked in memory, up to DefaultMaxFrameSize bytes
+// uncompressed.
+func ValidateAt(r io.ReaderAt, size int64) (*TermIndex, error) {
+	var version [1]byte
+	if size < 1 {
+		return nil, io.EOF
+	}
+	if _, err := r.ReadAt(version[:], 0); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if version[0] != VersionTag {
+		return nil, ErrBadMagic
+	}
+	x, err := IndexAt(r, 1, size)
+	if err != nil {
+		return nil, err
+	}
+	if x.Offset+x.Size != size {
+		return nil, errTrailingBytes
+	}
+	return x, nil
+}
+
+// IndexAt checks the term encoded at offset off of r, which is not
+// preceded by the version tag and must end within the first size bytes of
+// r, as ValidateAt does, and returns the index of its elements. With the
+// offsets of a TermIndex, it indexes the elements of nested terms.
+func IndexAt(r io.ReaderAt, off, size int64) (*TermIndex, error) {
+	if off < 0 || off >= size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	a := &atReader{r: r, off: off, end: size}
+	if err := a.fill(maxHeader); err != nil {
+		return nil, err
+	}
+	x := &TermIndex{Kind: tagKind(a.buf[0]), Offset: off}
+	if a.buf[0] == CompressedTag {
+		if err := a.skipCompressed(); err != nil {
+			return nil, err
+		}
+		x.Size = a.off - off
+		return x, nil
+	}
+
+	fixed, body, children, ok, err := termLayout(a.buf)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err := a.skip(int64(fixed) + int64(body)); err != nil {
+		return nil, err
+	}
+	// each element takes at least one byte, which bounds the allocation
+	if int64(children) > a.end-a.off {
+		return nil, io.ErrUnexpectedEOF
+	}
+	items := children
+	if x.Kind == ListKind && children > 0 {
+		items-- // the tail
+	}
+	x.Elements = make([]int64, 0, items)
+	for i := 0; i < children; i++ {
+		if i < items {
+			x.Elements = append(x.Elements, a.off)
+		}
+		if err := a.skipTerm(); err != nil {
+			return nil, err
+		}
+	}
+	x.Size = a.off - off
+	return x, nil
+}
+
+// tagKind returns the kind of the terms starting with tag.
+func tagKind(tag byte) TermKind {
+	switch tag {
+	case SmallIntTag, IntTag, SmallBignumTag, LargeBignumTag:
+		return IntegerKind
+	case FloatTag, NewFloatTag:
+		return FloatKind
+	case AtomTag, AtomUTF8Tag, SmallAtomTag, SmallAtomUTF8Tag:
+		return AtomKind
+	case StringTag:
+		return StringKind
+	case BinTag:
+		return BinaryKind
+	case BitTag:
+		return BitstringKind
+	case SmallTupleTag, LargeTupleTag:
+		return TupleKind
+	case NilTag, ListTag:
+		return ListKind
+	case MapTag:
+		return MapKind
+	}
+	return OtherKind
+}
+
+// An atReader reads an io.ReaderAt from an offset up to an end through a
+// buffer, keeping track of the offset of the bytes it has not consumed.
+type atReader struct {
+	r        io.ReaderAt
+	off, end int64
+	mem      []byte
+	buf      []byte // the bytes of mem from off
+}
+
+// fill makes buf hold at least n bytes, or all bytes up to end if fewer.
+func (a *atReader) fill(n int) error {
+	if len(a.buf) >= n {
+		return nil
+	}
+	want := atBufferSize
+	if n > want {
+		want = n
+	}
+	if rest := a.end - a.off; int64(want) > rest {
+		want = int(rest)
+	}
+	if len(a.buf) >= want {
+		return nil
+	}
+	if cap(a.mem) < want {
+		a.mem = make([]byte, want)
+	}
+	have := copy(a.mem[:cap(a.mem)], a.buf)
+	m, err := a.r.ReadAt(a.mem[have:want], a.off+int64(have))
+	a.buf = a.mem[:have+m]
+	if have+m < want {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// skip consumes n bytes.
+func (a *atReader) skip(n int64) error {
+	if n > a.end-a.off {
+		return io.ErrUnexpectedEOF
+	}
+	if n < int64(len(a.buf)) {
+		a.buf = a.buf[n:]
+	} else {
+		a.buf = nil
+	}
+	a.off += n
+	return nil
+}
+
+// skipTerm consumes the term at the offset, checking the headers of the
+// terms it contains.
+func (a *atReader) skipTerm() error {
+	for pending := 1; pending > 0; pending-- {
+		if err := a.fill(maxHeader); err != nil {
+			return err
+		}
+		fixed, body, children, ok, err := termLayout(a.buf)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return io.ErrUnexpectedEOF
+		}
+		if err := a.skip(int64(fixed) + int64(body)); err != nil {
+			return err
+		}
+		pending += children
+	}
+	return nil
+}
+
+// skipCompressed consumes the compressed term at the offset, checking the
+// term it holds.
+func (a *atReader) skipCompressed() error {
+	if err := a.fill(5); err != nil {
+		return err
+	}
+	size, _ := read4(bytes.NewReader(a.buf[1:5]))
+	if err := checkUncompressedSize(size, 0); err != nil {
+		return err
+	}
+	if err := a.skip(5); err != nil {
+		return err
+	}
+	data, err := inflate(a, size)
+	if err != nil {
+		return noEOF(err)
+	}
+	if n, err := termSize(data); err != nil || n != len(data) {
+		if err == nil {
+			err = errTrailingBytes
+		}
+		return err
+	}
+	return nil
+}
+
+// Read and ReadByte let zlib read compressed data through the buffer
+// without reading past its end.
+func (a *atReader) Read(p []byte) (int, error) {
+	if len(a.buf) == 0 {
+		if a.off >= a.end {
+			return 0, io.EOF
+		}
+		if err := a.fill(1); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, a.buf)
+	a.skip(int64(n))
+	return n, nil
+}
+
+func (a *atReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := a.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
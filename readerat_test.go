@@ -0,0 +1,114 @@
+package bert
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestValidateAt(t *testing.T) {
+	data, err := Encode([]Term{1, Binary("bin"), List{Items: []Term{Atom("a"), Atom("b")}}, 2.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+
+	x, err := ValidateAt(r, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, TupleKind, x.Kind)
+	assertEqual(t, int64(1), x.Offset)
+	assertEqual(t, int64(len(data)-1), x.Size)
+	assertEqual(t, 4, len(x.Elements))
+
+	term, err := DecodeAt(r, x.Elements[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte("bin"), term)
+	var f float64
+	if err := UnmarshalAt(r, x.Elements[3], &f); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2.5, f)
+
+	// nested terms are indexed from the offsets of their parent's index
+	list, err := IndexAt(r, x.Elements[2], int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ListKind, list.Kind)
+	assertEqual(t, 2, len(list.Elements))
+	term, err = DecodeAt(r, list.Elements[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("b"), term)
+
+	// a whole term is decoded from its start by a Decoder
+	term, err = new(Decoder).DecodeAt(r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 4, len(term.([]Term)))
+
+	if _, err := ValidateAt(r, int64(len(data)-1)); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated: got %v", err)
+	}
+	extra := bytes.NewReader(append(data, 106))
+	if _, err := ValidateAt(extra, int64(len(data)+1)); err != errTrailingBytes {
+		t.Errorf("trailing: got %v", err)
+	}
+	if _, err := ValidateAt(bytes.NewReader([]byte{130, 106}), 2); err != ErrBadMagic {
+		t.Errorf("magic: got %v", err)
+	}
+}
+
+func TestValidateAtLarge(t *testing.T) {
+	// elements span many buffers, and their bodies are skipped
+	items := make([]Term, 20000)
+	for i := range items {
+		items[i] = []Term{i, Binary(bytes.Repeat([]byte{byte(i)}, i%50))}
+	}
+	data, err := Encode(List{Items: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+
+	x, err := ValidateAt(r, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(items), len(x.Elements))
+	for _, i := range []int{0, 7777, len(items) - 1} {
+		term, err := DecodeAt(r, x.Elements[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, []Term{int64(i), bytes.Repeat([]byte{byte(i)}, i%50)}, term)
+	}
+}
+
+func TestValidateAtCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.CompressThreshold = 1
+	if err := enc.Encode([]Term{Binary(bytes.Repeat([]byte("x"), 100))}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	x, err := ValidateAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, OtherKind, x.Kind)
+	assertEqual(t, 0, len(x.Elements))
+
+	data[len(data)-1] ^= 0xff
+	if _, err := ValidateAt(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("corrupt checksum accepted")
+	}
+}
@@ -0,0 +1,46 @@
+package bert
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var recordCache sync.Map // map[reflect.Type]Atom
+
+// recordTag returns the record tag declared by struct type t with a blank
+// field tagged `bert:"record:Tag"`, as in
+//
+//	type User struct {
+//		_    struct{} `bert:"record:user"`
+//		Name string
+//	}
+//
+// which makes User encode as {user, Name} and unmarshal from that shape.
+func recordTag(t reflect.Type) (Atom, bool) {
+	if tag, ok := recordCache.Load(t); ok {
+		return tag.(Atom), tag.(Atom) != ""
+	}
+	var tag Atom
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Name != "_" {
+			continue
+		}
+		if name, _ := parseTag(sf.Tag.Get("bert")); strings.HasPrefix(name, "record:") {
+			tag = Atom(strings.TrimPrefix(name, "record:"))
+			break
+		}
+	}
+	recordCache.Store(t, tag)
+	return tag, tag != ""
+}
+
+// unmarshalRecord stores a {Tag, Field1, ...} tuple in the struct v whose
+// record tag is tag.
+func (u *unmarshaler) unmarshalRecord(tag Atom, tuple []Term, v reflect.Value) error {
+	if len(tuple) == 0 || tuple[0] != tag {
+		return &UnmarshalTypeError{Term: tuple, Type: v.Type()}
+	}
+	return u.unmarshalFields(tuple[1:], v)
+}
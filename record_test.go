@@ -0,0 +1,92 @@
+package bert
+
+import (
+	"bytes"
+	"testing"
+)
+
+type recordUser struct {
+	_    struct{} `bert:"record:user"`
+	Name string
+	Age  int
+}
+
+func TestRecordTag(t *testing.T) {
+	data := []byte{131, 104, 3,
+		100, 0, 4, 117, 115, 101, 114,
+		107, 0, 3, 106, 111, 101,
+		97, 30,
+	}
+	assertEncode(t, recordUser{Name: "joe", Age: 30}, data)
+
+	var u recordUser
+	if err := Unmarshal(data, &u); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, recordUser{Name: "joe", Age: 30}, u)
+
+	data, _ = Encode([]Term{Atom("group"), "joe", 30})
+	if err := Unmarshal(data, &u); err == nil {
+		t.Errorf("expected error unmarshaling a group record into a user")
+	}
+
+	assertEqual(t, true, Equal(recordUser{Name: "joe", Age: 30}, []Term{Atom("user"), "joe", int64(30)}))
+	assertEqual(t, 2, Stats(recordUser{}).Depth)
+}
+
+func TestRecordArity(t *testing.T) {
+	register(t, registerTestLocation)
+	short, _ := Encode([]Term{Atom("user"), "joe"})
+	long, _ := Encode([]Term{Atom("user"), "joe", 30, "joe@example.com"})
+
+	var u recordUser
+	if err := Unmarshal(short, &u); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, recordUser{Name: "joe"}, u)
+	if err := Unmarshal(long, &u); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, recordUser{Name: "joe", Age: 30}, u)
+
+	strict := UnmarshalOptions{StrictArity: true}
+	if err := strict.Unmarshal(short, &u); err == nil {
+		t.Errorf("expected arity error for a short record")
+	}
+	if err := strict.Unmarshal(long, &u); err == nil {
+		t.Errorf("expected arity error for a long record")
+	}
+
+	// Short extension tuples are accepted alike by every entry point but
+	// strict ones.
+	data, _ := Encode([]Term{Atom("geo"), 0.5})
+	unmarshals := map[string]func(*testLocation) error{
+		"Unmarshal":        func(loc *testLocation) error { return Unmarshal(data, loc) },
+		"UnmarshalFrom":    func(loc *testLocation) error { return UnmarshalFrom(bytes.NewReader(data), loc) },
+		"UnmarshalOptions": func(loc *testLocation) error { return UnmarshalOptions{}.Unmarshal(data, loc) },
+	}
+	for name, unmarshal := range unmarshals {
+		var loc testLocation
+		if err := unmarshal(&loc); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		assertEqual(t, testLocation{Lat: 0.5}, loc)
+	}
+	var loc testLocation
+	if err := strict.Unmarshal(data, &loc); err == nil {
+		t.Errorf("expected arity error for a short extension")
+	}
+
+	d := NewDecoder(bytes.NewReader([]byte{131, 104, 4,
+		100, 0, 3, 103, 101, 111,
+		70, 63, 224, 0, 0, 0, 0, 0, 0,
+		70, 191, 224, 0, 0, 0, 0, 0, 0,
+		97, 1,
+	}))
+	d.LenientArity = true
+	term, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, testLocation{0.5, -0.5}, term)
+}
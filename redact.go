@@ -0,0 +1,125 @@
+package bert
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RedactedAtom replaces the values Redact hides.
+const RedactedAtom = Atom("redacted")
+
+// A RedactPolicy selects what Redact hides. Its zero value hides nothing.
+type RedactPolicy struct {
+	// MaxBinary, if positive, replaces binaries, bitstrings and strings
+	// longer than MaxBinary bytes with a string giving their size, such
+	// as "<<4096 bytes>>".
+	MaxBinary int
+
+	// Keys lists the keys, matched case-insensitively, whose values are
+	// replaced with RedactedAtom. They apply to map keys and to the first
+	// element of {Key, Value} pairs, such as those of a proplist, that is
+	// an atom, string or binary.
+	Keys []string
+
+	// MaxList, if positive, truncates lists and tuples of more than
+	// MaxList elements, appending a string giving the number of elements
+	// left out, such as "...(12 more)".
+	MaxList int
+}
+
+// Redact returns a copy of term, as returned by Decode, with the values
+// selected by p replaced by placeholders, so that it can be logged without
+// leaking secrets or flooding the log. Values of other types are returned
+// unchanged.
+func Redact(term Term, p RedactPolicy) Term {
+	switch x := term.(type) {
+	case []byte:
+		if p.MaxBinary > 0 && len(x) > p.MaxBinary {
+			return fmt.Sprintf("<<%d bytes>>", len(x))
+		}
+	case string:
+		if p.MaxBinary > 0 && len(x) > p.MaxBinary {
+			return fmt.Sprintf("<<%d bytes>>", len(x))
+		}
+	case Bitstring:
+		if p.MaxBinary > 0 && len(x.Bytes) > p.MaxBinary {
+			return fmt.Sprintf("<<%d bytes>>", len(x.Bytes))
+		}
+	case []Term:
+		if len(x) == 2 && p.secret(x[0]) {
+			return []Term{x[0], RedactedAtom}
+		}
+		return p.redactItems(x)
+	case List:
+		return List{Items: p.redactItems(x.Items), Tail: x.Tail}
+	case TermMap:
+		return TermMap{entries: p.redactEntries(x.entries)}
+	case []KV:
+		return p.redactEntries(x)
+	default:
+		if v := reflect.ValueOf(term); v.Kind() == reflect.Map {
+			return p.redactMap(v)
+		}
+	}
+	return term
+}
+
+// secret reports whether the values under key must be hidden.
+func (p RedactPolicy) secret(key Term) bool {
+	switch key.(type) {
+	case Atom, string, []byte:
+	default:
+		return false
+	}
+	name := textOf(key)
+	for _, k := range p.Keys {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RedactPolicy) redactItems(items []Term) []Term {
+	n := len(items)
+	if p.MaxList > 0 && n > p.MaxList {
+		n = p.MaxList
+	}
+	redacted := make([]Term, n, n+1)
+	for i := range redacted {
+		redacted[i] = Redact(items[i], p)
+	}
+	if n < len(items) {
+		redacted = append(redacted, fmt.Sprintf("...(%d more)", len(items)-n))
+	}
+	return redacted
+}
+
+func (p RedactPolicy) redactEntries(entries []KV) []KV {
+	var redacted []KV
+	for _, kv := range entries {
+		if p.secret(kv.Key) {
+			redacted = append(redacted, KV{kv.Key, RedactedAtom})
+		} else {
+			redacted = append(redacted, KV{kv.Key, Redact(kv.Value, p)})
+		}
+	}
+	return redacted
+}
+
+// redactMap returns a redacted copy of the map m. The copy is a
+// map[Term]Term, as its values may no longer fit the type of m.
+func (p RedactPolicy) redactMap(m reflect.Value) Term {
+	redacted := make(map[Term]Term, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		k, v := iter.Key().Interface(), iter.Value().Interface()
+		if p.secret(k) {
+			redacted[k] = RedactedAtom
+		} else {
+			redacted[k] = Redact(v, p)
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,38 @@
+package bert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	p := RedactPolicy{MaxBinary: 4, Keys: []string{"password", "Token"}, MaxList: 3}
+
+	term := []Term{
+		[]Term{
+			[]Term{Atom("user"), []byte("joe")},
+			[]Term{Atom("password"), []byte("secret")},
+			[]Term{"token", int64(42)},
+			[]Term{Atom("avatar"), bytes.Repeat([]byte{1}, 100)},
+		},
+		map[Atom]Term{"TOKEN": "abc", "name": "joe"},
+		List{Items: []Term{Atom("a"), "long string"}},
+	}
+	assertEqual(t, []Term{
+		[]Term{
+			[]Term{Atom("user"), []byte("joe")},
+			[]Term{Atom("password"), RedactedAtom},
+			[]Term{"token", RedactedAtom},
+			"...(1 more)",
+		},
+		map[Term]Term{Atom("TOKEN"): RedactedAtom, Atom("name"): "joe"},
+		List{Items: []Term{Atom("a"), "<<11 bytes>>"}},
+	}, Redact(term, p))
+
+	// the original is left untouched
+	assertEqual(t, []byte("secret"), term[0].([]Term)[1].([]Term)[1])
+
+	assertEqual(t, term[0], Redact(term[0], RedactPolicy{}))
+	assertEqual(t, Bitstring{[]byte{1, 2, 3, 4, 5}, 33}, Redact(Bitstring{[]byte{1, 2, 3, 4, 5}, 33}, RedactPolicy{MaxBinary: 5}))
+	assertEqual(t, "<<5 bytes>>", Redact(Bitstring{[]byte{1, 2, 3, 4, 5}, 33}, p))
+}
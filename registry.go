@@ -0,0 +1,302 @@
+package bert
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// atomMapping holds the atoms registered for a single Go type.
+type atomMapping struct {
+	values map[Atom]reflect.Value
+	atoms  map[interface{}]Atom
+}
+
+var atomRegistry struct {
+	sync.RWMutex
+	types map[reflect.Type]*atomMapping
+}
+
+// RegisterAtom associates the atom a with v, typically a constant of a
+// named type such as
+//
+//	type Status int
+//	const StatusOK Status = 1
+//
+// Once registered, values equal to v encode as a, and Unmarshal stores v
+// into fields of v's type when it meets a. Values of the type that have no
+// registered atom encode as usual. RegisterAtom panics if v is nil or not
+// comparable, or if either side of the mapping is already registered.
+func RegisterAtom(a Atom, v interface{}) {
+	if v == nil {
+		panic("bert: RegisterAtom of nil value")
+	}
+	t := reflect.TypeOf(v)
+	if !t.Comparable() {
+		panic("bert: RegisterAtom of non-comparable type " + t.String())
+	}
+
+	atomRegistry.Lock()
+	defer atomRegistry.Unlock()
+
+	if atomRegistry.types == nil {
+		atomRegistry.types = make(map[reflect.Type]*atomMapping)
+	}
+	m := atomRegistry.types[t]
+	if m == nil {
+		m = &atomMapping{
+			values: make(map[Atom]reflect.Value),
+			atoms:  make(map[interface{}]Atom),
+		}
+		atomRegistry.types[t] = m
+	}
+	if _, dup := m.values[a]; dup {
+		panic(fmt.Sprintf("bert: atom %q registered twice for %v", a, t))
+	}
+	if _, dup := m.atoms[v]; dup {
+		panic(fmt.Sprintf("bert: value %v of %v registered twice", v, t))
+	}
+	m.values[a] = reflect.ValueOf(v)
+	m.atoms[v] = a
+}
+
+// atomFor returns the atom registered for val, if any.
+func atomFor(val reflect.Value) (Atom, bool) {
+	if !val.IsValid() || !val.CanInterface() {
+		return "", false
+	}
+
+	atomRegistry.RLock()
+	defer atomRegistry.RUnlock()
+
+	m := atomRegistry.types[val.Type()]
+	if m == nil {
+		return "", false
+	}
+	a, ok := m.atoms[val.Interface()]
+	return a, ok
+}
+
+var atomTypes struct {
+	sync.RWMutex
+	types map[reflect.Type]bool
+}
+
+// RegisterAtomType arranges for all values of the string type of v to
+// encode as atoms, as Atom values do, so that domain types such as
+//
+//	type Status string
+//
+// need not be converted to Atom first. Types named Atom, in any package,
+// are always atoms. RegisterAtomType panics if v is not of a string type.
+func RegisterAtomType(v interface{}) {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.String {
+		panic(fmt.Sprintf("bert: RegisterAtomType of non-string type %v", t))
+	}
+
+	atomTypes.Lock()
+	defer atomTypes.Unlock()
+	if atomTypes.types == nil {
+		atomTypes.types = make(map[reflect.Type]bool)
+	}
+	atomTypes.types[t] = true
+}
+
+// isAtomType reports whether strings of type t are atoms.
+func isAtomType(t reflect.Type) bool {
+	if t.Name() == "Atom" {
+		return true
+	}
+	atomTypes.RLock()
+	defer atomTypes.RUnlock()
+	return atomTypes.types[t]
+}
+
+// valueFor returns the value of type t registered for a. registered reports
+// whether t has any registered atoms at all.
+func valueFor(t reflect.Type, a Atom) (v reflect.Value, ok, registered bool) {
+	atomRegistry.RLock()
+	defer atomRegistry.RUnlock()
+
+	m := atomRegistry.types[t]
+	if m == nil {
+		return reflect.Value{}, false, false
+	}
+	v, ok = m.values[a]
+	return v, ok, true
+}
+
+// An EncoderFunc converts a value of a type registered with RegisterEncoder
+// into a term that is encoded in its place.
+type EncoderFunc func(v interface{}) (Term, error)
+
+// A DecoderFunc converts a decoded tuple whose first element is an atom
+// registered with RegisterDecoder into the value returned in its place.
+// Its error fails the whole decode, so a function meeting a tuple of a
+// shape it does not handle should return the tuple unchanged instead.
+type DecoderFunc func(tuple []Term) (Term, error)
+
+var codecRegistry struct {
+	sync.RWMutex
+	encoders   map[reflect.Type]EncoderFunc
+	decoders   map[Atom]DecoderFunc
+	extensions map[Atom]reflect.Type
+}
+
+// RegisterEncoder arranges for values of type t to be passed to fn and the
+// term it returns to be encoded instead. This defines a wire mapping for
+// types the encoder does not otherwise support, such as those from other
+// packages. fn must not return another value of type t. Registering a
+// second function for t replaces the first.
+func RegisterEncoder(t reflect.Type, fn EncoderFunc) {
+	codecRegistry.Lock()
+	defer codecRegistry.Unlock()
+
+	if codecRegistry.encoders == nil {
+		codecRegistry.encoders = make(map[reflect.Type]EncoderFunc)
+	}
+	codecRegistry.encoders[t] = fn
+}
+
+// RegisterDecoder arranges for decoded tuples whose first element is tag to
+// be passed to fn, and the value it returns to be decoded instead. It is
+// the counterpart of RegisterEncoder for encoders producing tagged tuples.
+// Tuples are converted wherever they appear, including in the packets of
+// BERT-RPC, so tags should be specific to the application. Registering a
+// second function for tag replaces the first.
+func RegisterDecoder(tag Atom, fn DecoderFunc) {
+	codecRegistry.Lock()
+	defer codecRegistry.Unlock()
+
+	if codecRegistry.decoders == nil {
+		codecRegistry.decoders = make(map[Atom]DecoderFunc)
+	}
+	codecRegistry.decoders[tag] = fn
+	delete(codecRegistry.extensions, tag)
+}
+
+func encoderFor(t reflect.Type) EncoderFunc {
+	codecRegistry.RLock()
+	defer codecRegistry.RUnlock()
+	return codecRegistry.encoders[t]
+}
+
+func decoderFor(tuple []Term) DecoderFunc {
+	if len(tuple) == 0 {
+		return nil
+	}
+	tag, ok := tuple[0].(Atom)
+	if !ok {
+		return nil
+	}
+
+	codecRegistry.RLock()
+	defer codecRegistry.RUnlock()
+	return codecRegistry.decoders[tag]
+}
+
+// RegisterExtension maps the struct type of v to tuples tagged with tag.
+// Values of the type encode as {tag, Field1, Field2, ...}, and tuples of
+// that shape decode into values of the type wherever they appear, so
+//
+//	type Location struct{ Lat, Lon float64 }
+//	bert.RegisterExtension("geo", Location{})
+//
+// turns {geo, 52.5, 13.4} into a Location on decode and back on encode.
+// Fields are laid out as for plain struct encoding. Tagged tuples that do
+// not fit the type, having another number of elements without
+// Decoder.LenientArity or elements of other types, decode as plain tuples.
+// RegisterExtension panics if v is not a struct or a pointer to one.
+func RegisterExtension(tag Atom, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("bert: RegisterExtension of non-struct type %v", t))
+	}
+
+	RegisterEncoder(t, func(x interface{}) (Term, error) {
+		rv := reflect.ValueOf(x)
+		fields := structFields(t)
+		tuple := make([]Term, 1, len(fields)+1)
+		tuple[0] = tag
+		for _, f := range fields {
+			fv := fieldByIndex(rv, f.index)
+			if !fv.IsValid() {
+				tuple = append(tuple, nil)
+				continue
+			}
+			tuple = append(tuple, fv.Interface())
+		}
+		return tuple, nil
+	})
+
+	codecRegistry.Lock()
+	defer codecRegistry.Unlock()
+	delete(codecRegistry.decoders, tag)
+	if codecRegistry.extensions == nil {
+		codecRegistry.extensions = make(map[Atom]reflect.Type)
+	}
+	codecRegistry.extensions[tag] = t
+}
+
+// extensionFor returns the type registered with RegisterExtension for the
+// tag of tuple, if any.
+func extensionFor(tuple []Term) (reflect.Type, bool) {
+	if len(tuple) == 0 {
+		return nil, false
+	}
+	tag, ok := tuple[0].(Atom)
+	if !ok {
+		return nil, false
+	}
+
+	codecRegistry.RLock()
+	defer codecRegistry.RUnlock()
+	t, ok := codecRegistry.extensions[tag]
+	return t, ok
+}
+
+// decodeExtension converts the tagged tuple into a value of the extension
+// type t. Unless lenient, the tuple must have one element per field. ok is
+// false if the tuple does not fit the type.
+func decodeExtension(t reflect.Type, tuple []Term, lenient bool) (v Term, ok bool) {
+	if !lenient && len(tuple)-1 != len(structFields(t)) {
+		return nil, false
+	}
+	p := reflect.New(t)
+	if err := unmarshalTerm(tuple[1:], p.Elem()); err != nil {
+		return nil, false
+	}
+	return p.Elem().Interface(), true
+}
+
+// A convertFunc turns a decoded term into a value of the type it is
+// registered for. ok is false if term has a shape the function does not
+// handle, in which case Unmarshal falls back to its usual rules.
+type convertFunc func(term Term) (v interface{}, ok bool, err error)
+
+var converters struct {
+	sync.RWMutex
+	types map[reflect.Type]convertFunc
+}
+
+// registerConverter arranges for Unmarshal to fill values of type t using
+// fn.
+func registerConverter(t reflect.Type, fn convertFunc) {
+	converters.Lock()
+	defer converters.Unlock()
+
+	if converters.types == nil {
+		converters.types = make(map[reflect.Type]convertFunc)
+	}
+	converters.types[t] = fn
+}
+
+func converterFor(t reflect.Type) convertFunc {
+	converters.RLock()
+	defer converters.RUnlock()
+	return converters.types[t]
+}
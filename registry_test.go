@@ -0,0 +1,276 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testStatus int
+
+const (
+	testStatusOK testStatus = iota + 1
+	testStatusError
+	testStatusUnknown
+)
+
+// register calls fn to register types for the test, and restores the
+// registries as they were once the test ends.
+func register(t *testing.T, fn func()) {
+	t.Helper()
+
+	atomRegistry.Lock()
+	atoms := make(map[reflect.Type]*atomMapping, len(atomRegistry.types))
+	for k, v := range atomRegistry.types {
+		m := &atomMapping{
+			values: make(map[Atom]reflect.Value, len(v.values)),
+			atoms:  make(map[interface{}]Atom, len(v.atoms)),
+		}
+		for a, x := range v.values {
+			m.values[a] = x
+		}
+		for x, a := range v.atoms {
+			m.atoms[x] = a
+		}
+		atoms[k] = m
+	}
+	atomRegistry.Unlock()
+
+	atomTypes.Lock()
+	types := make(map[reflect.Type]bool, len(atomTypes.types))
+	for k, v := range atomTypes.types {
+		types[k] = v
+	}
+	atomTypes.Unlock()
+
+	codecRegistry.Lock()
+	encoders := make(map[reflect.Type]EncoderFunc, len(codecRegistry.encoders))
+	for k, v := range codecRegistry.encoders {
+		encoders[k] = v
+	}
+	decoders := make(map[Atom]DecoderFunc, len(codecRegistry.decoders))
+	for k, v := range codecRegistry.decoders {
+		decoders[k] = v
+	}
+	extensions := make(map[Atom]reflect.Type, len(codecRegistry.extensions))
+	for k, v := range codecRegistry.extensions {
+		extensions[k] = v
+	}
+	codecRegistry.Unlock()
+
+	converters.Lock()
+	convs := make(map[reflect.Type]convertFunc, len(converters.types))
+	for k, v := range converters.types {
+		convs[k] = v
+	}
+	converters.Unlock()
+
+	t.Cleanup(func() {
+		atomRegistry.Lock()
+		atomRegistry.types = atoms
+		atomRegistry.Unlock()
+		atomTypes.Lock()
+		atomTypes.types = types
+		atomTypes.Unlock()
+		codecRegistry.Lock()
+		codecRegistry.encoders, codecRegistry.decoders, codecRegistry.extensions = encoders, decoders, extensions
+		codecRegistry.Unlock()
+		converters.Lock()
+		converters.types = convs
+		converters.Unlock()
+	})
+	fn()
+}
+
+func registerTestStatus() {
+	RegisterAtom(Atom("ok"), testStatusOK)
+	RegisterAtom(Atom("error"), testStatusError)
+}
+
+func TestRegisterAtom(t *testing.T) {
+	register(t, registerTestStatus)
+	assertEncode(t, testStatusOK, []byte{131, 100, 0, 2, 111, 107})
+	assertEncode(t, []Term{testStatusError, 1},
+		[]byte{131, 104, 2, 100, 0, 5, 101, 114, 114, 111, 114, 97, 1})
+
+	// unregistered values of the type fall back to their usual encoding
+	assertEncode(t, testStatusUnknown, []byte{131, 97, 3})
+
+	var reply struct {
+		Status testStatus
+		Value  Atom
+	}
+	err := Unmarshal([]byte{131, 104, 2,
+		100, 0, 5, 101, 114, 114, 111, 114,
+		100, 0, 3, 102, 111, 111,
+	}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, testStatusError, reply.Status)
+	assertEqual(t, Atom("foo"), reply.Value)
+
+	err = Unmarshal([]byte{131, 104, 1, 100, 0, 3, 102, 111, 111}, &reply)
+	if err == nil {
+		t.Errorf("expected error unmarshaling unregistered atom")
+	}
+}
+
+func TestRegisterAtomDuplicate(t *testing.T) {
+	register(t, registerTestStatus)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic registering atom twice")
+		}
+	}()
+	RegisterAtom(Atom("ok"), testStatusUnknown)
+}
+
+type testRole string
+
+func TestRegisterAtomType(t *testing.T) {
+	register(t, func() { RegisterAtomType(testRole("")) })
+	assertEncode(t, testRole("admin"), []byte{131, 100, 0, 5, 97, 100, 109, 105, 110})
+	assertEqual(t, AtomKind, KindOf(testRole("admin")))
+	assertEqual(t, true, Equal(testRole("admin"), Atom("admin")))
+
+	var user struct {
+		Name string
+		Role testRole
+	}
+	err := Unmarshal([]byte{131, 104, 2,
+		107, 0, 3, 97, 110, 110,
+		100, 0, 5, 97, 100, 109, 105, 110,
+	}, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, testRole("admin"), user.Role)
+
+	// Binaries are not accepted for atoms.
+	err = Unmarshal([]byte{131, 104, 2,
+		107, 0, 3, 97, 110, 110,
+		109, 0, 0, 0, 5, 97, 100, 109, 105, 110,
+	}, &user)
+	if err == nil {
+		t.Errorf("expected an error unmarshaling a binary into an atom type")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic registering a non-string type")
+		}
+	}()
+	RegisterAtomType(testStatusOK)
+}
+
+type testID [4]byte
+
+func registerTestID() {
+	RegisterEncoder(reflect.TypeOf(testID{}), func(v interface{}) (Term, error) {
+		id := v.(testID)
+		return []Term{Atom("id"), id[:]}, nil
+	})
+	RegisterDecoder(Atom("id"), func(tuple []Term) (Term, error) {
+		var id testID
+		if len(tuple) != 2 {
+			return nil, errors.New("bad id")
+		}
+		b, ok := tuple[1].([]byte)
+		if !ok || len(b) != len(id) {
+			return nil, errors.New("bad id")
+		}
+		copy(id[:], b)
+		return id, nil
+	})
+}
+
+func TestRegisterEncoderDecoder(t *testing.T) {
+	register(t, registerTestID)
+	id := testID{1, 2, 3, 4}
+	data := []byte{131, 104, 2, 100, 0, 2, 105, 100, 109, 0, 0, 0, 4, 1, 2, 3, 4}
+	assertEncode(t, id, data)
+	assertEncode(t, &id, data)
+	assertDecode(t, data, id)
+
+	var msg struct {
+		ID testID
+	}
+	err := Unmarshal([]byte{131, 104, 1, 104, 2, 100, 0, 2, 105, 100, 109, 0, 0, 0, 4, 1, 2, 3, 4}, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, id, msg.ID)
+
+	_, err = Decode([]byte{131, 104, 2, 100, 0, 2, 105, 100, 97, 1})
+	if err == nil || err.Error() != "bad id" {
+		t.Errorf("expected decoder error, got %v", err)
+	}
+}
+
+type testLocation struct {
+	Lat, Lon float64
+}
+
+func registerTestLocation() {
+	RegisterExtension(Atom("geo"), testLocation{})
+}
+
+func TestRegisterExtension(t *testing.T) {
+	register(t, registerTestLocation)
+	loc := testLocation{0.5, -0.5}
+	data := []byte{131, 104, 3,
+		100, 0, 3, 103, 101, 111,
+		70, 63, 224, 0, 0, 0, 0, 0, 0,
+		70, 191, 224, 0, 0, 0, 0, 0, 0,
+	}
+	assertDecode(t, data, loc)
+
+	encoded, err := Encode(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, loc, back)
+
+	// extensions apply at any depth
+	nested := []byte{131, 108, 0, 0, 0, 1,
+		104, 2, 100, 0, 2, 97, 116,
+		104, 3,
+		100, 0, 3, 103, 101, 111,
+		70, 63, 224, 0, 0, 0, 0, 0, 0,
+		70, 191, 224, 0, 0, 0, 0, 0, 0,
+		106,
+	}
+	assertDecode(t, nested, []Term{[]Term{Atom("at"), loc}})
+
+	// Tuples that do not fit the type are left as they are.
+	assertDecode(t, []byte{131, 104, 2, 100, 0, 3, 103, 101, 111, 97, 1},
+		[]Term{Atom("geo"), int64(1)})
+	assertDecode(t, []byte{131, 104, 3, 100, 0, 3, 103, 101, 111, 97, 1, 100, 0, 1, 120},
+		[]Term{Atom("geo"), int64(1), Atom("x")})
+
+	// So are RPC packets, whatever their tags.
+	register(t, func() {
+		RegisterExtension(Atom("call"), testLocation{})
+		RegisterExtension(Atom("reply"), testLocation{})
+	})
+	c := pipe(newTestServer())
+	defer c.Close()
+	result, err := c.Call(context.Background(), "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+}
+
+func TestRegisterCleanup(t *testing.T) {
+	// Types registered by other tests are gone.
+	assertEncode(t, testStatusOK, []byte{131, 97, 1})
+	assertDecode(t, []byte{131, 104, 2, 100, 0, 3, 103, 101, 111, 97, 1},
+		[]Term{Atom("geo"), int64(1)})
+}
@@ -0,0 +1,140 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Resolver discovers the addresses of the servers offering a service,
+// given as for DialAddr, so that a BalancedClient can follow backends as
+// they scale.
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// A ResolverFunc is a function used as a Resolver.
+type ResolverFunc func(ctx context.Context) ([]string, error)
+
+// Resolve returns f(ctx).
+func (f ResolverFunc) Resolve(ctx context.Context) ([]string, error) { return f(ctx) }
+
+// An SRVResolver resolves the servers of a service from DNS SRV records,
+// looking up _Service._Proto.Name as net.LookupSRV does. Servers are
+// returned by priority, and randomized by weight within each priority.
+type SRVResolver struct {
+	Service, Proto, Name string
+
+	// Resolver is the DNS resolver used. Nil means net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// Resolve looks up the SRV records of the service.
+func (r SRVResolver) Resolve(ctx context.Context) ([]string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, srvs, err := resolver.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	return srvAddrs(srvs), nil
+}
+
+// srvAddrs returns the host:port addresses of SRV records.
+func srvAddrs(srvs []*net.SRV) []string {
+	addrs := make([]string, len(srvs))
+	for i, srv := range srvs {
+		addrs[i] = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+	}
+	return addrs
+}
+
+// DefaultRefreshInterval is the interval at which NewResolvedClient
+// refreshes endpoints by default.
+const DefaultRefreshInterval = 30 * time.Second
+
+// NewResolvedClient returns a client spreading calls between the servers
+// found by r, which it consults again every interval until it is closed.
+// Zero means DefaultRefreshInterval. The servers are first resolved with
+// ctx, failing if they cannot be; later failures are logged and keep the
+// endpoints found before.
+func NewResolvedClient(ctx context.Context, r Resolver, interval time.Duration) (*BalancedClient, error) {
+	c := &BalancedClient{}
+	if err := c.Refresh(ctx, r); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	c.stop = make(chan struct{})
+	go c.refreshEvery(r, interval, c.stop)
+	return c, nil
+}
+
+// refreshEvery refreshes the endpoints of c from r every interval until
+// stop is closed.
+func (c *BalancedClient) refreshEvery(r Resolver, interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := c.Refresh(ctx, r); err != nil {
+			logf(c.Logger, "bert: refreshing endpoints failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Refresh replaces the endpoints of c with the servers found by r. Those
+// already known keep their connection and health; the connections of
+// those gone are closed once their calls in flight are answered. Finding
+// no server is an error, leaving the endpoints unchanged.
+func (c *BalancedClient) Refresh(ctx context.Context, r Resolver) error {
+	addrs, err := r.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return errors.New("no servers found")
+	}
+
+	c.mu.Lock()
+	known := make(map[string]*endpoint, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		known[ep.addr] = ep
+	}
+	endpoints := make([]*endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		ep, ok := known[addr]
+		if !ok {
+			ep = &endpoint{addr: addr}
+		}
+		delete(known, addr)
+		endpoints = append(endpoints, ep)
+	}
+	c.endpoints = endpoints
+	var idle []*Client
+	for _, ep := range known {
+		ep.removed = true
+		if ep.pending == 0 && ep.client != nil {
+			idle = append(idle, ep.client)
+			ep.client = nil
+		}
+	}
+	c.mu.Unlock()
+
+	for _, client := range idle {
+		client.Close()
+	}
+	return nil
+}
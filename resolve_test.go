@@ -0,0 +1,90 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResolvedClient(t *testing.T) {
+	var mu sync.Mutex
+	addrs := []string{"a", "b"}
+	var resolveErr error
+	r := ResolverFunc(func(ctx context.Context) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return addrs, resolveErr
+	})
+
+	s := NewServer()
+	s.Register("who", "am_i", func(ctx context.Context, args []Term) (Term, error) {
+		return Atom("ok"), nil
+	})
+	ctx := context.Background()
+
+	c, err := NewResolvedClient(ctx, r, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dialed := make(chan string, 10)
+	c.Dial = func(addr string) (*Client, error) {
+		dialed <- addr
+		return pipe(s), nil
+	}
+
+	addrsOf := func() []string {
+		var got []string
+		for _, status := range c.Endpoints() {
+			got = append(got, status.Addr)
+		}
+		return got
+	}
+	assertEqual(t, []string{"a", "b"}, addrsOf())
+	if _, err := c.Call(ctx, "who", "am_i"); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "a", <-dialed)
+
+	// Endpoints follow the resolver, keeping those already known.
+	mu.Lock()
+	addrs = []string{"c", "a"}
+	mu.Unlock()
+	for len(addrsOf()) != 2 || addrsOf()[0] != "c" {
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, []string{"c", "a"}, addrsOf())
+	for i := 0; i < 2; i++ {
+		if _, err := c.Call(ctx, "who", "am_i"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertEqual(t, "c", <-dialed)
+	select {
+	case addr := <-dialed:
+		t.Errorf("expected a to keep its connection, dialed %s", addr)
+	default:
+	}
+
+	// Failures keep the endpoints found before.
+	mu.Lock()
+	resolveErr = errors.New("no such host")
+	mu.Unlock()
+	if err := c.Refresh(ctx, r); err == nil {
+		t.Errorf("expected the resolver's error")
+	}
+	if err := c.Refresh(ctx, ResolverFunc(func(ctx context.Context) ([]string, error) { return nil, nil })); err == nil {
+		t.Errorf("expected an error when no server is found")
+	}
+	assertEqual(t, []string{"c", "a"}, addrsOf())
+}
+
+func TestSRVAddrs(t *testing.T) {
+	assertEqual(t, []string{"a.example.com:9999", "[::1]:80"}, srvAddrs([]*net.SRV{
+		{Target: "a.example.com.", Port: 9999},
+		{Target: "::1", Port: 80},
+	}))
+}
@@ -0,0 +1,125 @@
+package bert
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Defaults of RetryPolicy.
+const (
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 10 * time.Second
+)
+
+// A RetryPolicy configures how a BalancedClient retries calls and casts
+// that failed for transient reasons: because their endpoint could not be
+// dialed, its connection failed, or the server refused the request with
+// protocol error 4 or 5, being too busy.
+//
+// Each retry goes to an endpoint picked anew, after a backoff that starts
+// at InitialBackoff and doubles up to MaxBackoff. A request that may have
+// reached a server, because the connection failed after it was sent, is
+// only retried if Idempotent allows it, since the server may have handled
+// it already.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the
+	// first. Zero or one means requests are not retried.
+	MaxAttempts int
+
+	// InitialBackoff and MaxBackoff bound the backoff between attempts.
+	// Zero means DefaultInitialBackoff and DefaultMaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Jitter, from 0 to 1, is the fraction of each backoff that is
+	// randomized, so that clients failing together do not retry together.
+	Jitter float64
+
+	// Retryable, if set, reports whether other errors are transient too.
+	Retryable func(err error) bool
+
+	// Idempotent reports whether a request of kind CallAtom or CastAtom
+	// for module:function may be sent again if it may have reached a
+	// server. Nil means no request may.
+	Idempotent func(kind, module, function Atom) bool
+}
+
+// A failure classifies the error of an attempt at a request.
+type failure int
+
+const (
+	failPermanent failure = iota // not to be retried
+	failUnsent                   // the request did not reach a server
+	failMaybeSent                // the request may have reached a server
+)
+
+// retry reports whether a request that failed with err, classified as f,
+// on the given attempt, counting from 1, is retried.
+func (p *RetryPolicy) retry(attempt int, err error, f failure, kind, module, function Atom) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if f == failPermanent && p.Retryable != nil && p.Retryable(err) {
+		f = failMaybeSent
+	}
+	switch f {
+	case failUnsent:
+		return true
+	case failMaybeSent:
+		return p.Idempotent != nil && p.Idempotent(kind, module, function)
+	}
+	return false
+}
+
+// backoff returns the time to wait after the given attempt failed.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d, max := p.InitialBackoff, p.MaxBackoff
+	if d <= 0 {
+		d = DefaultInitialBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if j := p.Jitter; j > 0 {
+		if j > 1 {
+			j = 1
+		}
+		spread := time.Duration(float64(d) * j)
+		d -= time.Duration(rand.Int63n(int64(spread) + 1))
+	}
+	return d
+}
+
+// sleep waits for d or until ctx is done, returning ctx's error then.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// classify returns the failure of a request to client that failed with
+// err.
+func classify(client *Client, err error) failure {
+	if e, ok := err.(*RPCError); ok {
+		if e.Type == ProtocolError && (e.Code == 4 || e.Code == 5) {
+			return failUnsent
+		}
+		return failPermanent
+	}
+	if client.failed() != nil {
+		return failMaybeSent
+	}
+	return failPermanent
+}
@@ -0,0 +1,93 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBalancedClientRetry(t *testing.T) {
+	s := NewServer()
+	s.Register("who", "am_i", func(ctx context.Context, args []Term) (Term, error) {
+		return Atom("ok"), nil
+	})
+	dial := func(addr string) (*Client, error) {
+		switch addr {
+		case "down":
+			return nil, errors.New("connection refused")
+		case "flaky":
+			// reads the request, then drops the connection
+			a, b := net.Pipe()
+			go func() {
+				io.ReadFull(b, make([]byte, 4))
+				b.Close()
+			}()
+			return NewClient(a), nil
+		}
+		return pipe(s), nil
+	}
+	ctx := context.Background()
+
+	newClient := func(addrs ...string) *BalancedClient {
+		c := NewBalancedClient(addrs...)
+		c.Dial = dial
+		c.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	// Requests that never reached a server are retried.
+	c := newClient("down", "ok")
+	result, err := c.Call(ctx, "who", "am_i")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("ok"), result)
+
+	// Those that may have are only retried if idempotent.
+	c = newClient("flaky", "ok")
+	if _, err := c.Call(ctx, "who", "am_i"); err == nil {
+		t.Errorf("expected a call that may have been handled not to be retried")
+	}
+	c = newClient("flaky", "ok")
+	c.Retry.Idempotent = func(kind, module, function Atom) bool { return kind == CallAtom }
+	if _, err := c.Call(ctx, "who", "am_i"); err != nil {
+		t.Errorf("expected an idempotent call to be retried, got %v", err)
+	}
+	c = newClient("flaky", "ok")
+	c.Retry.Idempotent = func(kind, module, function Atom) bool { return kind == CallAtom }
+	if err := c.Cast(ctx, "who", "am_i"); err == nil {
+		t.Errorf("expected a cast not to be retried")
+	}
+
+	// Attempts are limited.
+	c = newClient("down")
+	c.Retry.MaxAttempts = 2
+	if _, err := c.Call(ctx, "who", "am_i"); err == nil || err.Error() != "connection refused" {
+		t.Errorf("expected the dial error, got %v", err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	var got []time.Duration
+	for attempt := 1; attempt <= 5; attempt++ {
+		got = append(got, p.backoff(attempt))
+	}
+	assertEqual(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, got)
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(2); d < time.Second || d > 2*time.Second {
+			t.Fatalf("backoff %v outside [1s, 2s]", d)
+		}
+	}
+
+	busy := &RPCError{Type: ProtocolError, Code: 5}
+	if !(&RetryPolicy{MaxAttempts: 2}).retry(1, busy, classify(&Client{}, busy), CastAtom, "m", "f") {
+		t.Errorf("expected a refused cast to be retried")
+	}
+}
@@ -0,0 +1,233 @@
+package bert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// BERT-RPC packet atoms.
+const (
+	CallAtom    = Atom("call")
+	CastAtom    = Atom("cast")
+	ReplyAtom   = Atom("reply")
+	NoReplyAtom = Atom("noreply")
+	ErrorAtom   = Atom("error")
+	InfoAtom    = Atom("info")
+)
+
+// BERT-RPC error types.
+const (
+	ProtocolError = Atom("protocol")
+	ServerError   = Atom("server")
+	UserError     = Atom("user")
+	ProxyError    = Atom("proxy")
+)
+
+// ErrShutdown is returned for calls on a client whose connection has been
+// closed.
+var ErrShutdown = errors.New("connection is shut down")
+
+// An RPCError is an error response
+// {error, {Type, Code, Class, Detail, Backtrace}} as defined by BERT-RPC.
+type RPCError struct {
+	Type      Atom
+	Code      int
+	Class     string
+	Detail    string
+	Backtrace []string
+
+	// CorrelationID is the correlation ID of the request the error
+	// answered, if the client sent one. It is not part of the response.
+	CorrelationID string
+}
+
+func (e *RPCError) Error() string {
+	var msg string
+	if e.Class == "" {
+		msg = fmt.Sprintf("%s error %d: %s", e.Type, e.Code, e.Detail)
+	} else {
+		msg = fmt.Sprintf("%s error %d (%s): %s", e.Type, e.Code, e.Class, e.Detail)
+	}
+	return msg + correlationNote(e.CorrelationID)
+}
+
+// term returns the response packet for e. Its class, detail and backtrace
+// lines are binaries, as BERT-RPC peers expect.
+func (e *RPCError) term() Term {
+	backtrace := List{Items: make([]Term, len(e.Backtrace))}
+	for i, line := range e.Backtrace {
+		backtrace.Items[i] = Binary(line)
+	}
+	return []Term{ErrorAtom, []Term{e.Type, e.Code, Binary(e.Class), Binary(e.Detail), backtrace}}
+}
+
+// userError returns err as an *RPCError, making any other error a user
+// error.
+func userError(err error) *RPCError {
+	if rpcErr, ok := err.(*RPCError); ok {
+		return rpcErr
+	}
+	return &RPCError{Type: UserError, Code: 0, Detail: err.Error()}
+}
+
+// parseRPCError parses the detail tuple of an error response.
+func parseRPCError(term Term) (*RPCError, error) {
+	detail, ok := term.([]Term)
+	if !ok || len(detail) != 5 {
+		return nil, fmt.Errorf("malformed error response %v", term)
+	}
+
+	e := &RPCError{}
+	if e.Type, ok = detail[0].(Atom); !ok {
+		return nil, fmt.Errorf("malformed error response %v", term)
+	}
+	switch code := detail[1].(type) {
+	case int64:
+		e.Code = int(code)
+	case int:
+		e.Code = code
+	default:
+		return nil, fmt.Errorf("malformed error response %v", term)
+	}
+	e.Class = textOf(detail[2])
+	e.Detail = textOf(detail[3])
+	if lines, ok := listOf(detail[4]); ok {
+		for _, line := range lines {
+			e.Backtrace = append(e.Backtrace, textOf(line))
+		}
+	}
+	return e, nil
+}
+
+// textOf returns the text of a string, binary or atom term, and formats any
+// other term.
+func textOf(term Term) string {
+	switch x := term.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case Atom:
+		return string(x)
+	}
+	return fmt.Sprint(term)
+}
+
+// listOf returns the elements of a list term. Lists of small integers
+// arrive as STRING_EXT and are expanded back into integers.
+func listOf(term Term) ([]Term, bool) {
+	switch x := term.(type) {
+	case []Term:
+		return x, true
+	case List:
+		return x.Items, true
+	case string:
+		list := make([]Term, len(x))
+		for i := 0; i < len(x); i++ {
+			list[i] = int64(x[i])
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// parseRequest parses a {call|cast, Module, Function, Arguments} packet.
+func parseRequest(term Term) (Request, error) {
+	var req Request
+	tuple, ok := term.([]Term)
+	if !ok || len(tuple) != 4 {
+		return req, fmt.Errorf("malformed request %v", term)
+	}
+	req.Kind, _ = tuple[0].(Atom)
+	req.Module, _ = tuple[1].(Atom)
+	req.Function, _ = tuple[2].(Atom)
+	req.Arguments, ok = listOf(tuple[3])
+	if (req.Kind != CallAtom && req.Kind != CastAtom) || req.Module == "" || req.Function == "" || !ok {
+		return req, fmt.Errorf("malformed request %v", term)
+	}
+	return req, nil
+}
+
+// requestTerm returns the packet for a request.
+func requestTerm(kind, module, function Atom, args []Term) Term {
+	if args == nil {
+		args = []Term{}
+	}
+	return []Term{kind, module, function, List{Items: args}}
+}
+
+// infoTerm returns an {info, Command, Options} packet.
+func infoTerm(command Atom, options ...Term) Term {
+	if options == nil {
+		options = []Term{}
+	}
+	return []Term{InfoAtom, command, List{Items: options}}
+}
+
+// parseInfo parses an {info, Command, Options} packet.
+func parseInfo(term Term) (command Atom, options []Term, ok bool) {
+	tuple, isTuple := term.([]Term)
+	if !isTuple || len(tuple) != 3 || tuple[0] != InfoAtom {
+		return "", nil, false
+	}
+	if command, ok = tuple[1].(Atom); !ok {
+		return "", nil, false
+	}
+	options, ok = listOf(tuple[2])
+	return command, options, ok
+}
+
+// parseResponse parses a reply, noreply or error packet, returning the
+// result of a reply or the error of an error response.
+func parseResponse(term Term) (Term, error) {
+	tuple, ok := term.([]Term)
+	if ok && len(tuple) > 0 {
+		switch tuple[0] {
+		case ReplyAtom:
+			if len(tuple) == 2 {
+				return tuple[1], nil
+			}
+		case NoReplyAtom:
+			if len(tuple) == 1 {
+				return nil, nil
+			}
+		case ErrorAtom:
+			if len(tuple) == 2 {
+				e, err := parseRPCError(tuple[1])
+				if err != nil {
+					return nil, err
+				}
+				return nil, e
+			}
+		}
+	}
+	return nil, fmt.Errorf("malformed response %v", term)
+}
+
+// peekSeq returns the sequence number of an enveloped packet from the
+// first bytes of its encoding.
+func peekSeq(head []byte) (seq int64, ok bool) {
+	if len(head) < 5 || head[0] != VersionTag || head[1] != SmallTupleTag || head[2] != 2 {
+		return 0, false
+	}
+	switch head[3] {
+	case SmallIntTag:
+		return int64(head[4]), true
+	case IntTag:
+		if len(head) >= 8 {
+			return int64(int32(binary.BigEndian.Uint32(head[4:8]))), true
+		}
+	}
+	return 0, false
+}
+
+// envelope splits a multiplexed {SeqID, Payload} packet.
+func envelope(term Term) (seq int64, payload Term, ok bool) {
+	tuple, isTuple := term.([]Term)
+	if !isTuple || len(tuple) != 2 {
+		return 0, nil, false
+	}
+	seq, ok = tuple[0].(int64)
+	return seq, tuple[1], ok
+}
@@ -0,0 +1,439 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer() *Server {
+	s := NewServer()
+	s.Register("math", "add", func(ctx context.Context, args []Term) (Term, error) {
+		var sum int64
+		for _, arg := range args {
+			sum += arg.(int64)
+		}
+		return sum, nil
+	})
+	s.Register("math", "fail", func(ctx context.Context, args []Term) (Term, error) {
+		return nil, errors.New("boom")
+	})
+	return s
+}
+
+// pipe returns a client connected to s over an in-memory connection.
+func pipe(s *Server) *Client {
+	a, b := net.Pipe()
+	go s.ServeConn(b)
+	return NewClient(a)
+}
+
+func TestClientCall(t *testing.T) {
+	c := pipe(newTestServer())
+	defer c.Close()
+	ctx := context.Background()
+
+	result, err := c.Call(ctx, "math", "add", 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(6), result)
+
+	if err := c.Cast(ctx, "math", "add", 1); err != nil {
+		t.Errorf("cast failed: %v", err)
+	}
+
+	_, err = c.Call(ctx, "math", "fail")
+	assertEqual(t, &RPCError{Type: UserError, Detail: "boom"}, err)
+
+	_, err = c.Call(ctx, "nope", "add")
+	if e, ok := err.(*RPCError); !ok || e.Type != ServerError || e.Code != 1 {
+		t.Errorf("expected server error 1, got %v", err)
+	}
+	_, err = c.Call(ctx, "math", "nope")
+	if e, ok := err.(*RPCError); !ok || e.Type != ServerError || e.Code != 2 {
+		t.Errorf("expected server error 2, got %v", err)
+	}
+
+	c.Close()
+	if _, err := c.Call(ctx, "math", "add"); err != ErrShutdown {
+		t.Errorf("expected ErrShutdown, got %v", err)
+	}
+}
+
+func TestRPCErrorTerm(t *testing.T) {
+	e := &RPCError{Type: UserError, Code: 3, Class: "RuntimeError", Detail: "boom", Backtrace: []string{"a.go:1"}}
+	data, err := Encode(e.term())
+	if err != nil {
+		t.Fatal(err)
+	}
+	term, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Class, detail and backtrace lines travel as binaries.
+	assertEqual(t, []Term{ErrorAtom, []Term{UserError, int64(3),
+		[]byte("RuntimeError"), []byte("boom"), []Term{[]byte("a.go:1")}}}, term)
+	parsed, err := parseRPCError(term.([]Term)[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, e, parsed)
+}
+
+func TestClientMultiplex(t *testing.T) {
+	s := newTestServer()
+	release := make(chan struct{})
+	s.Register("sync", "wait", func(ctx context.Context, args []Term) (Term, error) {
+		<-release
+		return Atom("released"), nil
+	})
+	s.Register("sync", "release", func(ctx context.Context, args []Term) (Term, error) {
+		close(release)
+		return Atom("ok"), nil
+	})
+
+	c := pipe(s)
+	c.Multiplex = true
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the first call only completes once the second one has been handled
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result, err := c.Call(ctx, "sync", "wait")
+		if err != nil {
+			t.Error(err)
+		}
+		assertEqual(t, Atom("released"), result)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	result, err := c.Call(ctx, "sync", "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("ok"), result)
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := c.Call(ctx, "math", "add", i, i)
+			if err != nil {
+				t.Error(err)
+			}
+			assertEqual(t, int64(2*i), result)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestClientMultiplexWriteTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := NewClient(a)
+	c.Multiplex = true
+	defer c.Close()
+
+	// Nothing is written for terms that cannot be encoded.
+	if _, err := c.Call(context.Background(), "math", "add", make(chan int)); err == nil {
+		t.Fatal("expected an encoding error")
+	}
+	if err := c.failed(); err != nil {
+		t.Fatalf("connection failed after an encoding error: %v", err)
+	}
+
+	// A write interrupted by the deadline may leave a partial frame, so
+	// the connection fails rather than serve later calls misaligned.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, "math", "add", 1); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if _, err := c.Call(context.Background(), "math", "add", 1); err == nil {
+		t.Error("expected the connection to have failed")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := newTestServer()
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	s.Register("slow", "run", func(ctx context.Context, args []Term) (Term, error) {
+		close(started)
+		<-finish
+		return Atom("done"), nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	c, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	called := make(chan Term, 1)
+	go func() {
+		result, err := c.Call(context.Background(), "slow", "run")
+		if err != nil {
+			t.Error(err)
+		}
+		called <- result
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Errorf("expected listener to be closed")
+	}
+
+	close(finish)
+	assertEqual(t, Atom("done"), <-called)
+	if err := <-shutdown; err != nil {
+		t.Errorf("expected clean shutdown, got %v", err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s := newTestServer()
+	started := make(chan struct{})
+	s.Register("slow", "hang", func(ctx context.Context, args []Term) (Term, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	c := pipe(s)
+	c.Multiplex = true
+	defer c.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Call(context.Background(), "slow", "hang")
+		errs <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+
+	err := <-errs
+	if e, ok := err.(*RPCError); !ok || e.Type != ProtocolError {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+}
+
+func assertProtocolError(t *testing.T, code int, err error) {
+	t.Helper()
+	if e, ok := err.(*RPCError); !ok || e.Type != ProtocolError || e.Code != code {
+		t.Errorf("expected protocol error %d, got %v", code, err)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := newTestServer()
+	s.MaxRequestSize = 64
+	for _, multiplex := range []bool{false, true} {
+		c := pipe(s)
+		c.Multiplex = multiplex
+		_, err := c.Call(ctx, "math", "add", make([]Term, 100)...)
+		assertProtocolError(t, 3, err)
+		// the connection is still usable
+		result, err := c.Call(ctx, "math", "add", 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, int64(3), result)
+		c.Close()
+	}
+
+	s = newTestServer()
+	s.MaxConcurrentRequests = 1
+	release := make(chan struct{})
+	s.Register("sync", "wait", func(ctx context.Context, args []Term) (Term, error) {
+		<-release
+		return Atom("released"), nil
+	})
+	c := pipe(s)
+	c.Multiplex = true
+	done := make(chan error)
+	go func() {
+		_, err := c.Call(ctx, "sync", "wait")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, err := c.Call(ctx, "math", "add", 1)
+	assertProtocolError(t, 4, err)
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	c.Close()
+
+	s = newTestServer()
+	s.RateLimit = 0.001
+	s.RateBurst = 2
+	c = pipe(s)
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := c.Call(ctx, "math", "add", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = c.Call(ctx, "math", "add", 1)
+	assertProtocolError(t, 5, err)
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	now := time.Now()
+	assertEqual(t, true, b.allow(now))
+	assertEqual(t, true, b.allow(now))
+	assertEqual(t, false, b.allow(now))
+	assertEqual(t, false, b.allow(now.Add(50*time.Millisecond)))
+	assertEqual(t, true, b.allow(now.Add(100*time.Millisecond)))
+	assertEqual(t, true, b.allow(now.Add(time.Hour)))
+	assertEqual(t, true, b.allow(now.Add(time.Hour)))
+	assertEqual(t, false, b.allow(now.Add(time.Hour)))
+}
+
+func TestClientChecksum(t *testing.T) {
+	s := newTestServer()
+	s.Checksum = CRC32Checksum
+	c := pipe(s)
+	defer c.Close()
+	c.Checksum = CRC32Checksum
+
+	result, err := c.Call(context.Background(), "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+}
+
+func TestServerCasts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := newTestServer()
+	s.MaxConcurrentRequests = 1
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s.Register("sync", "wait", func(ctx context.Context, args []Term) (Term, error) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil, nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	c, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A running cast takes a slot.
+	if err := c.Cast(ctx, "sync", "wait"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	_, err = c.Call(ctx, "math", "add", 1)
+	assertProtocolError(t, 4, err)
+
+	// Shutdown waits for casts to return.
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the cast", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServerPanics(t *testing.T) {
+	s := newTestServer()
+	s.Register("oops", "panic", func(ctx context.Context, args []Term) (Term, error) {
+		panic("boom")
+	})
+	c := pipe(s)
+	defer c.Close()
+	ctx := context.Background()
+
+	// Panics are recovered, for calls and casts alike.
+	_, err := c.Call(ctx, "oops", "panic")
+	if e, ok := err.(*RPCError); !ok || e.Type != UserError {
+		t.Errorf("expected a user error, got %v", err)
+	}
+	if err := c.Cast(ctx, "oops", "panic"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Call(ctx, "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+}
+
+func TestServerShutdownCancelsCasts(t *testing.T) {
+	s := newTestServer()
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	s.Register("sync", "hang", func(ctx context.Context, args []Term) (Term, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	c := pipe(s)
+	defer c.Close()
+	if err := c.Cast(context.Background(), "sync", "hang"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("cast not cancelled")
+	}
+}
@@ -0,0 +1,278 @@
+package bert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Schema describes the expected shape of a term, for validating terms
+// received from other programs. Schemas are built with the functions
+// below, e.g.
+//
+//	bert.OneOf(
+//		bert.Tuple(bert.Atoms("ok"), bert.Any),
+//		bert.Tuple(bert.Atoms("error"), bert.OfKind(bert.BinaryKind)),
+//	)
+type Schema interface {
+	// String describes the terms the schema accepts.
+	String() string
+
+	check(path string, term Term, v *[]Violation)
+}
+
+// A Violation is a part of a term that does not match its schema. Path
+// locates it within the term as in Difference.
+type Violation struct {
+	Path     string
+	Expected string
+	Got      Term
+}
+
+func (v Violation) Error() string {
+	if v.Path == "" {
+		return fmt.Sprintf("expected %s, got %s", v.Expected, describeTerm(v.Got))
+	}
+	return fmt.Sprintf("expected %s at %s, got %s", v.Expected, v.Path, describeTerm(v.Got))
+}
+
+// describeTerm describes term for a Violation, as in "integer 42".
+func describeTerm(term Term) string {
+	switch x := term.(type) {
+	case nil:
+		return "nil"
+	case Atom:
+		return fmt.Sprintf("atom '%s'", x)
+	case []byte:
+		return fmt.Sprintf("binary of %d bytes", len(x))
+	case []Term:
+		return fmt.Sprintf("tuple of %d elements", len(x))
+	}
+	return fmt.Sprintf("%v %v", KindOf(term), term)
+}
+
+// Validate checks term against s and returns every violation found, or
+// nil if term matches.
+func Validate(term Term, s Schema) []Violation {
+	var v []Violation
+	s.check("", term, &v)
+	return v
+}
+
+// A SchemaError reports the violations found validating a term against
+// its schema.
+type SchemaError struct {
+	Violations []Violation
+}
+
+func (e *SchemaError) Error() string {
+	if len(e.Violations) == 1 {
+		return e.Violations[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more violations)", e.Violations[0].Error(), len(e.Violations)-1)
+}
+
+// UnmarshalSchema decodes a term from data and validates it against s
+// before storing it in the value pointed to by val, as Unmarshal does. A
+// term that does not match s is reported with a *SchemaError and leaves
+// val unchanged.
+func UnmarshalSchema(data []byte, s Schema, val interface{}) error {
+	term, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	if v := Validate(term, s); v != nil {
+		return &SchemaError{Violations: v}
+	}
+	return UnmarshalTerm(term, val)
+}
+
+// Any accepts any term.
+var Any Schema = anySchema{}
+
+type anySchema struct{}
+
+func (anySchema) String() string                   { return "any term" }
+func (anySchema) check(string, Term, *[]Violation) {}
+
+// OfKind accepts terms of the given kind. Since Decode returns lists as
+// []Term, ListKind also accepts tuples and TupleKind lists.
+func OfKind(kind TermKind) Schema { return kindSchema(kind) }
+
+type kindSchema TermKind
+
+func (s kindSchema) String() string { return TermKind(s).String() }
+
+func (s kindSchema) check(path string, term Term, v *[]Violation) {
+	kind := KindOf(term)
+	switch {
+	case kind == TermKind(s):
+	case TermKind(s) == ListKind && isList(term):
+	case TermKind(s) == TupleKind && kind == ListKind:
+	default:
+		*v = append(*v, Violation{Path: path, Expected: s.String(), Got: term})
+	}
+}
+
+// isList reports whether term can be a decoded list.
+func isList(term Term) bool {
+	_, ok := listOf(term)
+	return ok
+}
+
+// Atoms accepts the given atoms.
+func Atoms(atoms ...Atom) Schema { return atomSchema(atoms) }
+
+type atomSchema []Atom
+
+func (s atomSchema) String() string {
+	names := make([]string, len(s))
+	for i, a := range s {
+		names[i] = "'" + string(a) + "'"
+	}
+	return "atom " + strings.Join(names, "|")
+}
+
+func (s atomSchema) check(path string, term Term, v *[]Violation) {
+	if a, ok := term.(Atom); ok {
+		for _, want := range s {
+			if a == want {
+				return
+			}
+		}
+	}
+	*v = append(*v, Violation{Path: path, Expected: s.String(), Got: term})
+}
+
+// Tuple accepts tuples with one element for each of elems, matching it.
+func Tuple(elems ...Schema) Schema { return tupleSchema(elems) }
+
+type tupleSchema []Schema
+
+func (s tupleSchema) String() string {
+	elems := make([]string, len(s))
+	for i, elem := range s {
+		elems[i] = elem.String()
+	}
+	return "{" + strings.Join(elems, ", ") + "}"
+}
+
+func (s tupleSchema) check(path string, term Term, v *[]Violation) {
+	tuple, ok := term.([]Term)
+	if !ok || len(tuple) != len(s) {
+		*v = append(*v, Violation{Path: path, Expected: s.String(), Got: term})
+		return
+	}
+	for i, elem := range s {
+		elem.check(fmt.Sprintf("%s[%d]", path, i), tuple[i], v)
+	}
+}
+
+// ListOf accepts lists whose elements all match elem.
+func ListOf(elem Schema) Schema { return listSchema{elem} }
+
+type listSchema struct{ elem Schema }
+
+func (s listSchema) String() string { return "list of " + s.elem.String() }
+
+func (s listSchema) check(path string, term Term, v *[]Violation) {
+	items, ok := listOf(term)
+	if !ok {
+		*v = append(*v, Violation{Path: path, Expected: s.String(), Got: term})
+		return
+	}
+	for i, item := range items {
+		s.elem.check(fmt.Sprintf("%s[%d]", path, i), item, v)
+	}
+}
+
+// Map accepts maps with exactly the keys of fields, matched as by Equal,
+// whose values match the schema of their key. Keys whose schema is wrapped in Optional may
+// be left out.
+func Map(fields map[Term]Schema) Schema { return mapSchema(fields) }
+
+type mapSchema map[Term]Schema
+
+// keys returns the keys of s in a stable order.
+func (s mapSchema) keys() []Term {
+	keys := make([]Term, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return Compare(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+func (s mapSchema) String() string {
+	keys := s.keys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = fmt.Sprint(k)
+	}
+	return "map with keys " + strings.Join(names, ", ")
+}
+
+func (s mapSchema) check(path string, term Term, v *[]Violation) {
+	tv := termValue(term)
+	if kindOf(tv) != MapKind {
+		*v = append(*v, Violation{Path: path, Expected: s.String(), Got: term})
+		return
+	}
+	m := termMapOf(tv)
+
+	for _, k := range s.keys() {
+		elem := fmt.Sprintf("%s[%#v]", path, k)
+		value, ok := m.Get(k)
+		if !ok {
+			if _, ok := s[k].(optionalSchema); !ok {
+				*v = append(*v, Violation{Path: elem, Expected: s[k].String(), Got: nil})
+			}
+			continue
+		}
+		s[k].check(elem, value, v)
+	}
+
+	for _, kv := range m.entries {
+		if !s.has(kv.Key) {
+			elem := fmt.Sprintf("%s[%#v]", path, kv.Key)
+			*v = append(*v, Violation{Path: elem, Expected: "no entry", Got: kv.Value})
+		}
+	}
+}
+
+// has reports whether s has a schema for key.
+func (s mapSchema) has(key Term) bool {
+	for k := range s {
+		if Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Optional marks the schema of a Map key whose entry may be left out.
+func Optional(s Schema) Schema { return optionalSchema{s} }
+
+type optionalSchema struct{ Schema }
+
+// OneOf accepts terms matching any of alts.
+func OneOf(alts ...Schema) Schema { return oneOfSchema(alts) }
+
+type oneOfSchema []Schema
+
+func (s oneOfSchema) String() string {
+	alts := make([]string, len(s))
+	for i, alt := range s {
+		alts[i] = alt.String()
+	}
+	return strings.Join(alts, " or ")
+}
+
+func (s oneOfSchema) check(path string, term Term, v *[]Violation) {
+	for _, alt := range s {
+		if len(Validate(term, alt)) == 0 {
+			return
+		}
+	}
+	*v = append(*v, Violation{Path: path, Expected: s.String(), Got: term})
+}
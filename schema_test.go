@@ -0,0 +1,98 @@
+package bert
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	s := Tuple(
+		Atoms("user"),
+		OfKind(IntegerKind),
+		ListOf(OfKind(BinaryKind)),
+		Map(map[Term]Schema{
+			Atom("name"):  OfKind(StringKind),
+			Atom("email"): Optional(OfKind(BinaryKind)),
+			Atom("role"):  Atoms("admin", "guest"),
+		}),
+	)
+
+	valid := []Term{
+		Atom("user"),
+		int64(1),
+		[]Term{[]byte("a"), []byte("b")},
+		map[Atom]Term{"name": "joe", "role": Atom("guest")},
+	}
+	if v := Validate(valid, s); v != nil {
+		t.Errorf("expected no violations, got %v", v)
+	}
+
+	invalid := []Term{
+		Atom("group"),
+		int64(1),
+		[]Term{[]byte("a"), int64(2)},
+		map[Atom]Term{"role": Atom("root"), "age": int64(30)},
+	}
+	assertEqual(t, []Violation{
+		{Path: "[0]", Expected: "atom 'user'", Got: Atom("group")},
+		{Path: "[2][1]", Expected: "binary", Got: int64(2)},
+		{Path: `[3]["name"]`, Expected: "string", Got: nil},
+		{Path: `[3]["role"]`, Expected: "atom 'admin'|'guest'", Got: Atom("root")},
+		{Path: `[3]["age"]`, Expected: "no entry", Got: int64(30)},
+	}, Validate(invalid, s))
+
+	v := Validate([]Term{Atom("user")}, s)
+	if len(v) != 1 || v[0].Path != "" {
+		t.Errorf("expected a single arity violation, got %v", v)
+	}
+}
+
+func TestValidateOneOf(t *testing.T) {
+	s := OneOf(
+		Tuple(Atoms("ok"), Any),
+		Tuple(Atoms("error"), OfKind(BinaryKind)),
+	)
+
+	if v := Validate([]Term{Atom("ok"), int64(1)}, s); v != nil {
+		t.Errorf("expected no violations, got %v", v)
+	}
+	if v := Validate([]Term{Atom("error"), []byte("boom")}, s); v != nil {
+		t.Errorf("expected no violations, got %v", v)
+	}
+
+	v := Validate(int64(42), s)
+	assertEqual(t, 1, len(v))
+	assertEqual(t, "expected {atom 'ok', any term} or {atom 'error', binary}, got integer 42", v[0].Error())
+}
+
+func TestValidateList(t *testing.T) {
+	// lists of small integers decode as strings
+	if v := Validate("abc", ListOf(OfKind(IntegerKind))); v != nil {
+		t.Errorf("expected no violations, got %v", v)
+	}
+	if v := Validate(List{Items: []Term{1}}, OfKind(ListKind)); v != nil {
+		t.Errorf("expected no violations, got %v", v)
+	}
+	assertEqual(t, "expected list of integer at [1], got atom 'x'",
+		Validate([]Term{int64(1), Atom("x")}, Tuple(Any, ListOf(OfKind(IntegerKind))))[0].Error())
+}
+
+func TestUnmarshalSchema(t *testing.T) {
+	type reply struct {
+		Status Atom
+		Value  int
+	}
+	s := Tuple(Atoms("ok", "error"), OfKind(IntegerKind))
+
+	var r reply
+	data, _ := Encode([]Term{Atom("ok"), 7})
+	if err := UnmarshalSchema(data, s, &r); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, reply{"ok", 7}, r)
+
+	data, _ = Encode([]Term{42, "seven"})
+	err := UnmarshalSchema(data, s, &r)
+	if _, ok := err.(*SchemaError); !ok {
+		t.Fatalf("expected *SchemaError, got %T", err)
+	}
+	assertEqual(t, `expected atom 'ok'|'error' at [0], got integer 42 (and 1 more violations)`, err.Error())
+	assertEqual(t, reply{"ok", 7}, r)
+}
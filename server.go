@@ -0,0 +1,612 @@
+package bert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A HandlerFunc implements a BERT-RPC function. It is called with the
+// decoded arguments of a request and returns the result to reply with. A
+// returned *RPCError is sent as is; any other error is sent as a user
+// error. A result that is an io.Reader or a StreamFunc is sent as a
+// streamed binary response. A panic is recovered and sent as a user error.
+type HandlerFunc func(ctx context.Context, args []Term) (Term, error)
+
+// A Server dispatches BERT-RPC requests to registered handlers.
+//
+// Besides plain BERT-RPC, where a connection carries one request at a
+// time, the server accepts requests wrapped in a {SeqID, Request}
+// envelope. Those are handled concurrently and answered with a
+// {SeqID, Response} envelope, so that one connection can carry many
+// requests in flight.
+//
+// Requests exceeding the limits below are answered with a protocol error
+// and the connection is kept open: code 3 for a request that is too large,
+// 4 for one beyond MaxConcurrentRequests and 5 for one beyond the rate
+// limit. The limits must be set before the server starts serving. Code 7
+// answers a request whose response exceeds the frame size the client
+// announced, as described for MaxFrameSizeAtom.
+type Server struct {
+	// Authenticate, if set, is called for each new connection before any
+	// request is served. Connections it rejects are answered with protocol
+	// error 6 and closed.
+	Authenticate Authenticator
+
+	// AuthenticateHTTP, if set, is called for each request served by
+	// HTTPHandler, which otherwise refuses every request if Authenticate
+	// is set.
+	AuthenticateHTTP HTTPAuthenticator
+
+	// CheckOrigin, if set, is called for each upgrade request served by
+	// WebSocketHandler and refuses it by returning false. Otherwise
+	// requests from browsers on other origins are refused, as by
+	// SameOrigin.
+	CheckOrigin func(r *http.Request) bool
+
+	// MaxRequestSize limits the encoded size of a request. Zero means
+	// DefaultMaxFrameSize.
+	MaxRequestSize int
+
+	// MaxConcurrentRequests limits the number of requests handled at once
+	// on each connection. Zero means no limit.
+	MaxConcurrentRequests int
+
+	// RateLimit limits the number of requests accepted per second on each
+	// connection, allowing bursts of up to RateBurst requests. Zero means
+	// no limit.
+	RateLimit float64
+	RateBurst int
+
+	// Logger, if set, is told about connections opening and closing,
+	// requests that are refused or malformed, and calls taking longer than
+	// SlowCall.
+	Logger   Logger
+	SlowCall time.Duration
+
+	// Tracer, if set, traces each call handled, continuing the trace of
+	// the client if it sent one.
+	Tracer Tracer
+
+	// CompressThreshold is the size from which responses are compressed on
+	// connections that negotiated compression. Zero means
+	// DefaultCompressThreshold and a negative value refuses compression.
+	CompressThreshold int
+
+	// ObserveRead and ObserveWrite, if set, are called with the statistics
+	// of each packet read from and written to a connection.
+	ObserveRead  func(CodecStats)
+	ObserveWrite func(CodecStats)
+
+	// Checksum selects the checksum trailing each packet, which clients
+	// must use too, as described for FrameChecksum.
+	Checksum FrameChecksum
+
+	mu      sync.RWMutex
+	modules map[Atom]map[Atom]HandlerFunc
+
+	// ctx bounds the lifetime of handlers, cancelled by Shutdown once its
+	// context expires, and casts tracks the casts run for HTTPHandler.
+	ctx    context.Context
+	cancel context.CancelFunc
+	casts  sync.WaitGroup
+
+	listeners  map[net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+	connsDone  sync.WaitGroup
+	inShutdown bool
+}
+
+// ErrServerClosed is returned by Serve after a call to Shutdown.
+var ErrServerClosed = errors.New("server closed")
+
+// NewServer returns a server with no registered handlers besides those of
+// RPCModule.
+func NewServer() *Server {
+	s := &Server{
+		modules:   make(map[Atom]map[Atom]HandlerFunc),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.registerBuiltins()
+	return s
+}
+
+// Register arranges for fn to handle requests for module:function,
+// replacing any previous handler.
+func (s *Server) Register(module, function Atom, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.modules[module]
+	if m == nil {
+		m = make(map[Atom]HandlerFunc)
+		s.modules[module] = m
+	}
+	m[function] = fn
+}
+
+func (s *Server) lookup(module, function Atom) (HandlerFunc, *RPCError) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.modules[module]
+	if !ok {
+		return nil, &RPCError{Type: ServerError, Code: 1, Detail: fmt.Sprintf("module '%s' not found", module)}
+	}
+	fn, ok := m[function]
+	if !ok {
+		return nil, &RPCError{Type: ServerError, Code: 2, Detail: fmt.Sprintf("function '%s:%s' not found", module, function)}
+	}
+	return fn, nil
+}
+
+// Serve accepts connections on l and serves each in its own goroutine. It
+// returns when Accept fails, or ErrServerClosed after Shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.inShutdown {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inShutdown
+}
+
+// Shutdown gracefully shuts the server down. It closes all listeners,
+// stops reading requests from every connection, and waits for the requests
+// in flight to be answered and the casts received to be handled, closing
+// each connection once it is idle. If ctx expires first, requests still
+// pending are answered with a protocol error, the contexts of their
+// handlers and of casts are cancelled, the remaining connections are
+// closed, and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.stopReading()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.connsDone.Wait()
+		s.casts.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.RLock()
+	conns = conns[:0]
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mu.RUnlock()
+	for _, sc := range conns {
+		sc.abort(&RPCError{Type: ProtocolError, Code: 0, Detail: "server shutting down"})
+	}
+	s.cancel()
+	return ctx.Err()
+}
+
+// A serverConn is a connection being served.
+type serverConn struct {
+	server *Server
+	conn   *TermConn
+
+	// ctx is passed to handlers and readCtx bounds reading requests.
+	ctx, readCtx          context.Context
+	cancel, cancelReading context.CancelFunc
+
+	mu       sync.Mutex
+	inflight map[*inflightRequest]struct{}
+	extra    int // slots taken by requests within batches and by casts
+	limiter  *tokenBucket
+	handlers sync.WaitGroup
+
+	trace       map[string]string // trace context for the next request
+	deadline    time.Time         // of the next request, if any
+	correlation string            // ID of the next request, if any
+}
+
+// An inflightRequest is a request that has not been answered yet.
+type inflightRequest struct {
+	seq       int64
+	enveloped bool
+	answered  bool
+}
+
+// ServeConn serves requests arriving on c until it is closed, a protocol
+// error occurs or the server shuts down, and then closes it.
+func (s *Server) ServeConn(c net.Conn) {
+	sc := &serverConn{
+		server:   s,
+		conn:     NewTermConn(c),
+		inflight: make(map[*inflightRequest]struct{}),
+	}
+	sc.conn.Logger = s.Logger
+	sc.conn.ObserveRead = s.ObserveRead
+	sc.conn.ObserveWrite = s.ObserveWrite
+	sc.conn.Checksum = s.Checksum
+	sc.ctx, sc.cancel = context.WithCancel(s.ctx)
+	sc.readCtx, sc.cancelReading = context.WithCancel(sc.ctx)
+	if s.RateLimit > 0 {
+		sc.limiter = newTokenBucket(s.RateLimit, s.RateBurst)
+	}
+
+	s.mu.Lock()
+	if s.inShutdown {
+		s.mu.Unlock()
+		c.Close()
+		return
+	}
+	s.conns[sc] = struct{}{}
+	s.connsDone.Add(1)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, sc)
+		s.mu.Unlock()
+		s.connsDone.Done()
+	}()
+
+	logf(s.Logger, "bert: connection from %s opened", c.RemoteAddr())
+	err := sc.serve()
+	if err == io.EOF || err == context.Canceled {
+		logf(s.Logger, "bert: connection from %s closed", c.RemoteAddr())
+	} else {
+		logf(s.Logger, "bert: connection from %s closed: %v", c.RemoteAddr(), err)
+	}
+}
+
+// serve serves the connection and returns the reason it stopped.
+func (sc *serverConn) serve() error {
+	defer sc.conn.Close()
+	defer sc.cancel()
+	defer sc.handlers.Wait()
+
+	if auth := sc.server.Authenticate; auth != nil {
+		if err := auth(sc.readCtx, sc.conn); err != nil {
+			resp := &RPCError{Type: ProtocolError, Code: 6, Detail: err.Error()}
+			sc.conn.WriteTerm(sc.ctx, resp.term())
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+
+	maxSize := sc.server.maxRequestSize()
+
+	for {
+		term, size, head, err := sc.conn.readRequest(sc.readCtx, maxSize)
+		if err == ErrFrameTooLarge {
+			req := &inflightRequest{}
+			req.seq, req.enveloped = peekSeq(head)
+			sc.respond(req, (&RPCError{Type: ProtocolError, Code: 3, Detail: fmt.Sprintf("request exceeds %d bytes", maxSize)}).term())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if command, options, ok := parseInfo(term); ok {
+			switch command {
+			case CompressAtom:
+				sc.negotiateCompression(options)
+			case PingAtom:
+				sc.conn.WriteTerm(sc.ctx, infoTerm(PongAtom, options...))
+			case TraceAtom:
+				sc.trace = traceCarrier(options)
+			case DeadlineAtom:
+				sc.deadline, _ = parseDeadline(options, time.Now())
+			case CorrelationAtom:
+				sc.correlation, _ = parseCorrelation(options)
+			case MaxFrameSizeAtom:
+				sc.negotiateMaxFrameSize(options)
+			}
+			// other info packets have no meaning to the server
+			continue
+		}
+
+		req := &inflightRequest{}
+		if seq, payload, ok := envelope(term); ok {
+			req.seq, req.enveloped, term = seq, true, payload
+		}
+		if rpcErr := sc.admit(); rpcErr != nil {
+			logf(sc.server.Logger, "bert: refused request from %s: %v", sc.conn.Conn().RemoteAddr(), rpcErr)
+			sc.respond(req, rpcErr.term())
+			continue
+		}
+		sc.mu.Lock()
+		sc.inflight[req] = struct{}{}
+		sc.mu.Unlock()
+
+		carrier, deadline, correlation := sc.trace, sc.deadline, sc.correlation
+		sc.trace, sc.deadline, sc.correlation = nil, time.Time{}, ""
+
+		sc.handlers.Add(1)
+		done := make(chan struct{})
+		go func(term Term) {
+			defer sc.handlers.Done()
+			defer close(done)
+			ctx := sc.ctx
+			if correlation != "" {
+				ctx = WithCorrelationID(ctx, correlation)
+			}
+			if !deadline.IsZero() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, deadline)
+				defer cancel()
+			}
+			ctx, end := sc.server.startSpan(ctx, term, carrier)
+			resp := sc.server.handle(ctx, term, sc)
+			end(size, sc.respond(req, resp), resp)
+		}(term)
+
+		if !req.enveloped {
+			// plain BERT-RPC carries one request at a time
+			select {
+			case <-done:
+			case <-sc.ctx.Done():
+				return sc.ctx.Err()
+			}
+		}
+	}
+}
+
+// admit checks a new request against the connection's limits.
+func (sc *serverConn) admit() *RPCError {
+	if !sc.allow() {
+		return &RPCError{Type: ProtocolError, Code: 5, Detail: "rate limit exceeded"}
+	}
+	if max := sc.server.MaxConcurrentRequests; max > 0 {
+		sc.mu.Lock()
+		n := len(sc.inflight) + sc.extra
+		sc.mu.Unlock()
+		if n >= max {
+			return &RPCError{Type: ProtocolError, Code: 4, Detail: fmt.Sprintf("more than %d concurrent requests", max)}
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) allow() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.limiter == nil || sc.limiter.allow(time.Now())
+}
+
+func (sc *serverConn) acquire() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if max := sc.server.MaxConcurrentRequests; max > 0 && len(sc.inflight)+sc.extra >= max {
+		return false
+	}
+	sc.extra++
+	return true
+}
+
+func (sc *serverConn) release() {
+	sc.mu.Lock()
+	sc.extra--
+	sc.mu.Unlock()
+}
+
+func (sc *serverConn) detach(fn func(ctx context.Context)) {
+	sc.mu.Lock()
+	sc.extra++
+	sc.mu.Unlock()
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		defer sc.release()
+		fn(sc.ctx)
+	}()
+}
+
+// respond sends resp for req unless it has already been answered, and
+// returns the size of the response written.
+func (sc *serverConn) respond(req *inflightRequest, resp Term) int {
+	sc.mu.Lock()
+	if req.answered {
+		sc.mu.Unlock()
+		return 0
+	}
+	req.answered = true
+	delete(sc.inflight, req)
+	sc.mu.Unlock()
+
+	if fn, ok := resp.(streamResponse); ok {
+		sc.stream(req, fn)
+		return 0
+	}
+	n, err := sc.conn.writeTerms(sc.ctx, req.wrap(resp))
+	if err == ErrFrameTooLarge {
+		// nothing was written, so the client can still learn why
+		tooLarge := &RPCError{Type: ProtocolError, Code: 7, Detail: "response exceeds the frame size of the client"}
+		n, _ = sc.conn.writeTerms(sc.ctx, req.wrap(tooLarge.term()))
+	}
+	return n
+}
+
+// wrap returns resp in the envelope of req, if it was enveloped.
+func (req *inflightRequest) wrap(resp Term) Term {
+	if req.enveloped {
+		return []Term{req.seq, resp}
+	}
+	return resp
+}
+
+// stopReading stops accepting new requests on the connection, which is
+// closed once the requests in flight have been answered.
+func (sc *serverConn) stopReading() { sc.cancelReading() }
+
+// abort answers every pending request with err and closes the connection.
+func (sc *serverConn) abort(err *RPCError) {
+	sc.mu.Lock()
+	pending := make([]*inflightRequest, 0, len(sc.inflight))
+	for req := range sc.inflight {
+		pending = append(pending, req)
+	}
+	sc.mu.Unlock()
+
+	for _, req := range pending {
+		sc.respond(req, err.term())
+	}
+	sc.cancel()
+	sc.conn.Close()
+}
+
+// requestLimits applies the limits of a server to the requests within a
+// batch and to casts, on behalf of the connection or HTTPHandler that
+// admitted them.
+type requestLimits interface {
+	// allow reports whether the rate limit allows another request.
+	allow() bool
+	// acquire takes a slot for another concurrent request, if there is
+	// one left, until release is called.
+	acquire() bool
+	release()
+	// detach runs a cast, whose request has been admitted, keeping a slot
+	// until it returns, with a context ending with the connection or the
+	// server.
+	detach(fn func(ctx context.Context))
+}
+
+// handle answers a single request packet, counting the requests of a
+// batch against limits.
+func (s *Server) handle(ctx context.Context, term Term, limits requestLimits) Term {
+	if reqs, ok := parseBatch(term); ok {
+		return s.handleBatch(ctx, reqs, limits)
+	}
+	return s.handleRequest(ctx, term, limits)
+}
+
+// handleRequest answers a request packet other than a batch.
+func (s *Server) handleRequest(ctx context.Context, term Term, limits requestLimits) Term {
+	req, err := parseRequest(term)
+	if err != nil {
+		logf(s.Logger, "bert: %v%s", err, correlationNote(CorrelationID(ctx)))
+		return (&RPCError{Type: ProtocolError, Code: 0, Detail: err.Error()}).term()
+	}
+
+	fn, rpcErr := s.lookup(req.Module, req.Function)
+	if rpcErr != nil {
+		return rpcErr.term()
+	}
+
+	if req.Kind == CastAtom {
+		id := CorrelationID(ctx)
+		limits.detach(func(ctx context.Context) {
+			if id != "" {
+				ctx = WithCorrelationID(ctx, id)
+			}
+			if _, err := s.call(ctx, req, fn); err != nil {
+				logf(s.Logger, "bert: cast %s:%s failed: %v%s", req.Module, req.Function, err, correlationNote(id))
+			}
+		})
+		return []Term{NoReplyAtom}
+	}
+
+	start := time.Now()
+	result, err := s.call(ctx, req, fn)
+	if d := time.Since(start); s.SlowCall > 0 && d >= s.SlowCall {
+		logf(s.Logger, "bert: slow call %s:%s took %v%s", req.Module, req.Function, d, correlationNote(CorrelationID(ctx)))
+	}
+	if err != nil {
+		return userError(err).term()
+	}
+	switch r := result.(type) {
+	case StreamFunc:
+		return streamResponse(r)
+	case io.Reader:
+		return streamReader(r)
+	}
+	return []Term{ReplyAtom, result}
+}
+
+// call calls fn for req, turning a panic into an error.
+func (s *Server) call(ctx context.Context, req Request, fn HandlerFunc) (result Term, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logf(s.Logger, "bert: %s:%s panicked: %v%s", req.Module, req.Function, r, correlationNote(CorrelationID(ctx)))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, req.Arguments)
+}
+
+// A tokenBucket allows rate events per second on average, in bursts of up
+// to burst events.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// allow reports whether an event may happen at now, consuming a token if
+// so.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
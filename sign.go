@@ -0,0 +1,67 @@
+package bert
+
+import (
+	"crypto/hmac"
+	"errors"
+)
+
+// SignedAtom tags terms signed by SignTerm.
+const SignedAtom = Atom("signed")
+
+// ErrBadSignature is returned by VerifyTerm for terms that are not signed
+// with its key.
+var ErrBadSignature = errors.New("bad signature")
+
+// SignTerm encodes val and returns a {signed, Payload, MAC} term holding
+// the encoding as a binary and its HMAC-SHA256 keyed with key, so that the
+// receiver, sharing the key, can check with VerifyTerm that the term was
+// not forged or altered. The signed term is itself a term, to be sent
+// through any BERT pipeline.
+//
+// The encoding is canonical, as written by an Encoder with Canonical set,
+// so that signing equal values gives the same term. The MAC covers the
+// payload bytes, however, so that the receiver need not encode the term
+// the same way to verify it.
+func SignTerm(key []byte, val interface{}) (Term, error) {
+	payload, err := encodeCanonical(val)
+	if err != nil {
+		return nil, err
+	}
+	return []Term{SignedAtom, payload, hmacSHA256(key, payload)}, nil
+}
+
+// VerifyTerm checks the MAC of term, signed by SignTerm with key, and
+// returns the term it signs, decoded. It returns ErrBadSignature if term
+// is not signed or the MAC does not match.
+func VerifyTerm(key []byte, term Term) (Term, error) {
+	payload, err := verifyPayload(key, term)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(payload)
+}
+
+// VerifyUnmarshal checks the MAC of term as VerifyTerm does and stores the
+// term it signs in val, as Unmarshal does.
+func VerifyUnmarshal(key []byte, term Term, val interface{}) error {
+	payload, err := verifyPayload(key, term)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(payload, val)
+}
+
+// verifyPayload returns the payload of the signed term, if its MAC is that
+// of key.
+func verifyPayload(key []byte, term Term) ([]byte, error) {
+	t, ok := term.([]Term)
+	if !ok || len(t) != 3 || t[0] != SignedAtom {
+		return nil, ErrBadSignature
+	}
+	payload, ok := t[1].([]byte)
+	mac, ok2 := t[2].([]byte)
+	if !ok || !ok2 || !hmac.Equal(mac, hmacSHA256(key, payload)) {
+		return nil, ErrBadSignature
+	}
+	return payload, nil
+}
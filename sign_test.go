@@ -0,0 +1,38 @@
+package bert
+
+import "testing"
+
+func TestSignTerm(t *testing.T) {
+	key := []byte("shared secret")
+	signed, err := SignTerm(key, map[string]int{"b": 2, "a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the signed term survives a trip through the wire
+	data, err := Encode(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]int
+	if err := VerifyUnmarshal(key, received, &m); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, map[string]int{"a": 1, "b": 2}, m)
+
+	if _, err := VerifyTerm([]byte("other secret"), received); err != ErrBadSignature {
+		t.Errorf("wrong key: expected ErrBadSignature, got %v", err)
+	}
+	payload := received.([]Term)[1].([]byte)
+	payload[len(payload)-1]++
+	if _, err := VerifyTerm(key, received); err != ErrBadSignature {
+		t.Errorf("altered payload: expected ErrBadSignature, got %v", err)
+	}
+	if _, err := VerifyTerm(key, []Term{Atom("ok"), 1}); err != ErrBadSignature {
+		t.Errorf("unsigned term: expected ErrBadSignature, got %v", err)
+	}
+}
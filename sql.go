@@ -0,0 +1,81 @@
+package bert
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// An SQLTerm stores a term in a database column as its BERT encoding, in a
+// BYTEA, BLOB or similar binary column. It implements driver.Valuer and
+// sql.Scanner, so it can be passed as a query argument and scanned into:
+//
+//	var t bert.SQLTerm
+//	err := db.QueryRow("SELECT payload FROM messages WHERE id = $1", id).Scan(&t)
+//
+// A nil Term is stored as NULL, and NULL scans as a nil Term.
+type SQLTerm struct {
+	Term Term
+}
+
+// Value returns the encoding of t.Term.
+func (t SQLTerm) Value() (driver.Value, error) {
+	if t.Term == nil {
+		return nil, nil
+	}
+	return Encode(t.Term)
+}
+
+// Scan decodes the term stored in a column into t.Term.
+func (t *SQLTerm) Scan(src interface{}) error {
+	if src == nil {
+		t.Term = nil
+		return nil
+	}
+	data, err := sqlBytes(src)
+	if err != nil {
+		return err
+	}
+	term, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	t.Term = term
+	return nil
+}
+
+// An SQLValue stores a Go value in a database column as its BERT
+// encoding, as SQLTerm does for terms, and unmarshals it back into V when
+// scanned. NULL scans as the zero value.
+type SQLValue[T any] struct {
+	V T
+}
+
+// Value returns the encoding of v.V.
+func (v SQLValue[T]) Value() (driver.Value, error) {
+	return Encode(v.V)
+}
+
+// Scan unmarshals the term stored in a column into v.V.
+func (v *SQLValue[T]) Scan(src interface{}) error {
+	var zero T
+	v.V = zero
+	if src == nil {
+		return nil
+	}
+	data, err := sqlBytes(src)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(data, &v.V)
+}
+
+// sqlBytes returns the bytes of a binary column value.
+func sqlBytes(src interface{}) ([]byte, error) {
+	switch x := src.(type) {
+	case []byte:
+		return x, nil
+	case string:
+		return []byte(x), nil
+	}
+	return nil, fmt.Errorf("cannot scan %T into a term", src)
+}
@@ -0,0 +1,66 @@
+package bert
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+var (
+	_ driver.Valuer = SQLTerm{}
+	_ sql.Scanner   = (*SQLTerm)(nil)
+	_ driver.Valuer = SQLValue[int]{}
+	_ sql.Scanner   = (*SQLValue[int])(nil)
+)
+
+func TestSQLTerm(t *testing.T) {
+	in := SQLTerm{[]Term{Atom("ok"), 42}}
+	v, err := in.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 104, 2, 100, 0, 2, 111, 107, 97, 42}, v)
+
+	var out SQLTerm
+	if err := out.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("ok"), int64(42)}, out.Term)
+	if err := out.Scan(string(v.([]byte))); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []Term{Atom("ok"), int64(42)}, out.Term)
+
+	// NULL round-trips as nil.
+	v, _ = SQLTerm{}.Value()
+	assertEqual(t, nil, v)
+	if err := out.Scan(nil); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, nil, out.Term)
+
+	if err := out.Scan(int64(1)); err == nil {
+		t.Errorf("expected an error scanning an integer column")
+	}
+	if err := out.Scan([]byte{1, 2}); err == nil {
+		t.Errorf("expected an error scanning a column that is not BERT")
+	}
+}
+
+func TestSQLValue(t *testing.T) {
+	in := SQLValue[recordUser]{recordUser{Name: "ann", Age: 30}}
+	v, err := in.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := SQLValue[recordUser]{recordUser{Name: "bob"}}
+	if err := out.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, in, out)
+	if err := out.Scan(nil); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, recordUser{}, out.V)
+}
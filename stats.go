@@ -0,0 +1,222 @@
+package bert
+
+import "reflect"
+
+// A TermKind is the kind of BERT term a Go value encodes as.
+type TermKind int
+
+const (
+	OtherKind TermKind = iota
+	IntegerKind
+	FloatKind
+	AtomKind
+	StringKind
+	BinaryKind
+	BitstringKind
+	TupleKind
+	ListKind
+	MapKind
+	NilKind
+	BooleanKind
+)
+
+var kindNames = [...]string{
+	OtherKind:     "other",
+	IntegerKind:   "integer",
+	FloatKind:     "float",
+	AtomKind:      "atom",
+	StringKind:    "string",
+	BinaryKind:    "binary",
+	BitstringKind: "bitstring",
+	TupleKind:     "tuple",
+	ListKind:      "list",
+	MapKind:       "map",
+	NilKind:       "nil",
+	BooleanKind:   "boolean",
+}
+
+func (k TermKind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return kindNames[OtherKind]
+	}
+	return kindNames[k]
+}
+
+var (
+	bitstringType = reflect.TypeOf(Bitstring{})
+	listType      = reflect.TypeOf(List{})
+	ioListType    = reflect.TypeOf(IOList{})
+	binaryType    = reflect.TypeOf(Binary(""))
+)
+
+// KindOf returns the kind of term that term encodes as. Since Decode
+// returns both tuples and lists as []Term, decoded lists are reported as
+// tuples. Values encoded as BERT complex terms, such as times, are of
+// OtherKind.
+func KindOf(term Term) TermKind {
+	return kindOf(reflect.Indirect(reflect.ValueOf(term)))
+}
+
+func kindOf(v reflect.Value) TermKind {
+	if _, ok := atomFor(v); ok {
+		return AtomKind
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return NilKind
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NilKind
+		}
+		return kindOf(v.Elem())
+	case reflect.Bool:
+		return BooleanKind
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return IntegerKind
+	case reflect.Float32, reflect.Float64:
+		return FloatKind
+	case reflect.String:
+		if isAtomType(v.Type()) {
+			return AtomKind
+		}
+		if v.Type() == binaryType {
+			return BinaryKind
+		}
+		return StringKind
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return BinaryKind
+		}
+		if v.Type() == kvsType {
+			return MapKind
+		}
+		return TupleKind
+	case reflect.Array:
+		return ListKind
+	case reflect.Map:
+		return MapKind
+	case reflect.Struct:
+		switch v.Type() {
+		case bitstringType:
+			return BitstringKind
+		case listType:
+			return ListKind
+		case termMapType:
+			return MapKind
+		case ioListType:
+			if v.Interface().(IOList).Nested {
+				return ListKind
+			}
+			return BinaryKind
+		case bigIntType:
+			return IntegerKind
+		case timeType:
+			return OtherKind
+		}
+		return TupleKind
+	}
+	return OtherKind
+}
+
+// TermStats summarizes the contents of a term.
+type TermStats struct {
+	// Depth is the nesting depth of the term: 1 for a term without
+	// elements, 2 for a tuple or list of such terms, and so on.
+	Depth int
+
+	// Counts is the number of terms of each kind, including the term
+	// itself and map keys.
+	Counts map[TermKind]int
+
+	// BinaryBytes is the total size of the binaries and bitstrings.
+	BinaryBytes int
+
+	// UniqueAtoms is the number of distinct atoms.
+	UniqueAtoms int
+}
+
+// Stats returns statistics about term and the terms it contains.
+func Stats(term Term) TermStats {
+	s := termStats{
+		TermStats: TermStats{Counts: make(map[TermKind]int)},
+		atoms:     make(map[Atom]struct{}),
+	}
+	s.add(reflect.ValueOf(term), 1)
+	s.UniqueAtoms = len(s.atoms)
+	return s.TermStats
+}
+
+type termStats struct {
+	TermStats
+	atoms map[Atom]struct{}
+}
+
+func (s *termStats) add(v reflect.Value, depth int) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+
+	kind := kindOf(v)
+	s.Counts[kind]++
+	if depth > s.Depth {
+		s.Depth = depth
+	}
+
+	switch kind {
+	case AtomKind:
+		a, ok := atomFor(v)
+		if !ok {
+			a = Atom(v.String())
+		}
+		s.atoms[a] = struct{}{}
+	case BinaryKind:
+		if v.Type() == ioListType {
+			n, _ := v.Interface().(IOList).Len()
+			s.BinaryBytes += n
+		} else {
+			s.BinaryBytes += v.Len()
+		}
+	case BitstringKind:
+		b := v.Interface().(Bitstring)
+		s.BinaryBytes += len(b.data())
+	case TupleKind, ListKind:
+		if v.Kind() == reflect.Struct {
+			if v.Type() == listType || v.Type() == ioListType {
+				v = v.Field(0)
+			} else {
+				if tag, ok := recordTag(v.Type()); ok {
+					s.add(reflect.ValueOf(tag), depth+1)
+				}
+				fields := structFields(v.Type())
+				for _, f := range fields {
+					s.add(fieldByIndex(v, f.index), depth+1)
+				}
+				return
+			}
+		}
+		for i := 0; i < v.Len(); i++ {
+			s.add(v.Index(i), depth+1)
+		}
+	case MapKind:
+		if v.Type() == termMapType || v.Type() == kvsType {
+			entries, ok := v.Interface().([]KV)
+			if !ok {
+				entries = v.Interface().(TermMap).entries
+			}
+			for _, kv := range entries {
+				s.add(reflect.ValueOf(kv.Key), depth+1)
+				s.add(reflect.ValueOf(kv.Value), depth+1)
+			}
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			s.add(iter.Key(), depth+1)
+			s.add(iter.Value(), depth+1)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package bert
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		term Term
+		kind TermKind
+	}{
+		{int64(1), IntegerKind},
+		{uint8(1), IntegerKind},
+		{*big.NewInt(1), IntegerKind},
+		{1.5, FloatKind},
+		{Atom("ok"), AtomKind},
+		{"ok", StringKind},
+		{[]byte("ok"), BinaryKind},
+		{IOList{Items: []Term{"ok"}}, BinaryKind},
+		{Bitstring{[]byte{128}, 1}, BitstringKind},
+		{[]Term{1}, TupleKind},
+		{struct{ X int }{1}, TupleKind},
+		{[1]Term{1}, ListKind},
+		{List{}, ListKind},
+		{map[Atom]int{}, MapKind},
+		{nil, NilKind},
+		{(*int)(nil), NilKind},
+		{true, BooleanKind},
+		{time.Time{}, OtherKind},
+		{func() {}, OtherKind},
+	}
+	for _, test := range tests {
+		if kind := KindOf(test.term); kind != test.kind {
+			t.Errorf("KindOf(%#v) = %v, expected %v", test.term, kind, test.kind)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	term := []Term{
+		Atom("reply"),
+		List{Items: []Term{
+			[]Term{Atom("ok"), []byte("abc")},
+			[]Term{Atom("ok"), Bitstring{[]byte{1, 128}, 9}},
+		}},
+		map[Atom]Term{"error": nil},
+	}
+
+	s := Stats(term)
+	assertEqual(t, 4, s.Depth)
+	assertEqual(t, map[TermKind]int{
+		TupleKind:     3,
+		ListKind:      1,
+		MapKind:       1,
+		AtomKind:      4,
+		BinaryKind:    1,
+		BitstringKind: 1,
+		NilKind:       1,
+	}, s.Counts)
+	assertEqual(t, 5, s.BinaryBytes)
+	assertEqual(t, 3, s.UniqueAtoms)
+
+	assertEqual(t, TermStats{Depth: 1, Counts: map[TermKind]int{IntegerKind: 1}}, Stats(42))
+}
@@ -0,0 +1,134 @@
+package bert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// EncodeList writes a list whose elements are produced by next, which is
+// called until it returns false. Because LIST_EXT records its length up
+// front, the encoded elements are buffered in memory, unless the encoder's
+// writer is an io.WriteSeeker, in which case they are written straight
+// through and the length is patched in afterwards. An error from next or
+// from encoding an element aborts the list; with a seekable writer the
+// partial output is left in place.
+func (e *Encoder) EncodeList(next func() (Term, bool, error)) error {
+	write1(e.w, VersionTag)
+	return e.writeStreamedList(next)
+}
+
+// EncodeChan writes a list of the values received from ch until it is
+// closed, as EncodeList does.
+func (e *Encoder) EncodeChan(ch <-chan Term) error {
+	return e.EncodeList(func() (Term, bool, error) {
+		elem, ok := <-ch
+		return elem, ok, nil
+	})
+}
+
+func (e *Encoder) writeStreamedList(next func() (Term, bool, error)) error {
+	if ws, ok := e.w.(io.WriteSeeker); ok {
+		return e.writePatchedList(ws, next)
+	}
+
+	var buf bytes.Buffer
+	sub := *e
+	sub.w = &buf
+	n, err := sub.writeElements(next)
+	if err != nil {
+		return err
+	}
+
+	write1(e.w, ListTag)
+	write4(e.w, uint32(n))
+	e.w.Write(buf.Bytes())
+	writeNil(e.w)
+	return nil
+}
+
+func (e *Encoder) writePatchedList(ws io.WriteSeeker, next func() (Term, bool, error)) error {
+	write1(ws, ListTag)
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	write4(ws, 0)
+
+	n, err := e.writeElements(next)
+	if err != nil {
+		return err
+	}
+	writeNil(ws)
+
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(n))
+	if _, err := ws.Write(size[:]); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// writeElements writes the terms produced by next and returns how many
+// there were.
+func (e *Encoder) writeElements(next func() (Term, bool, error)) (int, error) {
+	n := 0
+	for {
+		elem, ok, err := next()
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			return n, nil
+		}
+		if err := e.writeTag(reflect.ValueOf(elem)); err != nil {
+			return n, withPath(err, fmt.Sprintf("[%d]", n))
+		}
+		n++
+	}
+}
+
+// DecodeList reads a list from the decoder's input and calls fn with each
+// element in turn, without retaining the list, so arbitrarily long lists
+// are processed in constant memory. An error from fn stops decoding and is
+// returned. DecodeList returns an error if the input is not a list.
+func (d *Decoder) DecodeList(fn func(i int, elem Term) error) error {
+	tag, err := d.readStart()
+	if err != nil {
+		return err
+	}
+	switch tag {
+	case NilTag:
+		return nil
+	case ListTag:
+	default:
+		return fmt.Errorf("expected list, got tag %d", tag)
+	}
+
+	size, err := read4(d.r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		elem, err := d.readTag()
+		if err != nil {
+			return err
+		}
+		if err := fn(i, elem); err != nil {
+			return err
+		}
+	}
+
+	_, err = read1(d.r)
+	return err
+}
@@ -0,0 +1,102 @@
+package bert
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestEncodeList(t *testing.T) {
+	want := []byte{131, 108, 0, 0, 0, 3, 97, 1, 97, 2, 97, 3, 106}
+
+	i := 0
+	counter := func() (Term, bool, error) {
+		i++
+		return i, i <= 3, nil
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).EncodeList(counter); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, want, buf.Bytes())
+
+	ch := make(chan Term, 3)
+	ch <- 1
+	ch <- 2
+	ch <- 3
+	close(ch)
+	buf.Reset()
+	if err := NewEncoder(&buf).EncodeChan(ch); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, want, buf.Bytes())
+
+	failing := func() (Term, bool, error) { return nil, false, errors.New("boom") }
+	if err := NewEncoder(&buf).EncodeList(failing); err == nil || err.Error() != "boom" {
+		t.Errorf("expected iterator error, got %v", err)
+	}
+}
+
+func TestEncodeListSeekable(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte{1, 2})
+	i := 0
+	err = NewEncoder(f).EncodeList(func() (Term, bool, error) {
+		i++
+		return Atom("a"), i <= 2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{3})
+
+	f.Seek(0, io.SeekStart)
+	data, _ := io.ReadAll(f)
+	assertEqual(t, []byte{1, 2, 131, 108, 0, 0, 0, 2, 100, 0, 1, 97, 100, 0, 1, 97, 106, 3}, data)
+}
+
+func TestDecodeList(t *testing.T) {
+	data := []byte{131, 108, 0, 0, 0, 3, 97, 1, 97, 2, 97, 3, 106}
+
+	var sum int64
+	err := NewDecoder(bytes.NewReader(data)).DecodeList(func(i int, elem Term) error {
+		sum += elem.(int64) * int64(i+1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(14), sum)
+
+	calls := 0
+	err = NewDecoder(bytes.NewReader([]byte{131, 106})).DecodeList(func(int, Term) error {
+		calls++
+		return nil
+	})
+	if err != nil || calls != 0 {
+		t.Errorf("expected no calls for NIL, got %d calls and error %v", calls, err)
+	}
+
+	stop := errors.New("stop")
+	err = NewDecoder(bytes.NewReader(data)).DecodeList(func(i int, elem Term) error {
+		if i == 1 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("expected callback error, got %v", err)
+	}
+
+	err = NewDecoder(bytes.NewReader([]byte{131, 97, 1})).DecodeList(func(int, Term) error { return nil })
+	if err == nil {
+		t.Errorf("expected error decoding a non-list")
+	}
+}
@@ -0,0 +1,175 @@
+package bert
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Table is an in-memory store of tuples keyed by one of their elements,
+// like an ordered_set ETS table in Erlang. Keys may be any term and are
+// ordered and matched as by Compare, so that 1 and 1.0 are different keys
+// but int(1) and int64(1) are the same. A Table is safe for concurrent
+// use.
+//
+// The zero value is an empty table keyed by the first element of its
+// tuples. KeyPos must be set before the table is used.
+type Table struct {
+	// KeyPos is the position of the key in tuples, counting from 1 as
+	// in Erlang. Zero means 1.
+	KeyPos int
+
+	mu   sync.RWMutex
+	rows TermMap
+}
+
+// key returns the key of tuple.
+func (t *Table) key(tuple []Term) (Term, error) {
+	pos := t.KeyPos
+	if pos <= 0 {
+		pos = 1
+	}
+	if len(tuple) < pos {
+		return nil, fmt.Errorf("tuple %v has no element %d", tuple, pos)
+	}
+	return tuple[pos-1], nil
+}
+
+// Insert stores tuples in t, replacing the tuples already stored under
+// their keys. It stores nothing if a tuple is too short to hold a key.
+func (t *Table) Insert(tuples ...[]Term) error {
+	keys := make([]Term, len(tuples))
+	for i, tuple := range tuples {
+		key, err := t.key(tuple)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, tuple := range tuples {
+		t.rows.Set(keys[i], tuple)
+	}
+	return nil
+}
+
+// InsertNew stores tuple in t unless a tuple is already stored under its
+// key, reporting whether it was stored.
+func (t *Table) InsertNew(tuple []Term) (bool, error) {
+	key, err := t.key(tuple)
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rows.Get(key); ok {
+		return false, nil
+	}
+	t.rows.Set(key, tuple)
+	return true, nil
+}
+
+// Lookup returns the tuple stored under key, and whether there is one.
+func (t *Table) Lookup(key Term) ([]Term, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if row, ok := t.rows.Get(key); ok {
+		return row.([]Term), true
+	}
+	return nil, false
+}
+
+// Delete removes the tuple stored under key, reporting whether there was
+// one.
+func (t *Table) Delete(key Term) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rows.Delete(key)
+}
+
+// Len returns the number of tuples in t.
+func (t *Table) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.rows.Len()
+}
+
+// Range calls fn for each tuple of t in key order, until fn returns
+// false. The table must not be modified from fn.
+func (t *Table) Range(fn func(tuple []Term) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.rows.Range(func(_, row Term) bool {
+		return fn(row.([]Term))
+	})
+}
+
+// Select calls fn, in key order, with each tuple of t matching pattern as
+// by Match and the bindings of the pattern's variables, until fn returns
+// false. When the key of pattern holds no variables, only the tuple
+// stored under it is considered. The table must not be modified from fn.
+//
+// For example, with tuples {user, Name, Role} keyed by Name,
+//
+//	t.Select([]Term{Atom("user"), Var("Name"), Atom("admin")}, ...)
+//
+// selects the administrators, like ets:match(T, {user, '$1', admin}).
+func (t *Table) Select(pattern Term, fn func(tuple []Term, b Bindings) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if p, ok := pattern.([]Term); ok {
+		if key, err := t.key(p); err == nil && isGround(key) {
+			if row, ok := t.rows.Get(key); ok {
+				var b Bindings
+				if Match(row, pattern, &b) {
+					fn(row.([]Term), b)
+				}
+			}
+			return
+		}
+	}
+	t.rows.Range(func(_, row Term) bool {
+		var b Bindings
+		if !Match(row, pattern, &b) {
+			return true
+		}
+		return fn(row.([]Term), b)
+	})
+}
+
+// MatchObject returns the tuples of t matching pattern in key order, like
+// ets:match_object/2.
+func (t *Table) MatchObject(pattern Term) [][]Term {
+	var tuples [][]Term
+	t.Select(pattern, func(tuple []Term, _ Bindings) bool {
+		tuples = append(tuples, tuple)
+		return true
+	})
+	return tuples
+}
+
+// isGround reports whether pattern only matches terms equal to it: it
+// holds no variables or captures, nor maps, which match larger maps.
+func isGround(pattern Term) bool {
+	switch p := pattern.(type) {
+	case Var, capture:
+		return false
+	case []Term:
+		return groundItems(p)
+	case List:
+		return groundItems(p.Items) && isGround(p.Tail)
+	}
+	return kindOf(termValue(pattern)) != MapKind
+}
+
+func groundItems(items []Term) bool {
+	for _, item := range items {
+		if !isGround(item) {
+			return false
+		}
+	}
+	return true
+}
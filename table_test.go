@@ -0,0 +1,70 @@
+package bert
+
+import "testing"
+
+func TestTable(t *testing.T) {
+	tab := Table{KeyPos: 2}
+	err := tab.Insert(
+		[]Term{Atom("user"), "carol", Atom("admin")},
+		[]Term{Atom("user"), "alice", Atom("admin")},
+		[]Term{Atom("user"), "bob", Atom("guest")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 3, tab.Len())
+
+	row, ok := tab.Lookup("bob")
+	assertEqual(t, true, ok)
+	assertEqual(t, []Term{Atom("user"), "bob", Atom("guest")}, row)
+
+	ok, err = tab.InsertNew([]Term{Atom("user"), "bob", Atom("admin")})
+	assertEqual(t, false, ok)
+	assertEqual(t, nil, err)
+	if err := tab.Insert([]Term{Atom("user")}); err == nil {
+		t.Errorf("expected an error inserting a tuple without a key")
+	}
+
+	var names []Term
+	tab.Select([]Term{Atom("user"), Var("Name"), Atom("admin")}, func(_ []Term, b Bindings) bool {
+		names = append(names, b["Name"])
+		return true
+	})
+	assertEqual(t, []Term{"alice", "carol"}, names)
+
+	// A bound key looks up a single tuple.
+	assertEqual(t, [][]Term{{Atom("user"), "bob", Atom("guest")}},
+		tab.MatchObject([]Term{Wildcard, "bob", Wildcard}))
+	assertEqual(t, 0, len(tab.MatchObject([]Term{Wildcard, "bob", Atom("admin")})))
+
+	assertEqual(t, true, tab.Delete("alice"))
+	assertEqual(t, false, tab.Delete("alice"))
+
+	var keys []Term
+	tab.Range(func(tuple []Term) bool {
+		keys = append(keys, tuple[1])
+		return true
+	})
+	assertEqual(t, []Term{"bob", "carol"}, keys)
+}
+
+func TestTableKeyOrder(t *testing.T) {
+	var tab Table
+	tab.Insert(
+		[]Term{[]Term{Atom("b"), 1}},
+		[]Term{Atom("a")},
+		[]Term{2.5},
+		[]Term{int64(1), "one"},
+	)
+	tab.Insert([]Term{1, "uno"})
+
+	var keys []Term
+	tab.Range(func(tuple []Term) bool {
+		keys = append(keys, tuple[0])
+		return true
+	})
+	assertEqual(t, []Term{1, 2.5, Atom("a"), []Term{Atom("b"), 1}}, keys)
+
+	row, _ := tab.Lookup(int64(1))
+	assertEqual(t, []Term{1, "uno"}, row)
+}
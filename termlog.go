@@ -0,0 +1,373 @@
+package bert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// The header of a term log file: a magic number, a format version and
+// flags.
+const (
+	termLogMagic    = "BLOG"
+	termLogVersion  = 1
+	termLogChecksum = 1 << 0
+	termLogHeader   = len(termLogMagic) + 2
+)
+
+// ErrLogClosed is returned when using a TermLog after Close.
+var ErrLogClosed = errors.New("term log closed")
+
+// TermLogOptions configure a TermLog.
+type TermLogOptions struct {
+	// Checksum stores a CRC-32 checksum with each term, verified on
+	// replay. It only applies to new files: existing logs keep the
+	// setting they were created with.
+	Checksum bool
+
+	// SyncEveryAppend calls fsync after each Append, so that appended
+	// terms survive a crash of the machine. Otherwise the log is only
+	// synced by Sync, Rotate and Close.
+	SyncEveryAppend bool
+
+	// MaxSize, if positive, makes Append rotate the log before a term
+	// would take its file beyond MaxSize bytes.
+	MaxSize int64
+
+	// MaxFiles is the number of rotated files kept besides the current
+	// one, named after it with the suffixes .1, .2 and so on, from the
+	// most recent. Zero keeps a single rotated file.
+	MaxFiles int
+}
+
+// A TermLog is an append-only file of BERT terms, like Erlang's disk_log.
+// Each term is stored as a 4-byte length, the encoded term and, if the log
+// is checksummed, its CRC-32. A TermLog is safe for concurrent use.
+type TermLog struct {
+	path string
+	opts TermLogOptions
+
+	mu       sync.Mutex
+	f        logFile
+	checksum bool
+	size     int64
+}
+
+// A logFile is the open file of a TermLog.
+type logFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// OpenTermLog opens the log at path for appending, creating it if it does
+// not exist. A term left incomplete at the end of the file, as by a
+// crash, is truncated away. A corrupt term followed by others makes
+// OpenTermLog fail, leaving the file untouched.
+func OpenTermLog(path string, opts TermLogOptions) (*TermLog, error) {
+	l := &TermLog{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file at l.path, writing its header if it is new and
+// truncating any incomplete term at its end.
+func (l *TermLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if info.Size() == 0 {
+		l.checksum = l.opts.Checksum
+		var flags byte
+		if l.checksum {
+			flags |= termLogChecksum
+		}
+		header := append([]byte(termLogMagic), termLogVersion, flags)
+		if _, err := f.Write(header); err != nil {
+			f.Close()
+			return err
+		}
+		l.f, l.size = f, int64(len(header))
+		return nil
+	}
+
+	l.checksum, err = readTermLogHeader(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", l.path, err)
+	}
+	end, err := scanTermLog(f, info.Size(), l.checksum, nil)
+	if err != nil && err != errTornTerm {
+		f.Close()
+		return fmt.Errorf("%s: %v", l.path, err)
+	}
+	if end < info.Size() {
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, end
+	return nil
+}
+
+// readTermLogHeader reads the header at the start of r, returning whether
+// the log is checksummed.
+func readTermLogHeader(r io.Reader) (bool, error) {
+	header := make([]byte, termLogHeader)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false, errors.New("not a term log")
+	}
+	if string(header[:len(termLogMagic)]) != termLogMagic {
+		return false, errors.New("not a term log")
+	}
+	if header[len(termLogMagic)] != termLogVersion {
+		return false, fmt.Errorf("unsupported term log version %d", header[len(termLogMagic)])
+	}
+	return header[len(termLogMagic)+1]&termLogChecksum != 0, nil
+}
+
+// errTornTerm reports a term cut short by the end of the log, or failing
+// its checksum as the last term of the log, as left by a crash during
+// Append.
+var errTornTerm = errors.New("incomplete term at end of log")
+
+// scanTermLog reads the terms of the log file f, of the given size,
+// following its header, calling fn, if not nil, with the offset and
+// payload of each. It returns the offset of the end of the last complete
+// term. A term failing its checksum with more data after it is corrupt,
+// rather than torn, and makes scanTermLog return an error locating it.
+func scanTermLog(f io.ReaderAt, fileSize int64, checksum bool, fn func(off int64, payload []byte) error) (int64, error) {
+	off := int64(termLogHeader)
+	if fileSize < off {
+		return off, errTornTerm
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, off, fileSize-off))
+	trailer := 0
+	if checksum {
+		trailer = 4
+	}
+
+	for {
+		size, err := read4(r)
+		if err == io.EOF {
+			return off, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return off, errTornTerm
+		}
+		if err != nil {
+			return off, err
+		}
+		// a length running past the end of the file is that of a term
+		// cut short, and is never allocated
+		end := off + int64(4+size+trailer)
+		if end > fileSize {
+			return off, errTornTerm
+		}
+		record, err := readN(r, size+trailer)
+		if err != nil {
+			return off, err
+		}
+		payload := record[:size]
+		if checksum && crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(record[size:]) {
+			if end == fileSize {
+				return off, errTornTerm
+			}
+			return off, fmt.Errorf("corrupt term at offset %d: checksum mismatch", off)
+		}
+		if fn != nil {
+			if err := fn(off, payload); err != nil {
+				return off, err
+			}
+		}
+		off = end
+	}
+}
+
+// Append encodes val and appends it to the log. A term only partly
+// written is removed again, so that the terms appended after it can be
+// read; if it cannot be, the log is closed.
+func (l *TermLog) Append(val interface{}) error {
+	data, err := Encode(val)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	write4(&buf, uint32(len(data)))
+	buf.Write(data)
+	if l.checksum {
+		write4(&buf, crc32.ChecksumIEEE(data))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrLogClosed
+	}
+	if l.opts.MaxSize > 0 && l.size > int64(termLogHeader) && l.size+int64(buf.Len()) > l.opts.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if n, err := l.f.Write(buf.Bytes()); err != nil {
+		if n > 0 {
+			l.untear()
+		}
+		return err
+	}
+	l.size += int64(buf.Len())
+	if l.opts.SyncEveryAppend {
+		return l.f.Sync()
+	}
+	return nil
+}
+
+// untear removes a term partly written by Append, closing the log if it
+// cannot.
+func (l *TermLog) untear() {
+	err := l.f.Truncate(l.size)
+	if err == nil {
+		_, err = l.f.Seek(l.size, io.SeekStart)
+	}
+	if err != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
+
+// Sync commits the terms appended so far to stable storage.
+func (l *TermLog) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrLogClosed
+	}
+	return l.f.Sync()
+}
+
+// Replay calls fn with each term of the current log file, in the order
+// they were appended, along with its offset, which Truncate accepts. An
+// error from fn stops the replay and is returned. Only the terms appended
+// before the call are replayed, so that fn may itself Append to the log.
+func (l *TermLog) Replay(fn func(off int64, term Term) error) error {
+	l.mu.Lock()
+	if l.f == nil {
+		l.mu.Unlock()
+		return ErrLogClosed
+	}
+	// a file of its own leaves the log free for fn, and survives rotation
+	f, err := os.Open(l.path)
+	size, checksum := l.size, l.checksum
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = scanTermLog(f, size, checksum, func(off int64, payload []byte) error {
+		term, err := Decode(payload)
+		if err != nil {
+			return fmt.Errorf("term at offset %d: %v", off, err)
+		}
+		return fn(off, term)
+	})
+	if err == errTornTerm {
+		// the log was truncated meanwhile
+		return nil
+	}
+	return err
+}
+
+// Truncate removes the term at offset off, as passed to Replay, and all
+// terms after it.
+func (l *TermLog) Truncate(off int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrLogClosed
+	}
+	if off < int64(termLogHeader) || off > l.size {
+		return fmt.Errorf("offset %d out of range", off)
+	}
+	if err := l.f.Truncate(off); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	l.size = off
+	return nil
+}
+
+// Rotate closes the current file, renames it with the suffix .1, shifting
+// older rotated files along and removing those beyond MaxFiles, and
+// starts a new one.
+func (l *TermLog) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrLogClosed
+	}
+	return l.rotate()
+}
+
+func (l *TermLog) rotate() error {
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+
+	keep := l.opts.MaxFiles
+	if keep <= 0 {
+		keep = 1
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Close syncs and closes the log.
+func (l *TermLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrLogClosed
+	}
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
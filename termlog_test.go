@@ -0,0 +1,268 @@
+package bert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// replayAll returns the offsets and terms of the log.
+func replayAll(t *testing.T, l *TermLog) ([]int64, []Term) {
+	var offsets []int64
+	var terms []Term
+	err := l.Replay(func(off int64, term Term) error {
+		offsets = append(offsets, off)
+		terms = append(terms, term)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offsets, terms
+}
+
+func TestTermLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenTermLog(path, TermLogOptions{Checksum: true, SyncEveryAppend: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := l.Append([]Term{Atom("block"), i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offsets, terms := replayAll(t, l)
+	assertEqual(t, []Term{
+		[]Term{Atom("block"), int64(1)},
+		[]Term{Atom("block"), int64(2)},
+		[]Term{Atom("block"), int64(3)},
+	}, terms)
+
+	// Appends continue after a replay.
+	if err := l.Append(4); err != nil {
+		t.Fatal(err)
+	}
+	_, terms = replayAll(t, l)
+	assertEqual(t, 4, len(terms))
+	assertEqual(t, int64(4), terms[3])
+
+	if err := l.Truncate(offsets[2]); err != nil {
+		t.Fatal(err)
+	}
+	_, terms = replayAll(t, l)
+	assertEqual(t, 2, len(terms))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(5); err != ErrLogClosed {
+		t.Errorf("expected ErrLogClosed, got %v", err)
+	}
+
+	// A torn write is truncated on open, keeping the complete terms, and
+	// the log remembers it is checksummed.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 9, 131, 97})
+	f.Close()
+
+	l, err = OpenTermLog(path, TermLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Append(6); err != nil {
+		t.Fatal(err)
+	}
+	_, terms = replayAll(t, l)
+	assertEqual(t, []Term{
+		[]Term{Atom("block"), int64(1)},
+		[]Term{Atom("block"), int64(2)},
+		int64(6),
+	}, terms)
+}
+
+func TestTermLogChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	l, err := OpenTermLog(path, TermLogOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append("first")
+	l.Append("second")
+	l.Close()
+
+	// Corrupt the last byte of the second term's payload.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	l, err = OpenTermLog(path, TermLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, terms := replayAll(t, l)
+	assertEqual(t, []Term{"first"}, terms)
+}
+
+func TestTermLogCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	l, err := OpenTermLog(path, TermLogOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append("first")
+	l.Append("second")
+	l.Append("third")
+	l.Close()
+
+	// A corrupt term followed by others is reported, not truncated away
+	// with them.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), data...)
+	second := termLogHeader + 4 + 9 + 4
+	corrupt[second+5] ^= 0xff
+	os.WriteFile(path, corrupt, 0o644)
+	_, err = OpenTermLog(path, TermLogOptions{})
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("corrupt term at offset %d", second)) {
+		t.Errorf("expected a corruption error, got %v", err)
+	}
+	kept, _ := os.ReadFile(path)
+	assertEqual(t, len(corrupt), len(kept))
+
+	// So is a length reaching into the following terms, and one running
+	// past the end of the file is torn, without being allocated.
+	corrupt = append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(corrupt[second:], 1)
+	os.WriteFile(path, corrupt, 0o644)
+	if _, err := OpenTermLog(path, TermLogOptions{}); err == nil {
+		t.Error("expected a corruption error")
+	}
+	corrupt = append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(corrupt[second:], 0xffffffff)
+	os.WriteFile(path, corrupt, 0o644)
+	l, err = OpenTermLog(path, TermLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, terms := replayAll(t, l)
+	assertEqual(t, []Term{"first"}, terms)
+}
+
+func TestTermLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l, err := OpenTermLog(path, TermLogOptions{MaxSize: 40, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Each term takes 4 bytes of length and 6 of payload, so three fit
+	// after the header in 40 bytes.
+	for i := 0; i < 8; i++ {
+		if err := l.Append(1000 + i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, terms := replayAll(t, l)
+	assertEqual(t, []Term{int64(1006), int64(1007)}, terms)
+
+	for _, name := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.3 to be removed, got %v", path, err)
+	}
+
+	os.WriteFile(path+".1", []byte("garbage"), 0o644)
+	if _, err := OpenTermLog(path+".1", TermLogOptions{}); err == nil {
+		t.Errorf("expected an error opening a file that is not a term log")
+	}
+}
+
+// A shortFile writes only part of the next write, failing it.
+type shortFile struct {
+	logFile
+	short bool
+}
+
+func (f *shortFile) Write(p []byte) (int, error) {
+	if f.short {
+		f.short = false
+		n, _ := f.logFile.Write(p[:len(p)/2])
+		return n, errors.New("disk full")
+	}
+	return f.logFile.Write(p)
+}
+
+func TestTermLogShortWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenTermLog(path, TermLogOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(1); err != nil {
+		t.Fatal(err)
+	}
+	l.f = &shortFile{logFile: l.f, short: true}
+	if err := l.Append(Binary(strings.Repeat("x", 100))); err == nil {
+		t.Fatal("expected the short write to fail")
+	}
+	if err := l.Append(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The torn term does not hide the terms after it.
+	l, err = OpenTermLog(path, TermLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, terms := replayAll(t, l)
+	assertEqual(t, []Term{int64(1), int64(3)}, terms)
+}
+
+func TestTermLogReplayAppend(t *testing.T) {
+	l, err := OpenTermLog(filepath.Join(t.TempDir(), "audit.log"), TermLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 1; i <= 2; i++ {
+		if err := l.Append(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Appending from fn does not deadlock, nor replay the new terms.
+	replayed := 0
+	err = l.Replay(func(off int64, term Term) error {
+		replayed++
+		return l.Append(term.(int64) * 10)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, replayed)
+	_, terms := replayAll(t, l)
+	assertEqual(t, []Term{int64(1), int64(2), int64(10), int64(20)}, terms)
+}
@@ -0,0 +1,195 @@
+package bert
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// A KV is a key and value of a map. A []KV encodes as a map with its
+// entries in order, and is what maps decode as with Decoder.MapPairs.
+type KV struct {
+	Key, Value Term
+}
+
+var kvsType = reflect.TypeOf([]KV(nil))
+
+// A TermMap is a map whose keys may be any term, including tuples and
+// lists that cannot be Go map keys. Its entries are kept sorted by key in
+// Erlang term order, as defined by Compare, and keys are matched with
+// Equal. MAP_EXT terms decode as TermMaps.
+//
+// The zero value is an empty map. A TermMap must not be copied after it
+// has been modified.
+type TermMap struct {
+	entries []KV
+}
+
+var termMapType = reflect.TypeOf(TermMap{})
+
+// NewTermMap returns a map holding entries. Of entries with equal keys,
+// the last one wins.
+func NewTermMap(entries ...KV) TermMap {
+	if len(entries) == 0 {
+		return TermMap{}
+	}
+	sorted := make([]KV, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return Compare(sorted[i].Key, sorted[j].Key) < 0
+	})
+
+	unique := sorted[:0]
+	for _, kv := range sorted {
+		if n := len(unique); n > 0 && Equal(unique[n-1].Key, kv.Key) {
+			unique[n-1] = kv
+		} else {
+			unique = append(unique, kv)
+		}
+	}
+	return TermMap{entries: unique}
+}
+
+// search returns the index of key in m, or where it would be inserted.
+func (m TermMap) search(key Term) (int, bool) {
+	i := sort.Search(len(m.entries), func(i int) bool {
+		return Compare(m.entries[i].Key, key) >= 0
+	})
+	return i, i < len(m.entries) && Compare(m.entries[i].Key, key) == 0
+}
+
+// Len returns the number of entries in m.
+func (m TermMap) Len() int { return len(m.entries) }
+
+// Get returns the value stored under key, and whether there is one.
+func (m TermMap) Get(key Term) (Term, bool) {
+	if i, ok := m.search(key); ok {
+		return m.entries[i].Value, true
+	}
+	return nil, false
+}
+
+// Set stores value under key, replacing any value already there.
+func (m *TermMap) Set(key, value Term) {
+	i, ok := m.search(key)
+	if ok {
+		m.entries[i].Value = value
+		return
+	}
+	m.entries = append(m.entries, KV{})
+	copy(m.entries[i+1:], m.entries[i:])
+	m.entries[i] = KV{key, value}
+}
+
+// Delete removes the entry for key, reporting whether there was one.
+func (m *TermMap) Delete(key Term) bool {
+	i, ok := m.search(key)
+	if ok {
+		m.entries = append(m.entries[:i], m.entries[i+1:]...)
+		if len(m.entries) == 0 {
+			m.entries = nil
+		}
+	}
+	return ok
+}
+
+// Entries returns the entries of m sorted by key.
+func (m TermMap) Entries() []KV {
+	return append([]KV(nil), m.entries...)
+}
+
+// Range calls fn for each entry of m in key order, until fn returns false.
+func (m TermMap) Range(fn func(key, value Term) bool) {
+	for _, kv := range m.entries {
+		if !fn(kv.Key, kv.Value) {
+			return
+		}
+	}
+}
+
+// termMapOf returns the TermMap or Go map v as a TermMap.
+func termMapOf(v reflect.Value) TermMap {
+	switch v.Type() {
+	case termMapType:
+		return v.Interface().(TermMap)
+	case kvsType:
+		return NewTermMap(v.Interface().([]KV)...)
+	}
+	entries := make([]KV, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		entries = append(entries, KV{iter.Key().Interface(), iter.Value().Interface()})
+	}
+	return NewTermMap(entries...)
+}
+
+// readMap reads a MAP_EXT.
+func (d *Decoder) readMap() (Term, error) {
+	size, err := read4(d.r)
+	if err != nil {
+		return nil, err
+	}
+
+	n := size
+	if n > maxPrealloc {
+		n = maxPrealloc
+	}
+	entries := make([]KV, 0, n)
+	for i := 0; i < size; i++ {
+		key, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, KV{key, value})
+	}
+	if d.MapPairs || d.Fidelity {
+		return entries, nil
+	}
+	return NewTermMap(entries...), nil
+}
+
+// writeTermMap writes the entries of a map as a MAP_EXT.
+func (e *Encoder) writeTermMap(entries []KV) error {
+	if e.Nil == NilOmitted {
+		all := entries
+		entries = make([]KV, 0, len(all))
+		for _, kv := range all {
+			if !isNil(reflect.ValueOf(kv.Value)) {
+				entries = append(entries, kv)
+			}
+		}
+	}
+
+	if e.Canonical {
+		sorted := make([]mapEntry, len(entries))
+		for i, kv := range entries {
+			key, err := e.encodeValue(reflect.ValueOf(kv.Key))
+			if err != nil {
+				return withPath(err, fmt.Sprintf("[%#v]", kv.Key))
+			}
+			value, err := e.encodeValue(reflect.ValueOf(kv.Value))
+			if err != nil {
+				return withPath(err, fmt.Sprintf("[%#v]", kv.Key))
+			}
+			sorted[i] = mapEntry{key, value}
+		}
+		e.writeSortedMap(sorted)
+		return nil
+	}
+
+	write1(e.w, MapTag)
+	write4(e.w, uint32(len(entries)))
+	for _, kv := range entries {
+		if err := e.writeTag(reflect.ValueOf(kv.Key)); err != nil {
+			return withPath(err, fmt.Sprintf("[%#v]", kv.Key))
+		}
+		if err := e.writeTag(reflect.ValueOf(kv.Value)); err != nil {
+			return withPath(err, fmt.Sprintf("[%#v]", kv.Key))
+		}
+	}
+	return nil
+}
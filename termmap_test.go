@@ -0,0 +1,75 @@
+package bert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTermMap(t *testing.T) {
+	var m TermMap
+	m.Set([]Term{Atom("point"), int64(1)}, "a")
+	m.Set(Atom("b"), int64(2))
+	m.Set(List{Items: []Term{int64(1)}}, "c")
+	m.Set([]Term{Atom("point"), int64(1)}, "d")
+
+	assertEqual(t, 3, m.Len())
+	v, ok := m.Get([]Term{Atom("point"), 1})
+	assertEqual(t, true, ok)
+	assertEqual(t, "d", v)
+	_, ok = m.Get(Atom("c"))
+	assertEqual(t, false, ok)
+
+	assertEqual(t, []KV{
+		{Atom("b"), int64(2)},
+		{[]Term{Atom("point"), int64(1)}, "d"},
+		{List{Items: []Term{int64(1)}}, "c"},
+	}, m.Entries())
+
+	var keys []Term
+	m.Range(func(key, value Term) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	assertEqual(t, []Term{Atom("b"), []Term{Atom("point"), int64(1)}}, keys)
+
+	assertEqual(t, true, m.Delete(Atom("b")))
+	assertEqual(t, false, m.Delete(Atom("b")))
+	assertEqual(t, 2, m.Len())
+
+	assertEqual(t, NewTermMap(KV{Atom("a"), int64(2)}), NewTermMap(KV{Atom("a"), int64(1)}, KV{Atom("a"), int64(2)}))
+}
+
+func TestTermMapCodec(t *testing.T) {
+	data := []byte{131, 116, 0, 0, 0, 2,
+		104, 2, 100, 0, 1, 120, 97, 1, 109, 0, 0, 0, 1, 97,
+		100, 0, 1, 98, 97, 2,
+	}
+	m := NewTermMap(
+		KV{[]Term{Atom("x"), int64(1)}, []byte("a")},
+		KV{Atom("b"), int64(2)},
+	)
+	assertDecode(t, data, m)
+	assertEncode(t, m, []byte{131, 116, 0, 0, 0, 2,
+		100, 0, 1, 98, 97, 2,
+		104, 2, 100, 0, 1, 120, 97, 1, 109, 0, 0, 0, 1, 97,
+	})
+	assertDecode(t, []byte{131, 116, 0, 0, 0, 0}, TermMap{})
+}
+
+func TestDecoderMapPairs(t *testing.T) {
+	data := []byte{131, 116, 0, 0, 0, 2,
+		100, 0, 1, 98, 97, 2,
+		100, 0, 1, 97, 97, 1,
+	}
+	d := NewDecoder(bytes.NewReader(data))
+	d.MapPairs = true
+	term, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := []KV{{Atom("b"), int64(2)}, {Atom("a"), int64(1)}}
+	assertEqual(t, pairs, term)
+	assertEncode(t, pairs, data)
+	assertEqual(t, MapKind, KindOf(pairs))
+	assertEqual(t, true, Equal(pairs, map[Atom]int{"a": 1, "b": 2}))
+}
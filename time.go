@@ -0,0 +1,136 @@
+package bert
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+)
+
+// TimeEncoding selects how an Encoder represents time.Time values.
+type TimeEncoding int
+
+const (
+	// TimeAsBERT encodes times as the BERT complex type
+	// {bert, time, Megaseconds, Seconds, Microseconds}. This is the default.
+	TimeAsBERT TimeEncoding = iota
+	// TimeAsInteger encodes times as an integer count of Encoder.TimeUnit
+	// since the Unix epoch, like erlang:system_time/1.
+	TimeAsInteger
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// tagUnit returns the integer unit selected by a struct tag option. The
+// option native is accepted as an alias for ns, which is Erlang's native
+// time unit on common platforms.
+func tagUnit(opts tagOptions) (time.Duration, bool) {
+	switch {
+	case opts.Contains("s"):
+		return time.Second, true
+	case opts.Contains("ms"):
+		return time.Millisecond, true
+	case opts.Contains("us"):
+		return time.Microsecond, true
+	case opts.Contains("ns"), opts.Contains("native"):
+		return time.Nanosecond, true
+	}
+	return 0, false
+}
+
+// timeToUnits returns t as a count of unit since the Unix epoch.
+func timeToUnits(t time.Time, unit time.Duration) *big.Int {
+	n := big.NewInt(t.Unix())
+	n.Mul(n, big.NewInt(int64(time.Second/unit)))
+	return n.Add(n, big.NewInt(int64(t.Nanosecond())/int64(unit)))
+}
+
+// unitsToTime is the inverse of timeToUnits.
+func unitsToTime(n int64, unit time.Duration) time.Time {
+	perSecond := int64(time.Second / unit)
+	sec, frac := n/perSecond, n%perSecond
+	if frac < 0 {
+		sec--
+		frac += perSecond
+	}
+	return time.Unix(sec, frac*int64(unit)).UTC()
+}
+
+func (e *Encoder) writeTime(t time.Time, unit time.Duration) {
+	if unit != 0 || e.Time == TimeAsInteger {
+		if unit == 0 {
+			unit = e.timeUnit()
+		}
+		writeNumber(e.w, *timeToUnits(t, unit))
+		return
+	}
+
+	sec := t.Unix()
+	write1(e.w, SmallTupleTag)
+	write1(e.w, 5)
+	e.writeAtom(BertAtom)
+	e.writeAtom(TimeAtom)
+	writeNumber(e.w, *big.NewInt(sec / 1000000))
+	writeNumber(e.w, *big.NewInt(sec % 1000000))
+	writeNumber(e.w, *big.NewInt(int64(t.Nanosecond() / 1000)))
+}
+
+func (e *Encoder) writeDuration(d, unit time.Duration) {
+	if unit == 0 {
+		unit = e.DurationUnit
+	}
+	if unit <= 0 {
+		unit = time.Nanosecond
+	}
+	writeNumber(e.w, *big.NewInt(int64(d / unit)))
+}
+
+func (e *Encoder) timeUnit() time.Duration {
+	if e.TimeUnit <= 0 {
+		return time.Millisecond
+	}
+	return e.TimeUnit
+}
+
+// readTime converts a {bert, time, Megaseconds, Seconds, Microseconds}
+// tuple into a time.Time in UTC.
+func readTime(tuple []Term) (Term, error) {
+	if len(tuple) != 5 {
+		return nil, fmt.Errorf("invalid BERT time %v", tuple)
+	}
+	var parts [3]int64
+	for i := range parts {
+		n, ok := tuple[i+2].(int64)
+		if !ok {
+			return nil, fmt.Errorf("invalid BERT time %v", tuple)
+		}
+		parts[i] = n
+	}
+	return time.Unix(parts[0]*1000000+parts[1], parts[2]*1000).UTC(), nil
+}
+
+// Timestamp is the {MegaSecs, Secs, MicroSecs} tuple returned by Erlang's
+// erlang:timestamp/0 and os:timestamp/0. It encodes as that 3-tuple, and
+// Unmarshal fills it from one.
+type Timestamp struct {
+	Mega, Sec, Micro int
+}
+
+// TimestampOf returns the Timestamp for t, truncated to microseconds.
+func TimestampOf(t time.Time) Timestamp {
+	sec := t.Unix()
+	return Timestamp{
+		Mega:  int(sec / 1000000),
+		Sec:   int(sec % 1000000),
+		Micro: t.Nanosecond() / 1000,
+	}
+}
+
+// Time returns ts as a time.Time in UTC.
+func (ts Timestamp) Time() time.Time {
+	sec := int64(ts.Mega)*1000000 + int64(ts.Sec)
+	return time.Unix(sec, int64(ts.Micro)*1000).UTC()
+}
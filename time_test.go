@@ -0,0 +1,109 @@
+package bert
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTime(t *testing.T) {
+	tm := time.Date(2009, time.November, 10, 23, 0, 0, 123456000, time.UTC)
+	// 1257894000 = 1257 * 10^6 + 894000
+	data := []byte{131, 104, 5,
+		100, 0, 4, 98, 101, 114, 116,
+		100, 0, 4, 116, 105, 109, 101,
+		98, 0, 0, 4, 233,
+		98, 0, 13, 164, 48,
+		98, 0, 1, 226, 64,
+	}
+	assertEncode(t, tm, data)
+	assertDecode(t, data, tm)
+
+	// BERT complex types are only recognized in leading position
+	assertDecode(t, []byte{131, 104, 2, 100, 0, 3, 102, 111, 111, 100, 0, 4, 98, 101, 114, 116},
+		[]Term{Atom("foo"), BertAtom})
+}
+
+func TestTimeAsInteger(t *testing.T) {
+	tm := time.Date(2009, time.November, 10, 23, 0, 0, 123456000, time.UTC)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Time = TimeAsInteger
+	if err := enc.Encode(tm); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []byte{131, 110, 6, 0, 251, 53, 83, 224, 36, 1}, buf.Bytes())
+
+	buf.Reset()
+	enc.TimeUnit = time.Second
+	enc.Encode(tm)
+	assertEqual(t, []byte{131, 98, 74, 249, 240, 112}, buf.Bytes())
+
+	type event struct {
+		At      time.Time  `bert:"at,us"`
+		Expires *time.Time `bert:"expires,s"`
+	}
+	data := []byte{131, 104, 2,
+		110, 7, 0, 64, 222, 10, 69, 12, 120, 4,
+		98, 74, 249, 240, 112,
+	}
+	expires := tm.Truncate(time.Second)
+	assertEncode(t, event{tm, &expires}, data)
+
+	var ev event
+	if err := Unmarshal(data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(event{tm, &expires}, ev) {
+		t.Errorf("expected %v, but was %v", event{tm, &expires}, ev)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	tm := time.Date(2009, time.November, 10, 23, 0, 0, 123456789, time.UTC)
+	ts := TimestampOf(tm)
+	assertEqual(t, Timestamp{1257, 894000, 123456}, ts)
+	assertEqual(t, tm.Truncate(time.Microsecond), ts.Time())
+
+	data := []byte{131, 104, 3, 98, 0, 0, 4, 233, 98, 0, 13, 164, 48, 98, 0, 1, 226, 64}
+	assertEncode(t, ts, data)
+
+	var back Timestamp
+	if err := Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ts, back)
+}
+
+func TestDuration(t *testing.T) {
+	assertEncode(t, 1500*time.Millisecond, []byte{131, 98, 89, 104, 47, 0})
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.DurationUnit = time.Millisecond
+	enc.Encode(1500 * time.Millisecond)
+	assertEqual(t, []byte{131, 98, 0, 0, 5, 220}, buf.Bytes())
+
+	type options struct {
+		Timeout time.Duration  `bert:"timeout,ms"`
+		Retry   *time.Duration `bert:"retry,us"`
+		Idle    time.Duration  `bert:"idle,native"`
+	}
+	retry := 250 * time.Microsecond
+	data := []byte{131, 104, 3,
+		98, 0, 0, 5, 220,
+		97, 250,
+		98, 0, 0, 3, 232,
+	}
+	assertEncode(t, options{1500 * time.Millisecond, &retry, time.Microsecond}, data)
+
+	var opts options
+	if err := Unmarshal(data, &opts); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1500*time.Millisecond, opts.Timeout)
+	assertEqual(t, retry, *opts.Retry)
+	assertEqual(t, time.Microsecond, opts.Idle)
+}
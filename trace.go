@@ -0,0 +1,110 @@
+package bert
+
+import (
+	"context"
+	"sort"
+)
+
+// TraceAtom is the info command carrying the trace context of the request
+// that follows it, as {info, trace, [{Key, Value}...]} with binary keys
+// and values.
+const TraceAtom = Atom("trace")
+
+// A SpanKind tells the client and server sides of a call apart.
+type SpanKind int
+
+const (
+	ClientSpan SpanKind = iota
+	ServerSpan
+)
+
+// A Tracer traces RPC calls, typically by adapting a distributed tracing
+// system such as OpenTelemetry: Start starts a span as a child of any span
+// in ctx, and Inject and Extract propagate the trace context through a
+// text map carrier.
+type Tracer interface {
+	Start(ctx context.Context, kind SpanKind, module, function Atom) (context.Context, Span)
+	Inject(ctx context.Context, carrier map[string]string)
+	Extract(ctx context.Context, carrier map[string]string) context.Context
+}
+
+// A Span is a traced call in progress.
+type Span interface {
+	End(result SpanResult)
+}
+
+// SpanResult describes the outcome of a traced call.
+type SpanResult struct {
+	// RequestSize and ResponseSize are the encoded sizes of the request
+	// and response packets. They are zero when unknown, as for streamed
+	// responses.
+	RequestSize  int
+	ResponseSize int
+
+	// Err is the error the call failed with, if any.
+	Err error
+}
+
+// startSpan starts the client span of a call and arranges for its trace
+// context to be sent along with out. The returned function ends the span.
+func (c *Client) startSpan(ctx context.Context, module, function Atom, out *outgoing) (context.Context, func(error)) {
+	if c.Tracer == nil {
+		return ctx, func(error) {}
+	}
+	ctx, span := c.Tracer.Start(ctx, ClientSpan, module, function)
+	carrier := make(map[string]string)
+	c.Tracer.Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		out.info = traceInfo(carrier)
+	}
+	return ctx, func(err error) {
+		span.End(SpanResult{RequestSize: out.sent, ResponseSize: out.received, Err: err})
+	}
+}
+
+// startSpan starts the server span of a request, continuing the trace in
+// carrier. The returned function ends the span given the sizes of the
+// request and response and the response itself.
+func (s *Server) startSpan(ctx context.Context, term Term, carrier map[string]string) (context.Context, func(int, int, Term)) {
+	req, err := parseRequest(term)
+	if s.Tracer == nil || err != nil {
+		return ctx, func(int, int, Term) {}
+	}
+	if carrier != nil {
+		ctx = s.Tracer.Extract(ctx, carrier)
+	}
+	ctx, span := s.Tracer.Start(ctx, ServerSpan, req.Module, req.Function)
+	return ctx, func(requestSize, responseSize int, resp Term) {
+		result := SpanResult{RequestSize: requestSize, ResponseSize: responseSize}
+		if _, ok := resp.(streamResponse); !ok {
+			_, result.Err = parseResponse(resp)
+		}
+		span.End(result)
+	}
+}
+
+// traceInfo returns the info packet carrying carrier, with its entries
+// sorted by key.
+func traceInfo(carrier map[string]string) Term {
+	keys := make([]string, 0, len(carrier))
+	for k := range carrier {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := make([]Term, len(keys))
+	for i, k := range keys {
+		entries[i] = []Term{[]byte(k), []byte(carrier[k])}
+	}
+	return infoTerm(TraceAtom, entries...)
+}
+
+// traceCarrier returns the carrier sent in a trace info packet.
+func traceCarrier(options []Term) map[string]string {
+	carrier := make(map[string]string, len(options))
+	for _, option := range options {
+		if entry, ok := option.([]Term); ok && len(entry) == 2 {
+			carrier[textOf(entry[0])] = textOf(entry[1])
+		}
+	}
+	return carrier
+}
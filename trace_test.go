@@ -0,0 +1,121 @@
+package bert
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type traceKey struct{}
+
+// testTracer records spans, propagating the ID of the current span.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []testSpan
+}
+
+type testSpan struct {
+	id, parent string
+	kind       SpanKind
+	name       string
+	result     SpanResult
+}
+
+func (tr *testTracer) Start(ctx context.Context, kind SpanKind, module, function Atom) (context.Context, Span) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	parent, _ := ctx.Value(traceKey{}).(string)
+	span := &testSpanEnder{tr: tr, span: testSpan{
+		id:     string(rune('a' + len(tr.spans))),
+		parent: parent,
+		kind:   kind,
+		name:   string(module) + ":" + string(function),
+	}}
+	tr.spans = append(tr.spans, testSpan{})
+	return context.WithValue(ctx, traceKey{}, span.span.id), span
+}
+
+func (tr *testTracer) Inject(ctx context.Context, carrier map[string]string) {
+	if id, ok := ctx.Value(traceKey{}).(string); ok {
+		carrier["span"] = id
+	}
+}
+
+func (tr *testTracer) Extract(ctx context.Context, carrier map[string]string) context.Context {
+	return context.WithValue(ctx, traceKey{}, carrier["span"])
+}
+
+type testSpanEnder struct {
+	tr   *testTracer
+	span testSpan
+}
+
+func (s *testSpanEnder) End(result SpanResult) {
+	s.tr.mu.Lock()
+	defer s.tr.mu.Unlock()
+	s.span.result = result
+	s.tr.spans[s.span.id[0]-'a'] = s.span
+}
+
+func TestTracer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, multiplex := range []bool{false, true} {
+		tr := &testTracer{}
+		s := newTestServer()
+		s.Tracer = tr
+		a, b := net.Pipe()
+		served := make(chan struct{})
+		go func() {
+			s.ServeConn(b)
+			close(served)
+		}()
+		c := NewClient(a)
+		c.Multiplex = multiplex
+		c.Tracer = tr
+
+		if _, err := c.Call(ctx, "math", "add", 1, 2); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.Call(ctx, "math", "fail")
+		c.Close()
+		<-served
+
+		tr.mu.Lock()
+		spans := append([]testSpan(nil), tr.spans...)
+		tr.mu.Unlock()
+		if len(spans) != 4 {
+			t.Fatalf("expected 4 spans, got %v", spans)
+		}
+		client, server := spans[0], spans[1]
+		assertEqual(t, ClientSpan, client.kind)
+		assertEqual(t, "math:add", client.name)
+		assertEqual(t, ServerSpan, server.kind)
+		assertEqual(t, "math:add", server.name)
+		assertEqual(t, client.id, server.parent)
+		if client.result.RequestSize == 0 || client.result.ResponseSize == 0 {
+			t.Errorf("expected payload sizes, got %+v", client.result)
+		}
+		assertEqual(t, client.result.ResponseSize, server.result.ResponseSize)
+		assertEqual(t, err, spans[2].result.Err)
+		assertEqual(t, err, spans[3].result.Err)
+	}
+}
+
+func TestTraceInfo(t *testing.T) {
+	data, err := Encode(traceInfo(map[string]string{"traceparent": "00-abc-01", "b": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, options, ok := parseInfo(info)
+	assertEqual(t, true, ok)
+	assertEqual(t, TraceAtom, command)
+	assertEqual(t, map[string]string{"traceparent": "00-abc-01", "b": "2"}, traceCarrier(options))
+}
@@ -0,0 +1,65 @@
+package bert
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// splitAddr splits an address of the form unix:///path/to/socket,
+// tcp://host:port or plain host:port into the network and address to pass
+// to net.Dial or net.Listen.
+func splitAddr(addr string) (network, address string, err error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return "tcp", addr, nil
+	}
+	network, address = addr[:i], addr[i+3:]
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("unsupported address %q", addr)
+}
+
+// DialAddr connects to a BERT-RPC server at addr, which is either a TCP
+// address host:port, optionally prefixed with tcp://, or the path of a
+// Unix domain socket prefixed with unix://, as in unix:///run/app.sock.
+func DialAddr(addr string) (*Client, error) {
+	network, address, err := splitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return Dial(network, address)
+}
+
+// DialAddrContext connects to a BERT-RPC server at addr, given as for
+// DialAddr, using ctx for the connection: if ctx expires or is cancelled
+// before the connection completes, DialAddrContext returns an error.
+func DialAddrContext(ctx context.Context, addr string) (*Client, error) {
+	network, address, err := splitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// ListenAndServe listens on addr, given as for DialAddr, and serves
+// connections as Serve does.
+func (s *Server) ListenAndServe(addr string) error {
+	network, address, err := splitAddr(addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
@@ -0,0 +1,61 @@
+package bert
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplitAddr(t *testing.T) {
+	tests := []struct {
+		addr, network, address string
+	}{
+		{"localhost:9999", "tcp", "localhost:9999"},
+		{"tcp://localhost:9999", "tcp", "localhost:9999"},
+		{"tcp6://[::1]:9999", "tcp6", "[::1]:9999"},
+		{"unix:///run/app.sock", "unix", "/run/app.sock"},
+		{"unix://app.sock", "unix", "app.sock"},
+	}
+	for _, test := range tests {
+		network, address, err := splitAddr(test.addr)
+		if err != nil {
+			t.Errorf("splitAddr(%q) returned error '%v'", test.addr, err)
+			continue
+		}
+		assertEqual(t, test.network, network)
+		assertEqual(t, test.address, address)
+	}
+	if _, _, err := splitAddr("http://localhost"); err == nil {
+		t.Errorf("expected error for http address")
+	}
+}
+
+func TestUnixTransport(t *testing.T) {
+	addr := "unix://" + filepath.Join(t.TempDir(), "bert.sock")
+	s := newTestServer()
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(addr) }()
+
+	var c *Client
+	var err error
+	for i := 0; i < 100; i++ {
+		if c, err = DialAddr(addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.Call(context.Background(), "math", "add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(3), result)
+
+	s.Shutdown(context.Background())
+	assertEqual(t, ErrServerClosed, <-served)
+}
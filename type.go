@@ -1,29 +1,48 @@
 package bert
 
 const (
-	VersionTag     = 131
-	SmallIntTag    = 97
-	IntTag         = 98
-	SmallBignumTag = 110
-	LargeBignumTag = 111
-	FloatTag       = 99
-	AtomTag        = 100
-	SmallTupleTag  = 104
-	LargeTupleTag  = 105
-	NilTag         = 106
-	StringTag      = 107
-	ListTag        = 108
-	BinTag         = 109
-	BitTag         = 77
+	VersionTag       = 131
+	SmallIntTag      = 97
+	IntTag           = 98
+	SmallBignumTag   = 110
+	LargeBignumTag   = 111
+	FloatTag         = 99
+	NewFloatTag      = 70
+	AtomTag          = 100
+	SmallAtomTag     = 115
+	AtomUTF8Tag      = 118
+	SmallAtomUTF8Tag = 119
+	SmallTupleTag    = 104
+	LargeTupleTag    = 105
+	NilTag           = 106
+	StringTag        = 107
+	ListTag          = 108
+	BinTag           = 109
+	BitTag           = 77
+	MapTag           = 116
+	CompressedTag    = 80
 )
 
 type Atom string
+
+// A Bitstring is a sequence of bits that need not fill whole bytes. Its
+// bits are held by Bytes, most significant first, with the last byte
+// holding Bits%8 of them, or 8 if that is zero; see Len. BIT_BINARY_EXT
+// terms decode as Bitstrings.
 type Bitstring struct {
 	Bytes []byte
 	Bits  uint8
 }
+
+// A Binary is text that always encodes as a binary, as Elixir strings
+// are, rather than as STRING_EXT like other Go strings.
+type Binary string
+
+// A List is a list term. Tail is the tail of an improper list, and nil
+// for a proper list ending with NIL.
 type List struct {
 	Items []Term
+	Tail  Term
 }
 
 const (
@@ -31,6 +50,7 @@ const (
 	NilAtom   = Atom("nil")
 	TrueAtom  = Atom("true")
 	FalseAtom = Atom("false")
+	TimeAtom  = Atom("time")
 )
 
 type Term interface{}
@@ -0,0 +1,570 @@
+package bert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An UnmarshalTypeError describes a term that could not be stored in a Go
+// value of a specific type. Path locates the term within the one being
+// unmarshaled as in Violation.
+type UnmarshalTypeError struct {
+	Term Term
+	Type reflect.Type
+	Path string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("cannot unmarshal %#v into Go value of type %v", e.Term, e.Type)
+	}
+	return fmt.Sprintf("cannot unmarshal %#v into Go value of type %v at %s", e.Term, e.Type, e.Path)
+}
+
+// unmarshalPath prefixes the path of an UnmarshalTypeError returned for an
+// element with the index that leads to it.
+func unmarshalPath(err error, elem string) error {
+	if e, ok := err.(*UnmarshalTypeError); ok {
+		e.Path = elem + e.Path
+	}
+	return err
+}
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// termBigInt returns the integer held by term as a big.Int.
+func termBigInt(term Term) (*big.Int, bool) {
+	switch n := term.(type) {
+	case int64:
+		return big.NewInt(n), true
+	case int:
+		return big.NewInt(int64(n)), true
+	case big.Int:
+		return &n, true
+	case *big.Int:
+		return n, n != nil
+	}
+	return nil, false
+}
+
+// derefType returns the type t points to, through any number of pointers.
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// UnmarshalTerm stores an already decoded term in the value pointed to by
+// val, converting it as Unmarshal does.
+func UnmarshalTerm(term Term, val interface{}) error {
+	return UnmarshalOptions{}.UnmarshalTerm(term, val)
+}
+
+// UnmarshalOptions configures how terms are stored in Go values. Its zero
+// value converts terms as Unmarshal does, including the lenient arity of
+// records and of tuples for types registered with RegisterExtension.
+type UnmarshalOptions struct {
+	// StrictArity requires tuples stored in structs to have exactly one
+	// element for each field, following the tag of records. Otherwise a
+	// shorter tuple leaves the remaining fields zero and the extra elements
+	// of a longer one are ignored, so that peers sending an older version
+	// of a struct or record remain understood.
+	StrictArity bool
+
+	// Weak allows conversions between loosely matching types, as older
+	// Erlang code often sends numbers as text: strings, binaries and
+	// charlists holding decimal numbers fill integer and float values,
+	// integers fill float values, and a term that is not a list fills a
+	// slice as its single element.
+	Weak bool
+
+	// DecodeHook, if set, transforms each term before it is stored, as
+	// described for DecodeHook. Use ComposeDecodeHooks to apply several.
+	DecodeHook DecodeHook
+
+	// AllErrors continues past elements, fields and map entries that
+	// cannot be stored, leaving them zero, and returns an UnmarshalErrors
+	// listing every failure instead of stopping at the first one.
+	AllErrors bool
+}
+
+// UnmarshalErrors lists the failures of an unmarshal with
+// UnmarshalOptions.AllErrors, in the order they were found. Those that
+// are UnmarshalTypeError values locate the term that failed in their Path.
+type UnmarshalErrors []error
+
+func (e UnmarshalErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors listed, for errors.Is and errors.As.
+func (e UnmarshalErrors) Unwrap() []error { return e }
+
+// Unmarshal decodes a term from data and stores it in the value pointed to
+// by val. Unless o.StrictArity is set, tuples for types registered with
+// RegisterExtension are decoded with Decoder.LenientArity.
+func (o UnmarshalOptions) Unmarshal(data []byte, val interface{}) error {
+	if holdsRaw(reflect.TypeOf(val)) {
+		u := &unmarshaler{opts: o}
+		return u.result(u.unmarshalRaw(data, reflect.ValueOf(val).Elem()))
+	}
+	return o.unmarshalFrom(bytes.NewReader(data), val)
+}
+
+// unmarshalFrom decodes a term from r and stores it in the value pointed
+// to by val.
+func (o UnmarshalOptions) unmarshalFrom(r io.Reader, val interface{}) error {
+	d := NewDecoder(r)
+	d.LenientArity = !o.StrictArity
+	term, err := d.Decode()
+	if err != nil {
+		return err
+	}
+	return o.UnmarshalTerm(term, val)
+}
+
+// UnmarshalTerm stores an already decoded term in the value pointed to by
+// val.
+func (o UnmarshalOptions) UnmarshalTerm(term Term, val interface{}) error {
+	u := &unmarshaler{opts: o}
+	return u.result(u.unmarshalTerm(term, reflect.ValueOf(val).Elem()))
+}
+
+// An unmarshaler holds the state of storing a term in a Go value.
+type unmarshaler struct {
+	opts UnmarshalOptions
+	errs UnmarshalErrors // with AllErrors
+}
+
+// result returns the error of an unmarshal that returned err, with the
+// failures collected with AllErrors.
+func (u *unmarshaler) result(err error) error {
+	if err != nil {
+		u.errs = append(u.errs, err)
+	}
+	if len(u.errs) == 0 {
+		return nil
+	}
+	if !u.opts.AllErrors {
+		return u.errs[0]
+	}
+	return u.errs
+}
+
+// element stores the element of a term found at elem, by calling fn. An
+// error fn returns is prefixed with elem and returned, or, with AllErrors,
+// collected. So are those collected by fn.
+func (u *unmarshaler) element(elem string, fn func() error) error {
+	mark := len(u.errs)
+	if err := fn(); err != nil {
+		if !u.opts.AllErrors {
+			return unmarshalPath(err, elem)
+		}
+		u.errs = append(u.errs, err)
+	}
+	for _, err := range u.errs[mark:] {
+		unmarshalPath(err, elem)
+	}
+	return nil
+}
+
+// unmarshalTerm stores term in v with the default options.
+func unmarshalTerm(term Term, v reflect.Value) error {
+	return (&unmarshaler{}).unmarshalTerm(term, v)
+}
+
+// unmarshalField stores term in the struct field v described by f, honoring
+// its tag options.
+func (u *unmarshaler) unmarshalField(term Term, v reflect.Value, f field) error {
+	if unit, ok := tagUnit(f.opts); ok && derefType(v.Type()) == timeType {
+		if n, ok := term.(int64); ok {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					v.Set(reflect.New(v.Type().Elem()))
+				}
+				v = v.Elem()
+			}
+			v.Set(reflect.ValueOf(unitsToTime(n, unit)))
+			return nil
+		}
+	}
+	if unit, ok := tagUnit(f.opts); ok && derefType(v.Type()) == durationType {
+		if n, ok := term.(int64); ok {
+			term = int64(time.Duration(n) * unit)
+		}
+	}
+	return u.unmarshalTerm(term, v)
+}
+
+// unmarshalTerm stores term in v, converting it to v's type where the
+// mapping is unambiguous.
+func (u *unmarshaler) unmarshalTerm(term Term, v reflect.Value) error {
+	if v.Type() == rawTermType {
+		return unmarshalRawTerm(term, v)
+	}
+	if x, ok := term.(Verbatim); ok {
+		term = x.Term
+	}
+	if l, ok := term.(List); ok && l.Tail == nil {
+		term = l.Items
+	}
+	if u.opts.DecodeHook != nil {
+		var err error
+		if term, err = u.opts.DecodeHook(term, v.Type()); err != nil {
+			return err
+		}
+	}
+	if v.CanAddr() {
+		if o, ok := v.Addr().Interface().(optionalPtr); ok {
+			if isAbsent(term) {
+				o.setOption(false)
+				return nil
+			}
+			return u.unmarshalTerm(term, o.setOption(true))
+		}
+	}
+
+	if a, ok := term.(Atom); ok {
+		if rv, ok, registered := valueFor(v.Type(), a); registered {
+			if !ok {
+				return fmt.Errorf("atom %q is not registered for %v", a, v.Type())
+			}
+			v.Set(rv)
+			return nil
+		}
+	}
+
+	if fn := converterFor(v.Type()); fn != nil {
+		x, ok, err := fn(term)
+		if err != nil {
+			return err
+		}
+		if ok {
+			v.Set(reflect.ValueOf(x))
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		// like encoding/json, decode into a pointer the interface already
+		// holds, and otherwise store the term itself
+		if e := v.Elem(); term != nil && e.Kind() == reflect.Ptr && !e.IsNil() {
+			return u.unmarshalTerm(term, e)
+		}
+		if v.NumMethod() == 0 {
+			if term == nil {
+				v.Set(reflect.Zero(v.Type()))
+			} else {
+				v.Set(reflect.ValueOf(term))
+			}
+			return nil
+		}
+	case reflect.Ptr:
+		if term == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return u.unmarshalTerm(term, v.Elem())
+	case reflect.Struct:
+		if v.Type() == bigIntType {
+			if n, ok := termBigInt(term); ok {
+				v.Set(reflect.ValueOf(*n))
+				return nil
+			}
+			break
+		}
+		if v.Type() == bigFloatType {
+			if unmarshalBigFloat(term, v) {
+				return nil
+			}
+			break
+		}
+		if kindOf(termValue(term)) == MapKind {
+			return u.unmarshalStructMap(termMapOf(termValue(term)), v)
+		}
+		tuple, ok := term.([]Term)
+		if !ok {
+			break
+		}
+		if tag, ok := recordTag(v.Type()); ok {
+			return u.unmarshalRecord(tag, tuple, v)
+		}
+		return u.unmarshalFields(tuple, v)
+	case reflect.Map:
+		if kindOf(termValue(term)) == MapKind {
+			return u.unmarshalMap(termMapOf(termValue(term)), v)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := termBigInt(term)
+		if !ok {
+			break
+		}
+		if !n.IsInt64() || v.OverflowInt(n.Int64()) {
+			return &UnmarshalTypeError{Term: term, Type: v.Type()}
+		}
+		v.SetInt(n.Int64())
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := termBigInt(term)
+		if !ok {
+			break
+		}
+		if n.Sign() < 0 || !n.IsUint64() || v.OverflowUint(n.Uint64()) {
+			return &UnmarshalTypeError{Term: term, Type: v.Type()}
+		}
+		v.SetUint(n.Uint64())
+		return nil
+	case reflect.String:
+		if v.Type() == jsonNumberType {
+			if s, ok := decimalText(term); ok {
+				v.SetString(s)
+				return nil
+			}
+			break
+		}
+		// binaries hold the text of strings encoded with StringAsBinary
+		if b, ok := term.([]byte); ok && !isAtomType(v.Type()) {
+			v.SetString(string(b))
+			return nil
+		}
+		// strings too long for STRING_EXT arrive as lists of bytes
+		if items, ok := term.([]Term); ok && len(items) > 0 && !isAtomType(v.Type()) {
+			if b, ok := byteList(items); ok {
+				v.SetString(string(b))
+				return nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := term.(float64); ok {
+			if v.OverflowFloat(f) {
+				return &UnmarshalTypeError{Term: term, Type: v.Type()}
+			}
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.Slice:
+		// tuples and lists fill slices element by element, unless the
+		// slice holds terms
+		if items, ok := term.([]Term); ok && v.Type().Elem().Kind() != reflect.Interface {
+			return u.unmarshalSlice(items, v)
+		}
+	}
+
+	if term == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	tv := reflect.ValueOf(term)
+	switch {
+	case tv.Type().AssignableTo(v.Type()):
+		v.Set(tv)
+		return nil
+	case tv.Kind() == v.Kind() && tv.Type().ConvertibleTo(v.Type()):
+		v.Set(tv.Convert(v.Type()))
+		return nil
+	}
+
+	if u.opts.Weak {
+		if ok, err := u.unmarshalWeak(term, v); ok {
+			return err
+		}
+	}
+
+	return &UnmarshalTypeError{Term: term, Type: v.Type()}
+}
+
+// unmarshalMap stores the entries of m in the Go map v, allocating it if
+// it is nil. Binary keys are stored as strings, and as atoms in maps keyed
+// by Atom.
+func (u *unmarshaler) unmarshalMap(m TermMap, v reflect.Value) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(v.Type(), m.Len()))
+	}
+	kt, et := v.Type().Key(), v.Type().Elem()
+	for _, kv := range m.entries {
+		key := kv.Key
+		if b, ok := key.([]byte); ok && kt.Kind() == reflect.String {
+			key = string(b)
+		}
+		k := reflect.New(kt).Elem()
+		e := reflect.New(et).Elem()
+		ok := false
+		err := u.element(fmt.Sprintf("[%#v]", kv.Key), func() error {
+			if err := u.unmarshalTerm(key, k); err != nil {
+				return err
+			}
+			if err := u.unmarshalTerm(kv.Value, e); err != nil {
+				return err
+			}
+			ok = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if ok {
+			v.SetMapIndex(k, e)
+		}
+	}
+	return nil
+}
+
+// unmarshalSlice stores items in the slice v, replacing its elements.
+func (u *unmarshaler) unmarshalSlice(items []Term, v reflect.Value) error {
+	s := reflect.MakeSlice(v.Type(), len(items), len(items))
+	for i, item := range items {
+		err := u.element(fmt.Sprintf("[%d]", i), func() error {
+			return u.unmarshalTerm(item, s.Index(i))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	v.Set(s)
+	return nil
+}
+
+// unmarshalWeak stores term in v with the conversions of
+// UnmarshalOptions.Weak, reporting whether any applies.
+func (u *unmarshaler) unmarshalWeak(term Term, v reflect.Value) (bool, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s, ok := weakText(term)
+		if !ok {
+			return false, nil
+		}
+		n, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+		if !ok {
+			return true, &UnmarshalTypeError{Term: term, Type: v.Type()}
+		}
+		return true, u.unmarshalTerm(*n, v)
+	case reflect.Float32, reflect.Float64:
+		if n, ok := termBigInt(term); ok {
+			f, _ := new(big.Float).SetInt(n).Float64()
+			if v.OverflowFloat(f) {
+				return true, &UnmarshalTypeError{Term: term, Type: v.Type()}
+			}
+			v.SetFloat(f)
+			return true, nil
+		}
+		s, ok := weakText(term)
+		if !ok {
+			return false, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || v.OverflowFloat(f) {
+			return true, &UnmarshalTypeError{Term: term, Type: v.Type()}
+		}
+		v.SetFloat(f)
+		return true, nil
+	case reflect.Slice:
+		if _, ok := term.([]Term); ok {
+			return false, nil
+		}
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		if err := u.unmarshalTerm(term, s.Index(0)); err != nil {
+			return true, err
+		}
+		v.Set(s)
+		return true, nil
+	}
+	return false, nil
+}
+
+// weakText returns the text of a string, binary or charlist.
+func weakText(term Term) (string, bool) {
+	switch x := term.(type) {
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	case []Term:
+		if b, ok := byteList(x); ok && len(x) > 0 {
+			return string(b), true
+		}
+	}
+	return "", false
+}
+
+// unmarshalFields stores the elements of tuple in the fields of struct v.
+func (u *unmarshaler) unmarshalFields(tuple []Term, v reflect.Value) error {
+	fields := structFields(v.Type())
+	if u.opts.StrictArity && len(tuple) != len(fields) {
+		return fmt.Errorf("%v expects %d elements, got %d", v.Type(), len(fields), len(tuple))
+	}
+	for i := 0; i < len(tuple) && i < len(fields); i++ {
+		fv, err := settableFieldByIndex(v, fields[i].index)
+		if err != nil {
+			return err
+		}
+		err = u.element(fmt.Sprintf("[%d]", i), func() error {
+			return u.unmarshalField(tuple[i], fv, fields[i])
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalStructMap stores the entries of m in the fields of struct v
+// they name, as written by Encoder.StructMaps. Entries naming no field are
+// ignored.
+func (u *unmarshaler) unmarshalStructMap(m TermMap, v reflect.Value) error {
+	fields := structFields(v.Type())
+	for _, kv := range m.entries {
+		switch kv.Key.(type) {
+		case Atom, string, []byte:
+		default:
+			continue
+		}
+		name := textOf(kv.Key)
+		for _, f := range fields {
+			if f.name != name {
+				continue
+			}
+			fv, err := settableFieldByIndex(v, f.index)
+			if err != nil {
+				return err
+			}
+			err = u.element(fmt.Sprintf("[%#v]", kv.Key), func() error {
+				return u.unmarshalField(kv.Value, fv, f)
+			})
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// byteList returns the bytes of a list of integers from 0 to 255.
+func byteList(items []Term) ([]byte, bool) {
+	b := make([]byte, len(items))
+	for i, item := range items {
+		n, ok := item.(int64)
+		if !ok || n < 0 || n > 255 {
+			return nil, false
+		}
+		b[i] = byte(n)
+	}
+	return b, true
+}
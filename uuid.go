@@ -0,0 +1,95 @@
+package bert
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+)
+
+// UUIDForm selects the wire representation used for a UUID type.
+type UUIDForm int
+
+const (
+	// UUIDBinary encodes UUIDs as 16-byte binaries.
+	UUIDBinary UUIDForm = iota
+	// UUIDString encodes UUIDs as 36-byte binaries holding the canonical
+	// hyphenated text form, as Elixir's Ecto.UUID does.
+	UUIDString
+)
+
+// RegisterUUID registers encode and decode adapters for t, which must be a
+// [16]byte array type such as github.com/google/uuid.UUID. Values of t
+// encode in the given form, and Unmarshal fills them from binaries or
+// strings in either form.
+func RegisterUUID(t reflect.Type, form UUIDForm) {
+	if t.Kind() != reflect.Array || t.Len() != 16 || t.Elem().Kind() != reflect.Uint8 {
+		panic(fmt.Sprintf("bert: RegisterUUID of non-[16]byte type %v", t))
+	}
+
+	RegisterEncoder(t, func(v interface{}) (Term, error) {
+		var id [16]byte
+		reflect.Copy(reflect.ValueOf(id[:]), reflect.ValueOf(v))
+		if form == UUIDString {
+			return []byte(formatUUID(id)), nil
+		}
+		return id[:], nil
+	})
+
+	registerConverter(t, func(term Term) (interface{}, bool, error) {
+		var b []byte
+		switch x := term.(type) {
+		case []byte:
+			b = x
+		case string:
+			b = []byte(x)
+		default:
+			return nil, false, nil
+		}
+
+		id, err := parseUUID(b)
+		if err != nil {
+			return nil, false, err
+		}
+		v := reflect.New(t).Elem()
+		reflect.Copy(v, reflect.ValueOf(id[:]))
+		return v.Interface(), true, nil
+	})
+}
+
+func formatUUID(id [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf[:])
+}
+
+// parseUUID accepts the 16 raw bytes of a UUID or its 36-byte canonical
+// text form.
+func parseUUID(b []byte) (id [16]byte, err error) {
+	switch len(b) {
+	case 16:
+		copy(id[:], b)
+		return id, nil
+	case 36:
+		if b[8] != '-' || b[13] != '-' || b[18] != '-' || b[23] != '-' {
+			break
+		}
+		text := make([]byte, 0, 32)
+		text = append(text, b[0:8]...)
+		text = append(text, b[9:13]...)
+		text = append(text, b[14:18]...)
+		text = append(text, b[19:23]...)
+		text = append(text, b[24:]...)
+		if _, err := hex.Decode(id[:], text); err == nil {
+			return id, nil
+		}
+	}
+	return id, fmt.Errorf("invalid UUID %q", b)
+}
@@ -0,0 +1,46 @@
+package bert
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type testUUID [16]byte
+
+type testTextUUID [16]byte
+
+func registerTestUUIDs() {
+	RegisterUUID(reflect.TypeOf(testUUID{}), UUIDBinary)
+	RegisterUUID(reflect.TypeOf(testTextUUID{}), UUIDString)
+}
+
+func TestUUID(t *testing.T) {
+	register(t, registerTestUUIDs)
+	raw := [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	text := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	assertEncode(t, testUUID(raw), append([]byte{131, 109, 0, 0, 0, 16}, raw[:]...))
+	assertEncode(t, testTextUUID(raw), append([]byte{131, 109, 0, 0, 0, 36}, text...))
+
+	var ids struct {
+		A testUUID
+		B testUUID
+		C testTextUUID
+	}
+	var data bytes.Buffer
+	data.Write([]byte{131, 104, 3})
+	data.Write(append([]byte{109, 0, 0, 0, 16}, raw[:]...))
+	data.Write(append([]byte{109, 0, 0, 0, 36}, text...))
+	data.Write(append([]byte{107, 0, 36}, text...))
+	if err := Unmarshal(data.Bytes(), &ids); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, testUUID(raw), ids.A)
+	assertEqual(t, testUUID(raw), ids.B)
+	assertEqual(t, testTextUUID(raw), ids.C)
+
+	if err := Unmarshal([]byte{131, 104, 1, 109, 0, 0, 0, 3, 1, 2, 3}, &ids); err == nil {
+		t.Errorf("expected error for short UUID")
+	}
+}
@@ -0,0 +1,440 @@
+package bert
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The WebSocket transport carries each BERT term as one binary message,
+// without the length prefix used on plain connections. The connections
+// returned by UpgradeWebSocket and DialWebSocketConn translate between the
+// two, so that TermConn, Client and Server work over them unchanged.
+
+// webSocketGUID is the key suffix defined by RFC 6455.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketProtocol is the subprotocol offered and accepted for BERT.
+const WebSocketProtocol = "bert"
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// wsCloseTimeout is the time Close waits for its close frame to be sent.
+const wsCloseTimeout = time.Second
+
+var (
+	errWebSocketHandshake = errors.New("websocket handshake failed")
+	errWebSocketMask      = errors.New("websocket frame masked incorrectly")
+	errWebSocketOrigin    = errors.New("websocket origin not allowed")
+)
+
+// WebSocketHandler returns an http.Handler that upgrades requests to
+// WebSocket connections and serves BERT-RPC on them with s. Requests are
+// checked by s.CheckOrigin.
+func WebSocketHandler(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		check := s.CheckOrigin
+		if check == nil {
+			check = SameOrigin
+		}
+		c, err := upgradeWebSocket(w, r, check)
+		if err != nil {
+			if err == errWebSocketOrigin {
+				logf(s.Logger, "bert: websocket upgrade from %s refused: origin %q not allowed", r.RemoteAddr, r.Header.Get("Origin"))
+			}
+			return
+		}
+		s.ServeConn(c)
+	})
+}
+
+// SameOrigin reports whether r has no Origin header, as from clients other
+// than browsers, or one whose host is that of the request, so that pages
+// of other sites cannot open connections on behalf of their visitors.
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// UpgradeWebSocket completes the WebSocket handshake for r and returns the
+// connection, which reads and writes length-prefixed BERT frames as
+// binary messages. If r is not a valid upgrade request, or comes from
+// another origin as decided by SameOrigin, it replies with an HTTP error
+// and returns it.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	return upgradeWebSocket(w, r, SameOrigin)
+}
+
+// upgradeWebSocket is UpgradeWebSocket, refusing requests for which
+// checkOrigin returns false.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, checkOrigin func(*http.Request) bool) (net.Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errWebSocketHandshake
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+		return nil, errWebSocketOrigin
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade unsupported", http.StatusInternalServerError)
+		return nil, errWebSocketHandshake
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", WebSocketProtocol) {
+		resp += "Sec-WebSocket-Protocol: " + WebSocketProtocol + "\r\n"
+	}
+	if _, err := io.WriteString(conn, resp+"\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWSConn(conn, rw.Reader, false), nil
+}
+
+// DialWebSocket connects to a BERT-RPC server at a ws:// or wss:// URL.
+func DialWebSocket(ctx context.Context, rawurl string) (*Client, error) {
+	c, err := DialWebSocketConn(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// DialWebSocketConn opens a WebSocket connection to a ws:// or wss:// URL,
+// which reads and writes length-prefixed BERT frames as binary messages.
+func DialWebSocketConn(ctx context.Context, rawurl string) (net.Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ws":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "wss":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	case "wss":
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported websocket URL %q", rawurl)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stop, err := applyDeadline(ctx, conn.SetDeadline)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer stop()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", WebSocketProtocol)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, ctxError(ctx, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, ctxError(ctx, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, errWebSocketHandshake
+	}
+	return newWSConn(conn, br, true), nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains reports whether the comma separated header values of key
+// contain token, ignoring case.
+func headerContains(h http.Header, key, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A wsConn adapts a WebSocket connection to a stream of length-prefixed
+// frames. Each frame written is sent as a binary message, and each message
+// received is read as a frame.
+type wsConn struct {
+	net.Conn
+	br     *bufio.Reader
+	client bool // whether outgoing frames are masked
+
+	rbuf []byte // unread part of the current frame
+
+	wmu    sync.Mutex
+	wbuf   []byte // partial frame written so far
+	closed bool
+}
+
+func newWSConn(c net.Conn, br *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: c, br: br, client: client}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf = make([]byte, 4+len(msg))
+		binary.BigEndian.PutUint32(c.rbuf, uint32(len(msg)))
+		copy(c.rbuf[4:], msg)
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// readMessage reads the next data message, answering control frames on
+// the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			msg = payload
+		case wsContinuation:
+			msg = append(msg, payload...)
+		default:
+			return nil, fmt.Errorf("unknown websocket opcode %d", opcode)
+		}
+		if len(msg) > DefaultMaxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0f
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		// clients must mask their frames and servers must not, as RFC
+		// 6455 requires
+		return false, 0, nil, errWebSocketMask
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > DefaultMaxFrameSize {
+		return false, 0, nil, ErrFrameTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// Write sends each complete frame in p as a binary message, keeping any
+// partial frame until the rest of it is written.
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, p...)
+	for len(c.wbuf) >= 4 {
+		size := int(binary.BigEndian.Uint32(c.wbuf))
+		if len(c.wbuf) < 4+size {
+			break
+		}
+		if err := c.writeFrameLocked(wsBinary, c.wbuf[4:4+size]); err != nil {
+			c.wbuf = c.wbuf[:0]
+			return 0, err
+		}
+		c.wbuf = c.wbuf[4+size:]
+	}
+	if len(c.wbuf) == 0 {
+		c.wbuf = nil
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(append(frame, maskBit|127), ext[:]...)
+	}
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame, unless a write is in progress, and closes the
+// connection. The close frame is given wsCloseTimeout to be sent, so that
+// a peer that stopped reading does not hold Close up.
+func (c *wsConn) Close() error {
+	if c.wmu.TryLock() {
+		if !c.closed {
+			c.Conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+			c.writeFrameLocked(wsClose, nil)
+		}
+		c.closed = true
+		c.wmu.Unlock()
+		return c.Conn.Close()
+	}
+
+	// a writer is blocked or busy, and closing the connection releases it
+	err := c.Conn.Close()
+	c.wmu.Lock()
+	c.closed = true
+	c.wmu.Unlock()
+	return err
+}
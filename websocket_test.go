@@ -0,0 +1,176 @@
+package bert
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := newTestServer()
+	s.Register("text", "repeat", func(ctx context.Context, args []Term) (Term, error) {
+		return strings.Repeat(args[0].(string), int(args[1].(int64))), nil
+	})
+	hs := httptest.NewServer(WebSocketHandler(s))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	for _, multiplex := range []bool{false, true} {
+		c, err := DialWebSocket(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Multiplex = multiplex
+		result, err := c.Call(ctx, "math", "add", 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, int64(3), result)
+
+		// messages of every length encoding
+		for _, n := range []int{10, 1000, 40000} {
+			result, err = c.Call(ctx, "text", "repeat", "a", n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, strings.Repeat("a", n), result)
+		}
+		c.Close()
+	}
+
+	resp, err := http.Get(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertEqual(t, http.StatusBadRequest, resp.StatusCode)
+
+	if _, err := DialWebSocket(ctx, "http://localhost"); err == nil {
+		t.Errorf("expected error for http URL")
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	s := newTestServer()
+	hs := httptest.NewServer(WebSocketHandler(s))
+	defer hs.Close()
+
+	upgrade := func(origin string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, hs.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Requests from other origins are refused, unlike those from the
+	// server's own or from clients other than browsers.
+	assertEqual(t, http.StatusForbidden, upgrade("https://evil.example"))
+	assertEqual(t, http.StatusForbidden, upgrade("://"))
+	assertEqual(t, http.StatusSwitchingProtocols, upgrade(hs.URL))
+	assertEqual(t, http.StatusSwitchingProtocols, upgrade(""))
+
+	s.CheckOrigin = func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://app.example"
+	}
+	assertEqual(t, http.StatusSwitchingProtocols, upgrade("https://app.example"))
+	assertEqual(t, http.StatusForbidden, upgrade(hs.URL))
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	// terms travel as bare binary messages
+	received := make(chan []byte, 1)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := UpgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		ws := c.(*wsConn)
+		msg, err := ws.readMessage()
+		if err != nil {
+			return
+		}
+		received <- msg
+		ws.writeFrame(wsPing, []byte("hi"))
+		ws.writeFrame(wsBinary, msg)
+	}))
+	defer hs.Close()
+
+	c, err := DialWebSocketConn(context.Background(), "ws"+strings.TrimPrefix(hs.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	tc := NewTermConn(c)
+	if err := tc.WriteTerm(context.Background(), Atom("echo")); err != nil {
+		t.Fatal(err)
+	}
+	term, err := tc.ReadTerm(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, Atom("echo"), term)
+	assertEqual(t, []byte{131, 100, 0, 4, 101, 99, 104, 111}, <-received)
+}
+
+func TestWebSocketMasking(t *testing.T) {
+	// Servers reject unmasked frames and clients masked ones.
+	for _, client := range []bool{false, true} {
+		a, b := net.Pipe()
+		ws := newWSConn(a, bufio.NewReader(a), client)
+		peer := newWSConn(b, bufio.NewReader(b), client)
+		go peer.writeFrame(wsBinary, []byte{131, 106})
+		if _, err := ws.readMessage(); err != errWebSocketMask {
+			t.Errorf("client %v: expected errWebSocketMask, got %v", client, err)
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestWebSocketCloseBlockedWriter(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	ws := newWSConn(a, bufio.NewReader(a), false)
+
+	// The peer reads nothing, blocking the writer.
+	written := make(chan error, 1)
+	go func() {
+		_, err := ws.Write([]byte{0, 0, 0, 2, 131, 106})
+		written <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- ws.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked by a pending write")
+	}
+	if err := <-written; err == nil {
+		t.Error("expected the pending write to fail")
+	}
+}